@@ -0,0 +1,361 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// idPeAcmeIdentifier is the TLS-ALPN-01 certificate extension, see RFC 8737
+var idPeAcmeIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// testCa is a minimal RFC 8555 ACME CA for the tests. Challenges are validated synchronously against the httpAddr and tlsAddr.
+// Request signatures are not verified.
+type testCa struct {
+	t        *testing.T
+	server   *httptest.Server
+	key      *ecdsa.PrivateKey
+	cert     *x509.Certificate
+	validity time.Duration
+	// httpAddr and tlsAddr are the addresses the challenge validations connect to, independent of the host
+	httpAddr string
+	tlsAddr  string
+	lock     sync.Mutex
+	counter  int
+	// accounts maps the JWK thumbprint to the account url
+	accounts map[string]string
+	// thumbprints maps the account url to the JWK thumbprint
+	thumbprints    map[string]string
+	orders         map[string]*testOrder
+	authorizations map[string]*testAuthorization
+	certificates   map[string][]byte
+	// issued counts the issued certificates
+	issued int
+}
+
+type testOrder struct {
+	Status         string         `json:"status"`
+	Identifiers    []testIdentity `json:"identifiers"`
+	Authorizations []string       `json:"authorizations"`
+	Finalize       string         `json:"finalize"`
+	Certificate    string         `json:"certificate,omitempty"`
+}
+
+type testIdentity struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type testAuthorization struct {
+	Status     string          `json:"status"`
+	Identifier testIdentity    `json:"identifier"`
+	Challenges []testChallenge `json:"challenges"`
+	order      string
+}
+
+type testChallenge struct {
+	Type   string `json:"type"`
+	Url    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+}
+
+type jwsHeader struct {
+	Jwk map[string]string `json:"jwk"`
+	Kid string            `json:"kid"`
+}
+
+func newTestCa(t *testing.T) *testCa {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Duration(24*365) * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca := &testCa{
+		t:              t,
+		key:            key,
+		cert:           cert,
+		validity:       time.Duration(90*24) * time.Hour,
+		accounts:       make(map[string]string),
+		thumbprints:    make(map[string]string),
+		orders:         make(map[string]*testOrder),
+		authorizations: make(map[string]*testAuthorization),
+		certificates:   make(map[string][]byte),
+	}
+	ca.server = httptest.NewServer(http.HandlerFunc(ca.serveHTTP))
+	t.Cleanup(ca.server.Close)
+	return ca
+}
+
+func (ca *testCa) directoryUrl() string {
+	return ca.server.URL + "/directory"
+}
+
+func (ca *testCa) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ca.lock.Lock()
+	defer ca.lock.Unlock()
+	ca.counter++
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", ca.counter))
+	if r.URL.Path == "/directory" {
+		ca.writeJson(w, http.StatusOK, map[string]string{
+			"newNonce":   ca.server.URL + "/nonce",
+			"newAccount": ca.server.URL + "/account",
+			"newOrder":   ca.server.URL + "/order",
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	header, payload := ca.parseJws(r)
+	switch {
+	case r.URL.Path == "/account":
+		ca.newAccount(w, header)
+	case r.URL.Path == "/order":
+		ca.newOrder(w, payload)
+	case strings.HasPrefix(r.URL.Path, "/order/"):
+		ca.writeOrder(w, http.StatusOK, r.URL.Path)
+	case strings.HasPrefix(r.URL.Path, "/authz/"):
+		ca.writeJson(w, http.StatusOK, ca.authorizations[r.URL.Path])
+	case strings.HasPrefix(r.URL.Path, "/challenge/"):
+		ca.validateChallenge(w, r.URL.Path, ca.thumbprints[header.Kid])
+	case strings.HasPrefix(r.URL.Path, "/finalize/"):
+		ca.finalize(w, "/order/"+strings.TrimPrefix(r.URL.Path, "/finalize/"), payload)
+	case strings.HasPrefix(r.URL.Path, "/cert/"):
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, err := w.Write(ca.certificates[r.URL.Path])
+		require.NoError(ca.t, err)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (ca *testCa) parseJws(r *http.Request) (header *jwsHeader, payload []byte) {
+	var message jwsMessage
+	require.NoError(ca.t, json.NewDecoder(r.Body).Decode(&message))
+	headerData, err := base64.RawURLEncoding.DecodeString(message.Protected)
+	require.NoError(ca.t, err)
+	header = &jwsHeader{}
+	require.NoError(ca.t, json.Unmarshal(headerData, header))
+	payload, err = base64.RawURLEncoding.DecodeString(message.Payload)
+	require.NoError(ca.t, err)
+	return header, payload
+}
+
+func (ca *testCa) newAccount(w http.ResponseWriter, header *jwsHeader) {
+	// json.Marshal sorts the map keys as required for the JWK thumbprint
+	jwk, err := json.Marshal(header.Jwk)
+	require.NoError(ca.t, err)
+	hash := sha256.Sum256(jwk)
+	thumbprint := base64.RawURLEncoding.EncodeToString(hash[:])
+	accountUrl, ok := ca.accounts[thumbprint]
+	status := http.StatusOK
+	if !ok {
+		accountUrl = fmt.Sprintf("%s/account/%d", ca.server.URL, ca.counter)
+		ca.accounts[thumbprint] = accountUrl
+		ca.thumbprints[accountUrl] = thumbprint
+		status = http.StatusCreated
+	}
+	w.Header().Set("Location", accountUrl)
+	ca.writeJson(w, status, map[string]string{"status": "valid"})
+}
+
+func (ca *testCa) newOrder(w http.ResponseWriter, payload []byte) {
+	order := &testOrder{Status: "pending"}
+	require.NoError(ca.t, json.Unmarshal(payload, order))
+	orderPath := fmt.Sprintf("/order/%d", ca.counter)
+	order.Finalize = fmt.Sprintf("%s/finalize/%d", ca.server.URL, ca.counter)
+	for i, identifier := range order.Identifiers {
+		authzPath := fmt.Sprintf("/authz/%d-%d", ca.counter, i)
+		challengePath := fmt.Sprintf("/challenge/%d-%d", ca.counter, i)
+		token := fmt.Sprintf("token-%d-%d", ca.counter, i)
+		ca.authorizations[authzPath] = &testAuthorization{
+			Status:     "pending",
+			Identifier: identifier,
+			Challenges: []testChallenge{
+				{Type: string(ChallengeHttp01), Url: ca.server.URL + challengePath + "/http", Token: token, Status: "pending"},
+				{Type: string(ChallengeTlsAlpn01), Url: ca.server.URL + challengePath + "/tls", Token: token, Status: "pending"},
+			},
+			order: orderPath,
+		}
+		order.Authorizations = append(order.Authorizations, ca.server.URL+authzPath)
+	}
+	ca.orders[orderPath] = order
+	ca.writeOrder(w, http.StatusCreated, orderPath)
+}
+
+func (ca *testCa) writeOrder(w http.ResponseWriter, status int, orderPath string) {
+	w.Header().Set("Location", ca.server.URL+orderPath)
+	ca.writeJson(w, status, ca.orders[orderPath])
+}
+
+// validateChallenge validates the challenge synchronously and updates the authorization and order status
+func (ca *testCa) validateChallenge(w http.ResponseWriter, challengePath string, thumbprint string) {
+	authzPath := "/authz/" + strings.TrimPrefix(challengePath[:strings.LastIndex(challengePath, "/")], "/challenge/")
+	authz := ca.authorizations[authzPath]
+	var challenge *testChallenge
+	for i := range authz.Challenges {
+		if strings.HasSuffix(authz.Challenges[i].Url, challengePath) {
+			challenge = &authz.Challenges[i]
+		}
+	}
+	keyAuth := challenge.Token + "." + thumbprint
+	var valid bool
+	switch challenge.Type {
+	case string(ChallengeHttp01):
+		valid = ca.validateHttp01(authz.Identifier.Value, challenge.Token, keyAuth)
+	case string(ChallengeTlsAlpn01):
+		valid = ca.validateTlsAlpn01(authz.Identifier.Value, keyAuth)
+	}
+	challenge.Status = "invalid"
+	authz.Status = "invalid"
+	if valid {
+		challenge.Status = "valid"
+		authz.Status = "valid"
+	}
+	order := ca.orders[authz.order]
+	order.Status = "ready"
+	for _, authzUrl := range order.Authorizations {
+		switch ca.authorizations[strings.TrimPrefix(authzUrl, ca.server.URL)].Status {
+		case "invalid":
+			order.Status = "invalid"
+		case "pending":
+			if order.Status != "invalid" {
+				order.Status = "pending"
+			}
+		}
+	}
+	ca.writeJson(w, http.StatusOK, challenge)
+}
+
+func (ca *testCa) validateHttp01(host string, token string, keyAuth string) bool {
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, ca.httpAddr)
+		},
+	}}
+	resp, err := client.Get("http://" + host + "/.well-known/acme-challenge/" + token)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return err == nil && resp.StatusCode == http.StatusOK && strings.TrimSpace(string(body)) == keyAuth
+}
+
+func (ca *testCa) validateTlsAlpn01(host string, keyAuth string) bool {
+	conn, err := tls.Dial("tcp", ca.tlsAddr, &tls.Config{
+		ServerName:         host,
+		NextProtos:         []string{"acme-tls/1"},
+		InsecureSkipVerify: true, //nolint:gosec // the challenge certificate is self-signed
+	})
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	if conn.ConnectionState().NegotiatedProtocol != "acme-tls/1" {
+		return false
+	}
+	hash := sha256.Sum256([]byte(keyAuth))
+	expected, err := asn1.Marshal(hash[:])
+	require.NoError(ca.t, err)
+	leaf := conn.ConnectionState().PeerCertificates[0]
+	if leaf.VerifyHostname(host) != nil {
+		return false
+	}
+	for _, extension := range leaf.Extensions {
+		if extension.Id.Equal(idPeAcmeIdentifier) {
+			return extension.Critical && bytes.Equal(extension.Value, expected)
+		}
+	}
+	return false
+}
+
+func (ca *testCa) finalize(w http.ResponseWriter, orderPath string, payload []byte) {
+	order := ca.orders[orderPath]
+	if order.Status != "ready" {
+		ca.writeJson(w, http.StatusForbidden, map[string]string{"type": "urn:ietf:params:acme:error:orderNotReady"})
+		return
+	}
+	var request struct {
+		Csr string `json:"csr"`
+	}
+	require.NoError(ca.t, json.Unmarshal(payload, &request))
+	csrDer, err := base64.RawURLEncoding.DecodeString(request.Csr)
+	require.NoError(ca.t, err)
+	csr, err := x509.ParseCertificateRequest(csrDer)
+	require.NoError(ca.t, err)
+	certPath := "/cert/" + strings.TrimPrefix(orderPath, "/order/")
+	ca.certificates[certPath] = ca.sign(csr.DNSNames, csr.PublicKey, time.Now().Add(ca.validity))
+	ca.issued++
+	order.Status = "valid"
+	order.Certificate = ca.server.URL + certPath
+	ca.writeOrder(w, http.StatusOK, orderPath)
+}
+
+// sign returns the PEM encoded certificate chain for the hosts and public key
+func (ca *testCa) sign(hosts []string, publicKey crypto.PublicKey, notAfter time.Time) []byte {
+	ca.counter++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(ca.counter)),
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, publicKey, ca.key)
+	require.NoError(ca.t, err)
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+}
+
+func (ca *testCa) writeJson(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	require.NoError(ca.t, json.NewEncoder(w).Encode(value))
+}
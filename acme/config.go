@@ -0,0 +1,118 @@
+package acme
+
+import (
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Config is a data structure that holds the config options for the ACME manager
+type Config struct {
+	// Email is the contact address of the ACME account. Optional.
+	Email string
+	// AccountSecret is the secret in which the private key of the ACME account is stored.
+	// Defaults to the secret acme-account in the namespace ingress.
+	AccountSecret types.NamespacedName
+	// Challenge is the type of the challenge used to prove the control over the hosts.
+	// Defaults to http-01.
+	Challenge ChallengeType
+	// RenewBefore is the duration before the expiry of a certificate after which it is renewed.
+	// Defaults to 30 days.
+	RenewBefore time.Duration
+	// CheckInterval is the interval in which the certificates are checked for renewal.
+	// Defaults to 1 hour.
+	CheckInterval time.Duration
+	// RetryInterval is the minimum duration between two failed attempts to issue the certificate for a secret.
+	// Defaults to 10 minutes.
+	RetryInterval time.Duration
+	// IssueTimeout is the timeout to issue a single certificate including the challenge validation.
+	// Defaults to 5 minutes.
+	IssueTimeout time.Duration
+	// HttpClient is used for the requests to the ACME CA. Defaults to the http.DefaultClient.
+	HttpClient *http.Client
+}
+
+//nolint:gomnd
+var defaultConfig = Config{
+	AccountSecret: types.NamespacedName{Namespace: "ingress", Name: "acme-account"},
+	Challenge:     ChallengeHttp01,
+	RenewBefore:   time.Duration(30*24) * time.Hour,
+	CheckInterval: time.Hour,
+	RetryInterval: time.Duration(10) * time.Minute,
+	IssueTimeout:  time.Duration(5) * time.Minute,
+}
+
+// ConfigOption is used to implement the functional parameter pattern for the ACME manager
+type ConfigOption func(*Config)
+
+// Email sets the contact address of the ACME account
+func Email(email string) ConfigOption {
+	return func(config *Config) {
+		config.Email = email
+	}
+}
+
+// AccountSecret sets the secret in which the private key of the ACME account is stored
+func AccountSecret(secret types.NamespacedName) ConfigOption {
+	return func(config *Config) {
+		config.AccountSecret = secret
+	}
+}
+
+// Challenge sets the type of the challenge used to prove the control over the hosts
+func Challenge(challenge ChallengeType) ConfigOption {
+	return func(config *Config) {
+		config.Challenge = challenge
+	}
+}
+
+// RenewBefore sets the duration before the expiry of a certificate after which it is renewed
+func RenewBefore(renewBefore time.Duration) ConfigOption {
+	return func(config *Config) {
+		config.RenewBefore = renewBefore
+	}
+}
+
+// CheckInterval sets the interval in which the certificates are checked for renewal
+func CheckInterval(interval time.Duration) ConfigOption {
+	return func(config *Config) {
+		config.CheckInterval = interval
+	}
+}
+
+// RetryInterval sets the minimum duration between two failed attempts to issue the certificate for a secret
+func RetryInterval(interval time.Duration) ConfigOption {
+	return func(config *Config) {
+		config.RetryInterval = interval
+	}
+}
+
+// IssueTimeout sets the timeout to issue a single certificate
+func IssueTimeout(timeout time.Duration) ConfigOption {
+	return func(config *Config) {
+		config.IssueTimeout = timeout
+	}
+}
+
+// HttpClient sets the http client for the requests to the ACME CA
+func HttpClient(client *http.Client) ConfigOption {
+	return func(config *Config) {
+		config.HttpClient = client
+	}
+}
+
+// applyOptions applied the given variadic options to the config.
+// the argument config option is modified, the returned value is only for ease of use.
+func (config *Config) applyOptions(options ...ConfigOption) *Config {
+	for _, option := range options {
+		option(config)
+	}
+	return config
+}
+
+// clone creates a deep copy of the config
+func (config *Config) clone() *Config {
+	clone := *config
+	return &clone
+}
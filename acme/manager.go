@@ -0,0 +1,455 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/rs/zerolog/log"
+	xacme "golang.org/x/crypto/acme"
+	v1Core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+var (
+	ErrUnknownChallenge    = errors.New("unknown challenge type")
+	ErrChallengeNotOffered = errors.New("challenge type not offered by the ACME CA")
+	ErrWildcardHost        = errors.New("wildcard hosts require the dns-01 challenge, which is not supported")
+	ErrInvalidAccountKey   = errors.New("invalid ACME account key")
+)
+
+// accountKeyField is the data field of the account secret that holds the PEM encoded private key
+const accountKeyField = "account.key"
+
+// ChallengeType is the ACME challenge used to prove the control over the hosts
+type ChallengeType string
+
+const (
+	// ChallengeHttp01 answers the challenges via HTTP under /.well-known/acme-challenge, see revproxy.ReverseProxy.GetHttpsRedirectHandler.
+	ChallengeHttp01 ChallengeType = "http-01"
+	// ChallengeTlsAlpn01 answers the challenges during the TLS handshake, see revproxy.ReverseProxy.GetCertificateFunc.
+	ChallengeTlsAlpn01 ChallengeType = "tls-alpn-01"
+)
+
+// ParseChallenge parses the challenge type from its name
+func ParseChallenge(value string) (ChallengeType, error) {
+	challenge := ChallengeType(value)
+	switch challenge {
+	case ChallengeHttp01, ChallengeTlsAlpn01:
+		return challenge, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownChallenge, value)
+	}
+}
+
+// Manager issues and renews the certificates of the ingresses with the state.AnnotationAcme via an ACME CA.
+// The certificates are stored in the referenced kubernetes.io/tls secrets and then picked up like any other tls secret.
+// It also provides the responses for the pending challenges, see revproxy.ChallengeSolver.
+type Manager struct {
+	config  *Config
+	client  *xacme.Client
+	secrets corev1client.SecretsGetter
+	// trigger is signaled if the desired certificates changed
+	trigger chan struct{}
+	// certificates are the desired certificates keyed by their secret, guarded by the certificatesLock
+	certificates     map[types.NamespacedName]*certificate
+	certificatesLock sync.Mutex
+	// httpTokens maps the tokens of the pending HTTP-01 challenges to their key authorization, guarded by the challengeLock
+	httpTokens map[string]string
+	// alpnCerts maps the hosts of the pending TLS-ALPN-01 challenges to the challenge certificates, guarded by the challengeLock
+	alpnCerts     map[string]*tls.Certificate
+	challengeLock sync.RWMutex
+	// the following fields are only accessed from the Run loop
+	registered bool
+	// issued holds the certificates issued by this manager, as the ingress state might not contain them yet
+	issued map[types.NamespacedName]*issuedCertificate
+	// failures holds the time of the last failed attempt to issue the certificate for the secret
+	failures map[types.NamespacedName]time.Time
+}
+
+// certificate is a desired certificate for the hosts stored in the secret
+type certificate struct {
+	secret types.NamespacedName
+	// hosts are sorted
+	hosts []string
+	// tlsCert is the current content of the secret, nil if the secret does not exist
+	tlsCert *state.TlsCert
+}
+
+// issuedCertificate are the relevant information of a certificate issued by the manager
+type issuedCertificate struct {
+	hosts    []string
+	notAfter time.Time
+}
+
+// New returns a manager for the ACME CA with the given directory url. The secrets are used to store the account key and the certificates.
+// The certificates are only issued when Run has been called.
+func New(directoryUrl string, secrets corev1client.SecretsGetter, options ...ConfigOption) *Manager {
+	config := defaultConfig.clone().applyOptions(options...)
+	return &Manager{
+		config: config,
+		client: &xacme.Client{
+			DirectoryURL: directoryUrl,
+			HTTPClient:   config.HttpClient,
+			UserAgent:    "ngergs-ingress",
+		},
+		secrets:      secrets,
+		trigger:      make(chan struct{}, 1),
+		certificates: make(map[types.NamespacedName]*certificate),
+		httpTokens:   make(map[string]string),
+		alpnCerts:    make(map[string]*tls.Certificate),
+		issued:       make(map[types.NamespacedName]*issuedCertificate),
+		failures:     make(map[types.NamespacedName]time.Time),
+	}
+}
+
+// LoadIngressState collects the desired certificates from the hosts with a state.DomainConfig AcmeSecret.
+// Hosts with the same secret share a certificate. Triggers the issuance of missing certificates if Run has been called.
+func (m *Manager) LoadIngressState(ingressState state.IngressState) error {
+	certificates := make(map[types.NamespacedName]*certificate)
+	for host, domainConfig := range ingressState {
+		if domainConfig.AcmeSecret == nil {
+			continue
+		}
+		cert, ok := certificates[*domainConfig.AcmeSecret]
+		if !ok {
+			cert = &certificate{secret: *domainConfig.AcmeSecret}
+			certificates[cert.secret] = cert
+		}
+		cert.hosts = append(cert.hosts, host)
+		for _, tlsCert := range domainConfig.TlsCerts {
+			if tlsCert.Secret == cert.secret {
+				cert.tlsCert = tlsCert
+			}
+		}
+	}
+	for _, cert := range certificates {
+		slices.Sort(cert.hosts)
+	}
+	m.certificatesLock.Lock()
+	m.certificates = certificates
+	m.certificatesLock.Unlock()
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+		// already triggered
+	}
+	return nil
+}
+
+// Run issues and renews the certificates till the context is done.
+// Certificates are checked whenever the ingress state changes as well as periodically.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.config.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.trigger:
+		case <-ticker.C:
+		}
+		m.renewCertificates(ctx, time.Now())
+	}
+}
+
+// renewCertificates issues all desired certificates that are missing or due for renewal.
+// Certificates whose last issuance attempt failed are skipped for the RetryInterval.
+func (m *Manager) renewCertificates(ctx context.Context, now time.Time) {
+	m.certificatesLock.Lock()
+	certificates := make([]*certificate, 0, len(m.certificates))
+	for _, cert := range m.certificates {
+		certificates = append(certificates, cert)
+	}
+	m.certificatesLock.Unlock()
+	// stable order for the logs
+	slices.SortFunc(certificates, func(a *certificate, b *certificate) int {
+		return strings.Compare(a.secret.String(), b.secret.String())
+	})
+
+	for _, cert := range certificates {
+		if !m.needsRenewal(cert, now) {
+			continue
+		}
+		if lastFailure, ok := m.failures[cert.secret]; ok && now.Sub(lastFailure) < m.config.RetryInterval {
+			continue
+		}
+		if err := m.issue(ctx, cert); err != nil {
+			log.Error().Err(err).Msgf("failed to issue ACME certificate for secret %s in namespace %s", cert.secret.Name, cert.secret.Namespace)
+			m.failures[cert.secret] = now
+			continue
+		}
+		delete(m.failures, cert.secret)
+	}
+}
+
+// needsRenewal returns whether the certificate is missing, does not cover all hosts or expires within the RenewBefore duration
+func (m *Manager) needsRenewal(cert *certificate, now time.Time) bool {
+	renewalTime := now.Add(m.config.RenewBefore)
+	if issued, ok := m.issued[cert.secret]; ok && slices.Equal(issued.hosts, cert.hosts) && renewalTime.Before(issued.notAfter) {
+		return false
+	}
+	if cert.tlsCert == nil {
+		return true
+	}
+	block, _ := pem.Decode(cert.tlsCert.Cert)
+	if block == nil {
+		return true
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || !renewalTime.Before(leaf.NotAfter) {
+		return true
+	}
+	for _, host := range cert.hosts {
+		if leaf.VerifyHostname(host) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// issue orders a new certificate for the hosts and stores it in the secret
+func (m *Manager) issue(ctx context.Context, cert *certificate) error {
+	for _, host := range cert.hosts {
+		if strings.HasPrefix(host, "*") {
+			return fmt.Errorf("%w: %s", ErrWildcardHost, host)
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.config.IssueTimeout)
+	defer cancel()
+	if err := m.register(ctx); err != nil {
+		return err
+	}
+	log.Info().Msgf("Ordering ACME certificate for hosts %v", cert.hosts)
+	order, err := m.client.AuthorizeOrder(ctx, xacme.DomainIDs(cert.hosts...))
+	if err != nil {
+		return fmt.Errorf("failed to create order: %w", err)
+	}
+	for _, authzUrl := range order.AuthzURLs {
+		if err = m.authorize(ctx, authzUrl); err != nil {
+			return err
+		}
+	}
+	if _, err = m.client.WaitOrder(ctx, order.URI); err != nil {
+		return fmt.Errorf("order not ready: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate certificate key: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: cert.hosts}, key)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate request: %w", err)
+	}
+	chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("failed to finalize order: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return fmt.Errorf("invalid certificate issued: %w", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode certificate key: %w", err)
+	}
+	var certPem []byte
+	for _, der := range chain {
+		certPem = append(certPem, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err = m.storeCertificate(ctx, cert.secret, certPem, keyPem); err != nil {
+		return err
+	}
+	m.issued[cert.secret] = &issuedCertificate{hosts: cert.hosts, notAfter: leaf.NotAfter}
+	log.Info().Msgf("Issued ACME certificate for hosts %v valid till %v", cert.hosts, leaf.NotAfter)
+	return nil
+}
+
+// authorize solves the challenge of the authorization if it is not yet valid
+func (m *Manager) authorize(ctx context.Context, authzUrl string) error {
+	authz, err := m.client.GetAuthorization(ctx, authzUrl)
+	if err != nil {
+		return fmt.Errorf("failed to get authorization: %w", err)
+	}
+	if authz.Status == xacme.StatusValid {
+		return nil
+	}
+	var challenge *xacme.Challenge
+	for _, el := range authz.Challenges {
+		if el.Type == string(m.config.Challenge) {
+			challenge = el
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("%w: %s for %s", ErrChallengeNotOffered, m.config.Challenge, authz.Identifier.Value)
+	}
+	cleanup, err := m.provisionChallenge(challenge, authz.Identifier.Value)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if _, err = m.client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("failed to accept challenge for %s: %w", authz.Identifier.Value, err)
+	}
+	if _, err = m.client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("authorization failed for %s: %w", authz.Identifier.Value, err)
+	}
+	return nil
+}
+
+// provisionChallenge makes the challenge response available and returns a function to remove it again
+func (m *Manager) provisionChallenge(challenge *xacme.Challenge, host string) (cleanup func(), err error) {
+	m.challengeLock.Lock()
+	defer m.challengeLock.Unlock()
+	switch m.config.Challenge {
+	case ChallengeHttp01:
+		keyAuth, err := m.client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute challenge response: %w", err)
+		}
+		m.httpTokens[challenge.Token] = keyAuth
+		return func() {
+			m.challengeLock.Lock()
+			defer m.challengeLock.Unlock()
+			delete(m.httpTokens, challenge.Token)
+		}, nil
+	case ChallengeTlsAlpn01:
+		cert, err := m.client.TLSALPN01ChallengeCert(challenge.Token, host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create challenge certificate: %w", err)
+		}
+		m.alpnCerts[host] = &cert
+		return func() {
+			m.challengeLock.Lock()
+			defer m.challengeLock.Unlock()
+			delete(m.alpnCerts, host)
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownChallenge, m.config.Challenge)
+}
+
+// register loads or creates the account key and registers the account with the ACME CA if that did not happen yet
+func (m *Manager) register(ctx context.Context) error {
+	if m.registered {
+		return nil
+	}
+	key, err := m.loadAccountKey(ctx)
+	if err != nil {
+		return err
+	}
+	m.client.Key = key
+	account := &xacme.Account{}
+	if m.config.Email != "" {
+		account.Contact = []string{"mailto:" + m.config.Email}
+	}
+	_, err = m.client.Register(ctx, account, xacme.AcceptTOS)
+	if err != nil && !errors.Is(err, xacme.ErrAccountAlreadyExists) {
+		return fmt.Errorf("failed to register ACME account: %w", err)
+	}
+	m.registered = true
+	return nil
+}
+
+// loadAccountKey reads the account key from the AccountSecret. A new key is generated and stored if the secret does not exist.
+func (m *Manager) loadAccountKey(ctx context.Context) (*ecdsa.PrivateKey, error) {
+	secrets := m.secrets.Secrets(m.config.AccountSecret.Namespace)
+	secret, err := secrets.Get(ctx, m.config.AccountSecret.Name, metav1.GetOptions{})
+	if err == nil {
+		block, _ := pem.Decode(secret.Data[accountKeyField])
+		if block == nil {
+			return nil, fmt.Errorf("%w: no PEM data in secret %s", ErrInvalidAccountKey, m.config.AccountSecret)
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidAccountKey, err)
+		}
+		return key, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get ACME account secret: %w", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ACME account key: %w", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode ACME account key: %w", err)
+	}
+	_, err = secrets.Create(ctx, &v1Core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: m.config.AccountSecret.Namespace, Name: m.config.AccountSecret.Name},
+		Type:       v1Core.SecretTypeOpaque,
+		Data:       map[string][]byte{accountKeyField: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store ACME account key: %w", err)
+	}
+	log.Info().Msgf("Created ACME account key in secret %s", m.config.AccountSecret)
+	return key, nil
+}
+
+// storeCertificate creates or updates the kubernetes.io/tls secret with the certificate chain and key
+func (m *Manager) storeCertificate(ctx context.Context, secretName types.NamespacedName, certPem []byte, keyPem []byte) error {
+	secrets := m.secrets.Secrets(secretName.Namespace)
+	secret, err := secrets.Get(ctx, secretName.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = secrets.Create(ctx, &v1Core.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: secretName.Namespace, Name: secretName.Name},
+			Type:       v1Core.SecretTypeTLS,
+			Data:       map[string][]byte{v1Core.TLSCertKey: certPem, v1Core.TLSPrivateKeyKey: keyPem},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to create secret %s: %w", secretName, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get secret %s: %w", secretName, err)
+	}
+	if secret.Type != v1Core.SecretTypeTLS {
+		return fmt.Errorf("%w: secret %s with type %s", state.ErrTlsSecretWrongType, secretName, secret.Type)
+	}
+	secret = secret.DeepCopy()
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[v1Core.TLSCertKey] = certPem
+	secret.Data[v1Core.TLSPrivateKeyKey] = keyPem
+	if _, err = secrets.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update secret %s: %w", secretName, err)
+	}
+	return nil
+}
+
+// HTTP01ChallengeResponse returns the key authorization for the token of a pending HTTP-01 challenge
+func (m *Manager) HTTP01ChallengeResponse(token string) (keyAuth string, ok bool) {
+	m.challengeLock.RLock()
+	defer m.challengeLock.RUnlock()
+	keyAuth, ok = m.httpTokens[token]
+	return keyAuth, ok
+}
+
+// TLSALPN01ChallengeCert returns the challenge certificate for a pending TLS-ALPN-01 challenge of the server name
+func (m *Manager) TLSALPN01ChallengeCert(serverName string) (cert *tls.Certificate, ok bool) {
+	m.challengeLock.RLock()
+	defer m.challengeLock.RUnlock()
+	cert, ok = m.alpnCerts[strings.ToLower(serverName)]
+	return cert, ok
+}
@@ -0,0 +1,253 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/stretchr/testify/require"
+	v1Core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const dummyNamespace = "dummyNamespace"
+
+var dummySecret = types.NamespacedName{Namespace: dummyNamespace, Name: "dummySecret"}
+
+// getAcmeState returns an ingress state in which all hosts share the dummySecret
+func getAcmeState(hosts ...string) state.IngressState {
+	ingressState := make(state.IngressState)
+	for _, host := range hosts {
+		ingressState[host] = &state.DomainConfig{AcmeSecret: &dummySecret}
+	}
+	return ingressState
+}
+
+// serveHttp01 starts a server that answers the HTTP-01 challenges of the manager
+func serveHttp01(t *testing.T, ca *testCa, manager *Manager) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyAuth, ok := manager.HTTP01ChallengeResponse(strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/"))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, err := w.Write([]byte(keyAuth))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+	ca.httpAddr = server.Listener.Addr().String()
+}
+
+// serveTlsAlpn01 starts a TLS listener that answers the TLS-ALPN-01 challenges of the manager
+func serveTlsAlpn01(t *testing.T, ca *testCa, manager *Manager) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		NextProtos: []string{"acme-tls/1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := manager.TLSALPN01ChallengeCert(hello.ServerName)
+			return cert, nil
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+	ca.tlsAddr = listener.Addr().String()
+}
+
+// getIssuedCertificate returns the leaf certificate stored in the secret and checks that it matches the stored key
+func getIssuedCertificate(t *testing.T, secrets *fake.Clientset, secretName types.NamespacedName) *x509.Certificate {
+	secret, err := secrets.CoreV1().Secrets(secretName.Namespace).Get(context.Background(), secretName.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, v1Core.SecretTypeTLS, secret.Type)
+	keyPair, err := tls.X509KeyPair(secret.Data[v1Core.TLSCertKey], secret.Data[v1Core.TLSPrivateKeyKey])
+	require.NoError(t, err)
+	require.Len(t, keyPair.Certificate, 2)
+	leaf, err := x509.ParseCertificate(keyPair.Certificate[0])
+	require.NoError(t, err)
+	return leaf
+}
+
+func TestIssueHttp01(t *testing.T) {
+	ctx := context.Background()
+	ca := newTestCa(t)
+	secrets := fake.NewSimpleClientset()
+	manager := New(ca.directoryUrl(), secrets.CoreV1(), Email("admin@example.com"))
+	serveHttp01(t, ca, manager)
+
+	err := manager.LoadIngressState(getAcmeState("b.example.com", "a.example.com"))
+	require.NoError(t, err)
+	manager.renewCertificates(ctx, time.Now())
+	require.Equal(t, 1, ca.issued)
+	leaf := getIssuedCertificate(t, secrets, dummySecret)
+	require.Equal(t, []string{"a.example.com", "b.example.com"}, leaf.DNSNames)
+	_, err = secrets.CoreV1().Secrets("ingress").Get(ctx, "acme-account", metav1.GetOptions{})
+	require.NoError(t, err)
+	// pending challenges are cleaned up
+	require.Empty(t, manager.httpTokens)
+
+	// the issued certificate is not yet part of the ingress state, but must not be issued again
+	manager.renewCertificates(ctx, time.Now())
+	require.Equal(t, 1, ca.issued)
+}
+
+func TestIssueTlsAlpn01(t *testing.T) {
+	ca := newTestCa(t)
+	secrets := fake.NewSimpleClientset()
+	manager := New(ca.directoryUrl(), secrets.CoreV1(), Challenge(ChallengeTlsAlpn01))
+	serveTlsAlpn01(t, ca, manager)
+
+	err := manager.LoadIngressState(getAcmeState("a.example.com"))
+	require.NoError(t, err)
+	manager.renewCertificates(context.Background(), time.Now())
+	require.Equal(t, 1, ca.issued)
+	leaf := getIssuedCertificate(t, secrets, dummySecret)
+	require.Equal(t, []string{"a.example.com"}, leaf.DNSNames)
+	require.Empty(t, manager.alpnCerts)
+}
+
+func TestIssueExistingAccountAndSecret(t *testing.T) {
+	ctx := context.Background()
+	ca := newTestCa(t)
+	secrets := fake.NewSimpleClientset(&v1Core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: dummySecret.Namespace, Name: dummySecret.Name},
+		Type:       v1Core.SecretTypeTLS,
+		Data:       map[string][]byte{"ca.crt": []byte("dummyCa")},
+	})
+	manager := New(ca.directoryUrl(), secrets.CoreV1())
+	serveHttp01(t, ca, manager)
+	err := manager.LoadIngressState(getAcmeState("a.example.com"))
+	require.NoError(t, err)
+	manager.renewCertificates(ctx, time.Now())
+	require.Equal(t, 1, ca.issued)
+
+	// a second manager reuses the stored account key
+	secondManager := New(ca.directoryUrl(), secrets.CoreV1())
+	serveHttp01(t, ca, secondManager)
+	err = secondManager.LoadIngressState(getAcmeState("b.example.com"))
+	require.NoError(t, err)
+	secondManager.renewCertificates(ctx, time.Now())
+	require.Equal(t, 2, ca.issued)
+	require.Len(t, ca.accounts, 1)
+	leaf := getIssuedCertificate(t, secrets, dummySecret)
+	require.Equal(t, []string{"b.example.com"}, leaf.DNSNames)
+	secret, err := secrets.CoreV1().Secrets(dummySecret.Namespace).Get(ctx, dummySecret.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []byte("dummyCa"), secret.Data["ca.crt"])
+}
+
+func TestIssueFailureRetry(t *testing.T) {
+	ctx := context.Background()
+	ca := newTestCa(t)
+	secrets := fake.NewSimpleClientset()
+	manager := New(ca.directoryUrl(), secrets.CoreV1(), RetryInterval(time.Minute))
+	// no challenge server, the validation fails
+	ca.httpAddr = "127.0.0.1:1"
+	err := manager.LoadIngressState(getAcmeState("a.example.com"))
+	require.NoError(t, err)
+
+	now := time.Now()
+	manager.renewCertificates(ctx, now)
+	require.Len(t, ca.orders, 1)
+	require.Contains(t, manager.failures, dummySecret)
+	manager.renewCertificates(ctx, now.Add(time.Duration(30)*time.Second))
+	require.Len(t, ca.orders, 1)
+
+	serveHttp01(t, ca, manager)
+	manager.renewCertificates(ctx, now.Add(time.Duration(2)*time.Minute))
+	require.Len(t, ca.orders, 2)
+	require.Equal(t, 1, ca.issued)
+	require.NotContains(t, manager.failures, dummySecret)
+}
+
+func TestIssueWildcardHost(t *testing.T) {
+	ca := newTestCa(t)
+	manager := New(ca.directoryUrl(), fake.NewSimpleClientset().CoreV1())
+	err := manager.issue(context.Background(), &certificate{secret: dummySecret, hosts: []string{"*.example.com"}})
+	require.ErrorIs(t, err, ErrWildcardHost)
+	require.Empty(t, ca.orders)
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	ca := newTestCa(t)
+	manager := New(ca.directoryUrl(), fake.NewSimpleClientset().CoreV1(), RenewBefore(time.Duration(24)*time.Hour))
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	now := time.Now()
+	getCert := func(notAfter time.Time, hosts ...string) *state.TlsCert {
+		return &state.TlsCert{Cert: ca.sign(hosts, &key.PublicKey, notAfter)}
+	}
+
+	tests := []struct {
+		name     string
+		tlsCert  *state.TlsCert
+		hosts    []string
+		expected bool
+	}{
+		{name: "missing", tlsCert: nil, hosts: []string{"a.example.com"}, expected: true},
+		{name: "invalid", tlsCert: &state.TlsCert{Cert: []byte("dummy")}, hosts: []string{"a.example.com"}, expected: true},
+		{name: "valid", tlsCert: getCert(now.Add(time.Duration(48)*time.Hour), "a.example.com"), hosts: []string{"a.example.com"}, expected: false},
+		{name: "expiring", tlsCert: getCert(now.Add(time.Duration(12)*time.Hour), "a.example.com"), hosts: []string{"a.example.com"}, expected: true},
+		{name: "missing host", tlsCert: getCert(now.Add(time.Duration(48)*time.Hour), "a.example.com"), hosts: []string{"a.example.com", "b.example.com"}, expected: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cert := &certificate{secret: dummySecret, hosts: test.hosts, tlsCert: test.tlsCert}
+			require.Equal(t, test.expected, manager.needsRenewal(cert, now))
+		})
+	}
+}
+
+func TestRenewExpiringCertificate(t *testing.T) {
+	ca := newTestCa(t)
+	secrets := fake.NewSimpleClientset()
+	manager := New(ca.directoryUrl(), secrets.CoreV1())
+	serveHttp01(t, ca, manager)
+	ingressState := getAcmeState("a.example.com")
+	err := manager.LoadIngressState(ingressState)
+	require.NoError(t, err)
+	now := time.Now()
+	manager.renewCertificates(context.Background(), now)
+	require.Equal(t, 1, ca.issued)
+
+	// shortly before the expiry of the issued certificate it is renewed
+	manager.renewCertificates(context.Background(), now.Add(ca.validity-time.Duration(24)*time.Hour))
+	require.Equal(t, 2, ca.issued)
+	leaf := getIssuedCertificate(t, secrets, dummySecret)
+	require.Equal(t, []string{"a.example.com"}, leaf.DNSNames)
+}
+
+func TestRun(t *testing.T) {
+	ca := newTestCa(t)
+	secrets := fake.NewSimpleClientset()
+	manager := New(ca.directoryUrl(), secrets.CoreV1())
+	serveHttp01(t, ca, manager)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go manager.Run(ctx)
+
+	err := manager.LoadIngressState(getAcmeState("a.example.com"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := secrets.CoreV1().Secrets(dummySecret.Namespace).Get(ctx, dummySecret.Name, metav1.GetOptions{})
+		return err == nil
+	}, time.Duration(5)*time.Second, time.Duration(10)*time.Millisecond)
+}
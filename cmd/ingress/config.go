@@ -20,8 +20,18 @@ import (
 var (
 	version               = "snapshot"
 	accessLog             = flag.Bool("access-log", true, "Prints an access log.")
+	acmeDirectoryUrl      = flag.String("acme-directory-url", "", "Directory URL of the ACME CA for the built-in ACME client, e.g. https://acme-v02.api.letsencrypt.org/directory. The ACME client is disabled if empty.")
+	acmeEmail             = flag.String("acme-email", "", "Contact email address of the ACME account. Optional.")
+	acmeAccountSecret     = flag.String("acme-account-secret", "ingress/acme-account", "Secret in the format namespace/name in which the private key of the ACME account is stored. Created if it does not exist.")
+	acmeChallenge         = flag.String("acme-challenge", "http-01", "ACME challenge type to prove the control over the hosts. One of http-01 or tls-alpn-01.")
+	acmeRenewBefore       = flag.Int("acme-renew-before", 30, "Days before the expiry of an ACME certificate after which it is renewed.")
+	connectTimeout        = flag.Int("connect-timeout", 20, "Default timeout to connect to a backend endpoint in seconds. Can be overridden per ingress via annotation.")
 	debugLogging          = flag.Bool("debug", false, "Log debug level")
+	defaultCertSecret     = flag.String("default-cert-secret", "", "kubernetes.io/tls secret in the format namespace/name with the certificate for hosts without certificate and clients without SNI. Optional.")
+	defaultCertFile       = flag.String("default-cert-file", "", "PEM file with the certificate for hosts without certificate and clients without SNI. Requires default-key-file. If neither this nor the default-cert-secret is set, a self-signed certificate is generated.")
+	defaultKeyFile        = flag.String("default-key-file", "", "PEM file with the private key for the default-cert-file.")
 	help                  = flag.Bool("help", false, "Prints the help.")
+	ocspStapling          = flag.Bool("ocsp-stapling", true, "Whether the OCSP responses of the served certificates are fetched in the background and stapled to the TLS handshakes.")
 	prettyLogging         = flag.Bool("pretty", false, "Activates zerolog pretty logging")
 	hostIpString          = flag.String("host-ip", "", "Host IP addresses. Optional, but needs to be set if the ingress status should be updated.")
 	hostIp                net.IP
@@ -35,18 +45,40 @@ var (
 	hstsMaxAge            = flag.Int("hsts-max-age", 63072000, "Max-Age for the HSTS-Header, only relevant if hsts is activated.")
 	hstsIncludeSubdomains = flag.Bool("hsts-subdomains", true, "Whether HSTS if activated should add the includeSubdomains directive.")
 	hstsPreload           = flag.Bool("hsts-preload", false, "Whether the HSTS preload directive should be active.")
+	gatewayControllerName = flag.String("gateway-controller-name", "", "Controller name of the GatewayClasses that are handled by this controller, e.g. ngergs.github.io/ingress. The Gateway API support is disabled if empty.")
 	healthPort            = flag.Int("health-port", 8081, "TCP-Port under which the health check endpoint runs.")
 	healthPath            = flag.String("health-path", "/health", "Path under which the health endpoint runs.")
 	idleTimeout           = flag.Int("idle-timeout", 30, "Timeout for idle TCP connections with keep-alive in seconds.")
 	ingressClassName      = flag.String("ingress-class-name", "ingress", "Corresponds to spec.ingressClassName. Only ingress definitions that match these are evaluated.")
 	k8sClientQps          = flag.Int("k8s-client-qps", 20, "Query per second threshold above which client throttling occurs")
 	k8sClientBurst        = flag.Int("k8s-client-burst", 40, "Query per second absolute threshold for client throttling")
+	loadBalancing         = flag.String("load-balancing", "round-robin", "Algorithm to distribute requests across the ready endpoints of a backend service. One of round-robin, least-connections or random-of-two.")
+	maxConnections        = flag.Int("max-connections", 0, "Default maximum number of open connections to each backend. Unlimited if 0. Can be overridden per ingress via annotation.")
+	maxPendingRequests    = flag.Int("max-pending-requests", 0, "Default maximum number of requests that wait if the max-requests of a backend are reached, further requests are rejected. Can be overridden per ingress via annotation.")
+	maxRequests           = flag.Int("max-requests", 0, "Default maximum number of concurrent requests to each backend. Unlimited if 0. Can be overridden per ingress via annotation.")
 	metricsNamespace      = flag.String("metrics-namespace", "ingress", "Prometheus namespace for the collected metrics.")
 	metricsPort           = flag.Int("metrics-port", 9090, "TCP-Port under which the metrics endpoint runs.")
+	rateLimitRedisAddr    = flag.String("rate-limit-redis-addr", "", "Address (host:port) of a redis compatible server in which the token buckets of the rate limits are shared between the replicas. The token buckets are kept in memory per replica if empty.")
+	rateLimitRedisPwdFile = flag.String("rate-limit-redis-password-file", "", "File with the password for the AUTH of the rate-limit-redis-addr server. Leading and trailing whitespace is ignored. No authentication if empty.")
 	readTimeout           = flag.Int("read-timeout", 10, "Timeout to read the entire request in seconds.")
 	readinessPath         = flag.String("ready-path", "/ready", "Path under which the ready endpoint runs (health port).")
+	rejectExpiredCerts    = flag.Bool("reject-expired-certs", false, "Whether expired ingress certificates are not served. Their hosts use the default certificate instead.")
+	requestTimeout        = flag.Int("request-timeout", 0, "Default timeout for the complete proxied request including retries in seconds, answered with 504. Replaces the read-timeout and write-timeout for the request. Disabled if 0. Can be overridden per ingress via annotation.")
+	responseHeaderTimeout = flag.Int("response-header-timeout", 0, "Default timeout to wait for the response headers of a backend in seconds, answered with 504. Disabled if 0. Can be overridden per ingress via annotation.")
+	retries               = flag.Int("retries", 2, "Default maximum number of retries of failed idempotent requests without body at other endpoints. 0 disables retries. Can be overridden per ingress via annotation.")
+	retryOn               = flag.String("retry-on", "connect-failure", "Failures that are retried by default. One of connect-failure or gateway-error (additionally 502, 503 and 504 responses). Can be overridden per ingress via annotation.")
+	retryBackoff          = flag.Int("retry-backoff", 25, "Base of the exponential backoff between retries in milliseconds.")
+	retryBudget           = flag.Float64("retry-budget", 0.2, "Maximum ratio of retries to requests across all backends to prevent retry storms.")
 	shutdownTimeout       = flag.Int("shutdown-timeout", 10, "Timeout to graceful shutdown the reverse proxy in seconds.")
 	shutdownDelay         = flag.Int("shutdown-delay", 5, "Delay before shutting down the server in seconds. To make sure that the load balancing of the surrounding infrastructure had time to update.")
+	streamIdleTimeout     = flag.Int("stream-idle-timeout", 0, "Default timeout without transfer of the request or response body in seconds, e.g. for long-polling and streaming. Replaces the read-timeout and write-timeout for the request. Disabled if 0. Can be overridden per ingress via annotation.")
+	tlsProfile            = flag.String("tls-profile", "intermediate", "Default TLS profile with the supported TLS versions, cipher suites and curves. One of modern (TLS 1.3 only), intermediate (TLS 1.2+) or legacy (TLS 1.0+). Can be overridden per host via annotation.")
+	tlsMinVersion         = flag.String("tls-min-version", "", "Overrides the minimum TLS version of the tls-profile. One of 1.0, 1.1, 1.2 or 1.3. Optional.")
+	tlsMaxVersion         = flag.String("tls-max-version", "", "Overrides the maximum TLS version of the tls-profile. One of 1.0, 1.1, 1.2 or 1.3. Optional.")
+	tlsCipherSuites       = flag.String("tls-cipher-suites", "", "Overrides the TLS 1.0-1.2 cipher suites of the tls-profile as comma separated list of names, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. TLS 1.3 cipher suites are not configurable. Optional.")
+	tlsCurves             = flag.String("tls-curves", "", "Overrides the curves of the tls-profile as comma separated list ordered by preference. Supported are X25519, P256, P384 and P521. Optional.")
+	webhookPort           = flag.Int("webhook-port", 0, "TCP-Port for the validating admission webhook for ingresses. The webhook is disabled if set to 0.")
+	webhookCertDir        = flag.String("webhook-cert-dir", "/tmp/k8s-webhook-server/serving-certs", "Folder that contains the tls.crt and tls.key for the validating admission webhook.")
 	writeTimeout          = flag.Int("write-timeout", 10, "Timeout to write the complete response in seconds.")
 	hstsConfig            *HstsConfig
 )
@@ -3,10 +3,13 @@ package main
 import (
 	"crypto/tls"
 	"errors"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
 
+	chi "github.com/go-chi/chi/v5/middleware"
+	"github.com/ngergs/ingress/v2/revproxy"
 	websrv "github.com/ngergs/websrv/v3/server"
 	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
@@ -15,14 +18,15 @@ import (
 
 // listenAndServeTls is a wrapper that starts a net.Listener under the given tcp port
 // and subsequently listens with the provided http.Server to that listener.
+// The tcp listener is wrapped by the passthrough function before the TLS termination, see revproxy.ReverseProxy.PassthroughListener.
 // Blocks until finished just like http.server.ListenAndServe
-func listenAndServeTls(port int, server *http.Server, tlsConfig *tls.Config) error {
+func listenAndServeTls(port int, server *http.Server, tlsConfig *tls.Config, passthrough func(net.Listener) net.Listener) error {
 	log.Info().Msgf("Listening for HTTPS under container port tcp/%d", port)
-	tlsListener, err := tls.Listen("tcp", ":"+strconv.Itoa(port), tlsConfig)
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
 	if err != nil {
 		return err
 	}
-	return server.Serve(tlsListener)
+	return server.Serve(tls.NewListener(passthrough(listener), tlsConfig))
 }
 
 // listenAndServeQuic is a wrapper that starts a quic.EarlyListener under the given udp port
@@ -81,3 +85,65 @@ func logErrors(errChan <-chan error) {
 		}
 	}
 }
+
+// logAccess is a middleware that logs the served requests. For requests that can be retried the number of backend attempts is logged, see revproxy.Attempts.
+func logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r = r.WithContext(revproxy.WithAttemptCounter(r.Context()))
+		accessWriter := &accessLogResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(accessWriter, r)
+		event := log.Info().
+			Str("requestId", chi.GetReqID(r.Context())).
+			Str("method", r.Method).
+			Str("host", r.Host).
+			Str("path", r.URL.Path).
+			Str("protocol", r.Proto).
+			Int("status", accessWriter.status()).
+			Int64("size", accessWriter.size).
+			Dur("duration", time.Since(start)).
+			Str("remoteAddr", r.RemoteAddr).
+			Str("userAgent", r.UserAgent())
+		if attempts := revproxy.Attempts(r.Context()); attempts > 0 {
+			event = event.Int("attempts", attempts)
+		}
+		event.Msg("")
+	})
+}
+
+// accessLogResponseWriter records the status and the body size of the response for the access log
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	size       int64
+}
+
+func (w *accessLogResponseWriter) WriteHeader(statusCode int) {
+	// informational responses except for protocol switches are followed by the final status
+	if w.statusCode == 0 && (statusCode >= http.StatusOK || statusCode == http.StatusSwitchingProtocols) {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *accessLogResponseWriter) Write(data []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.size += int64(n)
+	return n, err
+}
+
+// status returns the status of the response, 200 if neither header nor body have been written
+func (w *accessLogResponseWriter) status() int {
+	if w.statusCode == 0 {
+		return http.StatusOK
+	}
+	return w.statusCode
+}
+
+// Unwrap returns the wrapped response writer for the http.ResponseController, e.g. to flush or hijack the connection
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
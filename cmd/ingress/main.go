@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/go-logr/logr"
+	"github.com/ngergs/ingress/v2/acme"
 	"github.com/ngergs/ingress/v2/state"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 	"os"
 	"path/filepath"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -23,13 +27,18 @@ import (
 	chi "github.com/go-chi/chi/v5/middleware"
 	websrv "github.com/ngergs/websrv/v3/server"
 
+	"k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+
 	_ "go.uber.org/automaxprocs"
 )
 
+var errInvalidNamespacedName = errors.New("not of the format namespace/name")
+
 // main starts the ingress controller
 func main() {
 	logger := setup()
@@ -57,14 +66,22 @@ func main() {
 	websrv.AddGracefulShutdown(httpCtx, &wg, httpServer, time.Duration(*shutdownTimeout)*time.Second)
 	tlsCtx := context.WithValue(sigtermCtx, websrv.ServerName, "https server")
 	websrv.AddGracefulShutdown(tlsCtx, &wg, tlsServer, time.Duration(*shutdownTimeout)*time.Second)
-	tlsConfig := getTlsConfig(reverseProxy.GetCertificateFunc())
+	tlsSettings, err := getTlsSettings()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid TLS settings")
+	}
+	tlsConfig := getTlsConfig(reverseProxy.GetCertificateFunc(), tlsSettings)
+	// per host TLS profiles and client authentication
+	tlsConfig.GetConfigForClient = reverseProxy.GetConfigForClientFunc(tlsConfig)
 
 	errChan := make(chan error)
 	go func() {
 		log.Info().Msgf("Listening for HTTP under container port tcp/%s", httpServer.Addr[1:])
 		errChan <- httpServer.ListenAndServe()
 	}()
-	go func() { errChan <- listenAndServeTls(*httpsPort, tlsServer, tlsConfig) }()
+	go func() {
+		errChan <- listenAndServeTls(*httpsPort, tlsServer, tlsConfig, reverseProxy.PassthroughListener)
+	}()
 	if *http3Enabled {
 		quicServer := getServer(nil, reverseProxy.GetHandlerProxying(), middlewareTLS...)
 		quicCtx := context.WithValue(sigtermCtx, websrv.ServerName, "http3 server")
@@ -97,8 +114,19 @@ func setupControllerManager(k8sConfig *rest.Config, logger logr.Logger) (ctrl.Ma
 	log.Info().Msgf("Health check is tcp/%d/%s", *healthPort, *healthPath)
 	log.Info().Msgf("Readiness check is  tcp/%d/%s", *healthPort, *readinessPath)
 	log.Info().Msgf("Metrics address is tcp/%d//metrics", *metricsPort)
+	webhookOptions := webhook.Options{Port: -1} // disable webhook server
+	if *webhookPort != 0 {
+		log.Info().Msgf("Validating admission webhook is tcp/%d", *webhookPort)
+		webhookOptions = webhook.Options{Port: *webhookPort, CertDir: *webhookCertDir}
+	}
+	if *gatewayControllerName != "" {
+		log.Info().Msgf("Gateway API support is enabled for the controller name %s", *gatewayControllerName)
+		if err := gatewayv1.Install(scheme.Scheme); err != nil {
+			return nil, fmt.Errorf("error registering the gateway api types: %w", err)
+		}
+	}
 	mgr, err := ctrl.NewManager(k8sConfig, ctrl.Options{
-		WebhookServer:          webhook.NewServer(webhook.Options{Port: -1}), // disable webhook server
+		WebhookServer:          webhook.NewServer(webhookOptions),
 		HealthProbeBindAddress: fmt.Sprintf(":%d", *healthPort),
 		LivenessEndpointName:   *healthPath,
 		ReadinessEndpointName:  *readinessPath,
@@ -120,21 +148,124 @@ func setupControllerManager(k8sConfig *rest.Config, logger logr.Logger) (ctrl.Ma
 // setupReverseProxy sets up the Kubernetes Api Client and subsequently sets up everything for the reverse proxy.
 // This includes automatic updates when the Kubernetes resource status (ingress, service, secrets) changes.
 func setupReverseProxy(ctx context.Context, mgr ctrl.Manager) (reverseProxy *revproxy.ReverseProxy, ingressStateReconciler *state.IngressReconciler, err error) {
-	backendTimeout := time.Duration(*readTimeout+*writeTimeout) * time.Second
+	loadBalancingAlgorithm, err := revproxy.ParseLoadBalancing(*loadBalancing)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid load balancing config: %w", err)
+	}
+	retryOnFailures, err := revproxy.ParseRetryOn(*retryOn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid retry config: %w", err)
+	}
 	ingressStateReconciler, err = state.New(mgr, *ingressClassName, hostIp)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error setting up ingress reconciler: %w", err)
 	}
+	if *webhookPort != 0 {
+		if err = ingressStateReconciler.SetupWebhookWithManager(mgr); err != nil {
+			return nil, nil, fmt.Errorf("error setting up validating admission webhook: %w", err)
+		}
+	}
+	if *gatewayControllerName != "" {
+		if err = ingressStateReconciler.SetupGatewayApiWithManager(mgr, *gatewayControllerName); err != nil {
+			return nil, nil, fmt.Errorf("error setting up gateway api controller: %w", err)
+		}
+	}
 
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to setup kubebuilder manager: %w", err)
 	}
-	reverseProxy = revproxy.New(revproxy.BackendTimeout(backendTimeout))
+	if *defaultCertSecret != "" {
+		secret, err := parseNamespacedName(*defaultCertSecret)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid default certificate secret: %w", err)
+		}
+		ingressStateReconciler.SetDefaultCertificateSecret(secret)
+	}
+	ingressStateReconciler.SetRejectExpiredCertificates(*rejectExpiredCerts)
+	defaultCert, err := getDefaultCertificate()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error setting up default certificate: %w", err)
+	}
+	proxyMetrics, err := revproxy.RegisterMetrics(metrics.Registry, *metricsNamespace)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error registering reverse proxy metrics: %w", err)
+	}
+	proxyOptions := []revproxy.ConfigOption{
+		revproxy.Timeouts(state.Timeouts{
+			Connect:        time.Duration(*connectTimeout) * time.Second,
+			ResponseHeader: time.Duration(*responseHeaderTimeout) * time.Second,
+			Request:        time.Duration(*requestTimeout) * time.Second,
+			StreamIdle:     time.Duration(*streamIdleTimeout) * time.Second,
+		}),
+		revproxy.LoadBalancing(loadBalancingAlgorithm),
+		revproxy.DefaultCertificate(defaultCert),
+		revproxy.Metrics(proxyMetrics),
+		revproxy.Retries(*retries, retryOnFailures),
+		revproxy.RetryBackoff(time.Duration(*retryBackoff) * time.Millisecond),
+		revproxy.RetryBudget(*retryBudget),
+		revproxy.CircuitBreaker(state.CircuitBreaker{MaxRequests: *maxRequests, MaxPendingRequests: *maxPendingRequests, MaxConnections: *maxConnections}),
+	}
+	if *rateLimitRedisAddr != "" {
+		var redisOptions []revproxy.RedisOption
+		if *rateLimitRedisPwdFile != "" {
+			password, err := os.ReadFile(*rateLimitRedisPwdFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error reading the redis password file: %w", err)
+			}
+			redisOptions = append(redisOptions, revproxy.RedisPassword(strings.TrimSpace(string(password))))
+		}
+		proxyOptions = append(proxyOptions, revproxy.RateLimitStore(revproxy.NewRedisTokenBucketStore(revproxy.NewRedisClient(*rateLimitRedisAddr, redisOptions...))))
+	}
+	var acmeManager *acme.Manager
+	if *acmeDirectoryUrl != "" {
+		acmeManager, err = setupAcme(mgr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error setting up acme client: %w", err)
+		}
+		proxyOptions = append(proxyOptions, revproxy.AcmeSolver(acmeManager))
+		go acmeManager.Run(ctx)
+	}
+	reverseProxy = revproxy.New(proxyOptions...)
+	if *ocspStapling {
+		go reverseProxy.RunOcspStapling(ctx)
+	}
 
-	go forwardUpdates(ctx, ingressStateReconciler, reverseProxy)
+	go forwardUpdates(ctx, ingressStateReconciler, reverseProxy, acmeManager)
 	return reverseProxy, ingressStateReconciler, nil
 }
 
+// setupAcme sets up the built-in ACME client from the acme flags
+func setupAcme(mgr ctrl.Manager) (*acme.Manager, error) {
+	challenge, err := acme.ParseChallenge(*acmeChallenge)
+	if err != nil {
+		return nil, err
+	}
+	accountSecret, err := parseNamespacedName(*acmeAccountSecret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid account secret: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, fmt.Errorf("error setting up k8s client: %w", err)
+	}
+	log.Info().Msgf("ACME client is enabled for the directory %s with challenge %s", *acmeDirectoryUrl, challenge)
+	return acme.New(*acmeDirectoryUrl, client.CoreV1(),
+		acme.Email(*acmeEmail),
+		acme.AccountSecret(accountSecret),
+		acme.Challenge(challenge),
+		acme.RenewBefore(time.Duration(*acmeRenewBefore*24)*time.Hour),
+	), nil
+}
+
+// parseNamespacedName parses a value of the format namespace/name
+func parseNamespacedName(value string) (types.NamespacedName, error) {
+	namespace, name, ok := strings.Cut(value, "/")
+	if !ok || namespace == "" || name == "" {
+		return types.NamespacedName{}, fmt.Errorf("%w: %s", errInvalidNamespacedName, value)
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, nil
+}
+
 // setupMiddleware constructs the relevant websrv.HandlerMiddleware for the given config
 func setupMiddleware() (middleware []websrv.HandlerMiddleware, middlewareTLS []websrv.HandlerMiddleware) {
 	var promRegistration *websrv.PrometheusRegistration
@@ -145,7 +276,7 @@ func setupMiddleware() (middleware []websrv.HandlerMiddleware, middlewareTLS []w
 	}
 	middleware = []websrv.HandlerMiddleware{
 		websrv.Optional(websrv.AccessMetrics(promRegistration), *accessLog),
-		websrv.Optional(websrv.AccessLog(), *accessLog),
+		websrv.Optional(logAccess, *accessLog),
 		chi.RequestID,
 	}
 	middlewareTLS = middleware
@@ -192,7 +323,8 @@ func setupk8s() (*rest.Config, error) {
 }
 
 // forwardUpdates listens to the update channel from the stateManager and calls the LoadIngressState method of the reverse proxy to forwards the results.
-func forwardUpdates(ctx context.Context, ingressReconciler *state.IngressReconciler, reverseProxy *revproxy.ReverseProxy) {
+// The results are also forwarded to the acmeManager if it is not nil.
+func forwardUpdates(ctx context.Context, ingressReconciler *state.IngressReconciler, reverseProxy *revproxy.ReverseProxy, acmeManager *acme.Manager) {
 	for {
 		select {
 		case currentState := <-ingressReconciler.GetStateChan():
@@ -200,6 +332,11 @@ func forwardUpdates(ctx context.Context, ingressReconciler *state.IngressReconci
 			if err != nil {
 				log.Error().Err(err).Msg("failed to apply updated currentState")
 			}
+			if acmeManager != nil {
+				if err = acmeManager.LoadIngressState(currentState); err != nil {
+					log.Error().Err(err).Msg("failed to apply updated currentState to the acme client")
+				}
+			}
 		case <-ctx.Done():
 			return
 		}
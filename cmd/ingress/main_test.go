@@ -16,6 +16,7 @@ import (
 	"github.com/testcontainers/testcontainers-go/modules/k3s"
 	"io"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	netv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -31,8 +32,9 @@ import (
 const (
 	testTimeout     = 60 * time.Second
 	testContainer   = "docker.io/rancher/k3s:v1.29.1-k3s2"
-	svcName         = "app"
 	svcPort         = 8081
+	podIp           = "10.42.0.100"
+	podPort         = 8080
 	namespace       = "test"
 	responseContent = "Hello World!"
 	host            = "localhost"
@@ -44,7 +46,7 @@ func TestIntegration(t *testing.T) {
 	defer cancel()
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
-	svcUrl := fmt.Sprintf("http://%s.%s.svc.cluster.local:%d/", svcName, namespace, svcPort)
+	svcUrl := fmt.Sprintf("http://%s/", net.JoinHostPort(podIp, strconv.Itoa(podPort)))
 	httpmock.RegisterResponder("GET", svcUrl, httpmock.NewStringResponder(200, responseContent))
 	revProxy, ingressStateReconciler, c, shutdown := setupCluster(ctx, t)
 	defer shutdown()
@@ -54,9 +56,11 @@ func TestIntegration(t *testing.T) {
 		assert.NoError(t, err)
 	}()
 	tlsServer := getServer(nil, revProxy.GetHandlerProxying())
-	tlsConfig := getTlsConfig(revProxy.GetCertificateFunc())
+	tlsSettings, err := getTlsSettings()
+	require.NoError(t, err)
+	tlsConfig := getTlsConfig(revProxy.GetCertificateFunc(), tlsSettings)
 	go func() {
-		err := listenAndServeTls(httpsTestPort, tlsServer, tlsConfig)
+		err := listenAndServeTls(httpsTestPort, tlsServer, tlsConfig, revProxy.PassthroughListener)
 		assert.NoError(t, err)
 	}()
 	time.Sleep(time.Millisecond)
@@ -99,6 +103,7 @@ LOOP:
 func setupK8sApp(ctx context.Context, t *testing.T, c *kubernetes.Clientset) *testcerts.CertificateAuthority {
 	_ = setupNamespace(ctx, t, c)
 	svc := setupService(ctx, t, c)
+	_ = setupEndpointSlice(ctx, t, c, svc)
 	certSecret, ca := setupCertSecret(ctx, t, c)
 	_ = setupIngress(ctx, t, c, svc, certSecret)
 	return ca
@@ -166,12 +171,12 @@ func setupService(ctx context.Context, t *testing.T, c *kubernetes.Clientset) *c
 			Name:      "app",
 			Namespace: namespace,
 		},
+		// no selector as we manage the EndpointSlice ourselves
 		Spec: corev1.ServiceSpec{
-			Type:     corev1.ServiceTypeClusterIP,
-			Selector: map[string]string{"app.kubernetes.io/name": "app"},
+			Type: corev1.ServiceTypeClusterIP,
 			Ports: []corev1.ServicePort{{
 				Name:       "http",
-				TargetPort: intstr.IntOrString{Type: intstr.Int, IntVal: 8080},
+				TargetPort: intstr.IntOrString{Type: intstr.Int, IntVal: podPort},
 				Port:       svcPort,
 			}},
 		},
@@ -180,6 +185,34 @@ func setupService(ctx context.Context, t *testing.T, c *kubernetes.Clientset) *c
 	return svc
 }
 
+func setupEndpointSlice(ctx context.Context, t *testing.T, c *kubernetes.Clientset, svc *corev1.Service) *discoveryv1.EndpointSlice {
+	require.Len(t, svc.Spec.Ports, 1)
+	portName := svc.Spec.Ports[0].Name
+	port := int32(podPort)
+	ready := true
+	endpointSlice, err := c.DiscoveryV1().EndpointSlices(namespace).Create(ctx, &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svc.Name + "-manual",
+			Namespace: namespace,
+			Labels: map[string]string{
+				discoveryv1.LabelServiceName: svc.Name,
+				discoveryv1.LabelManagedBy:   "ingress-test",
+			},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{{
+			Addresses:  []string{podIp},
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+		}},
+		Ports: []discoveryv1.EndpointPort{{
+			Name: &portName,
+			Port: &port,
+		}},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	return endpointSlice
+}
+
 func setupNamespace(ctx context.Context, t *testing.T, c *kubernetes.Clientset) *corev1.Namespace {
 	namespace, err := c.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
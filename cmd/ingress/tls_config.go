@@ -1,26 +1,114 @@
 package main
 
-import "crypto/tls"
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
 
-func getTlsConfig(getCertFunc func(hello *tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	"github.com/ngergs/ingress/v2/acme"
+	"github.com/ngergs/ingress/v2/revproxy"
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/rs/zerolog/log"
+)
+
+var errInvalidTlsVersionRange = errors.New("tls-max-version is below the tls-min-version")
+
+func getTlsConfig(getCertFunc func(hello *tls.ClientHelloInfo) (*tls.Certificate, error), settings *revproxy.TlsSettings) *tls.Config {
 	conf := &tls.Config{
-		MinVersion:       tls.VersionTLS12,
-		CurvePreferences: []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
-			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
-		},
 		GetCertificate: getCertFunc,
 	}
+	settings.Apply(conf)
 	if *http3Enabled {
 		conf.NextProtos = []string{"h3", "h2", "http/1.1"}
 	} else {
 		conf.NextProtos = []string{"h2", "http/1.1"}
 	}
+	if *acmeDirectoryUrl != "" && *acmeChallenge == string(acme.ChallengeTlsAlpn01) {
+		// answered via the GetCertificate callback, see revproxy.ReverseProxy.GetCertificateFunc
+		conf.NextProtos = append(conf.NextProtos, "acme-tls/1")
+	}
 	return conf
+}
 
+// getTlsSettings returns the settings of the tls-profile, overridden by the custom tls-min-version, tls-max-version,
+// tls-cipher-suites and tls-curves values
+func getTlsSettings() (*revproxy.TlsSettings, error) {
+	settings, err := revproxy.GetTlsSettings(state.TlsProfile(*tlsProfile))
+	if err != nil {
+		return nil, err
+	}
+	if *tlsMinVersion != "" {
+		if settings.MinVersion, err = revproxy.ParseTlsVersion(*tlsMinVersion); err != nil {
+			return nil, err
+		}
+	}
+	if *tlsMaxVersion != "" {
+		if settings.MaxVersion, err = revproxy.ParseTlsVersion(*tlsMaxVersion); err != nil {
+			return nil, err
+		}
+	}
+	if *tlsCipherSuites != "" {
+		if settings.CipherSuites, err = revproxy.ParseCipherSuites(*tlsCipherSuites); err != nil {
+			return nil, err
+		}
+	}
+	if *tlsCurves != "" {
+		if settings.CurvePreferences, err = revproxy.ParseCurves(*tlsCurves); err != nil {
+			return nil, err
+		}
+	}
+	if settings.MaxVersion != 0 && settings.MaxVersion < settings.MinVersion {
+		return nil, errInvalidTlsVersionRange
+	}
+	return settings, nil
+}
+
+// getDefaultCertificate loads the default certificate from the default-cert-file and default-key-file.
+// If they are not set, a self-signed placeholder certificate is generated.
+func getDefaultCertificate() (*tls.Certificate, error) {
+	if *defaultCertFile != "" || *defaultKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(*defaultCertFile, *defaultKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load default certificate: %w", err)
+		}
+		log.Info().Msgf("Loaded default certificate from %s", *defaultCertFile)
+		return &cert, nil
+	}
+	return generateSelfSignedCertificate()
+}
+
+// generateSelfSignedCertificate generates a self-signed certificate that is only used as placeholder
+func generateSelfSignedCertificate() (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	//nolint:gomnd
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "ingress default certificate"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Duration(10*365*24) * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create self-signed certificate: %w", err)
+	}
+	log.Info().Msg("Generated self-signed default certificate")
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
 }
@@ -0,0 +1,46 @@
+package revproxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// acmeTlsAlpnProto is the ALPN protocol of the TLS-ALPN-01 challenge, see RFC 8737
+const acmeTlsAlpnProto = "acme-tls/1"
+
+// ChallengeSolver provides the responses for pending ACME challenges of certificates that are issued by the ingress itself
+type ChallengeSolver interface {
+	// HTTP01ChallengeResponse returns the key authorization for the token of a pending HTTP-01 challenge
+	HTTP01ChallengeResponse(token string) (keyAuth string, ok bool)
+	// TLSALPN01ChallengeCert returns the challenge certificate for a pending TLS-ALPN-01 challenge of the server name
+	TLSALPN01ChallengeCert(serverName string) (cert *tls.Certificate, ok bool)
+}
+
+// serveHttp01Challenge answers the request if it is an HTTP-01 challenge that is pending in the solver.
+// Returns whether the request has been answered.
+func serveHttp01Challenge(solver ChallengeSolver, w http.ResponseWriter, r *http.Request) bool {
+	if solver == nil {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.URL.Path, acmePath+"/")
+	if !ok {
+		return false
+	}
+	keyAuth, ok := solver.HTTP01ChallengeResponse(token)
+	if !ok {
+		return false
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(keyAuth))
+	return true
+}
+
+// tlsAlpn01ChallengeCert returns the challenge certificate if the handshake is a TLS-ALPN-01 challenge that is pending in the solver
+func tlsAlpn01ChallengeCert(solver ChallengeSolver, hello *tls.ClientHelloInfo) (cert *tls.Certificate, ok bool) {
+	if solver == nil || !slices.Contains(hello.SupportedProtos, acmeTlsAlpnProto) {
+		return nil, false
+	}
+	return solver.TLSALPN01ChallengeCert(hello.ServerName)
+}
@@ -0,0 +1,64 @@
+package revproxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const acmeToken = "token"
+
+// mockChallengeSolver has a pending HTTP-01 challenge for acmeToken and a pending TLS-ALPN-01 challenge for the dummyHost
+type mockChallengeSolver struct {
+	cert *tls.Certificate
+}
+
+func (solver *mockChallengeSolver) HTTP01ChallengeResponse(token string) (string, bool) {
+	return token + ".keyauth", token == acmeToken
+}
+
+func (solver *mockChallengeSolver) TLSALPN01ChallengeCert(serverName string) (*tls.Certificate, bool) {
+	return solver.cert, serverName == dummyHost
+}
+
+func TestHttp01Challenge(t *testing.T) {
+	w, r, next := getDefaultHandlerMocks()
+	reverseProxy := getDummyReverseProxy(t, next)
+	reverseProxy.acmeSolver = &mockChallengeSolver{}
+	r.Host = "other"
+	r.URL = &url.URL{Path: acmePath + "/" + acmeToken}
+	reverseProxy.GetHttpsRedirectHandler().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, acmeToken+".keyauth", w.Body.String())
+	require.Nil(t, next.r)
+
+	// other requests are still forwarded to the backend
+	w, r, _ = getDefaultHandlerMocks()
+	r.Host = dummyHost
+	r.URL = &url.URL{Path: acmePath}
+	reverseProxy.GetHttpsRedirectHandler().ServeHTTP(w, r)
+	require.NotNil(t, next.r)
+}
+
+func TestTlsAlpn01Challenge(t *testing.T) {
+	reverseProxy := getDummyReverseProxy(t, nil)
+	challengeCert := &tls.Certificate{}
+	reverseProxy.acmeSolver = &mockChallengeSolver{cert: challengeCert}
+	cert, err := reverseProxy.GetCertificateFunc()(&tls.ClientHelloInfo{
+		ServerName:      dummyHost,
+		SupportedProtos: []string{acmeTlsAlpnProto},
+	})
+	require.NoError(t, err)
+	require.Same(t, challengeCert, cert)
+
+	// regular handshakes get the regular certificate
+	cert, err = reverseProxy.GetCertificateFunc()(&tls.ClientHelloInfo{
+		ServerName:      dummyHost,
+		SupportedProtos: []string{"h2"},
+	})
+	require.NoError(t, err)
+	require.NotSame(t, challengeCert, cert)
+}
@@ -0,0 +1,137 @@
+package revproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/ngergs/ingress/v2/state"
+	"golang.org/x/net/http2"
+)
+
+var (
+	ErrInvalidBackendCa     = errors.New("no valid PEM encoded certificate in the backend CA certificates")
+	ErrUnsupportedTransport = errors.New("backend transport is not *http.Transport")
+)
+
+// loadBalancerKey identifies the load balancer of a backend path. Paths to the same service port share a load balancer
+// if they also use the same backend protocol and TLS configuration.
+func loadBalancerKey(backendPath *state.BackendPath) string {
+	key := backendServiceKey(backendPath)
+	if backendPath.Protocol != "" && backendPath.Protocol != state.BackendProtocolHttp {
+		key += " " + string(backendPath.Protocol)
+	}
+	if backendPath.BackendTls != nil {
+		caHash := sha256.Sum256(backendPath.BackendTls.CaCerts)
+		key += " " + backendPath.BackendTls.ServerName + " " + hex.EncodeToString(caHash[:])
+	}
+	return key
+}
+
+// newBackendLoadBalancer returns a load balancer without endpoints for the backend protocol of the backend path.
+// The base transport is used for plain HTTP, the transports for the other protocols are derived from it.
+// If maxConnections is set, the transport is also derived for plain HTTP to limit the open connections, see connectionLimiter.
+// The health probes use a transport for the same protocol without connection limit, so that they are not rejected by a full pool.
+func newBackendLoadBalancer(algorithm LoadBalancingAlgorithm, base http.RoundTripper, backendPath *state.BackendPath, maxConnections int) (*loadBalancer, error) {
+	connections := newConnectionLimiter(maxConnections)
+	scheme, transport, err := newBackendTransport(base, backendPath, connections)
+	if err != nil {
+		return nil, err
+	}
+	lb := newLoadBalancer(algorithm, scheme, transport)
+	if connections != nil {
+		if _, lb.probeTransport, err = newBackendTransport(base, backendPath, nil); err != nil {
+			return nil, err
+		}
+	}
+	lb.connections = connections
+	return lb, nil
+}
+
+// newBackendTransport returns the URL scheme and the transport for the backend protocol of the backend path.
+// The open connections of the transport are limited by the optional connection limiter.
+func newBackendTransport(base http.RoundTripper, backendPath *state.BackendPath, connections *connectionLimiter) (string, http.RoundTripper, error) {
+	switch {
+	case backendPath.Protocol.UsesTls():
+		transport, err := cloneTransport(base)
+		if err != nil {
+			return "", nil, err
+		}
+		transport.TLSClientConfig, err = getBackendTlsConfig(backendPath)
+		if err != nil {
+			return "", nil, err
+		}
+		// HTTP/2 is negotiated via ALPN, required for the trailers of gRPC
+		transport.ForceAttemptHTTP2 = true
+		connections.limitTransport(transport)
+		return "https", transport, nil
+	case backendPath.Protocol.UsesH2c():
+		transport, err := cloneTransport(base)
+		if err != nil {
+			return "", nil, err
+		}
+		connections.limitTransport(transport)
+		dial := transport.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		return "http", &http2.Transport{
+			AllowHTTP: true,
+			// plain TCP connections with HTTP/2 prior knowledge
+			DialTLSContext: func(ctx context.Context, network string, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		}, nil
+	case connections != nil:
+		transport, err := cloneTransport(base)
+		if err != nil {
+			return "", nil, err
+		}
+		connections.limitTransport(transport)
+		return "http", transport, nil
+	default:
+		return "http", base, nil
+	}
+}
+
+// cloneTransport clones the base transport to keep its timeouts, http.DefaultTransport is used if the base is no *http.Transport
+func cloneTransport(base http.RoundTripper) (*http.Transport, error) {
+	transport, ok := base.(*http.Transport)
+	if !ok {
+		transport, ok = http.DefaultTransport.(*http.Transport)
+	}
+	if !ok {
+		return nil, ErrUnsupportedTransport
+	}
+	return transport.Clone(), nil
+}
+
+// getBackendTlsConfig returns the TLS client config for the backend path. The server name defaults to the DNS name of the service.
+// The backend certificates are only verified if CA certificates are configured, see state.AnnotationBackendTlsSecret.
+func getBackendTlsConfig(backendPath *state.BackendPath) (*tls.Config, error) {
+	backendTls := backendPath.BackendTls
+	if backendTls == nil {
+		backendTls = &state.BackendTls{}
+	}
+	config := &tls.Config{
+		ServerName: backendTls.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if config.ServerName == "" {
+		config.ServerName = backendPath.ServiceName + "." + backendPath.Namespace + ".svc"
+	}
+	if len(backendTls.CaCerts) == 0 {
+		config.InsecureSkipVerify = true //nolint:gosec // verification is opt-in as cluster internal certificates are often self-signed
+		return config, nil
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(backendTls.CaCerts) {
+		return nil, ErrInvalidBackendCa
+	}
+	return config, nil
+}
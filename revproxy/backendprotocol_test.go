@@ -0,0 +1,98 @@
+package revproxy
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// protocolHandler answers with the protocol of the request and sets a trailer
+var protocolHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Trailer", "Grpc-Status")
+	_, _ = w.Write([]byte(r.Proto))
+	w.Header().Set("Grpc-Status", "0")
+})
+
+// requireBackendProtocol proxies a request via a reverse proxy for a single backend and checks the protocol that the backend received
+func requireBackendProtocol(t *testing.T, backend *httptest.Server, protocol state.BackendProtocol, backendTls *state.BackendTls, expectedProto string) {
+	backendPath := &state.BackendPath{
+		Namespace:   "default",
+		ServiceName: "svc",
+		ServicePort: 8080,
+		Protocol:    protocol,
+		BackendTls:  backendTls,
+		Endpoints:   []string{backend.Listener.Addr().String()},
+	}
+	reverseProxy := New()
+	err := reverseProxy.LoadIngressState(state.IngressState{dummyHost: &state.DomainConfig{DefaultBackend: backendPath}})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://"+dummyHost+"/", nil)
+	reverseProxy.GetHandlerProxying().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	body, err := io.ReadAll(w.Body)
+	require.NoError(t, err)
+	require.Equal(t, expectedProto, string(body))
+	require.Equal(t, "0", w.Result().Trailer.Get("Grpc-Status"))
+}
+
+func TestBackendProtocolHttps(t *testing.T) {
+	backend := httptest.NewUnstartedServer(protocolHandler)
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+
+	requireBackendProtocol(t, backend, state.BackendProtocolHttps, nil, "HTTP/2.0")
+	requireBackendProtocol(t, backend, state.BackendProtocolGrpcs, &state.BackendTls{CaCerts: ca, ServerName: "example.com"}, "HTTP/2.0")
+}
+
+func TestBackendProtocolHttpsVerificationFailure(t *testing.T) {
+	backend := httptest.NewTLSServer(protocolHandler)
+	defer backend.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+	reverseProxy := New()
+	// the certificate of the test server does not cover the default server name svc.default.svc
+	err := reverseProxy.LoadIngressState(state.IngressState{dummyHost: &state.DomainConfig{DefaultBackend: &state.BackendPath{
+		Namespace:   "default",
+		ServiceName: "svc",
+		ServicePort: 8080,
+		Protocol:    state.BackendProtocolHttps,
+		BackendTls:  &state.BackendTls{CaCerts: ca},
+		Endpoints:   []string{backend.Listener.Addr().String()},
+	}}})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	reverseProxy.GetHandlerProxying().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://"+dummyHost+"/", nil))
+	require.Equal(t, http.StatusBadGateway, w.Code)
+}
+
+func TestBackendProtocolH2c(t *testing.T) {
+	backend := httptest.NewServer(h2c.NewHandler(protocolHandler, &http2.Server{}))
+	defer backend.Close()
+
+	requireBackendProtocol(t, backend, state.BackendProtocolH2c, nil, "HTTP/2.0")
+	requireBackendProtocol(t, backend, state.BackendProtocolGrpc, nil, "HTTP/2.0")
+	requireBackendProtocol(t, backend, "", nil, "HTTP/1.1")
+}
+
+func TestLoadBalancerKey(t *testing.T) {
+	backendPath := &state.BackendPath{Namespace: "default", ServiceName: "svc", ServicePort: 8080}
+	require.Equal(t, "default/svc:8080", loadBalancerKey(backendPath))
+	backendPath.Protocol = state.BackendProtocolHttp
+	require.Equal(t, "default/svc:8080", loadBalancerKey(backendPath))
+	backendPath.Protocol = state.BackendProtocolHttps
+	backendPath.BackendTls = &state.BackendTls{ServerName: "example.com"}
+	key := loadBalancerKey(backendPath)
+	require.True(t, strings.HasPrefix(key, "default/svc:8080 https example.com "))
+	backendPath.BackendTls.CaCerts = []byte("ca")
+	require.NotEqual(t, key, loadBalancerKey(backendPath))
+}
@@ -0,0 +1,53 @@
+package revproxy
+
+import (
+	"math/rand/v2"
+	"net/http"
+
+	"github.com/ngergs/ingress/v2/state"
+)
+
+// canarySplitter splits the requests between the primary and the canary handler.
+// A matching header takes precedence over a matching cookie, which takes precedence over the weight.
+type canarySplitter struct {
+	primary http.Handler
+	canary  http.Handler
+	// weight is the percentage of the requests that are routed to the canary handler
+	weight      int
+	header      string
+	headerValue string
+	cookie      string
+}
+
+// ServeHTTP forwards the request either to the primary or the canary handler
+func (splitter *canarySplitter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if splitter.useCanary(r) {
+		splitter.canary.ServeHTTP(w, r)
+		return
+	}
+	splitter.primary.ServeHTTP(w, r)
+}
+
+// useCanary returns whether the request is routed to the canary handler
+func (splitter *canarySplitter) useCanary(r *http.Request) bool {
+	if splitter.header != "" {
+		switch r.Header.Get(splitter.header) {
+		case splitter.headerValue:
+			return true
+		case state.CanaryNever:
+			return false
+		}
+	}
+	if splitter.cookie != "" {
+		if cookie, err := r.Cookie(splitter.cookie); err == nil {
+			switch cookie.Value {
+			case state.CanaryAlways:
+				return true
+			case state.CanaryNever:
+				return false
+			}
+		}
+	}
+	//nolint:gosec // no cryptographic randomness required for the traffic split
+	return rand.IntN(100) < splitter.weight
+}
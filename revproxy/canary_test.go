@@ -0,0 +1,71 @@
+package revproxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/stretchr/testify/require"
+)
+
+// getDummyCanarySplitter returns a canarySplitter with mock handlers for the primary and canary backend
+func getDummyCanarySplitter(weight int) (splitter *canarySplitter, primary *mockHandler, canary *mockHandler) {
+	primary = &mockHandler{}
+	canary = &mockHandler{}
+	splitter = &canarySplitter{
+		primary:     primary,
+		canary:      canary,
+		weight:      weight,
+		header:      "X-Canary",
+		headerValue: state.CanaryAlways,
+		cookie:      "canary",
+	}
+	return
+}
+
+func TestCanaryWeight(t *testing.T) {
+	for _, testCase := range []struct {
+		weight         int
+		expectedCanary bool
+	}{{0, false}, {100, true}} {
+		splitter, primary, canary := getDummyCanarySplitter(testCase.weight)
+		w, r, _ := getDefaultHandlerMocks()
+		splitter.ServeHTTP(w, r)
+		require.Equal(t, testCase.expectedCanary, canary.r != nil)
+		require.Equal(t, !testCase.expectedCanary, primary.r != nil)
+	}
+}
+
+func TestCanaryHeader(t *testing.T) {
+	splitter, primary, canary := getDummyCanarySplitter(0)
+	w, r, _ := getDefaultHandlerMocks()
+	r.Header.Set("X-Canary", state.CanaryAlways)
+	splitter.ServeHTTP(w, r)
+	require.NotNil(t, canary.r)
+	require.Nil(t, primary.r)
+
+	// header takes precedence over the cookie and the weight
+	splitter, primary, canary = getDummyCanarySplitter(100)
+	w, r, _ = getDefaultHandlerMocks()
+	r.Header.Set("X-Canary", state.CanaryNever)
+	r.AddCookie(&http.Cookie{Name: "canary", Value: state.CanaryAlways})
+	splitter.ServeHTTP(w, r)
+	require.Nil(t, canary.r)
+	require.NotNil(t, primary.r)
+}
+
+func TestCanaryCookie(t *testing.T) {
+	splitter, primary, canary := getDummyCanarySplitter(0)
+	w, r, _ := getDefaultHandlerMocks()
+	r.AddCookie(&http.Cookie{Name: "canary", Value: state.CanaryAlways})
+	splitter.ServeHTTP(w, r)
+	require.NotNil(t, canary.r)
+	require.Nil(t, primary.r)
+
+	splitter, primary, canary = getDummyCanarySplitter(100)
+	w, r, _ = getDefaultHandlerMocks()
+	r.AddCookie(&http.Cookie{Name: "canary", Value: state.CanaryNever})
+	splitter.ServeHTTP(w, r)
+	require.Nil(t, canary.r)
+	require.NotNil(t, primary.r)
+}
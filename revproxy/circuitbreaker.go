@@ -0,0 +1,195 @@
+package revproxy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ngergs/ingress/v2/state"
+)
+
+var (
+	ErrCircuitOpen     = errors.New("request limits of the backend reached")
+	ErrConnectionLimit = errors.New("connection limit of the backend reached")
+)
+
+// circuitBreakerRetryAfter is the Retry-After header value in seconds for rejected requests
+const circuitBreakerRetryAfter = "1"
+
+// circuitBreakerLimits returns the limits for the backend path, the annotations override the defaults of the config
+func (proxy *ReverseProxy) circuitBreakerLimits(backendPath *state.BackendPath) state.CircuitBreaker {
+	limits := proxy.circuitBreaker
+	if override := backendPath.CircuitBreaker; override != nil {
+		limits.MaxRequests = cmp.Or(override.MaxRequests, limits.MaxRequests)
+		limits.MaxPendingRequests = cmp.Or(override.MaxPendingRequests, limits.MaxPendingRequests)
+		limits.MaxConnections = cmp.Or(override.MaxConnections, limits.MaxConnections)
+	}
+	return limits
+}
+
+// connectionLimitedKey extends the loadBalancerKey by the connection limit, as the limit is part of the transport of the load balancer
+func connectionLimitedKey(key string, maxConnections int) string {
+	if maxConnections == 0 {
+		return key
+	}
+	return key + " max-connections=" + strconv.Itoa(maxConnections)
+}
+
+// rejectRequest answers a request that exceeds the limits of the backend with HTTP 503
+func rejectRequest(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", circuitBreakerRetryAfter)
+	w.WriteHeader(http.StatusServiceUnavailable)
+}
+
+// requestLimits are the limits of a requestLimiter
+type requestLimits struct {
+	maxRequests        int64
+	maxPendingRequests int
+}
+
+// requestLimiter limits the concurrent requests of a load balancer. Requests beyond the limit wait in the order of their arrival
+// as long as the number of pending requests is below its limit, further requests are rejected.
+type requestLimiter struct {
+	// limits are nil if the requests are not limited
+	limits atomic.Pointer[requestLimits]
+	lock   sync.Mutex
+	active atomic.Int64
+	// waiters are closed when a request slot is handed over to the pending request
+	waiters    []chan struct{}
+	pending    atomic.Int64
+	rejections atomic.Int64
+}
+
+// setLimits sets the limits, a maxRequests of 0 disables the limits
+func (limiter *requestLimiter) setLimits(maxRequests int, maxPendingRequests int) {
+	if maxRequests == 0 {
+		limiter.limits.Store(nil)
+		return
+	}
+	limiter.limits.Store(&requestLimits{maxRequests: int64(maxRequests), maxPendingRequests: maxPendingRequests})
+}
+
+// acquire takes a request slot, possibly after waiting for it. Returns ErrCircuitOpen if the request is rejected
+// or the context error if it is done while waiting. The slot has to be returned via release.
+func (limiter *requestLimiter) acquire(ctx context.Context) error {
+	limits := limiter.limits.Load()
+	if limits == nil {
+		limiter.active.Add(1)
+		return nil
+	}
+	limiter.lock.Lock()
+	if limiter.active.Load() < limits.maxRequests && len(limiter.waiters) == 0 {
+		limiter.active.Add(1)
+		limiter.lock.Unlock()
+		return nil
+	}
+	if len(limiter.waiters) >= limits.maxPendingRequests {
+		limiter.lock.Unlock()
+		limiter.rejections.Add(1)
+		return ErrCircuitOpen
+	}
+	waiter := make(chan struct{})
+	limiter.waiters = append(limiter.waiters, waiter)
+	limiter.pending.Add(1)
+	limiter.lock.Unlock()
+	select {
+	case <-waiter:
+		return nil
+	case <-ctx.Done():
+		limiter.lock.Lock()
+		if i := slices.Index(limiter.waiters, waiter); i >= 0 {
+			limiter.waiters = slices.Delete(limiter.waiters, i, i+1)
+			limiter.pending.Add(-1)
+			limiter.lock.Unlock()
+			return ctx.Err()
+		}
+		limiter.lock.Unlock()
+		// the slot has been handed over concurrently
+		limiter.release()
+		return ctx.Err()
+	}
+}
+
+// release returns a request slot, it is handed over to the first pending request unless the limits have been lowered
+func (limiter *requestLimiter) release() {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	limits := limiter.limits.Load()
+	if len(limiter.waiters) > 0 && (limits == nil || limiter.active.Load() <= limits.maxRequests) {
+		close(limiter.waiters[0])
+		limiter.waiters = limiter.waiters[1:]
+		limiter.pending.Add(-1)
+		return
+	}
+	limiter.active.Add(-1)
+}
+
+// isOpen returns whether further requests have to wait or are rejected
+func (limiter *requestLimiter) isOpen() bool {
+	limits := limiter.limits.Load()
+	return limits != nil && limiter.active.Load() >= limits.maxRequests
+}
+
+// connectionLimiter limits the open connections of the transport of a load balancer, dials beyond the limit fail with ErrConnectionLimit
+type connectionLimiter struct {
+	max        int64
+	open       atomic.Int64
+	rejections atomic.Int64
+}
+
+// newConnectionLimiter returns a connection limiter for the maximum number of connections, nil if maxConnections is 0
+func newConnectionLimiter(maxConnections int) *connectionLimiter {
+	if maxConnections == 0 {
+		return nil
+	}
+	return &connectionLimiter{max: int64(maxConnections)}
+}
+
+// limitTransport wraps the dial of the transport to count the open connections, a nil receiver is a noop
+func (limiter *connectionLimiter) limitTransport(transport *http.Transport) {
+	if limiter == nil {
+		return
+	}
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	transport.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		if limiter.open.Add(1) > limiter.max {
+			limiter.open.Add(-1)
+			limiter.rejections.Add(1)
+			return nil, ErrConnectionLimit
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			limiter.open.Add(-1)
+			return nil, err
+		}
+		return &limitedConn{Conn: conn, limiter: limiter}, nil
+	}
+}
+
+// isOpen returns whether further connections are rejected, a nil receiver is never open
+func (limiter *connectionLimiter) isOpen() bool {
+	return limiter != nil && limiter.open.Load() >= limiter.max
+}
+
+// limitedConn releases its slot of the connectionLimiter when it is closed
+type limitedConn struct {
+	net.Conn
+	limiter *connectionLimiter
+	once    sync.Once
+}
+
+func (conn *limitedConn) Close() error {
+	conn.once.Do(func() {
+		conn.limiter.open.Add(-1)
+	})
+	return conn.Conn.Close()
+}
@@ -0,0 +1,80 @@
+package revproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLimiter(t *testing.T) {
+	limiter := &requestLimiter{}
+	limiter.setLimits(1, 1)
+	require.NoError(t, limiter.acquire(context.Background()))
+	require.True(t, limiter.isOpen())
+	acquired := make(chan error)
+	go func() {
+		acquired <- limiter.acquire(context.Background())
+	}()
+	require.Eventually(t, func() bool { return limiter.pending.Load() == 1 }, time.Second, time.Millisecond)
+	require.ErrorIs(t, limiter.acquire(context.Background()), ErrCircuitOpen)
+	require.Equal(t, int64(1), limiter.rejections.Load())
+	// the slot is handed over to the pending request
+	limiter.release()
+	require.NoError(t, <-acquired)
+	require.Equal(t, int64(1), limiter.active.Load())
+	require.Equal(t, int64(0), limiter.pending.Load())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(10)*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, limiter.acquire(ctx), context.DeadlineExceeded)
+	require.Equal(t, int64(0), limiter.pending.Load())
+	limiter.release()
+	require.False(t, limiter.isOpen())
+}
+
+func TestCircuitBreakerLimits(t *testing.T) {
+	reverseProxy := New(CircuitBreaker(state.CircuitBreaker{MaxRequests: 100, MaxConnections: 10}))
+	limits := reverseProxy.circuitBreakerLimits(&state.BackendPath{CircuitBreaker: &state.CircuitBreaker{MaxRequests: 5, MaxPendingRequests: 2}})
+	require.Equal(t, state.CircuitBreaker{MaxRequests: 5, MaxPendingRequests: 2, MaxConnections: 10}, limits)
+}
+
+// serveBlocked sends a request to the reverse proxy in the background, the backend blocks it till the returned channel is closed
+func serveBlocked(t *testing.T, limits state.CircuitBreaker) (reverseProxy *ReverseProxy, lb *loadBalancer, unblock chan struct{}) {
+	unblock = make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	t.Cleanup(backend.Close)
+	reverseProxy = New(CircuitBreaker(limits))
+	backendPath := &state.BackendPath{Namespace: "default", ServiceName: "svc", ServicePort: 8080, Endpoints: []string{backend.Listener.Addr().String()}}
+	err := reverseProxy.LoadIngressState(state.IngressState{dummyHost: &state.DomainConfig{DefaultBackend: backendPath}})
+	require.NoError(t, err)
+	lb = reverseProxy.state.Load().loadBalancers[connectionLimitedKey(loadBalancerKey(backendPath), limits.MaxConnections)]
+	go serveRetryRequest(reverseProxy, http.MethodGet)
+	return reverseProxy, lb, unblock
+}
+
+func TestCircuitBreakerMaxRequests(t *testing.T) {
+	reverseProxy, lb, unblock := serveBlocked(t, state.CircuitBreaker{MaxRequests: 1})
+	defer close(unblock)
+	require.Eventually(t, lb.requests.isOpen, time.Second, time.Millisecond)
+	w := serveRetryRequest(reverseProxy, http.MethodGet)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, circuitBreakerRetryAfter, w.Header().Get("Retry-After"))
+}
+
+func TestCircuitBreakerMaxConnections(t *testing.T) {
+	reverseProxy, lb, unblock := serveBlocked(t, state.CircuitBreaker{MaxConnections: 1})
+	defer close(unblock)
+	require.NotNil(t, lb.connections)
+	require.Eventually(t, lb.connections.isOpen, time.Second, time.Millisecond)
+	w := serveRetryRequest(reverseProxy, http.MethodGet)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, circuitBreakerRetryAfter, w.Header().Get("Retry-After"))
+	require.Equal(t, int64(1), lb.connections.rejections.Load())
+}
@@ -0,0 +1,78 @@
+package revproxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// HeaderClientCertSubject carries the subject of the verified client certificate to the backend
+	HeaderClientCertSubject = "X-Client-Cert-Subject"
+	// HeaderClientCertFingerprint carries the hex encoded SHA-256 fingerprint of the verified client certificate to the backend
+	HeaderClientCertFingerprint = "X-Client-Cert-Fingerprint"
+)
+
+// clientAuthConfig is the mutual TLS client authentication of a host
+type clientAuthConfig struct {
+	clientCAs  *x509.CertPool
+	clientAuth tls.ClientAuthType
+}
+
+// getClientAuthConfigs collects the client authentication configs from the ingress state keyed by host.
+// Invalid CA certificates result in an empty pool, which rejects all client certificates.
+func getClientAuthConfigs(ingressState state.IngressState) map[string]*clientAuthConfig {
+	configs := make(map[string]*clientAuthConfig)
+	for host, domainConfig := range ingressState {
+		if domainConfig.ClientAuth == nil {
+			continue
+		}
+		config := &clientAuthConfig{clientCAs: x509.NewCertPool(), clientAuth: tls.RequireAndVerifyClientCert}
+		if domainConfig.ClientAuth.Mode == state.ClientAuthOptional {
+			config.clientAuth = tls.VerifyClientCertIfGiven
+		}
+		if !config.clientCAs.AppendCertsFromPEM(domainConfig.ClientAuth.CaCerts) {
+			log.Warn().Msgf("no valid client CA certificates for host %s, all client certificates are rejected", host)
+		}
+		configs[host] = config
+	}
+	return configs
+}
+
+// serveClientAuth removes client provided client certificate headers and sets them from the verified client certificate.
+// Returns false if the request has already been answered, as the host requires client authentication that did not
+// take place during the TLS handshake, e.g. because the SNI differs from the host header.
+func serveClientAuth(clientAuthConfigs map[string]*clientAuthConfig, w http.ResponseWriter, r *http.Request) bool {
+	r.Header.Del(HeaderClientCertSubject)
+	r.Header.Del(HeaderClientCertFingerprint)
+	clientAuth, ok := lookupHost(clientAuthConfigs, hostWithoutPort(r.Host))
+	if !ok {
+		return true
+	}
+	if r.TLS == nil {
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	handshakeClientAuth, ok := lookupHost(clientAuthConfigs, r.TLS.ServerName)
+	if !ok || handshakeClientAuth != clientAuth {
+		w.WriteHeader(http.StatusMisdirectedRequest)
+		return false
+	}
+	if len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		if clientAuth.clientAuth == tls.RequireAndVerifyClientCert {
+			w.WriteHeader(http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	fingerprint := sha256.Sum256(leaf.Raw)
+	r.Header.Set(HeaderClientCertSubject, leaf.Subject.String())
+	r.Header.Set(HeaderClientCertFingerprint, hex.EncodeToString(fingerprint[:]))
+	return true
+}
@@ -0,0 +1,96 @@
+package revproxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/stretchr/testify/require"
+)
+
+const clientAuthHost = "admin.example.com"
+
+func TestGetClientAuthConfigs(t *testing.T) {
+	configs := getClientAuthConfigs(state.IngressState{
+		dummyHost:        &state.DomainConfig{},
+		clientAuthHost:   &state.DomainConfig{ClientAuth: &state.ClientAuth{Mode: state.ClientAuthRequired}},
+		"*.example.com":  &state.DomainConfig{ClientAuth: &state.ClientAuth{Mode: state.ClientAuthOptional}},
+		"ca.example.com": &state.DomainConfig{ClientAuth: &state.ClientAuth{CaCerts: []byte("invalid")}},
+	})
+	require.Len(t, configs, 3)
+	require.Equal(t, tls.RequireAndVerifyClientCert, configs[clientAuthHost].clientAuth)
+	require.Equal(t, tls.VerifyClientCertIfGiven, configs["*.example.com"].clientAuth)
+	require.Equal(t, tls.RequireAndVerifyClientCert, configs["ca.example.com"].clientAuth)
+}
+
+func TestGetConfigForClient(t *testing.T) {
+	reverseProxy := getDummyReverseProxy(t, nil)
+	clientCAs := x509.NewCertPool()
+	reverseProxy.state.Load().clientAuthConfigs = map[string]*clientAuthConfig{
+		clientAuthHost: {clientCAs: clientCAs, clientAuth: tls.RequireAndVerifyClientCert},
+	}
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	base.GetConfigForClient = reverseProxy.GetConfigForClientFunc(base)
+
+	config, err := base.GetConfigForClient(&tls.ClientHelloInfo{ServerName: dummyHost})
+	require.NoError(t, err)
+	require.Nil(t, config)
+	config, err = base.GetConfigForClient(&tls.ClientHelloInfo{ServerName: clientAuthHost})
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+	require.Same(t, clientCAs, config.ClientCAs)
+	require.Equal(t, base.MinVersion, config.MinVersion)
+	require.Nil(t, config.GetConfigForClient)
+	require.Equal(t, tls.NoClientCert, base.ClientAuth)
+}
+
+func TestServeClientAuth(t *testing.T) {
+	required := &clientAuthConfig{clientAuth: tls.RequireAndVerifyClientCert}
+	optional := &clientAuthConfig{clientAuth: tls.VerifyClientCertIfGiven}
+	configs := map[string]*clientAuthConfig{
+		clientAuthHost:         required,
+		"optional.example.com": optional,
+	}
+	clientCert := &x509.Certificate{Raw: []byte("dummy"), Subject: pkix.Name{CommonName: "client"}}
+	fingerprint := sha256.Sum256(clientCert.Raw)
+
+	for _, testCase := range []struct {
+		name           string
+		host           string
+		tlsState       *tls.ConnectionState
+		expectedOk     bool
+		expectedStatus int
+		expectedHeader bool
+	}{
+		{name: "no client auth", host: dummyHost, expectedOk: true},
+		{name: "verified", host: clientAuthHost, tlsState: &tls.ConnectionState{ServerName: clientAuthHost, VerifiedChains: [][]*x509.Certificate{{clientCert}}},
+			expectedOk: true, expectedHeader: true},
+		{name: "no tls", host: clientAuthHost, expectedStatus: http.StatusForbidden},
+		{name: "other sni", host: clientAuthHost, tlsState: &tls.ConnectionState{ServerName: dummyHost}, expectedStatus: http.StatusMisdirectedRequest},
+		{name: "no client cert", host: clientAuthHost, tlsState: &tls.ConnectionState{ServerName: clientAuthHost}, expectedStatus: http.StatusForbidden},
+		{name: "optional", host: "optional.example.com", tlsState: &tls.ConnectionState{ServerName: "optional.example.com"}, expectedOk: true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			w, r, _ := getDefaultHandlerMocks()
+			r.Host = testCase.host
+			r.TLS = testCase.tlsState
+			r.Header.Set(HeaderClientCertSubject, "spoofed")
+			ok := serveClientAuth(configs, w, r)
+			require.Equal(t, testCase.expectedOk, ok)
+			if !ok {
+				require.Equal(t, testCase.expectedStatus, w.Code)
+			}
+			if testCase.expectedHeader {
+				require.Equal(t, clientCert.Subject.String(), r.Header.Get(HeaderClientCertSubject))
+				require.Equal(t, hex.EncodeToString(fingerprint[:]), r.Header.Get(HeaderClientCertFingerprint))
+			} else {
+				require.Empty(t, r.Header.Get(HeaderClientCertSubject))
+			}
+		})
+	}
+}
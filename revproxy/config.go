@@ -1,44 +1,169 @@
 package revproxy
 
 import (
+	"crypto/tls"
 	"time"
+
+	"github.com/ngergs/ingress/v2/state"
 )
 
 // Config is a data structure that holds the config options for the reverse proxy
 type Config struct {
-	// BackendTimeout is timeout for each proxied request to the respective backend.
-	// Defaults to 20 seconds.
+	// BackendTimeout is the timeout for connecting to the backends. Takes precedence over Timeouts.Connect if set.
+	//
+	// Deprecated: use Timeouts.Connect.
 	BackendTimeout time.Duration
-	DnsAddr        string
+	// Timeouts are the default timeouts of the proxied requests, see state.AnnotationConnectTimeout. Zero values are disabled.
+	// Defaults to a connect timeout of 20 seconds.
+	Timeouts state.Timeouts
+	DnsAddr  string
+	// LoadBalancing is the algorithm used to distribute the requests across the endpoints of a backend service.
+	// Defaults to round-robin.
+	LoadBalancing LoadBalancingAlgorithm
+	// AcmeSolver answers the ACME challenges of the built-in ACME client. Optional.
+	AcmeSolver ChallengeSolver
+	// DefaultCertificate is used for hosts without certificate and clients without SNI,
+	// unless the ingress state contains a default certificate. Optional.
+	DefaultCertificate *tls.Certificate
+	// Metrics are the prometheus metrics of the reverse proxy, see RegisterMetrics. Optional.
+	Metrics *PrometheusMetrics
+	// Retries is the default maximum number of retries of failed idempotent requests without body at other endpoints, see state.AnnotationRetries.
+	// Defaults to 2.
+	Retries int
+	// RetryOn are the failures that are retried by default. Defaults to state.RetryOnConnectFailure.
+	RetryOn state.RetryOn
+	// RetryBackoff is the base of the exponential backoff between retries. Defaults to 25 milliseconds.
+	RetryBackoff time.Duration
+	// RetryBudget is the maximum ratio of retries to requests across all backends. Defaults to 0.2.
+	RetryBudget float64
+	// CircuitBreaker are the default limits of the load on each backend, see state.AnnotationMaxRequests. Zero values are unlimited.
+	CircuitBreaker state.CircuitBreaker
+	// RateLimitStore holds the token buckets of the rate limits, see state.AnnotationRateLimit.
+	// Defaults to a MemoryTokenBucketStore.
+	RateLimitStore TokenBucketStore
 }
 
 //nolint:gomnd
 var defaultConfig = Config{
-	BackendTimeout: time.Duration(20) * time.Second,
+	Timeouts:      state.Timeouts{Connect: time.Duration(20) * time.Second},
+	LoadBalancing: RoundRobin,
+	Retries:       2,
+	RetryOn:       state.RetryOnConnectFailure,
+	RetryBackoff:  time.Duration(25) * time.Millisecond,
+	RetryBudget:   0.2,
 }
 
 // ConfigOption is used to implement the functional parameter pattern for the reverse proxy
 type ConfigOption func(*Config)
 
-// BackendTimeout sets the timeout for waiting for the backend response for the reverse proxy
+// BackendTimeout sets the timeout for connecting to the backends.
+//
+// Deprecated: use Timeouts.
 func BackendTimeout(timeout time.Duration) ConfigOption {
 	return func(config *Config) {
 		config.BackendTimeout = timeout
 	}
 }
 
+// Timeouts sets the default timeouts of the proxied requests
+func Timeouts(timeouts state.Timeouts) ConfigOption {
+	return func(config *Config) {
+		config.Timeouts = timeouts
+	}
+}
+
+// LoadBalancing sets the algorithm used to distribute the requests across the endpoints of a backend service
+func LoadBalancing(algorithm LoadBalancingAlgorithm) ConfigOption {
+	return func(config *Config) {
+		config.LoadBalancing = algorithm
+	}
+}
+
+// AcmeSolver sets the solver that answers the HTTP-01 and TLS-ALPN-01 challenges of the built-in ACME client
+func AcmeSolver(solver ChallengeSolver) ConfigOption {
+	return func(config *Config) {
+		config.AcmeSolver = solver
+	}
+}
+
+// DefaultCertificate sets the certificate for hosts without certificate and clients without SNI
+func DefaultCertificate(cert *tls.Certificate) ConfigOption {
+	return func(config *Config) {
+		config.DefaultCertificate = cert
+	}
+}
+
+// Metrics sets the prometheus metrics of the reverse proxy
+func Metrics(metrics *PrometheusMetrics) ConfigOption {
+	return func(config *Config) {
+		config.Metrics = metrics
+	}
+}
+
+// Retries sets the default maximum number of retries of failed idempotent requests and the retried failures
+func Retries(retries int, retryOn state.RetryOn) ConfigOption {
+	return func(config *Config) {
+		config.Retries = retries
+		config.RetryOn = retryOn
+	}
+}
+
+// RetryBackoff sets the base of the exponential backoff between retries
+func RetryBackoff(backoff time.Duration) ConfigOption {
+	return func(config *Config) {
+		config.RetryBackoff = backoff
+	}
+}
+
+// RetryBudget sets the maximum ratio of retries to requests across all backends
+func RetryBudget(ratio float64) ConfigOption {
+	return func(config *Config) {
+		config.RetryBudget = ratio
+	}
+}
+
+// CircuitBreaker sets the default limits of the load on each backend
+func CircuitBreaker(limits state.CircuitBreaker) ConfigOption {
+	return func(config *Config) {
+		config.CircuitBreaker = limits
+	}
+}
+
+// RateLimitStore sets the store of the token buckets of the rate limits, e.g. a RedisTokenBucketStore to share them between replicas
+func RateLimitStore(store TokenBucketStore) ConfigOption {
+	return func(config *Config) {
+		config.RateLimitStore = store
+	}
+}
+
 // applyOptions applied the given variadic options to the config.
 // the argument config option is modified, the returned value is only for ease of use.
+// The deprecated BackendTimeout is mapped to Timeouts.Connect.
 func (config *Config) applyOptions(options ...ConfigOption) *Config {
 	for _, option := range options {
 		option(config)
 	}
+	if config.BackendTimeout != 0 {
+		config.Timeouts.Connect = config.BackendTimeout
+	}
 	return config
 }
 
 // clone creates a deep copy of the config
 func (config *Config) clone() *Config {
 	return &Config{
-		BackendTimeout: config.BackendTimeout,
+		BackendTimeout:     config.BackendTimeout,
+		Timeouts:           config.Timeouts,
+		DnsAddr:            config.DnsAddr,
+		LoadBalancing:      config.LoadBalancing,
+		AcmeSolver:         config.AcmeSolver,
+		DefaultCertificate: config.DefaultCertificate,
+		Metrics:            config.Metrics,
+		Retries:            config.Retries,
+		RetryOn:            config.RetryOn,
+		RetryBackoff:       config.RetryBackoff,
+		RetryBudget:        config.RetryBudget,
+		CircuitBreaker:     config.CircuitBreaker,
+		RateLimitStore:     config.RateLimitStore,
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ngergs/ingress/v2/state"
 	"github.com/stretchr/testify/require"
 )
 
@@ -19,3 +20,23 @@ func TestConfig(t *testing.T) {
 	require.Equal(t, time.Duration(0), config.BackendTimeout)
 	require.Equal(t, timeout, configWithTimeout.BackendTimeout)
 }
+
+func TestConfigTimeouts(t *testing.T) {
+	config := &Config{
+		Timeouts: state.Timeouts{Connect: time.Duration(0)},
+	}
+	timeout := time.Duration(10) * time.Second
+	configWithTimeout := config.clone()
+	require.Equal(t, config, configWithTimeout)
+	configWithTimeout.applyOptions(Timeouts(state.Timeouts{Connect: timeout}))
+	// make sure that clone worked and the original config has not been changed
+	require.Equal(t, time.Duration(0), config.Timeouts.Connect)
+	require.Equal(t, timeout, configWithTimeout.Timeouts.Connect)
+}
+
+func TestConfigBackendTimeout(t *testing.T) {
+	timeout := time.Duration(5) * time.Second
+	config := defaultConfig.clone().applyOptions(BackendTimeout(timeout))
+	require.Equal(t, timeout, config.Timeouts.Connect)
+	require.Equal(t, timeout, New(BackendTimeout(timeout)).timeouts.Connect)
+}
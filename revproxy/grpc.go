@@ -0,0 +1,195 @@
+package revproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	grpcContentType     = "application/grpc"
+	headerGrpcStatus    = "Grpc-Status"
+	headerGrpcMessage   = "Grpc-Message"
+	headerGrpcTimeout   = "Grpc-Timeout"
+	grpcUnmatchedMethod = "unmatched"
+	// grpcMaxTimeoutDigits is the maximum number of digits of the grpc-timeout value
+	grpcMaxTimeoutDigits = 8
+	// grpcMaxMethodLabels is the maximum number of distinct methods in the metric labels, further methods are reported as grpcUnmatchedMethod
+	grpcMaxMethodLabels = 1000
+)
+
+// grpcMethodPattern matches the paths of gRPC methods, i.e. /package.Service/Method
+var grpcMethodPattern = regexp.MustCompile(`^/[A-Za-z_][A-Za-z0-9_.]*/[A-Za-z_][A-Za-z0-9_]*$`)
+
+// grpcCode is a gRPC status code, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+type grpcCode int
+
+//nolint:gomnd
+const (
+	grpcOk               grpcCode = 0
+	grpcUnknown          grpcCode = 2
+	grpcDeadlineExceeded grpcCode = 4
+	grpcPermissionDenied grpcCode = 7
+	grpcUnimplemented    grpcCode = 12
+	grpcInternal         grpcCode = 13
+	grpcUnavailable      grpcCode = 14
+	grpcUnauthenticated  grpcCode = 16
+)
+
+// grpcCodeNames are the names of the gRPC status codes indexed by their value
+var grpcCodeNames = []string{"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND", "ALREADY_EXISTS",
+	"PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED", "INTERNAL",
+	"UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED"}
+
+// String returns the name of the code, the number for unknown codes
+func (code grpcCode) String() string {
+	if code >= 0 && int(code) < len(grpcCodeNames) {
+		return grpcCodeNames[code]
+	}
+	return strconv.Itoa(int(code))
+}
+
+// grpcCodeFromHttp maps the HTTP status to a gRPC status code as defined in https://github.com/grpc/grpc/blob/master/doc/http-grpc-status-mapping.md
+func grpcCodeFromHttp(status int) grpcCode {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcInternal
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return grpcUnavailable
+	case http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	default:
+		return grpcUnknown
+	}
+}
+
+// isGrpcRequest returns whether the request is a gRPC request by its content type, e.g. application/grpc or application/grpc+proto
+func isGrpcRequest(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return contentType == grpcContentType || strings.HasPrefix(contentType, grpcContentType+"+") || strings.HasPrefix(contentType, grpcContentType+";")
+}
+
+// parseGrpcTimeout parses the value of the grpc-timeout header, e.g. 100m for 100 milliseconds
+func parseGrpcTimeout(value string) (timeout time.Duration, ok bool) {
+	if len(value) < 2 || len(value) > grpcMaxTimeoutDigits+1 {
+		return 0, false
+	}
+	amount, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || amount < 0 {
+		return 0, false
+	}
+	var unit time.Duration
+	switch value[len(value)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, false
+	}
+	return time.Duration(amount) * unit, true
+}
+
+// serveGrpc proxies a gRPC request. The grpc-timeout header bounds the time for the backend response.
+// HTTP errors of the reverse proxy and the backends are answered with the corresponding gRPC status, see grpcResponseWriter.
+func (proxy *ReverseProxy) serveGrpc(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if timeout, ok := parseGrpcTimeout(r.Header.Get(headerGrpcTimeout)); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	grpcWriter := &grpcResponseWriter{ResponseWriter: w, ctx: r.Context()}
+	routed := proxy.serveProxying(grpcWriter, r)
+	method := grpcUnmatchedMethod
+	if routed && grpcMethodPattern.MatchString(r.URL.Path) {
+		method = r.URL.Path
+	}
+	proxy.metrics.observeGrpcRequest(method, grpcWriter.status(), time.Since(start))
+}
+
+// grpcResponseWriter converts HTTP error responses into trailers-only gRPC responses with HTTP status 200, as gRPC clients
+// only report unspecific errors for other HTTP status codes. The body of converted responses is discarded.
+type grpcResponseWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+	// converted is the gRPC status of a converted HTTP error response, nil if the response has not been converted
+	converted *grpcCode
+}
+
+func (w *grpcResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	if status < http.StatusOK {
+		// informational responses
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+	if status == http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	code := grpcCodeFromHttp(status)
+	if errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		code = grpcDeadlineExceeded
+	}
+	w.converted = &code
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Type", grpcContentType)
+	header.Set(headerGrpcStatus, strconv.Itoa(int(code)))
+	header.Set(headerGrpcMessage, strconv.Itoa(status)+" "+http.StatusText(status))
+	w.ResponseWriter.WriteHeader(http.StatusOK)
+}
+
+func (w *grpcResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.converted != nil {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the wrapped response writer for the http.ResponseController, e.g. to flush streamed responses
+func (w *grpcResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// status returns the gRPC status of the response. The status of the backend is read from the trailers.
+func (w *grpcResponseWriter) status() grpcCode {
+	if w.converted != nil {
+		return *w.converted
+	}
+	value := w.Header().Get(headerGrpcStatus)
+	if value == "" {
+		value = w.Header().Get(http.TrailerPrefix + headerGrpcStatus)
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return grpcUnknown
+	}
+	return grpcCode(code)
+}
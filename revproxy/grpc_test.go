@@ -0,0 +1,122 @@
+package revproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const grpcMethod = "/test.Service/Method"
+
+func TestParseGrpcTimeout(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"1H":        time.Hour,
+		"2M":        time.Duration(2) * time.Minute,
+		"3S":        time.Duration(3) * time.Second,
+		"100m":      time.Duration(100) * time.Millisecond,
+		"5u":        time.Duration(5) * time.Microsecond,
+		"99999999n": time.Duration(99999999),
+	} {
+		timeout, ok := parseGrpcTimeout(value)
+		require.True(t, ok, value)
+		require.Equal(t, expected, timeout)
+	}
+	for _, value := range []string{"", "S", "10", "10s", "-1S", "123456789S"} {
+		_, ok := parseGrpcTimeout(value)
+		require.False(t, ok, value)
+	}
+}
+
+// serveGrpcRequest sends a gRPC request with the optional grpc-timeout via the reverse proxy
+func serveGrpcRequest(reverseProxy *ReverseProxy, host string, timeout string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "http://"+host+grpcMethod, nil)
+	r.Header.Set("Content-Type", "application/grpc+proto")
+	if timeout != "" {
+		r.Header.Set(headerGrpcTimeout, timeout)
+	}
+	reverseProxy.GetHandlerProxying().ServeHTTP(w, r)
+	return w
+}
+
+// requireGrpcStatus checks for a successful HTTP response with the gRPC status in the headers or trailers
+func requireGrpcStatus(t *testing.T, w *httptest.ResponseRecorder, expected grpcCode) {
+	require.Equal(t, http.StatusOK, w.Code)
+	status := w.Result().Header.Get(headerGrpcStatus)
+	if status == "" {
+		status = w.Result().Trailer.Get(headerGrpcStatus)
+	}
+	require.Equal(t, strconv.Itoa(int(expected)), status)
+}
+
+func TestGrpcProxying(t *testing.T) {
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(headerGrpcTimeout) != "" {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Trailer", headerGrpcStatus)
+		w.Header().Set("Content-Type", grpcContentType)
+		_, _ = w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set(headerGrpcStatus, "0")
+	}), &http2.Server{}))
+	defer backend.Close()
+	metrics, err := RegisterMetrics(prometheus.NewRegistry(), "test")
+	require.NoError(t, err)
+	reverseProxy := New(Metrics(metrics))
+	getBackend := func(serviceName string, endpoints ...string) *state.BackendPath {
+		return &state.BackendPath{Namespace: "default", ServiceName: serviceName, ServicePort: 8080, Protocol: state.BackendProtocolGrpc, Endpoints: endpoints}
+	}
+	err = reverseProxy.LoadIngressState(state.IngressState{
+		dummyHost:                  &state.DomainConfig{DefaultBackend: getBackend("svc", backend.Listener.Addr().String())},
+		"unavailable." + dummyHost: &state.DomainConfig{DefaultBackend: getBackend("other")},
+	})
+	require.NoError(t, err)
+
+	requireGrpcStatus(t, serveGrpcRequest(reverseProxy, dummyHost, ""), grpcOk)
+	requireGrpcStatus(t, serveGrpcRequest(reverseProxy, "unknown."+dummyHost, ""), grpcUnimplemented)
+	requireGrpcStatus(t, serveGrpcRequest(reverseProxy, "unavailable."+dummyHost, ""), grpcUnavailable)
+	w := serveGrpcRequest(reverseProxy, dummyHost, "10m")
+	requireGrpcStatus(t, w, grpcDeadlineExceeded)
+	require.Empty(t, w.Body.Bytes())
+
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.grpcRequests.WithLabelValues(grpcMethod, "OK")))
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.grpcRequests.WithLabelValues(grpcMethod, "DEADLINE_EXCEEDED")))
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.grpcRequests.WithLabelValues(grpcMethod, "UNAVAILABLE")))
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.grpcRequests.WithLabelValues(grpcUnmatchedMethod, "UNIMPLEMENTED")))
+	require.Equal(t, 2, testutil.CollectAndCount(metrics.grpcDurations))
+}
+
+func TestGrpcMethodLabels(t *testing.T) {
+	metrics, err := RegisterMetrics(prometheus.NewRegistry(), "test")
+	require.NoError(t, err)
+	require.True(t, grpcMethodPattern.MatchString(grpcMethod))
+	require.False(t, grpcMethodPattern.MatchString("/index.html"))
+	require.False(t, grpcMethodPattern.MatchString("/pkg.Service/Method/other"))
+
+	for i := range grpcMaxMethodLabels {
+		require.Equal(t, fmt.Sprintf("/pkg.Service/Method%d", i), metrics.grpcMethodLabel(fmt.Sprintf("/pkg.Service/Method%d", i)))
+	}
+	require.Equal(t, grpcUnmatchedMethod, metrics.grpcMethodLabel(grpcMethod))
+	require.Equal(t, "/pkg.Service/Method0", metrics.grpcMethodLabel("/pkg.Service/Method0"))
+}
+
+func TestNonGrpcErrorsUnchanged(t *testing.T) {
+	reverseProxy := New()
+	err := reverseProxy.LoadIngressState(state.IngressState{})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	reverseProxy.GetHandlerProxying().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://"+dummyHost+grpcMethod, nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Empty(t, w.Header().Get(headerGrpcStatus))
+}
@@ -0,0 +1,213 @@
+package revproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrHealthCheckStatus = errors.New("unhealthy response status")
+
+//nolint:gomnd
+const (
+	// healthCheckMaxTimeout limits the timeout of a health probe, which is otherwise the interval
+	healthCheckMaxTimeout = time.Duration(5) * time.Second
+	// maxEjectionMultiplier limits the growth of the ejection time of repeatedly ejected endpoints
+	maxEjectionMultiplier = 10
+)
+
+// healthConfig is the health check and outlier detection of a load balancer
+type healthConfig struct {
+	healthCheck      *state.HealthCheck
+	outlierDetection *state.OutlierDetection
+}
+
+// setHealthConfig sets the health check and the outlier detection of the load balancer.
+// The outlier detection applies immediately, the health checks are started by applyHealthChecks.
+func (lb *loadBalancer) setHealthConfig(healthCheck *state.HealthCheck, outlierDetection *state.OutlierDetection) {
+	if healthCheck == nil && outlierDetection == nil {
+		lb.healthConfig.Store(nil)
+		return
+	}
+	lb.healthConfig.Store(&healthConfig{healthCheck: healthCheck, outlierDetection: outlierDetection})
+}
+
+// availableEndpoints returns the endpoints that are neither unhealthy nor ejected.
+// If no endpoint is available all endpoints are returned, as trying an unhealthy endpoint is better than failing all requests.
+func (lb *loadBalancer) availableEndpoints(now time.Time) []*endpoint {
+	endpoints := *lb.endpoints.Load()
+	if lb.healthConfig.Load() == nil {
+		return endpoints
+	}
+	available := make([]*endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if ep.available(now) {
+			available = append(available, ep)
+		}
+	}
+	if len(available) == 0 {
+		return endpoints
+	}
+	return available
+}
+
+// available returns whether the endpoint is healthy and not ejected
+func (ep *endpoint) available(now time.Time) bool {
+	return ep.healthy.Load() && now.UnixNano() >= ep.ejectedUntil.Load()
+}
+
+// recordResult records the result of a proxied request for the outlier detection. The endpoint is ejected after the configured
+// number of consecutive errors. The ejection time grows with the number of ejections unless the last one is long ago.
+func (lb *loadBalancer) recordResult(ep *endpoint, success bool, now time.Time) {
+	config := lb.healthConfig.Load()
+	if config == nil || config.outlierDetection == nil {
+		return
+	}
+	if success {
+		ep.consecutiveErrors.Store(0)
+		return
+	}
+	if ep.consecutiveErrors.Add(1) != int64(config.outlierDetection.ConsecutiveErrors) {
+		return
+	}
+	ep.consecutiveErrors.Store(0)
+	baseEjectionTime := config.outlierDetection.BaseEjectionTime
+	if now.UnixNano()-ep.ejectedUntil.Load() > int64(maxEjectionMultiplier*baseEjectionTime) {
+		ep.ejections.Store(0)
+	}
+	ejectionTime := time.Duration(min(ep.ejections.Add(1), maxEjectionMultiplier)) * baseEjectionTime
+	ep.ejectedUntil.Store(now.Add(ejectionTime).UnixNano())
+	ep.ejectionsTotal.Add(1)
+	log.Warn().Msgf("ejected endpoint %s for %s after %d consecutive errors", ep.address, ejectionTime, config.outlierDetection.ConsecutiveErrors)
+}
+
+// applyHealthChecks starts, restarts or stops the health checker according to the health config.
+// The health state of the endpoints is reset if the health check changes.
+func (lb *loadBalancer) applyHealthChecks() {
+	lb.healthLock.Lock()
+	defer lb.healthLock.Unlock()
+	var healthCheck *state.HealthCheck
+	if config := lb.healthConfig.Load(); config != nil {
+		healthCheck = config.healthCheck
+	}
+	running := lb.healthChecker
+	if running == nil && healthCheck == nil {
+		return
+	}
+	if running != nil && healthCheck != nil && *running.config == *healthCheck {
+		return
+	}
+	if running != nil {
+		close(running.stop)
+		lb.healthChecker = nil
+		for _, ep := range *lb.endpoints.Load() {
+			ep.healthy.Store(true)
+			ep.probeSuccesses.Store(0)
+			ep.probeFailures.Store(0)
+		}
+	}
+	if healthCheck != nil {
+		lb.healthChecker = newHealthChecker(lb, healthCheck)
+		go lb.healthChecker.run()
+	}
+}
+
+// stopHealthChecks stops the health checker, used once the load balancer is not part of the state anymore
+func (lb *loadBalancer) stopHealthChecks() {
+	lb.healthLock.Lock()
+	defer lb.healthLock.Unlock()
+	if lb.healthChecker != nil {
+		close(lb.healthChecker.stop)
+		lb.healthChecker = nil
+	}
+}
+
+// healthChecker probes the endpoints of a load balancer in the configured interval till it is stopped
+type healthChecker struct {
+	lb     *loadBalancer
+	config *state.HealthCheck
+	client *http.Client
+	stop   chan struct{}
+}
+
+// newHealthChecker returns a health checker that uses the probe transport of the load balancer, so that the probes use the backend protocol
+// but do not count against the connection limit
+func newHealthChecker(lb *loadBalancer, config *state.HealthCheck) *healthChecker {
+	return &healthChecker{
+		lb:     lb,
+		config: config,
+		client: &http.Client{
+			Transport: lb.probeTransport,
+			Timeout:   min(config.Interval, healthCheckMaxTimeout),
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		stop: make(chan struct{}),
+	}
+}
+
+// run probes the endpoints till the health checker is stopped
+func (checker *healthChecker) run() {
+	ticker := time.NewTicker(checker.config.Interval)
+	defer ticker.Stop()
+	for {
+		checker.probeAll()
+		select {
+		case <-checker.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeAll probes the current endpoints concurrently and updates their health state
+func (checker *healthChecker) probeAll() {
+	var wg sync.WaitGroup
+	for _, ep := range *checker.lb.endpoints.Load() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checker.record(ep, checker.probe(ep))
+		}()
+	}
+	wg.Wait()
+}
+
+// probe requests the health check path of the endpoint, status codes below 400 are healthy
+func (checker *healthChecker) probe(ep *endpoint) error {
+	response, err := checker.client.Get(checker.lb.scheme + "://" + ep.address + checker.config.Path)
+	if err != nil {
+		return err
+	}
+	// drained so that the connection is reused for the next probe
+	_, _ = io.Copy(io.Discard, response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%w: %d", ErrHealthCheckStatus, response.StatusCode)
+	}
+	return nil
+}
+
+// record updates the health state of the endpoint once the healthy or unhealthy threshold of consecutive probe results is reached
+func (checker *healthChecker) record(ep *endpoint, err error) {
+	if err == nil {
+		ep.probeFailures.Store(0)
+		if ep.probeSuccesses.Add(1) >= int64(checker.config.HealthyThreshold) && !ep.healthy.Load() {
+			ep.healthy.Store(true)
+			log.Info().Msgf("endpoint %s is healthy again", ep.address)
+		}
+		return
+	}
+	ep.probeSuccesses.Store(0)
+	if ep.probeFailures.Add(1) >= int64(checker.config.UnhealthyThreshold) && ep.healthy.Load() {
+		ep.healthy.Store(false)
+		log.Warn().Err(err).Msgf("endpoint %s is unhealthy", ep.address)
+	}
+}
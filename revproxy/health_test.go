@@ -0,0 +1,125 @@
+package revproxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutlierEjection(t *testing.T) {
+	lb := getDummyLoadBalancer(t, RoundRobin)
+	baseEjectionTime := time.Second
+	lb.setHealthConfig(nil, &state.OutlierDetection{ConsecutiveErrors: 2, BaseEjectionTime: baseEjectionTime})
+	ep := (*lb.endpoints.Load())[0]
+	now := time.Now()
+
+	// successes reset the consecutive errors
+	lb.recordResult(ep, false, now)
+	lb.recordResult(ep, true, now)
+	lb.recordResult(ep, false, now)
+	require.True(t, ep.available(now))
+	lb.recordResult(ep, false, now)
+	require.False(t, ep.available(now))
+	require.True(t, ep.available(now.Add(baseEjectionTime)))
+	for i := 0; i < 2*len(dummyEndpoints); i++ {
+		picked, ok := lb.pick()
+		require.True(t, ok)
+		require.NotEqual(t, ep.address, picked.address)
+	}
+
+	// repeated ejections last longer
+	now = now.Add(baseEjectionTime)
+	lb.recordResult(ep, false, now)
+	lb.recordResult(ep, false, now)
+	require.False(t, ep.available(now.Add(baseEjectionTime)))
+	require.True(t, ep.available(now.Add(2*baseEjectionTime)))
+	require.Equal(t, int64(2), ep.ejectionsTotal.Load())
+}
+
+func TestAvailableEndpointsFallback(t *testing.T) {
+	lb := getDummyLoadBalancer(t, RoundRobin)
+	lb.setHealthConfig(nil, &state.OutlierDetection{ConsecutiveErrors: 1, BaseEjectionTime: time.Second})
+	now := time.Now()
+	for _, ep := range *lb.endpoints.Load() {
+		lb.recordResult(ep, false, now)
+	}
+	// trying the ejected endpoints is preferred to failing all requests
+	require.Len(t, lb.availableEndpoints(now), len(dummyEndpoints))
+}
+
+func TestActiveHealthCheck(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer unhealthy.Close()
+	metrics, err := RegisterMetrics(prometheus.NewRegistry(), "test")
+	require.NoError(t, err)
+	reverseProxy := New(Metrics(metrics))
+	backendPath := &state.BackendPath{Namespace: "default", ServiceName: "svc", ServicePort: 8080,
+		Endpoints:   []string{healthy.Listener.Addr().String(), unhealthy.Listener.Addr().String()},
+		HealthCheck: &state.HealthCheck{Path: "/healthz", Interval: time.Duration(10) * time.Millisecond, HealthyThreshold: 1, UnhealthyThreshold: 1},
+	}
+	err = reverseProxy.LoadIngressState(state.IngressState{dummyHost: &state.DomainConfig{DefaultBackend: backendPath}})
+	require.NoError(t, err)
+	lb := reverseProxy.state.Load().loadBalancers[loadBalancerKey(backendPath)]
+	require.Eventually(t, func() bool {
+		return len(lb.availableEndpoints(time.Now())) == 1
+	}, time.Second, time.Duration(10)*time.Millisecond)
+	require.Equal(t, healthy.Listener.Addr().String(), lb.availableEndpoints(time.Now())[0].address)
+
+	expected := fmt.Sprintf(`# HELP test_backend_endpoint_healthy Whether the backend endpoint is healthy and not ejected, 1 for available endpoints.
+# TYPE test_backend_endpoint_healthy gauge
+test_backend_endpoint_healthy{backend="%[1]s",endpoint="%[2]s"} 1
+test_backend_endpoint_healthy{backend="%[1]s",endpoint="%[3]s"} 0
+`, loadBalancerKey(backendPath), healthy.Listener.Addr().String(), unhealthy.Listener.Addr().String())
+	require.NoError(t, testutil.CollectAndCompare(metrics.backends, strings.NewReader(expected), "test_backend_endpoint_healthy"))
+
+	// the health checker is stopped once the backend is removed
+	err = reverseProxy.LoadIngressState(state.IngressState{})
+	require.NoError(t, err)
+	lb.healthLock.Lock()
+	defer lb.healthLock.Unlock()
+	require.Nil(t, lb.healthChecker)
+}
+
+func TestHealthCheckConnections(t *testing.T) {
+	var connections atomic.Int64
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 1<<20))
+	}))
+	backend.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+	backendPath := &state.BackendPath{Namespace: "default", ServiceName: "svc", ServicePort: 8080}
+	lb, err := newBackendLoadBalancer(RoundRobin, http.DefaultTransport.(*http.Transport).Clone(), backendPath, 1)
+	require.NoError(t, err)
+	require.NoError(t, lb.updateEndpoints([]string{backend.Listener.Addr().String()}))
+	checker := newHealthChecker(lb, &state.HealthCheck{Path: "/healthz", Interval: time.Second, HealthyThreshold: 1, UnhealthyThreshold: 1})
+
+	// the probes do not count against the connection limit of the proxied requests
+	lb.connections.open.Store(1)
+	for range 3 {
+		require.NoError(t, checker.probe((*lb.endpoints.Load())[0]))
+	}
+	require.Equal(t, int64(0), lb.connections.rejections.Load())
+	// the connection is reused as the response bodies are drained
+	require.Equal(t, int64(1), connections.Load())
+}
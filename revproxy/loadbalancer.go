@@ -0,0 +1,221 @@
+package revproxy
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrUnknownLoadBalancing = errors.New("unknown load balancing algorithm")
+
+// LoadBalancingAlgorithm determines how requests are distributed across the endpoints of a backend service
+type LoadBalancingAlgorithm string
+
+const (
+	// RoundRobin cycles through the ready endpoints
+	RoundRobin LoadBalancingAlgorithm = "round-robin"
+	// LeastConnections selects the endpoint with the fewest in-flight requests
+	LeastConnections LoadBalancingAlgorithm = "least-connections"
+	// RandomOfTwo selects two random endpoints and uses the one with fewer in-flight requests
+	RandomOfTwo LoadBalancingAlgorithm = "random-of-two"
+)
+
+// ParseLoadBalancing returns the LoadBalancingAlgorithm for the given name
+func ParseLoadBalancing(name string) (LoadBalancingAlgorithm, error) {
+	algorithm := LoadBalancingAlgorithm(name)
+	switch algorithm {
+	case RoundRobin, LeastConnections, RandomOfTwo:
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownLoadBalancing, name)
+	}
+}
+
+// endpoint is a single ready pod endpoint of a backend service
+type endpoint struct {
+	address string
+	proxy   *httputil.ReverseProxy
+	// active is the number of in-flight requests
+	active atomic.Int64
+	// healthy is the result of the active health probes, true without health check
+	healthy atomic.Bool
+	// probeSuccesses and probeFailures are the consecutive results of the active health probes
+	probeSuccesses atomic.Int64
+	probeFailures  atomic.Int64
+	// consecutiveErrors are the 5xx responses and connection errors since the last success
+	consecutiveErrors atomic.Int64
+	// ejectedUntil is the unix time in nanoseconds till which the endpoint is ejected by the outlier detection
+	ejectedUntil atomic.Int64
+	// ejections is the number of recent ejections that multiplies the ejection time, ejectionsTotal is never reset
+	ejections      atomic.Int64
+	ejectionsTotal atomic.Int64
+}
+
+// loadBalancer distributes requests across the endpoints of a single backend service port.
+// It is shared by all paths that reference the same service port and reused across state updates
+// so that the in-flight request counters survive reloads.
+type loadBalancer struct {
+	algorithm LoadBalancingAlgorithm
+	// scheme is the URL scheme of the endpoints, https for backend protocols with TLS
+	scheme    string
+	transport http.RoundTripper
+	// probeTransport is used by the health checks, the transport without connection limit
+	probeTransport http.RoundTripper
+	endpoints      atomic.Pointer[[]*endpoint]
+	next           atomic.Uint64
+	// healthConfig is the desired health check and outlier detection, see setHealthConfig
+	healthConfig atomic.Pointer[healthConfig]
+	// healthChecker probes the endpoints if a health check is configured, see applyHealthChecks
+	healthChecker *healthChecker
+	healthLock    sync.Mutex
+	// requests limits the concurrent requests, see setRequestLimits
+	requests requestLimiter
+	// connections limits the open connections of the transport, nil without limit
+	connections *connectionLimiter
+}
+
+// newLoadBalancer returns a load balancer without any endpoints, see updateEndpoints.
+func newLoadBalancer(algorithm LoadBalancingAlgorithm, scheme string, transport http.RoundTripper) *loadBalancer {
+	lb := &loadBalancer{
+		algorithm:      algorithm,
+		scheme:         scheme,
+		transport:      transport,
+		probeTransport: transport,
+	}
+	lb.endpoints.Store(&[]*endpoint{})
+	return lb
+}
+
+// updateEndpoints sets the endpoints to the given addresses (host:port). Already known endpoints are kept including their state.
+func (lb *loadBalancer) updateEndpoints(addresses []string) error {
+	endpoints, err := lb.newEndpoints(addresses)
+	if err != nil {
+		return err
+	}
+	lb.endpoints.Store(&endpoints)
+	return nil
+}
+
+// newEndpoints returns the endpoints for the given addresses (host:port) without applying them.
+// Already known endpoints are reused including their state.
+func (lb *loadBalancer) newEndpoints(addresses []string) ([]*endpoint, error) {
+	current := make(map[string]*endpoint)
+	for _, ep := range *lb.endpoints.Load() {
+		current[ep.address] = ep
+	}
+	endpoints := make([]*endpoint, len(addresses))
+	for i, address := range addresses {
+		ep, ok := current[address]
+		if !ok {
+			target, err := url.ParseRequestURI(lb.scheme + "://" + address)
+			if err != nil {
+				return nil, err
+			}
+			ep = &endpoint{
+				address: address,
+				proxy:   httputil.NewSingleHostReverseProxy(target),
+			}
+			ep.healthy.Store(true)
+			ep.proxy.Transport = &timeoutTransport{next: lb.transport}
+			ep.proxy.ModifyResponse = func(response *http.Response) error {
+				lb.recordResult(ep, response.StatusCode < http.StatusInternalServerError, time.Now())
+				return nil
+			}
+			ep.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+				if attempt, ok := w.(*retryResponseWriter); ok {
+					attempt.err = err
+				}
+				if errors.Is(err, ErrConnectionLimit) {
+					rejectRequest(w)
+					return
+				}
+				// requests that are canceled by the client or time out are no failure of the endpoint
+				if r.Context().Err() == nil {
+					lb.recordResult(ep, false, time.Now())
+				}
+				log.Debug().Err(err).Msgf("proxy error for endpoint %s", ep.address)
+				w.WriteHeader(proxyErrorStatus(r, err))
+			}
+		}
+		endpoints[i] = ep
+	}
+	return endpoints, nil
+}
+
+// ServeHTTP proxies the request to the endpoint selected by the load balancing algorithm.
+// Responds with HTTP 503 if no ready endpoint is present or the request limits are reached, see requestLimiter.
+func (lb *loadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := lb.requests.acquire(r.Context()); err != nil {
+		rejectRequest(w)
+		return
+	}
+	defer lb.requests.release()
+	ep, ok := lb.pick()
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	ep.ServeHTTP(w, r)
+}
+
+// ServeHTTP proxies the request to the endpoint while tracking the in-flight requests
+func (ep *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ep.active.Add(1)
+	defer ep.active.Add(-1)
+	ep.proxy.ServeHTTP(w, r)
+}
+
+// pick selects an endpoint according to the load balancing algorithm. Unhealthy and ejected endpoints are skipped, see availableEndpoints.
+// The excluded endpoints are only selected if no other endpoint is available, used to retry at another endpoint.
+func (lb *loadBalancer) pick(excluded ...*endpoint) (ep *endpoint, ok bool) {
+	endpoints := lb.availableEndpoints(time.Now())
+	if len(excluded) > 0 {
+		remaining := slices.DeleteFunc(slices.Clone(endpoints), func(ep *endpoint) bool {
+			return slices.Contains(excluded, ep)
+		})
+		if len(remaining) > 0 {
+			endpoints = remaining
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, false
+	}
+	//nolint:exhaustive // round-robin is the fallback
+	switch lb.algorithm {
+	case LeastConnections:
+		// start at a rotating offset so that ties are not always resolved towards the first endpoint
+		offset := int(lb.next.Add(1) % uint64(len(endpoints)))
+		ep = endpoints[offset]
+		for i := 1; i < len(endpoints); i++ {
+			candidate := endpoints[(offset+i)%len(endpoints)]
+			if candidate.active.Load() < ep.active.Load() {
+				ep = candidate
+			}
+		}
+		return ep, true
+	case RandomOfTwo:
+		if len(endpoints) == 1 {
+			return endpoints[0], true
+		}
+		i := rand.IntN(len(endpoints))
+		j := rand.IntN(len(endpoints) - 1)
+		if j >= i {
+			j++
+		}
+		if endpoints[j].active.Load() < endpoints[i].active.Load() {
+			return endpoints[j], true
+		}
+		return endpoints[i], true
+	default:
+		return endpoints[(lb.next.Add(1)-1)%uint64(len(endpoints))], true
+	}
+}
@@ -0,0 +1,113 @@
+package revproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var dummyEndpoints = []string{"10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.3:8080"}
+
+func getDummyLoadBalancer(t *testing.T, algorithm LoadBalancingAlgorithm) *loadBalancer {
+	lb := newLoadBalancer(algorithm, "http", http.DefaultTransport)
+	err := lb.updateEndpoints(dummyEndpoints)
+	require.NoError(t, err)
+	return lb
+}
+
+func TestParseLoadBalancing(t *testing.T) {
+	for _, algorithm := range []LoadBalancingAlgorithm{RoundRobin, LeastConnections, RandomOfTwo} {
+		parsed, err := ParseLoadBalancing(string(algorithm))
+		require.NoError(t, err)
+		require.Equal(t, algorithm, parsed)
+	}
+	_, err := ParseLoadBalancing("none")
+	require.ErrorIs(t, err, ErrUnknownLoadBalancing)
+}
+
+func TestRoundRobin(t *testing.T) {
+	lb := getDummyLoadBalancer(t, RoundRobin)
+	for i := 0; i < 2*len(dummyEndpoints); i++ {
+		ep, ok := lb.pick()
+		require.True(t, ok)
+		require.Equal(t, dummyEndpoints[i%len(dummyEndpoints)], ep.address)
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	lb := getDummyLoadBalancer(t, LeastConnections)
+	endpoints := *lb.endpoints.Load()
+	endpoints[0].active.Store(2)
+	endpoints[2].active.Store(1)
+	for i := 0; i < len(dummyEndpoints); i++ {
+		ep, ok := lb.pick()
+		require.True(t, ok)
+		require.Equal(t, dummyEndpoints[1], ep.address)
+	}
+}
+
+func TestRandomOfTwo(t *testing.T) {
+	lb := getDummyLoadBalancer(t, RandomOfTwo)
+	err := lb.updateEndpoints(dummyEndpoints[:2])
+	require.NoError(t, err)
+	(*lb.endpoints.Load())[0].active.Store(1)
+	for i := 0; i < 10; i++ {
+		ep, ok := lb.pick()
+		require.True(t, ok)
+		require.Equal(t, dummyEndpoints[1], ep.address)
+	}
+}
+
+func TestUpdateEndpointsKeepsState(t *testing.T) {
+	lb := getDummyLoadBalancer(t, LeastConnections)
+	(*lb.endpoints.Load())[1].active.Store(3)
+	err := lb.updateEndpoints(dummyEndpoints[1:])
+	require.NoError(t, err)
+	endpoints := *lb.endpoints.Load()
+	require.Len(t, endpoints, 2)
+	require.Equal(t, dummyEndpoints[1], endpoints[0].address)
+	require.Equal(t, int64(3), endpoints[0].active.Load())
+}
+
+func TestLoadBalancerNoEndpoints(t *testing.T) {
+	w, r, _ := getDefaultHandlerMocks()
+	lb := newLoadBalancer(RoundRobin, "http", http.DefaultTransport)
+	lb.ServeHTTP(w, r)
+	result := w.Result()
+	defer func() {
+		err := result.Body.Close()
+		require.NoError(t, err)
+	}()
+	require.Equal(t, http.StatusServiceUnavailable, result.StatusCode)
+}
+
+func TestLoadBalancerProxying(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(r.URL.Path))
+		require.NoError(t, err)
+	}))
+	defer backend.Close()
+	backendUrl, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	lb := newLoadBalancer(LeastConnections, "http", http.DefaultTransport)
+	err = lb.updateEndpoints([]string{backendUrl.Host})
+	require.NoError(t, err)
+
+	w, r, _ := getDefaultHandlerMocks()
+	r.URL = &url.URL{Path: prefixPath}
+	lb.ServeHTTP(w, r)
+	result := w.Result()
+	defer func() {
+		err := result.Body.Close()
+		require.NoError(t, err)
+	}()
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	body, err := io.ReadAll(result.Body)
+	require.NoError(t, err)
+	require.Equal(t, prefixPath, string(body))
+	require.Equal(t, int64(0), (*lb.endpoints.Load())[0].active.Load())
+}
@@ -0,0 +1,246 @@
+package revproxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusMetrics are the prometheus metrics of the reverse proxy, see RegisterMetrics
+type PrometheusMetrics struct {
+	ocspFailures         prometheus.Counter
+	certificateNotAfters *prometheus.GaugeVec
+	grpcRequests         *prometheus.CounterVec
+	grpcDurations        *prometheus.HistogramVec
+	// grpcMethods are the methods in the labels of the gRPC metrics, limited to grpcMaxMethodLabels
+	grpcMethods          map[string]struct{}
+	grpcMethodsLock      sync.Mutex
+	backends             *backendCollector
+	backendAttempts      *prometheus.HistogramVec
+	retryBudgetExhausted prometheus.Counter
+	rateLimited          *prometheus.CounterVec
+	rateLimitStoreErrors prometheus.Counter
+}
+
+// RegisterMetrics creates the prometheus metrics of the reverse proxy and registers them with the registerer
+func RegisterMetrics(registerer prometheus.Registerer, namespace string) (*PrometheusMetrics, error) {
+	metrics := &PrometheusMetrics{
+		ocspFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ocsp_staple_failures_total",
+			Help:      "Number of failed OCSP response fetches for the stapling of the served certificates.",
+		}),
+		certificateNotAfters: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "certificate_not_after_timestamp_seconds",
+			Help:      "Expiry of the served certificates as unix timestamp.",
+		}, []string{"host", "secret"}),
+		grpcRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_requests_total",
+			Help:      "Number of proxied gRPC requests by method and gRPC status code.",
+		}, []string{"method", "code"}),
+		grpcDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "grpc_request_duration_seconds",
+			Help:      "Duration of the proxied gRPC requests by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		grpcMethods: make(map[string]struct{}),
+		backendAttempts: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "backend_request_attempts",
+			Help:      "Number of attempts of the proxied requests that can be retried by backend.",
+			Buckets:   prometheus.LinearBuckets(1, 1, 5),
+		}, []string{"backend"}),
+		retryBudgetExhausted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retry_budget_exhausted_total",
+			Help:      "Number of retries that have been skipped as the retry budget is exhausted.",
+		}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_requests_total",
+			Help:      "Number of requests rejected by the rate limits by host.",
+		}, []string{"host"}),
+		rateLimitStoreErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limit_store_errors_total",
+			Help:      "Number of requests that are not rate limited as the token bucket store failed.",
+		}),
+		backends: &backendCollector{
+			endpointHealthy: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "backend_endpoint_healthy"),
+				"Whether the backend endpoint is healthy and not ejected, 1 for available endpoints.", []string{"backend", "endpoint"}, nil),
+			endpointEjections: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "backend_endpoint_ejections_total"),
+				"Number of ejections of the backend endpoint by the outlier detection.", []string{"backend", "endpoint"}, nil),
+			activeRequests: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "backend_active_requests"),
+				"Number of in-flight requests to the backend.", []string{"backend"}, nil),
+			pendingRequests: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "backend_pending_requests"),
+				"Number of requests waiting for the request limit of the backend.", []string{"backend"}, nil),
+			openConnections: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "backend_open_connections"),
+				"Number of open connections to the backend, only for backends with connection limit.", []string{"backend"}, nil),
+			circuitOpen: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "backend_circuit_breaker_open"),
+				"Whether the request or connection limit of the backend is reached, 1 if further requests wait or are rejected.", []string{"backend", "limit"}, nil),
+			rejections: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "backend_circuit_breaker_rejections_total"),
+				"Number of requests rejected by the request or connection limit of the backend.", []string{"backend", "limit"}, nil),
+		},
+	}
+	for _, collector := range []prometheus.Collector{metrics.ocspFailures, metrics.certificateNotAfters, metrics.grpcRequests, metrics.grpcDurations,
+		metrics.backends, metrics.backendAttempts, metrics.retryBudgetExhausted, metrics.rateLimited, metrics.rateLimitStoreErrors} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return metrics, nil
+}
+
+// incOcspFailures increments the ocsp failure counter, a nil receiver is a noop
+func (metrics *PrometheusMetrics) incOcspFailures() {
+	if metrics == nil {
+		return
+	}
+	metrics.ocspFailures.Inc()
+}
+
+// setCertificateNotAfters replaces the expiry gauges with the certificates of the ingress state, a nil receiver is a noop
+func (metrics *PrometheusMetrics) setCertificateNotAfters(ingressState state.IngressState) {
+	if metrics == nil {
+		return
+	}
+	metrics.certificateNotAfters.Reset()
+	for host, domainConfig := range ingressState {
+		for _, tlsCert := range domainConfig.TlsCerts {
+			leaf, err := tlsCert.ParseLeaf()
+			if err != nil {
+				continue
+			}
+			metrics.certificateNotAfters.WithLabelValues(host, tlsCert.Secret.String()).Set(float64(leaf.NotAfter.Unix()))
+		}
+	}
+}
+
+// observeGrpcRequest records a proxied gRPC request, a nil receiver is a noop.
+// Methods beyond grpcMaxMethodLabels distinct methods are recorded as grpcUnmatchedMethod to bound the label cardinality.
+func (metrics *PrometheusMetrics) observeGrpcRequest(method string, code grpcCode, duration time.Duration) {
+	if metrics == nil {
+		return
+	}
+	method = metrics.grpcMethodLabel(method)
+	metrics.grpcRequests.WithLabelValues(method, code.String()).Inc()
+	metrics.grpcDurations.WithLabelValues(method).Observe(duration.Seconds())
+}
+
+// grpcMethodLabel returns the method if it is already a label or there is space for further labels, grpcUnmatchedMethod otherwise
+func (metrics *PrometheusMetrics) grpcMethodLabel(method string) string {
+	metrics.grpcMethodsLock.Lock()
+	defer metrics.grpcMethodsLock.Unlock()
+	if _, ok := metrics.grpcMethods[method]; ok {
+		return method
+	}
+	if len(metrics.grpcMethods) >= grpcMaxMethodLabels {
+		return grpcUnmatchedMethod
+	}
+	metrics.grpcMethods[method] = struct{}{}
+	return method
+}
+
+// observeBackendAttempts records the number of attempts of a request that can be retried, a nil receiver is a noop
+func (metrics *PrometheusMetrics) observeBackendAttempts(backend string, attempts int) {
+	if metrics == nil {
+		return
+	}
+	metrics.backendAttempts.WithLabelValues(backend).Observe(float64(attempts))
+}
+
+// incRetryBudgetExhausted increments the counter of retries skipped due to the retry budget, a nil receiver is a noop
+func (metrics *PrometheusMetrics) incRetryBudgetExhausted() {
+	if metrics == nil {
+		return
+	}
+	metrics.retryBudgetExhausted.Inc()
+}
+
+// incRateLimited increments the counter of requests rejected by the rate limits, a nil receiver is a noop
+func (metrics *PrometheusMetrics) incRateLimited(host string) {
+	if metrics == nil {
+		return
+	}
+	metrics.rateLimited.WithLabelValues(host).Inc()
+}
+
+// incRateLimitStoreErrors increments the counter of requests that are not rate limited due to store errors, a nil receiver is a noop
+func (metrics *PrometheusMetrics) incRateLimitStoreErrors() {
+	if metrics == nil {
+		return
+	}
+	metrics.rateLimitStoreErrors.Inc()
+}
+
+// observeBackends collects the state of the backends of the reverse proxy on scrapes, a nil receiver is a noop
+func (metrics *PrometheusMetrics) observeBackends(proxy *ReverseProxy) {
+	if metrics == nil {
+		return
+	}
+	metrics.backends.proxy.Store(proxy)
+}
+
+// backendCollector collects the endpoint health and the circuit breaker state of the current load balancers,
+// the backend label is the load balancer key, see loadBalancerKey
+type backendCollector struct {
+	endpointHealthy   *prometheus.Desc
+	endpointEjections *prometheus.Desc
+	activeRequests    *prometheus.Desc
+	pendingRequests   *prometheus.Desc
+	openConnections   *prometheus.Desc
+	circuitOpen       *prometheus.Desc
+	rejections        *prometheus.Desc
+	proxy             atomic.Pointer[ReverseProxy]
+}
+
+func (collector *backendCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- collector.endpointHealthy
+	descs <- collector.endpointEjections
+	descs <- collector.activeRequests
+	descs <- collector.pendingRequests
+	descs <- collector.openConnections
+	descs <- collector.circuitOpen
+	descs <- collector.rejections
+}
+
+func (collector *backendCollector) Collect(metrics chan<- prometheus.Metric) {
+	proxy := collector.proxy.Load()
+	if proxy == nil {
+		return
+	}
+	currentState := proxy.state.Load()
+	if currentState == nil {
+		return
+	}
+	now := time.Now()
+	for key, lb := range currentState.loadBalancers {
+		for _, ep := range *lb.endpoints.Load() {
+			metrics <- prometheus.MustNewConstMetric(collector.endpointHealthy, prometheus.GaugeValue, boolToFloat(ep.available(now)), key, ep.address)
+			metrics <- prometheus.MustNewConstMetric(collector.endpointEjections, prometheus.CounterValue, float64(ep.ejectionsTotal.Load()), key, ep.address)
+		}
+		metrics <- prometheus.MustNewConstMetric(collector.activeRequests, prometheus.GaugeValue, float64(lb.requests.active.Load()), key)
+		metrics <- prometheus.MustNewConstMetric(collector.pendingRequests, prometheus.GaugeValue, float64(lb.requests.pending.Load()), key)
+		metrics <- prometheus.MustNewConstMetric(collector.circuitOpen, prometheus.GaugeValue, boolToFloat(lb.requests.isOpen()), key, "requests")
+		metrics <- prometheus.MustNewConstMetric(collector.rejections, prometheus.CounterValue, float64(lb.requests.rejections.Load()), key, "requests")
+		if lb.connections != nil {
+			metrics <- prometheus.MustNewConstMetric(collector.openConnections, prometheus.GaugeValue, float64(lb.connections.open.Load()), key)
+			metrics <- prometheus.MustNewConstMetric(collector.circuitOpen, prometheus.GaugeValue, boolToFloat(lb.connections.isOpen()), key, "connections")
+			metrics <- prometheus.MustNewConstMetric(collector.rejections, prometheus.CounterValue, float64(lb.connections.rejections.Load()), key, "connections")
+		}
+	}
+}
+
+// boolToFloat returns 1 for true and 0 for false
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,34 @@
+package revproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestCertificateNotAfterMetrics(t *testing.T) {
+	metrics, err := RegisterMetrics(prometheus.NewRegistry(), "test")
+	require.NoError(t, err)
+	reverseProxy := New(Metrics(metrics))
+	tlsCert := newTestOcspResponder(t).issue(t, ocspHost)
+	tlsCert.Secret = types.NamespacedName{Namespace: "namespace", Name: "secret"}
+	err = reverseProxy.LoadIngressState(state.IngressState{ocspHost: &state.DomainConfig{TlsCerts: []*state.TlsCert{tlsCert}}})
+	require.NoError(t, err)
+
+	cert, err := tls.X509KeyPair(tlsCert.Cert, tlsCert.Key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	require.Equal(t, float64(leaf.NotAfter.Unix()), testutil.ToFloat64(metrics.certificateNotAfters.WithLabelValues(ocspHost, "namespace/secret")))
+
+	// removed certificates are not reported anymore
+	err = reverseProxy.LoadIngressState(state.IngressState{})
+	require.NoError(t, err)
+	require.Equal(t, 0, testutil.CollectAndCount(metrics.certificateNotAfters))
+}
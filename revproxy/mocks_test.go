@@ -51,8 +51,8 @@ func getDummyReverseProxy(t *testing.T, handler http.Handler) *ReverseProxy {
 		Path:         acmePath,
 		ProxyHandler: handler,
 	}
-	pathMap := map[string]backendPathHandlers{
-		dummyHost: {pathHandler, acmeHandler},
+	pathMap := BackendRouting{
+		dummyHost: {pathHandlers: newBackendPathHandlers(pathHandler, acmeHandler)},
 	}
 
 	var certData [20]byte
@@ -61,8 +61,8 @@ func getDummyReverseProxy(t *testing.T, handler http.Handler) *ReverseProxy {
 	cert := tls.Certificate{
 		Certificate: [][]byte{certData[:]},
 	}
-	certMap := map[string]*tls.Certificate{
-		dummyHost: &cert,
+	certMap := TlsCerts{
+		dummyHost: {&cert},
 	}
 
 	reverseProxy := New()
@@ -0,0 +1,207 @@
+package revproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ocsp"
+)
+
+var (
+	ErrOcspResponder = errors.New("unexpected OCSP responder status code")
+	ErrOcspStatus    = errors.New("certificate status is not good")
+	ErrOcspExpired   = errors.New("OCSP response is expired")
+)
+
+//nolint:gomnd
+const (
+	// ocspCheckInterval is the interval in which the OCSP responses are checked for their refresh
+	ocspCheckInterval = time.Minute
+	// ocspRetryInterval is the delay after a failed OCSP request
+	ocspRetryInterval = time.Duration(5) * time.Minute
+	// ocspMaxRefreshInterval is the maximum time after which an OCSP response is refreshed
+	ocspMaxRefreshInterval = time.Duration(12) * time.Hour
+	// ocspMaxResponseSize limits the size of the read OCSP responses
+	ocspMaxResponseSize = 1 << 20
+)
+
+// ocspStaple is the OCSP response for a certificate
+type ocspStaple struct {
+	leaf   *x509.Certificate
+	issuer *x509.Certificate
+	// response is the raw OCSP response, nil if none has been fetched yet
+	response []byte
+	// nextUpdate is the time after which the response must not be used anymore, zero if the responder did not set it
+	nextUpdate  time.Time
+	nextRefresh time.Time
+}
+
+// ocspStapler fetches and refreshes the OCSP responses of the loaded certificates in the background, see ReverseProxy.RunOcspStapling
+type ocspStapler struct {
+	client  *http.Client
+	metrics *PrometheusMetrics
+	// trigger is signaled if the loaded certificates changed
+	trigger chan struct{}
+	lock    sync.RWMutex
+	// staples are keyed by the DER encoded leaf certificate, so that they are reused for the same certificate in a new state
+	staples map[string]*ocspStaple
+}
+
+// newOcspStapler setups a new OCSP stapler without certificates
+func newOcspStapler(metrics *PrometheusMetrics) *ocspStapler {
+	return &ocspStapler{
+		client:  &http.Client{Timeout: time.Duration(10) * time.Second}, //nolint:gomnd
+		metrics: metrics,
+		trigger: make(chan struct{}, 1),
+		staples: make(map[string]*ocspStaple),
+	}
+}
+
+// load sets the certificates for which OCSP responses are fetched. The responses of already known certificates are kept.
+// Certificates without OCSP server or issuer certificate in the chain are skipped.
+func (stapler *ocspStapler) load(tlsCerts TlsCerts) {
+	stapler.lock.Lock()
+	defer stapler.lock.Unlock()
+	staples := make(map[string]*ocspStaple)
+	for _, certs := range tlsCerts {
+		for _, cert := range certs {
+			if len(cert.Certificate) < 2 {
+				continue
+			}
+			key := string(cert.Certificate[0])
+			if staple, ok := stapler.staples[key]; ok {
+				staples[key] = staple
+				continue
+			}
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil || len(leaf.OCSPServer) == 0 {
+				continue
+			}
+			issuer, err := x509.ParseCertificate(cert.Certificate[1])
+			if err != nil {
+				continue
+			}
+			staples[key] = &ocspStaple{leaf: leaf, issuer: issuer}
+		}
+	}
+	stapler.staples = staples
+	select {
+	case stapler.trigger <- struct{}{}:
+	default:
+		// already triggered
+	}
+}
+
+// run refreshes the OCSP responses till the context is done
+func (stapler *ocspStapler) run(ctx context.Context) {
+	ticker := time.NewTicker(ocspCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stapler.trigger:
+		case <-ticker.C:
+		}
+		stapler.refresh(ctx, time.Now())
+	}
+}
+
+// refresh fetches the OCSP responses that are due. Responses are refreshed at the latest after half of their remaining validity.
+// Failed fetches are retried after the ocspRetryInterval, the last response is kept till it expires.
+func (stapler *ocspStapler) refresh(ctx context.Context, now time.Time) {
+	stapler.lock.RLock()
+	due := make([]*ocspStaple, 0)
+	for _, staple := range stapler.staples {
+		if !staple.nextRefresh.After(now) {
+			due = append(due, staple)
+		}
+	}
+	stapler.lock.RUnlock()
+
+	for _, staple := range due {
+		response, err := stapler.fetch(ctx, staple, now)
+		stapler.lock.Lock()
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to fetch OCSP response for certificate %s", strings.Join(staple.leaf.DNSNames, ","))
+			stapler.metrics.incOcspFailures()
+			staple.nextRefresh = now.Add(ocspRetryInterval)
+			if !staple.nextUpdate.IsZero() && !staple.nextUpdate.After(now) {
+				staple.response = nil
+			}
+		} else {
+			staple.response = response.Raw
+			staple.nextUpdate = response.NextUpdate
+			staple.nextRefresh = now.Add(ocspMaxRefreshInterval)
+			if !response.NextUpdate.IsZero() && response.NextUpdate.Sub(now)/2 < ocspMaxRefreshInterval {
+				staple.nextRefresh = now.Add(response.NextUpdate.Sub(now) / 2)
+			}
+		}
+		stapler.lock.Unlock()
+	}
+}
+
+// fetch requests the OCSP response for the staple from the first OCSP server of the certificate
+func (stapler *ocspStapler) fetch(ctx context.Context, staple *ocspStaple, now time.Time) (*ocsp.Response, error) {
+	request, err := ocsp.CreateRequest(staple.leaf, staple.issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCSP request: %w", err)
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, staple.leaf.OCSPServer[0], bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCSP request: %w", err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/ocsp-request")
+	httpResponse, err := stapler.client.Do(httpRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request OCSP response: %w", err)
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %d", ErrOcspResponder, httpResponse.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(httpResponse.Body, ocspMaxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCSP response: %w", err)
+	}
+	response, err := ocsp.ParseResponseForCert(body, staple.leaf, staple.issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse OCSP response: %w", err)
+	}
+	if response.Status == ocsp.Revoked {
+		return nil, fmt.Errorf("%w: revoked", ErrOcspStatus)
+	}
+	if response.Status != ocsp.Good {
+		return nil, fmt.Errorf("%w: unknown", ErrOcspStatus)
+	}
+	if !response.NextUpdate.IsZero() && !response.NextUpdate.After(now) {
+		return nil, ErrOcspExpired
+	}
+	return response, nil
+}
+
+// staple returns the certificate with the current OCSP response. The certificate is returned unchanged if there is none.
+func (stapler *ocspStapler) staple(cert *tls.Certificate, now time.Time) *tls.Certificate {
+	if len(cert.Certificate) == 0 {
+		return cert
+	}
+	stapler.lock.RLock()
+	defer stapler.lock.RUnlock()
+	staple, ok := stapler.staples[string(cert.Certificate[0])]
+	if !ok || staple.response == nil || (!staple.nextUpdate.IsZero() && !staple.nextUpdate.After(now)) {
+		return cert
+	}
+	stapled := *cert
+	stapled.OCSPStaple = staple.response
+	return &stapled
+}
@@ -0,0 +1,181 @@
+package revproxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+const ocspHost = "ocsp.example.com"
+
+// testOcspResponder is a local OCSP responder stand-in that answers with the configured status
+type testOcspResponder struct {
+	server   *httptest.Server
+	caCert   *x509.Certificate
+	caKey    crypto.Signer
+	status   atomic.Int32
+	requests atomic.Int32
+}
+
+// newTestOcspResponder starts a new OCSP responder with its own CA
+func newTestOcspResponder(t *testing.T) *testOcspResponder {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	responder := &testOcspResponder{caCert: caCert, caKey: caKey}
+	responder.server = httptest.NewServer(http.HandlerFunc(responder.serveHTTP))
+	t.Cleanup(responder.server.Close)
+	return responder
+}
+
+func (responder *testOcspResponder) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	responder.requests.Add(1)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	request, err := ocsp.ParseRequest(body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	status := int(responder.status.Load())
+	if status < 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	response, err := ocsp.CreateResponse(responder.caCert, responder.caCert, ocsp.Response{
+		Status:       status,
+		SerialNumber: request.SerialNumber,
+		ThisUpdate:   now.Add(-time.Minute),
+		NextUpdate:   now.Add(time.Hour),
+		RevokedAt:    now.Add(-time.Minute),
+	}, responder.caKey)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(response)
+}
+
+// issue returns a PEM encoded certificate chain and key for the host that references the responder
+func (responder *testOcspResponder) issue(t *testing.T, host string) *state.TlsCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		OCSPServer:   []string{responder.server.URL},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, responder.caCert, &key.PublicKey, responder.caKey)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: responder.caCert.Raw})...)
+	return &state.TlsCert{Cert: chain, Key: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})}
+}
+
+// getStaple returns the OCSP staple of the certificate that the reverse proxy serves for the host
+func getStaple(t *testing.T, reverseProxy *ReverseProxy, host string) []byte {
+	cert, err := reverseProxy.GetCertificateFunc()(&tls.ClientHelloInfo{ServerName: host})
+	require.NoError(t, err)
+	return cert.OCSPStaple
+}
+
+func TestOcspStapling(t *testing.T) {
+	responder := newTestOcspResponder(t)
+	reverseProxy := New()
+	ingressState := state.IngressState{ocspHost: &state.DomainConfig{TlsCerts: []*state.TlsCert{responder.issue(t, ocspHost)}}}
+	err := reverseProxy.LoadIngressState(ingressState)
+	require.NoError(t, err)
+	require.Empty(t, getStaple(t, reverseProxy, ocspHost))
+
+	now := time.Now()
+	reverseProxy.ocspStapler.refresh(context.Background(), now)
+	staple := getStaple(t, reverseProxy, ocspHost)
+	response, err := ocsp.ParseResponse(staple, responder.caCert)
+	require.NoError(t, err)
+	require.Equal(t, ocsp.Good, response.Status)
+	require.Equal(t, int32(1), responder.requests.Load())
+
+	// a new state reuses the staple of the same certificate
+	err = reverseProxy.LoadIngressState(ingressState)
+	require.NoError(t, err)
+	require.Equal(t, staple, getStaple(t, reverseProxy, ocspHost))
+	reverseProxy.ocspStapler.refresh(context.Background(), now)
+	require.Equal(t, int32(1), responder.requests.Load())
+
+	// refreshed after half of the remaining validity
+	reverseProxy.ocspStapler.refresh(context.Background(), now.Add(time.Duration(31)*time.Minute))
+	require.Equal(t, int32(2), responder.requests.Load())
+}
+
+func TestOcspStaplingFailure(t *testing.T) {
+	responder := newTestOcspResponder(t)
+	metrics, err := RegisterMetrics(prometheus.NewRegistry(), "test")
+	require.NoError(t, err)
+	reverseProxy := New(Metrics(metrics))
+	err = reverseProxy.LoadIngressState(state.IngressState{ocspHost: &state.DomainConfig{TlsCerts: []*state.TlsCert{responder.issue(t, ocspHost)}}})
+	require.NoError(t, err)
+	now := time.Now()
+	reverseProxy.ocspStapler.refresh(context.Background(), now)
+	require.NotEmpty(t, getStaple(t, reverseProxy, ocspHost))
+
+	// the last response is kept till it expires
+	responder.status.Store(-1)
+	reverseProxy.ocspStapler.refresh(context.Background(), now.Add(time.Duration(31)*time.Minute))
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.ocspFailures))
+	require.NotEmpty(t, getStaple(t, reverseProxy, ocspHost))
+
+	// revoked certificates are not stapled
+	responder.status.Store(ocsp.Revoked)
+	reverseProxy.ocspStapler.refresh(context.Background(), now.Add(time.Duration(61)*time.Minute))
+	require.Equal(t, float64(2), testutil.ToFloat64(metrics.ocspFailures))
+	require.Empty(t, reverseProxy.ocspStapler.staple(reverseProxy.state.Load().tlsCerts[ocspHost][0], now.Add(time.Duration(61)*time.Minute)).OCSPStaple)
+}
+
+func TestOcspStaplingWithoutOcspServer(t *testing.T) {
+	stapler := newOcspStapler(nil)
+	cert := &tls.Certificate{Certificate: [][]byte{[]byte("leaf")}}
+	stapler.load(TlsCerts{ocspHost: {cert}})
+	require.Empty(t, stapler.staples)
+	require.Same(t, cert, stapler.staple(cert, time.Now()))
+}
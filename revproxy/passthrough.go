@@ -0,0 +1,202 @@
+package revproxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// errClientHelloPeeked aborts the handshake of peekServerName once the ClientHello has been read
+var errClientHelloPeeked = errors.New("client hello peeked")
+
+// passthroughPeekTimeout limits the time a client has to send the ClientHello
+const passthroughPeekTimeout = time.Duration(10) * time.Second //nolint:gomnd
+
+// passthroughListener wraps the TCP listener of the HTTPS server. Connections for hosts with a TLS passthrough backend are
+// forwarded to the backend, all other connections are returned by Accept to be terminated by the tls.Listener.
+type passthroughListener struct {
+	net.Listener
+	proxy     *ReverseProxy
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// PassthroughListener wraps the TCP listener of the HTTPS server to support TLS passthrough, see state.AnnotationSslPassthrough.
+// The server name of the ClientHello is peeked before the connection is handed to the tls.Listener. If the host has a
+// passthrough backend, the raw TCP stream is forwarded to one of its endpoints instead.
+func (proxy *ReverseProxy) PassthroughListener(listener net.Listener) net.Listener {
+	passthrough := &passthroughListener{
+		Listener: listener,
+		proxy:    proxy,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	go passthrough.acceptLoop()
+	return passthrough
+}
+
+// acceptLoop accepts the connections of the wrapped listener till it is closed.
+// The connections are peeked concurrently so that a slow client does not block the others.
+func (listener *passthroughListener) acceptLoop() {
+	for {
+		conn, err := listener.Listener.Accept()
+		if err != nil {
+			select {
+			case listener.errs <- err:
+			case <-listener.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go listener.handle(conn)
+	}
+}
+
+// handle forwards the connection to the passthrough backend of its server name or hands it over to Accept
+func (listener *passthroughListener) handle(conn net.Conn) {
+	serverName, peeked, err := peekServerName(conn)
+	if err != nil {
+		// the tls.Listener answers the broken handshake
+		log.Debug().Err(err).Msgf("failed to peek the server name from %s", conn.RemoteAddr())
+	}
+	if backend := listener.proxy.passthroughBackend(serverName); backend != nil {
+		listener.proxy.forward(conn, peeked, backend)
+		return
+	}
+	peekedConn := &peekedConn{Conn: conn, reader: io.MultiReader(bytes.NewReader(peeked), conn)}
+	select {
+	case listener.conns <- peekedConn:
+	case <-listener.done:
+		_ = conn.Close()
+	}
+}
+
+// Accept returns the next connection that is not passed through
+func (listener *passthroughListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case err := <-listener.errs:
+		return nil, err
+	case <-listener.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the wrapped listener, the forwarded connections are kept open
+func (listener *passthroughListener) Close() error {
+	listener.closeOnce.Do(func() { close(listener.done) })
+	return listener.Listener.Close()
+}
+
+// peekServerName reads the ClientHello from the connection and returns its server name as well as the read bytes.
+func peekServerName(conn net.Conn) (serverName string, peeked []byte, err error) {
+	var buf bytes.Buffer
+	if err = conn.SetReadDeadline(time.Now().Add(passthroughPeekTimeout)); err != nil {
+		return "", nil, err
+	}
+	err = tls.Server(&readOnlyConn{Conn: conn, reader: io.TeeReader(conn, &buf)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errClientHelloPeeked
+		},
+	}).Handshake()
+	if deadlineErr := conn.SetReadDeadline(time.Time{}); deadlineErr != nil {
+		return "", buf.Bytes(), deadlineErr
+	}
+	if !errors.Is(err, errClientHelloPeeked) {
+		return "", buf.Bytes(), err
+	}
+	return serverName, buf.Bytes(), nil
+}
+
+// passthroughBackend returns the passthrough load balancer for the server name, nil if the host is terminated by the reverse proxy
+func (proxy *ReverseProxy) passthroughBackend(serverName string) *loadBalancer {
+	currentState := proxy.state.Load()
+	if currentState == nil || serverName == "" {
+		return nil
+	}
+	routing, ok := lookupHost(currentState.backendPathHandlers, serverName)
+	if !ok {
+		return nil
+	}
+	return routing.passthrough
+}
+
+// forward connects to an endpoint of the backend, replays the peeked bytes and copies the data in both directions till both sides are done.
+func (proxy *ReverseProxy) forward(conn net.Conn, peeked []byte, backend *loadBalancer) {
+	defer conn.Close()
+	ep, ok := backend.pick()
+	if !ok {
+		log.Debug().Msgf("no ready endpoint for tls passthrough connection from %s", conn.RemoteAddr())
+		return
+	}
+	ep.active.Add(1)
+	defer ep.active.Add(-1)
+	backendConn, err := proxy.dialer.Dial("tcp", ep.address)
+	if err != nil {
+		log.Warn().Err(err).Msgf("failed to connect to tls passthrough endpoint %s", ep.address)
+		return
+	}
+	defer backendConn.Close()
+	if _, err = backendConn.Write(peeked); err != nil {
+		log.Debug().Err(err).Msgf("failed to forward client hello to tls passthrough endpoint %s", ep.address)
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(2) //nolint:gomnd
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(backendConn, conn)
+		closeWrite(backendConn)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(conn, backendConn)
+		closeWrite(conn)
+	}()
+	wg.Wait()
+}
+
+// closeWrite signals the end of the stream to the peer if the connection supports half-closing
+func closeWrite(conn net.Conn) {
+	if tcpConn, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = tcpConn.CloseWrite()
+	}
+}
+
+// peekedConn replays the peeked bytes before reading from the connection
+type peekedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (conn *peekedConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+// readOnlyConn discards all writes, so that the aborted handshake of peekServerName sends no alert to the client
+type readOnlyConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (conn *readOnlyConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+func (conn *readOnlyConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
@@ -0,0 +1,89 @@
+package revproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/stretchr/testify/require"
+)
+
+const passthroughHost = "passthrough.localhost"
+
+// getPassthroughServer starts an HTTPS server for the reverse proxy behind its PassthroughListener and returns its address
+func getPassthroughServer(t *testing.T, reverseProxy *ReverseProxy) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("terminated"))
+		}),
+		ReadHeaderTimeout: time.Second,
+	}
+	tlsListener := tls.NewListener(reverseProxy.PassthroughListener(listener), &tls.Config{GetCertificate: reverseProxy.GetCertificateFunc()})
+	go func() { _ = server.Serve(tlsListener) }()
+	t.Cleanup(func() { _ = server.Close() })
+	return listener.Addr().String()
+}
+
+func TestPassthroughListener(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("passthrough"))
+	}))
+	defer backend.Close()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	reverseProxy := New()
+	err = reverseProxy.LoadIngressState(state.IngressState{
+		dummyHost: &state.DomainConfig{TlsCerts: []*state.TlsCert{getSelfSignedTlsCert(t, key)}},
+		passthroughHost: &state.DomainConfig{PassthroughBackend: &state.BackendPath{
+			Namespace:   "default",
+			ServiceName: "svc",
+			ServicePort: 443,
+			Endpoints:   []string{backend.Listener.Addr().String()},
+		}},
+	})
+	require.NoError(t, err)
+	addr := getPassthroughServer(t, reverseProxy)
+
+	for _, testCase := range []struct {
+		host         string
+		expectedBody string
+	}{
+		{dummyHost, "terminated"},
+		{passthroughHost, "passthrough"},
+	} {
+		t.Run(testCase.host, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{ServerName: testCase.host, InsecureSkipVerify: true}, //nolint:gosec // self-signed test certificates
+			}}
+			response, err := client.Get("https://" + addr)
+			require.NoError(t, err)
+			defer response.Body.Close()
+			body, err := io.ReadAll(response.Body)
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedBody, string(body))
+		})
+	}
+}
+
+func TestPassthroughListenerReplaysPeekedBytes(t *testing.T) {
+	reverseProxy := New()
+	err := reverseProxy.LoadIngressState(state.IngressState{})
+	require.NoError(t, err)
+	addr := getPassthroughServer(t, reverseProxy)
+
+	// the plain HTTP request is no ClientHello, it has to reach the HTTPS server unchanged to be answered
+	response, err := http.Get("http://" + addr)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
@@ -0,0 +1,137 @@
+package revproxy
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	v1Net "k8s.io/api/networking/v1"
+)
+
+// backendPathHandlers holds the backendPathHandlers of a single host and matches request paths against them.
+// Exact paths are looked up directly, prefix paths are stored in a trie of path elements so that matching is O(path length).
+// ImplementationSpecific paths are matched via their compiled pattern.
+type backendPathHandlers struct {
+	exact map[string]*backendPathHandler
+	// patterns are sorted by descending path length
+	patterns []*backendPathHandler
+	prefix   *pathTrieNode
+}
+
+// pathTrieNode is a node of the path element trie. The handler is only set if a prefix path ends at this node.
+type pathTrieNode struct {
+	children map[string]*pathTrieNode
+	handler  *backendPathHandler
+}
+
+// newBackendPathHandlers returns backendPathHandlers that contain the given path handlers
+func newBackendPathHandlers(pathHandlers ...*backendPathHandler) *backendPathHandlers {
+	result := &backendPathHandlers{
+		exact:  make(map[string]*backendPathHandler),
+		prefix: &pathTrieNode{},
+	}
+	for _, pathHandler := range pathHandlers {
+		result.add(pathHandler)
+	}
+	return result
+}
+
+// add adds the path handler. If a path handler with the same path and path type is already present the first one is kept.
+// ImplementationSpecific paths without a compiled pattern are treated as Prefix paths.
+func (pathHandlers *backendPathHandlers) add(pathHandler *backendPathHandler) {
+	if *pathHandler.PathType == v1Net.PathTypeExact {
+		if _, ok := pathHandlers.exact[pathHandler.Path]; !ok {
+			pathHandlers.exact[pathHandler.Path] = pathHandler
+		}
+		return
+	}
+	if *pathHandler.PathType == v1Net.PathTypeImplementationSpecific && pathHandler.pattern != nil {
+		pathHandlers.patterns = append(pathHandlers.patterns, pathHandler)
+		slices.SortStableFunc(pathHandlers.patterns, func(a *backendPathHandler, b *backendPathHandler) int {
+			return cmp.Compare(len(b.Path), len(a.Path))
+		})
+		return
+	}
+	node := pathHandlers.prefix
+	forEachPathElement(pathHandler.Path, func(element string) bool {
+		if node.children == nil {
+			node.children = make(map[string]*pathTrieNode)
+		}
+		child, ok := node.children[element]
+		if !ok {
+			child = &pathTrieNode{}
+			node.children[element] = child
+		}
+		node = child
+		return true
+	})
+	if node.handler == nil {
+		node.handler = pathHandler
+	}
+}
+
+// match returns the matching backendPathHandler for the given path argument if one is present.
+// Matching follows the ingress spec: The longest matching path takes precedence, Exact paths win over Prefix paths of the same length.
+// Prefix paths are matched element-wise split by "/", i.e. /foo matches /foo, /foo/ and /foo/bar but not /foobar.
+// A trailing slash of a Prefix path is ignored.
+// ImplementationSpecific patterns are checked after the Exact and before the Prefix paths, the longest matching pattern wins.
+func (pathHandlers *backendPathHandlers) match(path string) (pathHandler *backendPathHandler, ok bool) {
+	if pathHandlers == nil {
+		return nil, false
+	}
+	// an exact match is at least as long as every prefix match
+	if pathHandler, ok = pathHandlers.exact[path]; ok {
+		return pathHandler, true
+	}
+	for _, pathHandler = range pathHandlers.patterns {
+		if pathHandler.pattern.MatchString(path) {
+			return pathHandler, true
+		}
+	}
+	node := pathHandlers.prefix
+	pathHandler = node.handler
+	forEachPathElement(path, func(element string) bool {
+		node = node.children[element]
+		if node == nil {
+			return false
+		}
+		if node.handler != nil {
+			pathHandler = node.handler
+		}
+		return true
+	})
+	return pathHandler, pathHandler != nil
+}
+
+// forEachPathElement calls the consumer for each non-empty element of the "/"-separated path till the consumer returns false
+func forEachPathElement(path string, consumer func(element string) bool) {
+	for path != "" {
+		var element string
+		element, path, _ = strings.Cut(path, "/")
+		if element == "" {
+			continue
+		}
+		if !consumer(element) {
+			return
+		}
+	}
+}
+
+// prefixMatchLength returns the length of the beginning of the path that is covered by the elements of the matching prefix path
+func prefixMatchLength(prefix string, path string) int {
+	elementCount := 0
+	forEachPathElement(prefix, func(_ string) bool {
+		elementCount++
+		return true
+	})
+	i := 0
+	for ; elementCount > 0; elementCount-- {
+		for i < len(path) && path[i] == '/' {
+			i++
+		}
+		for i < len(path) && path[i] != '/' {
+			i++
+		}
+	}
+	return i
+}
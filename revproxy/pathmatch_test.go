@@ -0,0 +1,91 @@
+package revproxy
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+)
+
+func TestPathMatch(t *testing.T) {
+	exact := v1Net.PathTypeExact
+	prefix := v1Net.PathTypePrefix
+	pathHandlers := newBackendPathHandlers(
+		&backendPathHandler{PathType: &prefix, Path: "/"},
+		&backendPathHandler{PathType: &prefix, Path: "/foo"},
+		&backendPathHandler{PathType: &prefix, Path: "/foo/bar/"},
+		&backendPathHandler{PathType: &exact, Path: "/foo/bar"},
+		&backendPathHandler{PathType: &prefix, Path: "/aaa/bbb"},
+	)
+	// cases from the ingress spec examples
+	for _, testCase := range []struct {
+		path         string
+		expectedPath string
+		expectedType v1Net.PathType
+	}{
+		{"/", "/", prefix},
+		{"/foo", "/foo", prefix},
+		{"/foo/", "/foo", prefix},
+		{"/foobar", "/", prefix},
+		{"/foo/baz", "/foo", prefix},
+		{"/foo/bar", "/foo/bar", exact},
+		{"/foo/bar/", "/foo/bar/", prefix},
+		{"/foo/bar/baz", "/foo/bar/", prefix},
+		{"/aaa/bbb", "/aaa/bbb", prefix},
+		{"/aaa/bbb/", "/aaa/bbb", prefix},
+		{"/aaa/bbbxyz", "/", prefix},
+		{"/aaa/bbb/ccc", "/aaa/bbb", prefix},
+	} {
+		pathHandler, ok := pathHandlers.match(testCase.path)
+		require.True(t, ok, testCase.path)
+		require.Equal(t, testCase.expectedPath, pathHandler.Path, testCase.path)
+		require.Equal(t, testCase.expectedType, *pathHandler.PathType, testCase.path)
+	}
+}
+
+func TestPathMatchNone(t *testing.T) {
+	exact := v1Net.PathTypeExact
+	prefix := v1Net.PathTypePrefix
+	pathHandlers := newBackendPathHandlers(
+		&backendPathHandler{PathType: &exact, Path: "/foo"},
+		&backendPathHandler{PathType: &prefix, Path: "/bar"},
+	)
+	for _, path := range []string{"/", "/foo/", "/foobar", "/barfoo"} {
+		_, ok := pathHandlers.match(path)
+		require.False(t, ok, path)
+	}
+	var empty *backendPathHandlers
+	_, ok := empty.match("/")
+	require.False(t, ok)
+}
+
+func TestPathMatchFirstWins(t *testing.T) {
+	prefix := v1Net.PathTypePrefix
+	first := &backendPathHandler{PathType: &prefix, Path: "/foo"}
+	pathHandlers := newBackendPathHandlers(first, &backendPathHandler{PathType: &prefix, Path: "/foo/"})
+	pathHandler, ok := pathHandlers.match("/foo/bar")
+	require.True(t, ok)
+	require.Same(t, first, pathHandler)
+}
+
+func TestPathMatchPattern(t *testing.T) {
+	prefix := v1Net.PathTypePrefix
+	implementationSpecific := v1Net.PathTypeImplementationSpecific
+	pathHandlers := newBackendPathHandlers(
+		&backendPathHandler{PathType: &prefix, Path: "/api"},
+		&backendPathHandler{PathType: &implementationSpecific, Path: "/api/v[0-9]+", pattern: regexp.MustCompile("^(?:/api/v[0-9]+)")},
+		&backendPathHandler{PathType: &implementationSpecific, Path: "/api/v1/[a-z]+", pattern: regexp.MustCompile("^(?:/api/v1/[a-z]+)")},
+	)
+	for path, expectedPath := range map[string]string{
+		"/api":          "/api",
+		"/api/other":    "/api",
+		"/api/v2":       "/api/v[0-9]+",
+		"/api/v1/users": "/api/v1/[a-z]+",
+		"/api/v1/123":   "/api/v[0-9]+",
+	} {
+		pathHandler, ok := pathHandlers.match(path)
+		require.True(t, ok, path)
+		require.Equal(t, expectedPath, pathHandler.Path, path)
+	}
+}
@@ -0,0 +1,305 @@
+package revproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/rs/zerolog/log"
+)
+
+//nolint:gomnd
+const (
+	// rateLimitStoreTimeout limits the time to take a token from the store, the request is not limited if it is exceeded
+	rateLimitStoreTimeout = time.Duration(100) * time.Millisecond
+	// memoryStoreSweepInterval is the interval in which full token buckets are removed from the MemoryTokenBucketStore
+	memoryStoreSweepInterval = time.Minute
+	// rateLimitKeyHashLength is the length of the hex encoded hash of header and claim values in the bucket keys
+	rateLimitKeyHashLength = 32
+	// memoryStoreMaxBuckets limits the number of buckets in the MemoryTokenBucketStore, random buckets are evicted beyond it
+	memoryStoreMaxBuckets = 100000
+)
+
+// TokenBucketStore holds the token buckets of the rate limited clients.
+// The MemoryTokenBucketStore is local to each replica, the RedisTokenBucketStore is shared between replicas.
+type TokenBucketStore interface {
+	// Take takes a token from the bucket with the given key at the time now. Missing buckets are full.
+	Take(ctx context.Context, key string, limit *state.RateLimit, now time.Time) (TokenBucketResult, error)
+}
+
+// TokenBucketResult is the state of a token bucket after a token has been taken
+type TokenBucketResult struct {
+	// Allowed is whether a token has been available
+	Allowed bool
+	// Remaining are the remaining whole tokens
+	Remaining int
+	// Reset is the duration till the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the duration till the next token is available, zero if the request is allowed
+	RetryAfter time.Duration
+}
+
+// tokenBucket is a token bucket that is refilled continuously
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	// limit is the rate limit of the last take, used to determine whether the bucket is full
+	limit *state.RateLimit
+}
+
+// tokensPerNanosecond returns the refill rate of the token buckets for the rate limit
+func tokensPerNanosecond(limit *state.RateLimit) float64 {
+	return float64(limit.Requests) / float64(limit.Period)
+}
+
+// take refills the bucket till now and takes a token if available
+func (bucket *tokenBucket) take(limit *state.RateLimit, now time.Time) TokenBucketResult {
+	rate := tokensPerNanosecond(limit)
+	elapsed := max(now.Sub(bucket.updated), 0)
+	bucket.tokens = min(float64(limit.Burst), bucket.tokens+float64(elapsed)*rate)
+	bucket.updated = now
+	bucket.limit = limit
+	result := TokenBucketResult{}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1 - bucket.tokens) / rate))
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = time.Duration(math.Ceil((float64(limit.Burst) - bucket.tokens) / rate))
+	return result
+}
+
+// MemoryTokenBucketStore holds the token buckets in memory. Full buckets are removed periodically.
+// The number of buckets is limited to memoryStoreMaxBuckets, so that clients with many keys can not exhaust the memory.
+type MemoryTokenBucketStore struct {
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewMemoryTokenBucketStore returns an empty in-memory token bucket store
+func NewMemoryTokenBucketStore() *MemoryTokenBucketStore {
+	return &MemoryTokenBucketStore{buckets: make(map[string]*tokenBucket)}
+}
+
+// Take takes a token from the bucket with the given key, never returns an error
+func (store *MemoryTokenBucketStore) Take(_ context.Context, key string, limit *state.RateLimit, now time.Time) (TokenBucketResult, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if now.Sub(store.lastSweep) >= memoryStoreSweepInterval {
+		store.sweep(now)
+	}
+	bucket, ok := store.buckets[key]
+	if !ok {
+		// only evicts instead of sweeping, as a full sweep for each new key would block all rate limited requests
+		if len(store.buckets) >= memoryStoreMaxBuckets {
+			store.evict()
+		}
+		bucket = &tokenBucket{tokens: float64(limit.Burst), updated: now, limit: limit}
+		store.buckets[key] = bucket
+	}
+	return bucket.take(limit, now), nil
+}
+
+// evict removes a random bucket, the map iteration order is random. The lock has to be held by the caller.
+func (store *MemoryTokenBucketStore) evict() {
+	for key := range store.buckets {
+		delete(store.buckets, key)
+		return
+	}
+}
+
+// sweep removes the buckets that are full at the time now, as they are equivalent to missing buckets.
+// The lock has to be held by the caller.
+func (store *MemoryTokenBucketStore) sweep(now time.Time) {
+	store.lastSweep = now
+	for key, bucket := range store.buckets {
+		refill := time.Duration((float64(bucket.limit.Burst) - bucket.tokens) / tokensPerNanosecond(bucket.limit))
+		if now.Sub(bucket.updated) >= refill {
+			delete(store.buckets, key)
+		}
+	}
+}
+
+// rateLimitBucketPrefix returns the prefix of the bucket keys for the rate limit of a route of the host, see state.RateLimitScope.
+// The route is the path type and path, empty for the default backend.
+func rateLimitBucketPrefix(limit *state.RateLimit, host string, route string) string {
+	if limit.Scope == state.RateLimitScopeHost {
+		return host
+	}
+	if route == "" {
+		return host + " default"
+	}
+	return host + " " + route
+}
+
+// withRateLimit wraps the handler by a rateLimitHandler if the backend path has a rate limit
+func (proxy *ReverseProxy) withRateLimit(handler http.Handler, backendPath *state.BackendPath, host string, route string) http.Handler {
+	if backendPath.RateLimit == nil {
+		return handler
+	}
+	return &rateLimitHandler{
+		limit:   backendPath.RateLimit,
+		ipLimit: backendPath.RateLimit.IpLimit(),
+		prefix:  rateLimitBucketPrefix(backendPath.RateLimit, host, route),
+		host:    host,
+		store:   proxy.rateLimitStore,
+		metrics: proxy.metrics,
+		next:    handler,
+	}
+}
+
+// rateLimitHandler limits the request rate of each client via a token bucket, requests without token are answered with 429.
+// The RateLimit headers are set for all requests. If the store fails the requests are not limited.
+type rateLimitHandler struct {
+	limit *state.RateLimit
+	// ipLimit is the limit of the IP addresses in addition to the header or jwt-claim key, see state.RateLimit.IpLimit
+	ipLimit *state.RateLimit
+	// prefix of the bucket keys, see rateLimitBucketPrefix
+	prefix  string
+	host    string
+	store   TokenBucketStore
+	metrics *PrometheusMetrics
+	next    http.Handler
+}
+
+func (handler *rateLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), rateLimitStoreTimeout)
+	result, err := handler.take(ctx, rateLimitBuckets(r, handler.limit, handler.ipLimit), time.Now())
+	cancel()
+	if err != nil {
+		log.Warn().Err(err).Msgf("could not take a rate limit token for host %s, the request is not limited", handler.host)
+		handler.metrics.incRateLimitStoreErrors()
+		handler.next.ServeHTTP(w, r)
+		return
+	}
+	setRateLimitHeaders(w.Header(), handler.limit, result)
+	if !result.Allowed {
+		handler.metrics.incRateLimited(handler.host)
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	handler.next.ServeHTTP(w, r)
+}
+
+// take takes a token from the buckets in order. The request is only allowed if all buckets had a token.
+// It stops at the first bucket without token, so that rejected requests do not drain the following buckets.
+// The remaining tokens and reset are the ones of the first bucket, whose limit is reported in the headers.
+func (handler *rateLimitHandler) take(ctx context.Context, buckets []rateLimitBucket, now time.Time) (TokenBucketResult, error) {
+	var merged TokenBucketResult
+	for i, bucket := range buckets {
+		result, err := handler.store.Take(ctx, handler.prefix+" "+bucket.key, bucket.limit, now)
+		if err != nil {
+			return TokenBucketResult{}, err
+		}
+		if i == 0 {
+			merged = result
+		}
+		if !result.Allowed {
+			merged.Allowed = false
+			merged.RetryAfter = max(merged.RetryAfter, result.RetryAfter)
+			return merged, nil
+		}
+	}
+	return merged, nil
+}
+
+// setRateLimitHeaders sets the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers
+// of the IETF draft https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+func setRateLimitHeaders(header http.Header, limit *state.RateLimit, result TokenBucketResult) {
+	header.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", limit.Requests, ceilSeconds(limit.Period), limit.Burst))
+}
+
+// ceilSeconds returns the duration in whole seconds rounded up
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
+
+// rateLimitBucket is a token bucket key of the client with the limit of the bucket
+type rateLimitBucket struct {
+	key   string
+	limit *state.RateLimit
+}
+
+// rateLimitBuckets returns the buckets of the client according to the rate limit key.
+// Header values and the unverified claims are chosen by the client, hence their IP address is limited in addition with the ipLimit,
+// so that new values do not bypass the rate limit. Clients without the header or claim are identified by their IP address with the limit.
+// Header and claim values are hashed to limit the key length.
+func rateLimitBuckets(r *http.Request, limit *state.RateLimit, ipLimit *state.RateLimit) []rateLimitBucket {
+	var value string
+	//nolint:exhaustive // the ip is the fallback
+	switch limit.Key {
+	case state.RateLimitKeyHeader:
+		value = r.Header.Get(limit.KeyName)
+	case state.RateLimitKeyJwtClaim:
+		value = jwtClaim(r, limit.KeyName)
+	}
+	if value == "" {
+		return []rateLimitBucket{{key: "ip:" + clientIp(r), limit: limit}}
+	}
+	hash := sha256.Sum256([]byte(value))
+	buckets := []rateLimitBucket{{key: string(limit.Key) + ":" + hex.EncodeToString(hash[:])[:rateLimitKeyHashLength], limit: limit}}
+	if ipLimit != nil {
+		// separate from the buckets of the clients without value, as the limit differs
+		buckets = append(buckets, rateLimitBucket{key: "ip-total:" + clientIp(r), limit: ipLimit})
+	}
+	return buckets
+}
+
+// clientIp returns the IP address of the remote address of the request
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// jwtClaim returns the claim of the JWT bearer token of the request as string, empty if not present.
+// The token is not verified, hence the claim is only a trustworthy identifier if the backend rejects invalid tokens.
+func jwtClaim(r *http.Request, claim string) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(token, ".")
+	//nolint:gomnd // header, payload and signature
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims map[string]json.RawMessage
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	value, ok := claims[claim]
+	if !ok {
+		return ""
+	}
+	var stringValue string
+	if err = json.Unmarshal(value, &stringValue); err == nil {
+		return stringValue
+	}
+	// numbers and other values are used in their JSON encoding
+	return string(value)
+}
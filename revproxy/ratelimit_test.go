@@ -0,0 +1,195 @@
+package revproxy
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+)
+
+// testRateLimit allows 2 requests per second with a burst of 3
+var testRateLimit = &state.RateLimit{Requests: 2, Period: time.Second, Burst: 3, Key: state.RateLimitKeyIp, Scope: state.RateLimitScopeRoute}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	bucket := &tokenBucket{tokens: float64(testRateLimit.Burst), updated: now}
+	for remaining := 2; remaining >= 0; remaining-- {
+		result := bucket.take(testRateLimit, now)
+		require.True(t, result.Allowed)
+		require.Equal(t, remaining, result.Remaining)
+	}
+	result := bucket.take(testRateLimit, now)
+	require.False(t, result.Allowed)
+	require.Equal(t, time.Duration(500)*time.Millisecond, result.RetryAfter)
+	require.Equal(t, time.Duration(1500)*time.Millisecond, result.Reset)
+	// refilled by one token after half a second
+	result = bucket.take(testRateLimit, now.Add(time.Duration(500)*time.Millisecond))
+	require.True(t, result.Allowed)
+	require.Equal(t, 0, result.Remaining)
+}
+
+func TestMemoryTokenBucketStore(t *testing.T) {
+	store := NewMemoryTokenBucketStore()
+	now := time.Now()
+	for range testRateLimit.Burst {
+		result, err := store.Take(context.Background(), "a", testRateLimit, now)
+		require.NoError(t, err)
+		require.True(t, result.Allowed)
+	}
+	result, err := store.Take(context.Background(), "a", testRateLimit, now)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	result, err = store.Take(context.Background(), "b", testRateLimit, now)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	// buckets are removed once they are full again
+	_, err = store.Take(context.Background(), "b", testRateLimit, now.Add(memoryStoreSweepInterval))
+	require.NoError(t, err)
+	require.Len(t, store.buckets, 1)
+}
+
+func TestMemoryTokenBucketStoreMaxBuckets(t *testing.T) {
+	store := NewMemoryTokenBucketStore()
+	now := time.Now()
+	for i := range memoryStoreMaxBuckets + 10 {
+		_, err := store.Take(context.Background(), strconv.Itoa(i), testRateLimit, now)
+		require.NoError(t, err)
+	}
+	require.Len(t, store.buckets, memoryStoreMaxBuckets)
+}
+
+// getJwt returns an unsigned JWT with the payload
+func getJwt(payload string) string {
+	return "Bearer e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2lnbmF0dXJl"
+}
+
+func TestRateLimitBuckets(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://"+dummyHost+"/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("Authorization", getJwt(`{"sub":"user","tenant":42}`))
+	r.Header.Set("X-Api-Key", "key")
+	ipBuckets := []rateLimitBucket{{key: "ip:192.0.2.1", limit: testRateLimit}}
+	require.Equal(t, ipBuckets, rateLimitBuckets(r, testRateLimit, nil))
+
+	// the IP address is limited with the ip limit in addition to header and claim values
+	headerLimit := &state.RateLimit{Key: state.RateLimitKeyHeader, KeyName: "X-Api-Key", IpRequests: 10}
+	headerBuckets := rateLimitBuckets(r, headerLimit, testRateLimit)
+	require.Len(t, headerBuckets, 2)
+	require.Regexp(t, "^header:[0-9a-f]{32}$", headerBuckets[0].key)
+	require.Equal(t, headerLimit, headerBuckets[0].limit)
+	require.Equal(t, rateLimitBucket{key: "ip-total:192.0.2.1", limit: testRateLimit}, headerBuckets[1])
+	subBuckets := rateLimitBuckets(r, &state.RateLimit{Key: state.RateLimitKeyJwtClaim, KeyName: "sub"}, nil)
+	require.Len(t, subBuckets, 1)
+	require.Regexp(t, "^jwt-claim:[0-9a-f]{32}$", subBuckets[0].key)
+	tenantBuckets := rateLimitBuckets(r, &state.RateLimit{Key: state.RateLimitKeyJwtClaim, KeyName: "tenant"}, nil)
+	require.Len(t, tenantBuckets, 1)
+	require.Regexp(t, "^jwt-claim:[0-9a-f]{32}$", tenantBuckets[0].key)
+	require.NotEqual(t, subBuckets[0].key, tenantBuckets[0].key)
+	// clients without the header or claim are identified by the IP address with the limit
+	otherLimit := &state.RateLimit{Key: state.RateLimitKeyHeader, KeyName: "X-Other"}
+	require.Equal(t, []rateLimitBucket{{key: "ip:192.0.2.1", limit: otherLimit}}, rateLimitBuckets(r, otherLimit, testRateLimit))
+	r.Header.Set("Authorization", "Bearer invalid")
+	require.Len(t, rateLimitBuckets(r, &state.RateLimit{Key: state.RateLimitKeyJwtClaim, KeyName: "sub"}, testRateLimit), 1)
+}
+
+// getHeaderRateLimitHandler returns a rate limit handler keyed by the X-Api-Key header and a function that serves a request with the api key
+func getHeaderRateLimitHandler(limit *state.RateLimit) func(apiKey string) int {
+	handler := &rateLimitHandler{limit: limit, ipLimit: limit.IpLimit(), store: NewMemoryTokenBucketStore(),
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	return func(apiKey string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://"+dummyHost+"/", nil)
+		r.Header.Set("X-Api-Key", apiKey)
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+}
+
+func TestRateLimitHeaderKeyBypass(t *testing.T) {
+	serve := getHeaderRateLimitHandler(&state.RateLimit{Requests: 1, Period: time.Minute, Burst: 1,
+		Key: state.RateLimitKeyHeader, KeyName: "X-Api-Key", IpRequests: 2})
+	require.Equal(t, http.StatusOK, serve("a"))
+	require.Equal(t, http.StatusTooManyRequests, serve("a"))
+	// clients behind the same IP address have their own limit, rejected requests do not drain the IP address limit
+	require.Equal(t, http.StatusOK, serve("b"))
+	// new header values do not bypass the limit of the IP address
+	require.Equal(t, http.StatusTooManyRequests, serve("c"))
+}
+
+// getRateLimitProxy returns a reverse proxy with two rate limited paths that share a host scoped rate limit and an unlimited path
+func getRateLimitProxy(t *testing.T, store TokenBucketStore) (*ReverseProxy, *PrometheusMetrics) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(backend.Close)
+	metrics, err := RegisterMetrics(prometheus.NewRegistry(), "test")
+	require.NoError(t, err)
+	options := []ConfigOption{Metrics(metrics)}
+	if store != nil {
+		options = append(options, RateLimitStore(store))
+	}
+	reverseProxy := New(options...)
+	pathType := v1Net.PathTypePrefix
+	hostLimit := &state.RateLimit{Requests: 1, Period: time.Minute, Burst: 2, Key: state.RateLimitKeyIp, Scope: state.RateLimitScopeHost}
+	getBackendPath := func(path string, rateLimit *state.RateLimit) *state.BackendPath {
+		return &state.BackendPath{Namespace: "default", ServiceName: "svc", ServicePort: 8080, PathType: &pathType, Path: path,
+			Endpoints: []string{backend.Listener.Addr().String()}, RateLimit: rateLimit}
+	}
+	err = reverseProxy.LoadIngressState(state.IngressState{dummyHost: &state.DomainConfig{BackendPaths: []*state.BackendPath{
+		getBackendPath("/a", hostLimit), getBackendPath("/b", hostLimit), getBackendPath("/unlimited", nil),
+	}}})
+	require.NoError(t, err)
+	return reverseProxy, metrics
+}
+
+// serveRateLimitedRequest sends a GET request with the path via the reverse proxy
+func serveRateLimitedRequest(reverseProxy *ReverseProxy, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	reverseProxy.GetHandlerProxying().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://"+dummyHost+path, nil))
+	return w
+}
+
+func TestRateLimit(t *testing.T) {
+	reverseProxy, metrics := getRateLimitProxy(t, nil)
+	w := serveRateLimitedRequest(reverseProxy, "/a")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+	require.Equal(t, "1;w=60;burst=2", w.Header().Get("RateLimit-Policy"))
+	require.Equal(t, http.StatusOK, serveRateLimitedRequest(reverseProxy, "/b").Code)
+	w = serveRateLimitedRequest(reverseProxy, "/a")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	w = serveRateLimitedRequest(reverseProxy, "/unlimited")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Header().Get("RateLimit-Limit"))
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.rateLimited.WithLabelValues(dummyHost)))
+}
+
+// failingTokenBucketStore fails all takes
+type failingTokenBucketStore struct{}
+
+func (failingTokenBucketStore) Take(context.Context, string, *state.RateLimit, time.Time) (TokenBucketResult, error) {
+	return TokenBucketResult{}, errors.New("store unavailable")
+}
+
+func TestRateLimitStoreError(t *testing.T) {
+	reverseProxy, metrics := getRateLimitProxy(t, failingTokenBucketStore{})
+	for range 3 {
+		w := serveRateLimitedRequest(reverseProxy, "/a")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Empty(t, w.Header().Get("RateLimit-Limit"))
+	}
+	require.Equal(t, float64(3), testutil.ToFloat64(metrics.rateLimitStoreErrors))
+}
@@ -0,0 +1,287 @@
+package revproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1" //nolint:gosec // redis identifies scripts by their SHA1 digest
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+)
+
+var (
+	ErrRedisReply    = errors.New("error reply from redis")
+	ErrRedisProtocol = errors.New("unexpected redis reply")
+	// errRedisNoScript is the error reply for EVALSHA if the script is not cached by the server
+	errRedisNoScript = errors.New("NOSCRIPT")
+)
+
+//nolint:gomnd
+const (
+	// redisMaxIdleConns is the maximum number of idle connections of the RedisClient
+	redisMaxIdleConns = 16
+	// redisKeyPrefix is the prefix of the token bucket keys in redis
+	redisKeyPrefix = "ingress:rate-limit:"
+)
+
+// tokenBucketScript implements the tokenBucket.take in Lua, so that the token is taken atomically in redis.
+// The bucket is stored as hash and expires once it is full again.
+// Arguments are the tokens per millisecond, the burst and the current unix time in milliseconds.
+// Returns whether the token has been taken (0 or 1), the remaining whole tokens and the milliseconds till the bucket is full
+// and till the next token is available.
+const tokenBucketScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1]) or burst
+local updated = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate)
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry = math.ceil((1 - tokens) / rate)
+end
+local reset = math.ceil((burst - tokens) / rate)
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", now)
+redis.call("PEXPIRE", KEYS[1], math.max(reset, 1))
+return {allowed, math.floor(tokens), reset, retry}
+`
+
+// RedisClient evaluates Lua scripts at a redis compatible server that reply with an array of integers.
+// It is implemented by NewRedisClient and can be adapted from other redis clients or faked.
+type RedisClient interface {
+	Eval(ctx context.Context, script string, keys []string, args ...string) ([]int64, error)
+}
+
+// RedisTokenBucketStore holds the token buckets in redis, so that they are shared between replicas
+type RedisTokenBucketStore struct {
+	client RedisClient
+}
+
+// NewRedisTokenBucketStore returns a token bucket store that uses the redis client
+func NewRedisTokenBucketStore(client RedisClient) *RedisTokenBucketStore {
+	return &RedisTokenBucketStore{client: client}
+}
+
+// Take takes a token from the bucket with the given key via the tokenBucketScript
+func (store *RedisTokenBucketStore) Take(ctx context.Context, key string, limit *state.RateLimit, now time.Time) (TokenBucketResult, error) {
+	tokensPerMillisecond := tokensPerNanosecond(limit) * float64(time.Millisecond)
+	reply, err := store.client.Eval(ctx, tokenBucketScript, []string{redisKeyPrefix + key},
+		strconv.FormatFloat(tokensPerMillisecond, 'g', -1, 64), strconv.Itoa(limit.Burst), strconv.FormatInt(now.UnixMilli(), 10))
+	if err != nil {
+		return TokenBucketResult{}, err
+	}
+	//nolint:gomnd // allowed, remaining, reset and retry
+	if len(reply) != 4 {
+		return TokenBucketResult{}, fmt.Errorf("%w: %d values instead of 4 from the token bucket script", ErrRedisProtocol, len(reply))
+	}
+	return TokenBucketResult{
+		Allowed:    reply[0] == 1,
+		Remaining:  int(reply[1]),
+		Reset:      time.Duration(reply[2]) * time.Millisecond,
+		RetryAfter: time.Duration(reply[3]) * time.Millisecond,
+	}, nil
+}
+
+// redisClient is a minimal RESP client that only supports EVAL and EVALSHA with integer array replies
+type redisClient struct {
+	addr string
+	// password is sent via AUTH for new connections if not empty
+	password string
+	dialer   net.Dialer
+	// idle are the idle connections
+	idle chan *redisConn
+	// scriptHashes are the SHA1 digests of the evaluated scripts for EVALSHA
+	scriptHashes sync.Map
+}
+
+// RedisOption is used to implement the functional parameter pattern for NewRedisClient
+type RedisOption func(*redisClient)
+
+// RedisPassword sets the password that the RedisClient authenticates with via AUTH
+func RedisPassword(password string) RedisOption {
+	return func(client *redisClient) {
+		client.password = password
+	}
+}
+
+// redisConn is a connection to the redis server with a buffered reader for the replies
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisClient returns a minimal client for the redis compatible server at the address (host:port).
+// Without RedisPassword option the connections are not authenticated.
+func NewRedisClient(addr string, options ...RedisOption) RedisClient {
+	client := &redisClient{addr: addr, idle: make(chan *redisConn, redisMaxIdleConns)}
+	for _, option := range options {
+		option(client)
+	}
+	return client
+}
+
+// Eval evaluates the script at the server via EVALSHA, so that the script is only sent if the server has not cached it yet.
+// The deadline of the context applies to the connection.
+func (client *redisClient) Eval(ctx context.Context, script string, keys []string, args ...string) ([]int64, error) {
+	hash, ok := client.scriptHashes.Load(script)
+	if !ok {
+		digest := sha1.Sum([]byte(script)) //nolint:gosec // redis identifies scripts by their SHA1 digest
+		hash, _ = client.scriptHashes.LoadOrStore(script, hex.EncodeToString(digest[:]))
+	}
+	keysAndArgs := append(append([]string{strconv.Itoa(len(keys))}, keys...), args...)
+	reply, err := client.do(ctx, append([]string{"EVALSHA", hash.(string)}, keysAndArgs...))
+	if !errors.Is(err, errRedisNoScript) {
+		return reply, err
+	}
+	// EVAL also caches the script for the following EVALSHA commands
+	return client.do(ctx, append([]string{"EVAL", script}, keysAndArgs...))
+}
+
+// do sends the command and reads the integer array reply. The deadline of the context applies to the connection.
+func (client *redisClient) do(ctx context.Context, command []string) ([]int64, error) {
+	conn, err := client.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	if err = conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if _, err = conn.Write(encodeRedisCommand(command)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	reply, err := conn.readIntegerArray()
+	if err != nil && !errors.Is(err, ErrRedisReply) {
+		// the state of the connection is unknown
+		_ = conn.Close()
+		return nil, err
+	}
+	client.putConn(conn)
+	return reply, err
+}
+
+// getConn returns an idle connection or dials and authenticates a new one
+func (client *redisClient) getConn(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-client.idle:
+		return conn, nil
+	default:
+	}
+	netConn, err := client.dialer.DialContext(ctx, "tcp", client.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: netConn, reader: bufio.NewReader(netConn)}
+	if client.password == "" {
+		return conn, nil
+	}
+	if err = client.auth(ctx, conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// auth authenticates the new connection with the password
+func (client *redisClient) auth(ctx context.Context, conn *redisConn) error {
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	if _, err := conn.Write(encodeRedisCommand([]string{"AUTH", client.password})); err != nil {
+		return err
+	}
+	line, err := conn.readLine()
+	if err != nil {
+		return err
+	}
+	switch line[0] {
+	case '+':
+		return nil
+	case '-':
+		return fmt.Errorf("%w: %s", ErrRedisReply, line[1:])
+	default:
+		return fmt.Errorf("%w: %q instead of a status reply to AUTH", ErrRedisProtocol, line)
+	}
+}
+
+// putConn keeps the connection for reuse, it is closed if there are already enough idle connections
+func (client *redisClient) putConn(conn *redisConn) {
+	select {
+	case client.idle <- conn:
+	default:
+		_ = conn.Close()
+	}
+}
+
+// encodeRedisCommand encodes the command as RESP array of bulk strings
+func encodeRedisCommand(command []string) []byte {
+	var builder strings.Builder
+	builder.WriteString("*" + strconv.Itoa(len(command)) + "\r\n")
+	for _, value := range command {
+		builder.WriteString("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n")
+	}
+	return []byte(builder.String())
+}
+
+// readIntegerArray reads a RESP reply that has to be an array of integers. Error replies are returned as ErrRedisReply.
+func (conn *redisConn) readIntegerArray() ([]int64, error) {
+	line, err := conn.readLine()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasPrefix(line, "-"+errRedisNoScript.Error()):
+		return nil, fmt.Errorf("%w: %w: %s", ErrRedisReply, errRedisNoScript, line[1:])
+	case line[0] == '-':
+		return nil, fmt.Errorf("%w: %s", ErrRedisReply, line[1:])
+	case line[0] == '*':
+	default:
+		return nil, fmt.Errorf("%w: %q instead of an array", ErrRedisProtocol, line)
+	}
+	length, err := strconv.Atoi(line[1:])
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("%w: array length %q", ErrRedisProtocol, line[1:])
+	}
+	values := make([]int64, length)
+	for i := range values {
+		line, err = conn.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line[0] != ':' {
+			return nil, fmt.Errorf("%w: %q instead of an integer", ErrRedisProtocol, line)
+		}
+		if values[i], err = strconv.ParseInt(line[1:], 10, 64); err != nil {
+			return nil, fmt.Errorf("%w: integer %q", ErrRedisProtocol, line[1:])
+		}
+	}
+	return values, nil
+}
+
+// readLine reads a non-empty line of a RESP reply without the trailing CRLF
+func (conn *redisConn) readLine() (string, error) {
+	line, err := conn.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("%w: empty line", ErrRedisProtocol)
+	}
+	return line, nil
+}
@@ -0,0 +1,230 @@
+package revproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedisClient emulates the tokenBucketScript with the buckets in memory
+type fakeRedisClient struct {
+	lock    sync.Mutex
+	buckets map[string][2]float64
+}
+
+func newFakeRedisClient() *fakeRedisClient {
+	return &fakeRedisClient{buckets: make(map[string][2]float64)}
+}
+
+func (client *fakeRedisClient) Eval(_ context.Context, script string, keys []string, args ...string) ([]int64, error) {
+	if script != tokenBucketScript || len(keys) != 1 || len(args) != 3 {
+		return nil, fmt.Errorf("%w: unknown script", ErrRedisReply)
+	}
+	rate, _ := strconv.ParseFloat(args[0], 64)
+	burst, _ := strconv.ParseFloat(args[1], 64)
+	now, _ := strconv.ParseFloat(args[2], 64)
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	bucket, ok := client.buckets[keys[0]]
+	if !ok {
+		bucket = [2]float64{burst, now}
+	}
+	tokens := math.Min(burst, bucket[0]+math.Max(0, now-bucket[1])*rate)
+	allowed, retry := int64(0), int64(0)
+	if tokens >= 1 {
+		tokens--
+		allowed = 1
+	} else {
+		retry = int64(math.Ceil((1 - tokens) / rate))
+	}
+	client.buckets[keys[0]] = [2]float64{tokens, now}
+	return []int64{allowed, int64(tokens), int64(math.Ceil((burst - tokens) / rate)), retry}, nil
+}
+
+func TestRedisTokenBucketStore(t *testing.T) {
+	client := newFakeRedisClient()
+	store := NewRedisTokenBucketStore(client)
+	now := time.Now()
+	for remaining := 2; remaining >= 0; remaining-- {
+		result, err := store.Take(context.Background(), "a", testRateLimit, now)
+		require.NoError(t, err)
+		require.Equal(t, TokenBucketResult{Allowed: true, Remaining: remaining, Reset: time.Duration(3-remaining) * time.Duration(500) * time.Millisecond}, result)
+	}
+	result, err := store.Take(context.Background(), "a", testRateLimit, now)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Equal(t, time.Duration(500)*time.Millisecond, result.RetryAfter)
+	require.Contains(t, client.buckets, redisKeyPrefix+"a")
+}
+
+func TestRedisRateLimitSharedBetweenReplicas(t *testing.T) {
+	client := newFakeRedisClient()
+	replica1, _ := getRateLimitProxy(t, NewRedisTokenBucketStore(client))
+	replica2, _ := getRateLimitProxy(t, NewRedisTokenBucketStore(client))
+	require.Equal(t, 200, serveRateLimitedRequest(replica1, "/a").Code)
+	require.Equal(t, 200, serveRateLimitedRequest(replica2, "/a").Code)
+	require.Equal(t, 429, serveRateLimitedRequest(replica1, "/b").Code)
+	require.Equal(t, 429, serveRateLimitedRequest(replica2, "/b").Code)
+}
+
+// serveFakeRedis answers the AUTH, EVAL and EVALSHA commands of the connections via the fakeRedisClient till the listener is closed.
+// Connections have to authenticate first if the password is not empty. Other commands are answered with an error reply.
+func serveFakeRedis(listener net.Listener, client *fakeRedisClient, password string) {
+	var scriptsLock sync.Mutex
+	scripts := make(map[string]string)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			authenticated := password == ""
+			for {
+				command, err := readFakeRedisCommand(reader)
+				if err != nil {
+					return
+				}
+				var reply string
+				switch name := strings.ToUpper(command[0]); {
+				case name == "AUTH":
+					authenticated = command[1] == password
+					reply = "+OK\r\n"
+					if !authenticated {
+						reply = "-WRONGPASS invalid password\r\n"
+					}
+				case !authenticated:
+					reply = "-NOAUTH Authentication required.\r\n"
+				case name == "EVAL" || name == "EVALSHA":
+					script := command[1]
+					scriptsLock.Lock()
+					if name == "EVAL" {
+						digest := sha1.Sum([]byte(script)) //nolint:gosec // redis identifies scripts by their SHA1 digest
+						scripts[hex.EncodeToString(digest[:])] = script
+					} else {
+						script = scripts[command[1]]
+					}
+					scriptsLock.Unlock()
+					if script == "" {
+						reply = "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+						break
+					}
+					numKeys, _ := strconv.Atoi(command[2])
+					values, err := client.Eval(context.Background(), script, command[3:3+numKeys], command[3+numKeys:]...)
+					reply = "*" + strconv.Itoa(len(values)) + "\r\n"
+					for _, value := range values {
+						reply += ":" + strconv.FormatInt(value, 10) + "\r\n"
+					}
+					if err != nil {
+						reply = "-ERR " + err.Error() + "\r\n"
+					}
+				default:
+					reply = "-ERR unknown command\r\n"
+				}
+				if _, err = conn.Write([]byte(reply)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// readFakeRedisCommand reads a RESP array of bulk strings
+func readFakeRedisCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	command := make([]string, length)
+	for i := range command {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, value); err != nil {
+			return nil, err
+		}
+		command[i] = string(value[:size])
+	}
+	return command, nil
+}
+
+func TestRedisClient(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveFakeRedis(listener, newFakeRedisClient(), "")
+	client := NewRedisClient(listener.Addr().String())
+	store := NewRedisTokenBucketStore(client)
+	limit := &state.RateLimit{Requests: 1, Period: time.Minute, Burst: 1}
+	for _, allowed := range []bool{true, false, false} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		result, err := store.Take(ctx, "a", limit, time.Now())
+		cancel()
+		require.NoError(t, err)
+		require.Equal(t, allowed, result.Allowed)
+	}
+	_, err = client.Eval(context.Background(), "return 1", nil)
+	require.ErrorIs(t, err, ErrRedisReply)
+	// the connection is still usable after error replies
+	result, err := store.Take(context.Background(), "b", limit, time.Now())
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+}
+
+func TestRedisClientScriptCache(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveFakeRedis(listener, newFakeRedisClient(), "")
+	client := NewRedisClient(listener.Addr().String())
+	// the first EVALSHA falls back to EVAL as the script is unknown to the server, the following use the cached script
+	for _, want := range []int64{1, 0} {
+		reply, err := client.Eval(context.Background(), tokenBucketScript, []string{"a"}, "1", "1", "0")
+		require.NoError(t, err)
+		require.Equal(t, want, reply[0])
+	}
+	_, ok := client.(*redisClient).scriptHashes.Load(tokenBucketScript)
+	require.True(t, ok)
+}
+
+func TestRedisClientPassword(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveFakeRedis(listener, newFakeRedisClient(), "secret")
+	limit := &state.RateLimit{Requests: 1, Period: time.Minute, Burst: 1}
+
+	result, err := NewRedisTokenBucketStore(NewRedisClient(listener.Addr().String(), RedisPassword("secret"))).
+		Take(context.Background(), "a", limit, time.Now())
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	_, err = NewRedisTokenBucketStore(NewRedisClient(listener.Addr().String(), RedisPassword("wrong"))).
+		Take(context.Background(), "a", limit, time.Now())
+	require.ErrorIs(t, err, ErrRedisReply)
+	_, err = NewRedisTokenBucketStore(NewRedisClient(listener.Addr().String())).
+		Take(context.Background(), "a", limit, time.Now())
+	require.ErrorIs(t, err, ErrRedisReply)
+}
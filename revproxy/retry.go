@@ -0,0 +1,300 @@
+package revproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+)
+
+var ErrUnknownRetryOn = errors.New("unknown retry condition")
+
+//nolint:gomnd
+const (
+	// retryBudgetMaxTokens is the number of retries that the retry budget allows without further requests
+	retryBudgetMaxTokens = 10
+	// retryBudgetTokenUnit is the fixed point unit of the retry budget tokens
+	retryBudgetTokenUnit = 1000
+	// retryMaxBackoffMultiplier caps the exponential backoff between retries to a multiple of the base backoff
+	retryMaxBackoffMultiplier = 10
+)
+
+// idempotentMethods are the HTTP methods that can be retried safely
+var idempotentMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete}
+
+// attemptsKey is the context key of the attempt counter of a request, see WithAttemptCounter
+type attemptsKey struct{}
+
+// WithAttemptCounter returns a copy of the context with a counter for the backend attempts of the request.
+// The counter is set for requests that can be retried and read via Attempts once the request has been served, e.g. by the access log.
+func WithAttemptCounter(ctx context.Context) context.Context {
+	return context.WithValue(ctx, attemptsKey{}, &atomic.Int64{})
+}
+
+// Attempts returns the number of backend attempts of the request, 0 if the request could not be retried or the context has no counter
+func Attempts(ctx context.Context) int {
+	counter, ok := ctx.Value(attemptsKey{}).(*atomic.Int64)
+	if !ok {
+		return 0
+	}
+	return int(counter.Load())
+}
+
+// ParseRetryOn returns the state.RetryOn for the given name
+func ParseRetryOn(name string) (state.RetryOn, error) {
+	retryOn := state.RetryOn(name)
+	switch retryOn {
+	case state.RetryOnConnectFailure, state.RetryOnGatewayError:
+		return retryOn, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownRetryOn, name)
+	}
+}
+
+// retryBudget limits the retries across all backends to a ratio of the requests, so that retries do not overload failing backends.
+// Each request deposits the ratio as tokens and each retry withdraws a token. The balance is capped at retryBudgetMaxTokens.
+type retryBudget struct {
+	// tokens are the available tokens in retryBudgetTokenUnit
+	tokens  atomic.Int64
+	deposit int64
+}
+
+// newRetryBudget returns a full retry budget that allows the given ratio of retries to requests
+func newRetryBudget(ratio float64) *retryBudget {
+	budget := &retryBudget{deposit: int64(ratio * retryBudgetTokenUnit)}
+	budget.tokens.Store(retryBudgetMaxTokens * retryBudgetTokenUnit)
+	return budget
+}
+
+// addRequest deposits the tokens for a request
+func (budget *retryBudget) addRequest() {
+	for {
+		current := budget.tokens.Load()
+		next := min(current+budget.deposit, retryBudgetMaxTokens*retryBudgetTokenUnit)
+		if current == next || budget.tokens.CompareAndSwap(current, next) {
+			return
+		}
+	}
+}
+
+// withdraw takes the token for a retry, returns false if the budget is exhausted
+func (budget *retryBudget) withdraw() bool {
+	for {
+		current := budget.tokens.Load()
+		if current < retryBudgetTokenUnit {
+			return false
+		}
+		if budget.tokens.CompareAndSwap(current, current-retryBudgetTokenUnit) {
+			return true
+		}
+	}
+}
+
+// retrier holds the retry defaults and the retry budget shared by all backends
+type retrier struct {
+	retries int
+	retryOn state.RetryOn
+	backoff time.Duration
+	budget  *retryBudget
+	metrics *PrometheusMetrics
+}
+
+// newRetrier returns the retrier for the retry settings of the config
+func newRetrier(config *Config) *retrier {
+	return &retrier{
+		retries: config.Retries,
+		retryOn: config.RetryOn,
+		backoff: config.RetryBackoff,
+		budget:  newRetryBudget(config.RetryBudget),
+		metrics: config.Metrics,
+	}
+}
+
+// wrap returns a retryHandler for the load balancer, the policy overrides the defaults. The load balancer itself is returned if retries are disabled.
+// The backend identifies the load balancer in the metrics and logs.
+func (retrier *retrier) wrap(lb *loadBalancer, backend string, policy *state.RetryPolicy) http.Handler {
+	handler := &retryHandler{lb: lb, backend: backend, retries: retrier.retries, retryOn: retrier.retryOn, retrier: retrier}
+	if policy != nil && policy.Retries != nil {
+		handler.retries = *policy.Retries
+	}
+	if policy != nil && policy.RetryOn != "" {
+		handler.retryOn = policy.RetryOn
+	}
+	if handler.retries == 0 {
+		return lb
+	}
+	return handler
+}
+
+// backoffBefore returns the exponential backoff with jitter before the given retry (starting at 1)
+func (retrier *retrier) backoffBefore(retry int) time.Duration {
+	backoff := min(retrier.backoff<<(retry-1), retryMaxBackoffMultiplier*retrier.backoff)
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// retryHandler retries failed idempotent requests without body at other endpoints of the load balancer.
+// Connection failures are always retried, 502, 503 and 504 responses for state.RetryOnGatewayError.
+type retryHandler struct {
+	lb      *loadBalancer
+	backend string
+	retries int
+	retryOn state.RetryOn
+	retrier *retrier
+}
+
+func (handler *retryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isRetryable(r) {
+		handler.lb.ServeHTTP(w, r)
+		return
+	}
+	if err := handler.lb.requests.acquire(r.Context()); err != nil {
+		rejectRequest(w)
+		return
+	}
+	defer handler.lb.requests.release()
+	handler.retrier.budget.addRequest()
+	tried := make([]*endpoint, 0, handler.retries+1)
+	for {
+		ep, ok := handler.lb.pick(tried...)
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			break
+		}
+		tried = append(tried, ep)
+		attempt := &retryResponseWriter{ResponseWriter: w, header: make(http.Header), handler: handler, ctx: r.Context(), canRetry: len(tried) <= handler.retries}
+		ep.ServeHTTP(attempt, r)
+		if !attempt.retry {
+			break
+		}
+		if !sleepContext(r.Context(), handler.retrier.backoffBefore(len(tried))) {
+			w.WriteHeader(proxyErrorStatus(r, nil))
+			break
+		}
+	}
+	handler.retrier.metrics.observeBackendAttempts(handler.backend, len(tried))
+	if counter, ok := r.Context().Value(attemptsKey{}).(*atomic.Int64); ok {
+		counter.Store(int64(len(tried)))
+	}
+}
+
+// isRetryable returns whether the request can be retried, which requires an idempotent method and no body
+func isRetryable(r *http.Request) bool {
+	return slices.Contains(idempotentMethods, r.Method) && (r.Body == nil || r.Body == http.NoBody) && r.Header.Get("Upgrade") == ""
+}
+
+// isConnectFailure returns whether no connection to the endpoint could be established
+func isConnectFailure(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// isGatewayError returns whether the status is 502, 503 or 504
+func isGatewayError(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// sleepContext waits for the duration, returns false if the context is done before
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// retryResponseWriter is the response writer of a single attempt of a retryHandler. The response headers are held back till the status is known,
+// retried responses are discarded.
+type retryResponseWriter struct {
+	http.ResponseWriter
+	header  http.Header
+	handler *retryHandler
+	ctx     context.Context
+	// canRetry is false for the last attempt
+	canRetry    bool
+	wroteHeader bool
+	retry       bool
+	// err is the proxy error of the attempt, set by the error handler of the endpoint
+	err error
+}
+
+func (w *retryResponseWriter) Header() http.Header {
+	if w.wroteHeader && !w.retry {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *retryResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	header := w.ResponseWriter.Header()
+	if status < http.StatusOK && status != http.StatusSwitchingProtocols {
+		// informational responses are forwarded, their headers are removed afterward as by the httputil.ReverseProxy
+		for key, values := range w.header {
+			header[key] = values
+		}
+		w.ResponseWriter.WriteHeader(status)
+		for key := range w.header {
+			delete(header, key)
+		}
+		return
+	}
+	w.wroteHeader = true
+	if w.shouldRetry(status) {
+		w.retry = true
+		return
+	}
+	for key, values := range w.header {
+		header[key] = values
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// shouldRetry returns whether the response with the status is retried, this withdraws from the retry budget
+func (w *retryResponseWriter) shouldRetry(status int) bool {
+	if !w.canRetry || w.ctx.Err() != nil || errors.Is(w.err, ErrConnectionLimit) {
+		return false
+	}
+	if !isConnectFailure(w.err) && (w.handler.retryOn != state.RetryOnGatewayError || !isGatewayError(status)) {
+		return false
+	}
+	if !w.handler.retrier.budget.withdraw() {
+		w.handler.retrier.metrics.incRetryBudgetExhausted()
+		return false
+	}
+	return true
+}
+
+func (w *retryResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.retry {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// FlushError flushes the response unless it is discarded for a retry
+func (w *retryResponseWriter) FlushError() error {
+	if !w.wroteHeader || w.retry {
+		return nil
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped response writer for the http.ResponseController
+func (w *retryResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,123 @@
+package revproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// getClosedAddress returns an address on which connections are refused
+func getClosedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	return address
+}
+
+// getRetryProxy returns a reverse proxy with a default backend for the dummyHost with the given endpoints and retry policy
+func getRetryProxy(t *testing.T, metrics *PrometheusMetrics, policy *state.RetryPolicy, endpoints ...string) *ReverseProxy {
+	reverseProxy := New(Metrics(metrics), RetryBackoff(time.Millisecond))
+	err := reverseProxy.LoadIngressState(state.IngressState{dummyHost: &state.DomainConfig{DefaultBackend: &state.BackendPath{
+		Namespace: "default", ServiceName: "svc", ServicePort: 8080, Endpoints: endpoints, Retry: policy,
+	}}})
+	require.NoError(t, err)
+	return reverseProxy
+}
+
+// serveRetryRequest sends a request with the method via the reverse proxy
+func serveRetryRequest(reverseProxy *ReverseProxy, method string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	reverseProxy.GetHandlerProxying().ServeHTTP(w, httptest.NewRequest(method, "http://"+dummyHost+"/", nil))
+	return w
+}
+
+func TestParseRetryOn(t *testing.T) {
+	for _, retryOn := range []state.RetryOn{state.RetryOnConnectFailure, state.RetryOnGatewayError} {
+		parsed, err := ParseRetryOn(string(retryOn))
+		require.NoError(t, err)
+		require.Equal(t, retryOn, parsed)
+	}
+	_, err := ParseRetryOn("always")
+	require.ErrorIs(t, err, ErrUnknownRetryOn)
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(0.5)
+	for i := 0; i < retryBudgetMaxTokens; i++ {
+		require.True(t, budget.withdraw())
+	}
+	require.False(t, budget.withdraw())
+	budget.addRequest()
+	require.False(t, budget.withdraw())
+	budget.addRequest()
+	require.True(t, budget.withdraw())
+}
+
+func TestRetryConnectFailure(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	closed := getClosedAddress(t)
+	metrics, err := RegisterMetrics(prometheus.NewRegistry(), "test")
+	require.NoError(t, err)
+	reverseProxy := getRetryProxy(t, metrics, nil, closed, backend.Listener.Addr().String())
+	// round-robin starts with the closed endpoint
+	require.Equal(t, http.StatusOK, serveRetryRequest(reverseProxy, http.MethodGet).Code)
+	require.Equal(t, 1, testutil.CollectAndCount(metrics.backendAttempts))
+
+	reverseProxy = getRetryProxy(t, nil, nil, closed, backend.Listener.Addr().String())
+	require.Equal(t, http.StatusBadGateway, serveRetryRequest(reverseProxy, http.MethodPost).Code)
+
+	// without other endpoint the same endpoint is retried till the retries are exhausted
+	reverseProxy = getRetryProxy(t, nil, nil, closed)
+	require.Equal(t, http.StatusBadGateway, serveRetryRequest(reverseProxy, http.MethodGet).Code)
+
+	retries := 0
+	reverseProxy = getRetryProxy(t, nil, &state.RetryPolicy{Retries: &retries}, closed, backend.Listener.Addr().String())
+	require.Equal(t, http.StatusBadGateway, serveRetryRequest(reverseProxy, http.MethodGet).Code)
+}
+
+func TestRetryAttemptCounter(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	reverseProxy := getRetryProxy(t, nil, nil, getClosedAddress(t), backend.Listener.Addr().String())
+	r := httptest.NewRequest(http.MethodGet, "http://"+dummyHost+"/", nil)
+	r = r.WithContext(WithAttemptCounter(r.Context()))
+	w := httptest.NewRecorder()
+	reverseProxy.GetHandlerProxying().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, 2, Attempts(r.Context()))
+	require.Equal(t, 0, Attempts(context.Background()))
+}
+
+func TestRetryGatewayError(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Failed", "true")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("failed"))
+	}))
+	defer failing.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	endpoints := []string{failing.Listener.Addr().String(), backend.Listener.Addr().String()}
+
+	reverseProxy := getRetryProxy(t, nil, nil, endpoints...)
+	require.Equal(t, http.StatusServiceUnavailable, serveRetryRequest(reverseProxy, http.MethodGet).Code)
+
+	reverseProxy = getRetryProxy(t, nil, &state.RetryPolicy{RetryOn: state.RetryOnGatewayError}, endpoints...)
+	w := serveRetryRequest(reverseProxy, http.MethodGet)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "ok", w.Body.String())
+	// the headers of the discarded response are not forwarded
+	require.Empty(t, w.Header().Get("X-Failed"))
+}
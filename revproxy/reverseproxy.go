@@ -1,15 +1,19 @@
 package revproxy
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"github.com/ngergs/ingress/v2/state"
 	"github.com/rs/zerolog/log"
 	v1Net "k8s.io/api/networking/v1"
 	"net"
 	"net/http"
+	"regexp"
 	"strings"
 	"sync/atomic"
+	"time"
 )
 
 var (
@@ -25,129 +29,274 @@ type ReverseProxy struct {
 	state atomic.Pointer[reverseProxyState]
 	// Transport are the transport configurations for the reverse proxy. Will be cloned for each path.
 	Transport http.RoundTripper
+	// loadBalancing is the algorithm used to distribute requests across the endpoints of a backend service
+	loadBalancing LoadBalancingAlgorithm
+	// acmeSolver answers the ACME challenges of the built-in ACME client, nil if it is disabled
+	acmeSolver ChallengeSolver
+	// defaultCert is the fallback if neither the host nor the state.CatchAllHost has a certificate. Optional.
+	defaultCert *tls.Certificate
+	// ocspStapler provides the OCSP responses for the loaded certificates
+	ocspStapler *ocspStapler
+	// metrics are the prometheus metrics of the reverse proxy. Optional.
+	metrics *PrometheusMetrics
+	// dialer connects to the backends, it is also used for the TLS passthrough connections
+	dialer *net.Dialer
+	// retrier retries failed requests to the backends
+	retrier *retrier
+	// circuitBreaker are the default limits of the load on each backend
+	circuitBreaker state.CircuitBreaker
+	// timeouts are the default timeouts of the proxied requests, see routeTimeouts
+	timeouts state.Timeouts
+	// rateLimitStore holds the token buckets of the rate limits, see rateLimitHandler
+	rateLimitStore TokenBucketStore
 }
 
-// BackendRouting contains a mopping of host name to the relevant backend path handlers in order of priority
-type BackendRouting map[string]backendPathHandlers
+// BackendRouting contains a mopping of host name to the relevant routing information.
+// Host names may be wildcards (*.example.com), see lookupHost. The state.CatchAllHost entry applies to all hosts.
+type BackendRouting map[string]*hostRouting
 
-// TlsCerts contains a mapping of host name to the relevant TLS certificates
-type TlsCerts map[string]*tls.Certificate
+// hostRouting holds the backend path handlers and the optional default backend for a single host
+type hostRouting struct {
+	pathHandlers   *backendPathHandlers
+	defaultBackend http.Handler
+	// passthrough receives the TLS connections for the host without termination, see PassthroughListener. Optional.
+	passthrough *loadBalancer
+}
+
+// match returns the handler for the given host and path. The host routing is selected via lookupHost. The precedence is:
+// matching path of the host, matching path of the catch-all rules, default backend of the host, ingress class wide default backend.
+func (routing BackendRouting) match(host string, path string) (handler http.Handler, ok bool) {
+	hostRouting, hostOk := lookupHost(routing, host)
+	catchAllRouting, catchAllOk := routing[state.CatchAllHost]
+	if hostOk {
+		if pathHandler, ok := hostRouting.pathHandlers.match(path); ok {
+			return pathHandler.ProxyHandler, true
+		}
+	}
+	if catchAllOk {
+		if pathHandler, ok := catchAllRouting.pathHandlers.match(path); ok {
+			return pathHandler.ProxyHandler, true
+		}
+	}
+	if hostOk && hostRouting.defaultBackend != nil {
+		return hostRouting.defaultBackend, true
+	}
+	if catchAllOk && catchAllRouting.defaultBackend != nil {
+		return catchAllRouting.defaultBackend, true
+	}
+	return nil, false
+}
+
+// TlsCerts contains a mapping of host name to the relevant TLS certificates. Host names may be wildcards, see lookupHost.
+// The certificates of a host are ordered by preference, see selectCertificate.
+type TlsCerts map[string][]*tls.Certificate
 
 // reverseProxyState holds the current state of the reverse proxy.
 type reverseProxyState struct {
 	backendPathHandlers BackendRouting
 	tlsCerts            TlsCerts
+	// loadBalancers are keyed by the backend service port and protocol, see loadBalancerKey
+	loadBalancers map[string]*loadBalancer
+	// clientAuthConfigs are keyed by host, host names may be wildcards, see lookupHost
+	clientAuthConfigs map[string]*clientAuthConfig
+	// tlsSettings are the per host overrides of the TLS settings keyed by host, host names may be wildcards, see lookupHost
+	tlsSettings map[string]*TlsSettings
 }
 
-// backendPathHandlers is a slice of backendPathHandler
-type backendPathHandlers []*backendPathHandler
-
 // backendPathHandler holds the ingress PathRule for path matching as well as the corresponding reverse proxy handler for the given backend path.
 type backendPathHandler struct {
 	ProxyHandler http.Handler
 	PathType     *v1Net.PathType
 	Path         string
-}
-
-// match returns the matching backendPathHandler for the given path argument if one is present
-func (pathHandlers *backendPathHandlers) match(path string) (pathHandler *backendPathHandler, ok bool) {
-	for _, pathHandler := range *pathHandlers {
-		if *pathHandler.PathType == v1Net.PathTypeExact && path == pathHandler.Path {
-			return pathHandler, true
-		}
-		if strings.HasPrefix(path, pathHandler.Path) {
-			return pathHandler, true
-		}
-	}
-	return nil, false
+	// pattern is the compiled Path for the PathType ImplementationSpecific
+	pattern *regexp.Regexp
 }
 
 // New setups a new reverse proxy. To start it see methods GetServerHttp and GetServerHttps.
 func New(options ...ConfigOption) *ReverseProxy {
 	config := defaultConfig.clone().applyOptions(options...)
+	if config.RateLimitStore == nil {
+		config.RateLimitStore = NewMemoryTokenBucketStore()
+	}
 
+	dialer := &net.Dialer{
+		Timeout: config.Timeouts.Connect,
+	}
+	reverseProxy := &ReverseProxy{Transport: http.DefaultTransport, loadBalancing: config.LoadBalancing, acmeSolver: config.AcmeSolver, defaultCert: config.DefaultCertificate,
+		ocspStapler: newOcspStapler(config.Metrics), metrics: config.Metrics, dialer: dialer,
+		retrier: newRetrier(config), circuitBreaker: config.CircuitBreaker, timeouts: config.Timeouts,
+		rateLimitStore: config.RateLimitStore}
+	config.Metrics.observeBackends(reverseProxy)
 	defaultTransport, ok := http.DefaultTransport.(*http.Transport)
 	if !ok {
-		log.Warn().Msg("http.DefaultTransport is not *http.Transport, backendTimeout will not be configured")
-		return &ReverseProxy{Transport: http.DefaultTransport}
+		log.Warn().Msg("http.DefaultTransport is not *http.Transport, the route connect timeouts will not be configured")
+		return reverseProxy
 	}
 	transport := defaultTransport.Clone()
-	transport.DialContext = (&net.Dialer{
-		Timeout: config.BackendTimeout,
-	}).DialContext
-	return &ReverseProxy{Transport: transport}
+	transport.DialContext = reverseProxy.dialBackend
+	reverseProxy.Transport = transport
+	return reverseProxy
 }
 
 // GetCertificateFunc returns a function for the tls.Config.GetCertificate callback.
-// Supposed to be used with tls.Listener.
+// Supposed to be used with tls.Listener. Pending TLS-ALPN-01 challenges of the AcmeSolver are answered,
+// this requires the acme-tls/1 protocol in the tls.Config.NextProtos.
+// If a host has multiple certificates, the first one that the client supports is used, see selectCertificate.
+// Hosts without certificate and clients without SNI get the certificate of the state.CatchAllHost or the DefaultCertificate,
+// so that the handshake succeeds and the request is answered with an HTTP error.
+// The certificates of the ingress state are stapled with their OCSP response if available, see RunOcspStapling.
 func (proxy *ReverseProxy) GetCertificateFunc() func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-		state := proxy.state.Load()
-		if state == nil {
+		if cert, ok := tlsAlpn01ChallengeCert(proxy.acmeSolver, hello); ok {
+			return cert, nil
+		}
+		currentState := proxy.state.Load()
+		if currentState != nil {
+			if certs, ok := lookupHost(currentState.tlsCerts, hello.ServerName); ok {
+				return proxy.ocspStapler.staple(selectCertificate(hello, certs), time.Now()), nil
+			}
+			if certs, ok := currentState.tlsCerts[state.CatchAllHost]; ok {
+				return proxy.ocspStapler.staple(selectCertificate(hello, certs), time.Now()), nil
+			}
+		}
+		if proxy.defaultCert != nil {
+			return proxy.defaultCert, nil
+		}
+		if currentState == nil {
 			return nil, ErrNotInitialized
 		}
-		cert, ok := state.tlsCerts[hello.ServerName]
+		return nil, fmt.Errorf("%w: %s", ErrNoCertificateFound, hello.ServerName)
+	}
+}
 
-		if !ok {
-			return nil, fmt.Errorf("%w: %s", ErrNoCertificateFound, hello.ServerName)
+// selectCertificate returns the first certificate that the client supports, e.g. an ECDSA certificate for modern clients and an RSA
+// certificate for old clients. If the client supports none of them, the first one is returned and the handshake may fail on the client side.
+func selectCertificate(hello *tls.ClientHelloInfo, certs []*tls.Certificate) *tls.Certificate {
+	if len(certs) == 1 {
+		return certs[0]
+	}
+	for _, cert := range certs {
+		if hello.SupportsCertificate(cert) == nil {
+			return cert
 		}
-		return cert, nil
+	}
+	return certs[0]
+}
+
+// RunOcspStapling fetches and refreshes the OCSP responses of the loaded certificates till the context is done.
+// Failed fetches are logged and counted in the PrometheusMetrics.
+func (proxy *ReverseProxy) RunOcspStapling(ctx context.Context) {
+	proxy.ocspStapler.run(ctx)
+}
+
+// GetConfigForClientFunc returns a function for the tls.Config.GetConfigForClient callback of the given base config.
+// For hosts with overridden TLS settings or client authentication a modified clone of the base config is returned,
+// otherwise the base config is used.
+func (proxy *ReverseProxy) GetConfigForClientFunc(base *tls.Config) func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		currentState := proxy.state.Load()
+		if currentState == nil {
+			return nil, nil //nolint:nilnil // nil uses the base config
+		}
+		tlsSettings, hasTlsSettings := lookupHost(currentState.tlsSettings, hello.ServerName)
+		clientAuth, hasClientAuth := lookupHost(currentState.clientAuthConfigs, hello.ServerName)
+		if !hasTlsSettings && !hasClientAuth {
+			return nil, nil //nolint:nilnil // nil uses the base config
+		}
+		config := base.Clone()
+		// the clone would call this function again for the connection
+		config.GetConfigForClient = nil
+		if hasTlsSettings {
+			tlsSettings.Apply(config)
+		}
+		if hasClientAuth {
+			config.ClientAuth = clientAuth.clientAuth
+			config.ClientCAs = clientAuth.clientCAs
+		}
+		return config, nil
 	}
 }
 
 // GetHandlerProxying returns the main proxying handler. Can be used with HTTP and HTTPS listeners.
-// A TLS-terminating setup should use this for HTTPS only.
+// A TLS-terminating setup should use this for HTTPS only. For hosts with client authentication the verified
+// client certificate is forwarded via the HeaderClientCertSubject and HeaderClientCertFingerprint.
+// gRPC requests get gRPC status codes for errors and are bounded by their grpc-timeout, see serveGrpc.
 func (proxy *ReverseProxy) GetHandlerProxying() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		state := proxy.state.Load()
-		if state == nil {
-			w.WriteHeader(http.StatusServiceUnavailable)
-			return
-		}
-		// remove eventual port suffix from r.Host
-		pathHandlers, ok := state.backendPathHandlers[strings.Split(r.Host, ":")[0]]
-		if !ok {
-			w.WriteHeader(http.StatusNotFound)
-			return // no response if host does not match
-		}
-		// first match is selected
-		pathHandler, ok := pathHandlers.match(r.URL.Path)
-		if ok {
-			pathHandler.ProxyHandler.ServeHTTP(w, r)
+		if isGrpcRequest(r) {
+			proxy.serveGrpc(w, r)
 			return
 		}
-		w.WriteHeader(http.StatusNotFound)
+		proxy.serveProxying(w, r)
 	})
 }
 
+// serveProxying proxies the request to the matching backend. Returns whether a backend has been matched.
+func (proxy *ReverseProxy) serveProxying(w http.ResponseWriter, r *http.Request) (routed bool) {
+	currentState := proxy.state.Load()
+	if currentState == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return false
+	}
+	if !serveClientAuth(currentState.clientAuthConfigs, w, r) {
+		return false
+	}
+	handler, ok := currentState.backendPathHandlers.match(hostWithoutPort(r.Host), r.URL.Path)
+	if ok {
+		handler.ServeHTTP(w, r)
+		return true
+	}
+	w.WriteHeader(http.StatusNotFound)
+	return false
+}
+
+// lookupHost returns the map value for the given host. Exact hosts take precedence,
+// if none is present the value for the matching wildcard host is returned.
+// As defined in the ingress spec the wildcard only covers a single DNS label, i.e. *.example.com matches foo.example.com
+// but neither example.com nor bar.foo.example.com.
+func lookupHost[T any](hostMap map[string]T, host string) (value T, ok bool) {
+	host = strings.ToLower(host)
+	value, ok = hostMap[host]
+	if ok {
+		return value, true
+	}
+	_, parentDomain, found := strings.Cut(host, ".")
+	if !found || parentDomain == "" {
+		return value, false
+	}
+	value, ok = hostMap["*."+parentDomain]
+	return value, ok
+}
+
+// hostWithoutPort removes an eventual port suffix from the host
+func hostWithoutPort(host string) string {
+	return strings.Split(host, ":")[0]
+}
+
 // GetHttpsRedirectHandler returns a handler which redirects all requests with HTTP status 308 to the same route but with the https scheme.
 // Should therefore not be used for TLS listeners.
-// Paths that start with  "/.well-known/acme-challenge" are stil reverse proxied to the backend for ACME challenges.
+// Paths that start with  "/.well-known/acme-challenge" are stil reverse proxied to the backend for ACME challenges,
+// unless it is a pending HTTP-01 challenge of the AcmeSolver.
 func (proxy *ReverseProxy) GetHttpsRedirectHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if serveHttp01Challenge(proxy.acmeSolver, w, r) {
+			return
+		}
 		state := proxy.state.Load()
 		if state == nil {
 			w.WriteHeader(http.StatusServiceUnavailable)
 			return
 		}
-		pathHandlers, ok := state.backendPathHandlers[r.Host]
+		handler, ok := state.backendPathHandlers.match(hostWithoutPort(r.Host), r.URL.Path)
 		if !ok {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 		if strings.HasPrefix(r.URL.Path, acmePath) {
-			pathHandler, ok := pathHandlers.match(r.URL.Path)
-			if ok {
-				pathHandler.ProxyHandler.ServeHTTP(w, r)
-				return
-			}
-		}
-		_, ok = pathHandlers.match(r.URL.Path)
-		if ok {
-			w.Header().Set("Location", "https://"+r.Host+r.URL.Path)
-			w.WriteHeader(http.StatusPermanentRedirect)
+			handler.ServeHTTP(w, r)
 			return
 		}
-		w.WriteHeader(http.StatusNotFound)
+		w.Header().Set("Location", "https://"+r.Host+r.URL.Path)
+		w.WriteHeader(http.StatusPermanentRedirect)
 	})
 }
@@ -1,19 +1,30 @@
 package revproxy
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/ngergs/ingress/v2/state"
+	"math/big"
 	"net/http"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
 )
 
 func TestTlsConfigMatch(t *testing.T) {
 	reverseProxy := getDummyReverseProxy(t, nil)
 	state := reverseProxy.state.Load()
 	require.NotNil(t, state)
-	expectedCert := state.tlsCerts[dummyHost]
+	expectedCert := state.tlsCerts[dummyHost][0]
 	receivedCert, err := reverseProxy.GetCertificateFunc()(&tls.ClientHelloInfo{
 		ServerName: dummyHost,
 	})
@@ -37,6 +48,34 @@ func TestTlsConfigStateNotRdy(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestTlsConfigDefaultCertificate(t *testing.T) {
+	defaultCert := &tls.Certificate{}
+	reverseProxy := New(DefaultCertificate(defaultCert))
+	// also before the state is loaded
+	receivedCert, err := reverseProxy.GetCertificateFunc()(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	require.Same(t, defaultCert, receivedCert)
+
+	reverseProxy.state.Store(getDummyReverseProxy(t, nil).state.Load())
+	currentState := reverseProxy.state.Load()
+	receivedCert, err = reverseProxy.GetCertificateFunc()(&tls.ClientHelloInfo{ServerName: "none"})
+	require.NoError(t, err)
+	require.Same(t, defaultCert, receivedCert)
+	receivedCert, err = reverseProxy.GetCertificateFunc()(&tls.ClientHelloInfo{ServerName: dummyHost})
+	require.NoError(t, err)
+	require.Same(t, currentState.tlsCerts[dummyHost][0], receivedCert)
+
+	// the default certificate from the state takes precedence
+	stateDefaultCert := &tls.Certificate{}
+	currentState.tlsCerts[state.CatchAllHost] = []*tls.Certificate{stateDefaultCert}
+	receivedCert, err = reverseProxy.GetCertificateFunc()(&tls.ClientHelloInfo{ServerName: "none"})
+	require.NoError(t, err)
+	require.Same(t, stateDefaultCert, receivedCert)
+	receivedCert, err = reverseProxy.GetCertificateFunc()(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	require.Same(t, stateDefaultCert, receivedCert)
+}
+
 func internalTestHandlerProxying(t *testing.T, host string, path string, expectedStatus int) {
 	w, r, next := getDefaultHandlerMocks()
 	next.serveHttpFunc = func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
@@ -58,6 +97,7 @@ func TestHandlerProxying(t *testing.T) {
 	internalTestHandlerProxying(t, dummyHost, "/", http.StatusNotFound)
 	internalTestHandlerProxying(t, dummyHost, prefixPath, http.StatusOK)
 	internalTestHandlerProxying(t, dummyHost, prefixPath+"/sub", http.StatusOK)
+	internalTestHandlerProxying(t, dummyHost, prefixPath+"ing", http.StatusNotFound)
 }
 
 func internalTestHandlerRedirecting(t *testing.T, host string, path string, expectedStatus int) {
@@ -105,3 +145,146 @@ func TestHandlerStateNotRdy(t *testing.T) {
 	internalTestHandlerStateNotRdy(t, reverseProxy.GetHandlerProxying())
 	internalTestHandlerStateNotRdy(t, reverseProxy.GetHttpsRedirectHandler())
 }
+
+func TestHandlerProxyingFallback(t *testing.T) {
+	statusHandler := func(status int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(status) })
+	}
+	prefix := v1Net.PathTypePrefix
+	reverseProxy := &ReverseProxy{}
+	reverseProxy.state.Store(&reverseProxyState{
+		backendPathHandlers: BackendRouting{
+			dummyHost: {
+				pathHandlers:   newBackendPathHandlers(&backendPathHandler{PathType: &prefix, Path: prefixPath, ProxyHandler: statusHandler(http.StatusOK)}),
+				defaultBackend: statusHandler(http.StatusAccepted),
+			},
+			state.CatchAllHost: {
+				pathHandlers:   newBackendPathHandlers(&backendPathHandler{PathType: &prefix, Path: "/catch", ProxyHandler: statusHandler(http.StatusCreated)}),
+				defaultBackend: statusHandler(http.StatusNonAuthoritativeInfo),
+			},
+		},
+	})
+	for _, testCase := range []struct {
+		host           string
+		path           string
+		expectedStatus int
+	}{
+		{dummyHost, prefixPath, http.StatusOK},
+		{dummyHost, "/catch", http.StatusCreated},
+		{dummyHost, "/", http.StatusAccepted},
+		{"none", prefixPath, http.StatusNonAuthoritativeInfo},
+		{"none", "/catch", http.StatusCreated},
+	} {
+		w, r, _ := getDefaultHandlerMocks()
+		r.Host = testCase.host
+		r.URL = &url.URL{Path: testCase.path}
+		reverseProxy.GetHandlerProxying().ServeHTTP(w, r)
+		result := w.Result()
+		require.NoError(t, result.Body.Close())
+		require.Equal(t, testCase.expectedStatus, result.StatusCode, "host %s path %s", testCase.host, testCase.path)
+	}
+}
+
+func TestLookupHost(t *testing.T) {
+	hostMap := map[string]int{
+		"foo.example.com": 1,
+		"*.example.com":   2,
+	}
+	for _, testCase := range []struct {
+		host     string
+		expected int
+		ok       bool
+	}{
+		{"foo.example.com", 1, true},
+		{"FOO.example.com", 1, true},
+		{"bar.example.com", 2, true},
+		{"example.com", 0, false},
+		{"baz.bar.example.com", 0, false},
+		{"none", 0, false},
+	} {
+		value, ok := lookupHost(hostMap, testCase.host)
+		require.Equal(t, testCase.ok, ok, testCase.host)
+		require.Equal(t, testCase.expected, value, testCase.host)
+	}
+}
+
+func TestTlsConfigWildcardMatch(t *testing.T) {
+	reverseProxy := getDummyReverseProxy(t, nil)
+	state := reverseProxy.state.Load()
+	require.NotNil(t, state)
+	expectedCert := &tls.Certificate{}
+	state.tlsCerts["*.example.com"] = []*tls.Certificate{expectedCert}
+	receivedCert, err := reverseProxy.GetCertificateFunc()(&tls.ClientHelloInfo{
+		ServerName: "foo.example.com",
+	})
+	require.NoError(t, err)
+	require.Same(t, expectedCert, receivedCert)
+	_, err = reverseProxy.GetCertificateFunc()(&tls.ClientHelloInfo{
+		ServerName: "example.com",
+	})
+	require.ErrorIs(t, err, ErrNoCertificateFound)
+}
+
+func TestHandlerProxyingWildcard(t *testing.T) {
+	w, r, next := getDefaultHandlerMocks()
+	next.serveHttpFunc = func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	reverseProxy := getDummyReverseProxy(t, next)
+	state := reverseProxy.state.Load()
+	state.backendPathHandlers["*.example.com"] = state.backendPathHandlers[dummyHost]
+	r.Host = "foo.example.com:443"
+	r.URL = &url.URL{Path: prefixPath}
+	reverseProxy.GetHandlerProxying().ServeHTTP(w, r)
+	result := w.Result()
+	require.NoError(t, result.Body.Close())
+	require.Equal(t, http.StatusOK, result.StatusCode)
+}
+
+// getSelfSignedTlsCert returns a PEM encoded self-signed certificate for the dummy host with the given key
+func getSelfSignedTlsCert(t *testing.T, key crypto.Signer) *state.TlsCert {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{dummyHost},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return &state.TlsCert{
+		Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func TestTlsConfigMultipleCertificates(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	reverseProxy := New()
+	err = reverseProxy.LoadIngressState(state.IngressState{dummyHost: &state.DomainConfig{
+		TlsCerts: []*state.TlsCert{getSelfSignedTlsCert(t, rsaKey), getSelfSignedTlsCert(t, ecdsaKey)},
+	}})
+	require.NoError(t, err)
+
+	for _, testCase := range []struct {
+		name             string
+		signatureSchemes []tls.SignatureScheme
+		expected         x509.PublicKeyAlgorithm
+	}{
+		{"modern client", []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256}, x509.ECDSA},
+		{"rsa only client", []tls.SignatureScheme{tls.PSSWithSHA256}, x509.RSA},
+		{"no supported certificate", []tls.SignatureScheme{tls.Ed25519}, x509.ECDSA},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			cert, err := reverseProxy.GetCertificateFunc()(&tls.ClientHelloInfo{
+				ServerName:        dummyHost,
+				SupportedVersions: []uint16{tls.VersionTLS13},
+				SignatureSchemes:  testCase.signatureSchemes,
+			})
+			require.NoError(t, err)
+			require.Equal(t, testCase.expected, cert.Leaf.PublicKeyAlgorithm)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package revproxy
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	v1Net "k8s.io/api/networking/v1"
+)
+
+// pathRewriter replaces the matched part of the request path with the target before the request is forwarded to the next handler.
+// Location headers of the responses that point to the rewritten path are mapped back to the original path.
+type pathRewriter struct {
+	pathType v1Net.PathType
+	path     string
+	// pattern is only set for the path type ImplementationSpecific
+	pattern *regexp.Regexp
+	target  string
+	next    http.Handler
+}
+
+// ServeHTTP rewrites the request path and forwards the request to the next handler
+func (rewriter *pathRewriter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	matched, replacement, rest := rewriter.split(r.URL.Path)
+	// shallow copy just like http.StripPrefix
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = joinPath(replacement, rest)
+	r2.URL.RawPath = ""
+	rewriter.next.ServeHTTP(&locationRewriteWriter{
+		ResponseWriter: w,
+		host:           r.Host,
+		from:           replacement,
+		to:             matched,
+	}, r2)
+}
+
+// split returns the matched part of the path, the replacement for it and the unmatched rest of the path
+func (rewriter *pathRewriter) split(path string) (matched string, replacement string, rest string) {
+	switch {
+	case rewriter.pattern != nil:
+		loc := rewriter.pattern.FindStringSubmatchIndex(path)
+		if loc == nil {
+			return "", rewriter.target, path
+		}
+		return path[:loc[1]], string(rewriter.pattern.ExpandString(nil, rewriter.target, path, loc)), path[loc[1]:]
+	case rewriter.pathType == v1Net.PathTypeExact:
+		return path, rewriter.target, ""
+	default:
+		matchLength := prefixMatchLength(rewriter.path, path)
+		return path[:matchLength], rewriter.target, path[matchLength:]
+	}
+}
+
+// joinPath joins the two path segments with a single slash
+func joinPath(a string, b string) string {
+	if b == "" {
+		return a
+	}
+	aSlash := strings.HasSuffix(a, "/")
+	bSlash := strings.HasPrefix(b, "/")
+	switch {
+	case aSlash && bSlash:
+		return a + b[1:]
+	case !aSlash && !bSlash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// locationRewriteWriter maps the Location header of the response from the rewritten path back to the original path
+type locationRewriteWriter struct {
+	http.ResponseWriter
+	host string
+	from string
+	to   string
+}
+
+// WriteHeader rewrites the Location header if present and writes the header
+func (w *locationRewriteWriter) WriteHeader(statusCode int) {
+	if location := w.Header().Get("Location"); location != "" {
+		w.Header().Set("Location", w.rewriteLocation(location))
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Unwrap returns the underlying http.ResponseWriter for the http.ResponseController
+func (w *locationRewriteWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// rewriteLocation maps the location from the rewritten to the original path.
+// Only absolute paths and absolute URLs for the requested host are considered.
+func (w *locationRewriteWriter) rewriteLocation(location string) string {
+	locationUrl, err := url.Parse(location)
+	if err != nil || (locationUrl.Host != "" && locationUrl.Host != w.host) || !strings.HasPrefix(locationUrl.Path, "/") {
+		return location
+	}
+	rest, ok := strings.CutPrefix(locationUrl.Path, w.from)
+	// only cut at path element boundaries
+	if !ok || (rest != "" && !strings.HasSuffix(w.from, "/") && !strings.HasPrefix(rest, "/")) {
+		return location
+	}
+	path := joinPath(w.to, rest)
+	// keep the trailing slash of the location
+	if strings.HasSuffix(locationUrl.Path, "/") && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	locationUrl.Path = path
+	locationUrl.RawPath = ""
+	return locationUrl.String()
+}
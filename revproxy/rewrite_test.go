@@ -0,0 +1,78 @@
+package revproxy
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+)
+
+func internalTestRewrite(t *testing.T, rewriter *pathRewriter, path string, expectedPath string) {
+	w, r, next := getDefaultHandlerMocks()
+	rewriter.next = next
+	r.URL = &url.URL{Path: path}
+	rewriter.ServeHTTP(w, r)
+	require.Equal(t, expectedPath, next.r.URL.Path, path)
+	require.Equal(t, path, r.URL.Path, "original request must not be modified")
+}
+
+func TestRewritePrefix(t *testing.T) {
+	rewriter := &pathRewriter{pathType: v1Net.PathTypePrefix, path: "/api/x/", target: "/"}
+	internalTestRewrite(t, rewriter, "/api/x", "/")
+	internalTestRewrite(t, rewriter, "/api/x/", "/")
+	internalTestRewrite(t, rewriter, "/api/x/foo/bar", "/foo/bar")
+	rewriter.target = "/app"
+	internalTestRewrite(t, rewriter, "/api/x", "/app")
+	internalTestRewrite(t, rewriter, "/api/x/foo", "/app/foo")
+	rewriter = &pathRewriter{pathType: v1Net.PathTypePrefix, path: "/", target: "/app/"}
+	internalTestRewrite(t, rewriter, "/foo", "/app/foo")
+}
+
+func TestRewriteExact(t *testing.T) {
+	rewriter := &pathRewriter{pathType: v1Net.PathTypeExact, path: "/api/x", target: "/"}
+	internalTestRewrite(t, rewriter, "/api/x", "/")
+}
+
+func TestRewriteRegex(t *testing.T) {
+	rewriter := &pathRewriter{
+		pathType: v1Net.PathTypeImplementationSpecific,
+		path:     "/api/(v[0-9]+)/x",
+		pattern:  regexp.MustCompile("^(?:/api/(v[0-9]+)/x)"),
+		target:   "/$1",
+	}
+	internalTestRewrite(t, rewriter, "/api/v2/x", "/v2")
+	internalTestRewrite(t, rewriter, "/api/v2/x/foo", "/v2/foo")
+}
+
+func TestRewriteLocation(t *testing.T) {
+	for location, expectedLocation := range map[string]string{
+		"/login":                          "/api/x/login",
+		"/":                               "/api/x/",
+		"https://" + dummyHost + "/login": "https://" + dummyHost + "/api/x/login",
+		"https://other/login":             "https://other/login",
+		"relative":                        "relative",
+	} {
+		w, r, next := getDefaultHandlerMocks()
+		next.serveHttpFunc = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Location", location)
+			w.WriteHeader(http.StatusFound)
+		}
+		rewriter := &pathRewriter{pathType: v1Net.PathTypePrefix, path: "/api/x", target: "/", next: next}
+		r.Host = dummyHost
+		r.URL = &url.URL{Path: "/api/x/foo"}
+		rewriter.ServeHTTP(w, r)
+		result := w.Result()
+		require.NoError(t, result.Body.Close())
+		require.Equal(t, http.StatusFound, result.StatusCode)
+		require.Equal(t, expectedLocation, result.Header.Get("Location"), location)
+	}
+}
+
+func TestRewriteLocationBoundary(t *testing.T) {
+	writer := &locationRewriteWriter{host: dummyHost, from: "/app", to: "/api/x"}
+	require.Equal(t, "/api/x/login", writer.rewriteLocation("/app/login"))
+	require.Equal(t, "/application", writer.rewriteLocation("/application"))
+}
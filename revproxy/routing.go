@@ -0,0 +1,291 @@
+package revproxy
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/ngergs/ingress/v2/state"
+)
+
+// conditionalRoute is a handler that only applies to requests that fulfill the conditions
+type conditionalRoute struct {
+	method      string
+	headers     []*valueMatcher
+	queryParams []*valueMatcher
+	handler     http.Handler
+}
+
+// valueMatcher matches the value of a header or query parameter
+type valueMatcher struct {
+	name  string
+	value string
+	// pattern is only set for regular expression matches
+	pattern *regexp.Regexp
+}
+
+// conditionalHandler forwards the requests to the first route whose conditions match.
+// Requests that match no route are forwarded to the fallback handler, if it is nil a 404 is returned.
+type conditionalHandler struct {
+	// routes are sorted by descending specificity
+	routes   []*conditionalRoute
+	fallback http.Handler
+}
+
+// newConditionalRoute compiles the request conditions for the given handler
+func newConditionalRoute(conditions *state.RequestConditions, handler http.Handler) (*conditionalRoute, error) {
+	route := &conditionalRoute{
+		method:  conditions.Method,
+		handler: handler,
+	}
+	var err error
+	if route.headers, err = newValueMatchers(conditions.Headers); err != nil {
+		return nil, err
+	}
+	if route.queryParams, err = newValueMatchers(conditions.QueryParams); err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
+// newValueMatchers compiles the given value matches
+func newValueMatchers(matches []*state.ValueMatch) ([]*valueMatcher, error) {
+	result := make([]*valueMatcher, 0, len(matches))
+	for _, match := range matches {
+		pattern, err := match.Compile()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &valueMatcher{name: match.Name, value: match.Value, pattern: pattern})
+	}
+	return result, nil
+}
+
+// add adds the route. A route without conditions becomes the fallback, if a fallback is already present the first one is kept.
+// Routes are ordered by the Gateway API precedence: method matches before header matches before query parameter matches.
+func (handler *conditionalHandler) add(route *conditionalRoute) {
+	if route.method == "" && len(route.headers) == 0 && len(route.queryParams) == 0 {
+		if handler.fallback == nil {
+			handler.fallback = route.handler
+		}
+		return
+	}
+	handler.routes = append(handler.routes, route)
+	slices.SortStableFunc(handler.routes, func(a *conditionalRoute, b *conditionalRoute) int {
+		return cmp.Or(
+			cmp.Compare(len(b.method), len(a.method)),
+			cmp.Compare(len(b.headers), len(a.headers)),
+			cmp.Compare(len(b.queryParams), len(a.queryParams)))
+	})
+}
+
+// ServeHTTP forwards the request to the first matching route
+func (handler *conditionalHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, route := range handler.routes {
+		if route.matches(r) {
+			route.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	if handler.fallback == nil {
+		http.NotFound(w, r)
+		return
+	}
+	handler.fallback.ServeHTTP(w, r)
+}
+
+// matches returns whether the request fulfills all conditions of the route
+func (route *conditionalRoute) matches(r *http.Request) bool {
+	if route.method != "" && r.Method != route.method {
+		return false
+	}
+	for _, header := range route.headers {
+		values, ok := r.Header[http.CanonicalHeaderKey(header.name)]
+		if !ok || !header.matches(values[0]) {
+			return false
+		}
+	}
+	if len(route.queryParams) == 0 {
+		return true
+	}
+	query := r.URL.Query()
+	for _, queryParam := range route.queryParams {
+		values, ok := query[queryParam.name]
+		if !ok || !queryParam.matches(values[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches returns whether the value matches
+func (matcher *valueMatcher) matches(value string) bool {
+	if matcher.pattern != nil {
+		return matcher.pattern.MatchString(value)
+	}
+	return value == matcher.value
+}
+
+// weightedHandler splits the requests across the backends according to their weights.
+// Requests for a nil backend and all requests if the total weight is 0 are answered with a 500.
+type weightedHandler struct {
+	backends []http.Handler
+	// cumulativeWeights are the summed up weights of the backends up to and including the respective index
+	cumulativeWeights []int32
+}
+
+// add adds a backend with the given weight, the handler may be nil
+func (handler *weightedHandler) add(backend http.Handler, weight int32) {
+	total := handler.totalWeight()
+	handler.backends = append(handler.backends, backend)
+	handler.cumulativeWeights = append(handler.cumulativeWeights, total+weight)
+}
+
+// totalWeight returns the sum of all backend weights
+func (handler *weightedHandler) totalWeight() int32 {
+	if len(handler.cumulativeWeights) == 0 {
+		return 0
+	}
+	return handler.cumulativeWeights[len(handler.cumulativeWeights)-1]
+}
+
+// ServeHTTP forwards the request to a randomly selected backend
+func (handler *weightedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	total := handler.totalWeight()
+	if total <= 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	//nolint:gosec // no cryptographic randomness required for the traffic split
+	selected := rand.Int32N(total)
+	i, _ := slices.BinarySearch(handler.cumulativeWeights, selected+1)
+	if handler.backends[i] == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	handler.backends[i].ServeHTTP(w, r)
+}
+
+// redirectHandler answers all requests with a redirect. Unset fields of the redirect are taken from the request.
+type redirectHandler struct {
+	redirect *state.Redirect
+	// path is the matched prefix path, only relevant for a state.Redirect.PrefixPath
+	path string
+}
+
+// ServeHTTP answers the request with a redirect
+func (handler *redirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := *r.URL
+	target.Scheme = "http"
+	if r.TLS != nil {
+		target.Scheme = "https"
+	}
+	if handler.redirect.Scheme != "" {
+		target.Scheme = handler.redirect.Scheme
+	}
+	hostname, port := splitHostPort(r.Host)
+	if handler.redirect.Hostname != "" {
+		hostname = handler.redirect.Hostname
+	}
+	switch {
+	case handler.redirect.Port != 0:
+		port = strconv.FormatInt(int64(handler.redirect.Port), 10)
+	case handler.redirect.Scheme != "":
+		// a scheme change without explicit port uses the well-known port of the scheme
+		port = ""
+	}
+	if (target.Scheme == "http" && port == "80") || (target.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	target.Host = hostname
+	if port != "" {
+		target.Host += ":" + port
+	}
+	switch {
+	case handler.redirect.FullPath != nil:
+		target.Path = *handler.redirect.FullPath
+	case handler.redirect.PrefixPath != nil:
+		matchLength := prefixMatchLength(handler.path, r.URL.Path)
+		target.Path = joinPath(*handler.redirect.PrefixPath, r.URL.Path[matchLength:])
+	}
+	target.RawPath = ""
+	statusCode := handler.redirect.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusFound
+	}
+	http.Redirect(w, r, target.String(), statusCode)
+}
+
+// splitHostPort splits the host into hostname and port, the port is empty if not present
+func splitHostPort(host string) (hostname string, port string) {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return host, ""
+	}
+	return hostname, port
+}
+
+// headerModifierHandler modifies the request headers before they are forwarded and the response headers before they are written
+type headerModifierHandler struct {
+	request  *state.HeaderModifier
+	response *state.HeaderModifier
+	next     http.Handler
+}
+
+// ServeHTTP modifies the headers and forwards the request to the next handler
+func (handler *headerModifierHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler.request != nil {
+		r = r.Clone(r.Context())
+		modifyHeader(r.Header, handler.request)
+	}
+	if handler.response != nil {
+		w = &headerModifierWriter{ResponseWriter: w, modifier: handler.response}
+	}
+	handler.next.ServeHTTP(w, r)
+}
+
+// modifyHeader applies the header modifier to the header
+func modifyHeader(header http.Header, modifier *state.HeaderModifier) {
+	for key, value := range modifier.Set {
+		header.Set(key, value)
+	}
+	for key, value := range modifier.Add {
+		header.Add(key, value)
+	}
+	for _, key := range modifier.Remove {
+		header.Del(key)
+	}
+}
+
+// headerModifierWriter modifies the response headers before they are written
+type headerModifierWriter struct {
+	http.ResponseWriter
+	modifier    *state.HeaderModifier
+	wroteHeader bool
+}
+
+// WriteHeader modifies the response headers and writes them
+func (w *headerModifierWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		modifyHeader(w.Header(), w.modifier)
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write writes the response body, the header is written first if that did not happen yet
+func (w *headerModifierWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Unwrap returns the underlying http.ResponseWriter for the http.ResponseController
+func (w *headerModifierWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
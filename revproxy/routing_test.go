@@ -0,0 +1,167 @@
+package revproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+)
+
+func TestConditionalHandler(t *testing.T) {
+	fallback := &mockHandler{}
+	byMethod := &mockHandler{}
+	byHeader := &mockHandler{}
+	handler := &conditionalHandler{}
+	for _, testCase := range []struct {
+		conditions *state.RequestConditions
+		handler    http.Handler
+	}{
+		{&state.RequestConditions{}, fallback},
+		{&state.RequestConditions{Headers: []*state.ValueMatch{{Name: "X-Version", Value: "v[0-9]+", Regex: true}}}, byHeader},
+		{&state.RequestConditions{Method: http.MethodPost, QueryParams: []*state.ValueMatch{{Name: "debug", Value: "true"}}}, byMethod},
+	} {
+		route, err := newConditionalRoute(testCase.conditions, testCase.handler)
+		require.NoError(t, err)
+		handler.add(route)
+	}
+
+	for _, testCase := range []struct {
+		method   string
+		header   string
+		query    string
+		expected *mockHandler
+	}{
+		{method: http.MethodGet, expected: fallback},
+		{method: http.MethodGet, header: "v1", expected: byHeader},
+		{method: http.MethodGet, header: "version1", expected: fallback},
+		{method: http.MethodPost, header: "v1", query: "debug=true", expected: byMethod},
+		{method: http.MethodPost, header: "v1", query: "debug=false", expected: byHeader},
+	} {
+		for _, mock := range []*mockHandler{fallback, byMethod, byHeader} {
+			mock.r = nil
+		}
+		w, r, _ := getDefaultHandlerMocks()
+		r.Method = testCase.method
+		r.URL = &url.URL{Path: prefixPath, RawQuery: testCase.query}
+		if testCase.header != "" {
+			r.Header.Set("X-Version", testCase.header)
+		}
+		handler.ServeHTTP(w, r)
+		require.NotNil(t, testCase.expected.r)
+	}
+}
+
+func TestConditionalHandlerNoFallback(t *testing.T) {
+	route, err := newConditionalRoute(&state.RequestConditions{Method: http.MethodPost}, &mockHandler{})
+	require.NoError(t, err)
+	handler := &conditionalHandler{}
+	handler.add(route)
+	w, r, _ := getDefaultHandlerMocks()
+	r.Method = http.MethodGet
+	r.URL = &url.URL{Path: prefixPath}
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestWeightedHandler(t *testing.T) {
+	first := &mockHandler{}
+	second := &mockHandler{}
+	handler := &weightedHandler{}
+	handler.add(first, 0)
+	handler.add(second, 1)
+	w, r, _ := getDefaultHandlerMocks()
+	handler.ServeHTTP(w, r)
+	require.Nil(t, first.r)
+	require.NotNil(t, second.r)
+
+	// unresolved backends and a total weight of zero result in an error
+	for _, handler := range []*weightedHandler{{}, {backends: []http.Handler{nil}, cumulativeWeights: []int32{1}}} {
+		w, r, _ = getDefaultHandlerMocks()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	prefix := "/new"
+	fullPath := "/full"
+	for _, testCase := range []struct {
+		redirect         *state.Redirect
+		expectedCode     int
+		expectedLocation string
+	}{
+		{&state.Redirect{Scheme: "https"}, http.StatusFound, "https://localhost/test/sub?q=1"},
+		{&state.Redirect{Hostname: "example.com", Port: 8443, StatusCode: http.StatusMovedPermanently}, http.StatusMovedPermanently, "http://example.com:8443/test/sub?q=1"},
+		{&state.Redirect{PrefixPath: &prefix}, http.StatusFound, "http://localhost/new/sub?q=1"},
+		{&state.Redirect{FullPath: &fullPath}, http.StatusFound, "http://localhost/full?q=1"},
+	} {
+		handler := &redirectHandler{redirect: testCase.redirect, path: prefixPath}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://"+dummyHost+"/test/sub?q=1", nil)
+		handler.ServeHTTP(w, r)
+		require.Equal(t, testCase.expectedCode, w.Code)
+		require.Equal(t, testCase.expectedLocation, w.Header().Get("Location"))
+	}
+}
+
+func TestHeaderModifierHandler(t *testing.T) {
+	modifier := &state.HeaderModifier{
+		Set:    map[string]string{"X-Set": "set"},
+		Add:    map[string]string{"X-Add": "added"},
+		Remove: []string{"X-Remove"},
+	}
+	w, r, next := getDefaultHandlerMocks()
+	next.serveHttpFunc = func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Remove", "backend")
+		w.Header().Set("X-Add", "backend")
+		_, _ = w.Write([]byte("ok"))
+	}
+	r.Header.Set("X-Set", "request")
+	r.Header.Set("X-Remove", "request")
+	handler := &headerModifierHandler{request: modifier, response: modifier, next: next}
+	handler.ServeHTTP(w, r)
+
+	require.Equal(t, "set", next.r.Header.Get("X-Set"))
+	require.Equal(t, "added", next.r.Header.Get("X-Add"))
+	require.Empty(t, next.r.Header.Get("X-Remove"))
+	require.Equal(t, "request", r.Header.Get("X-Set"), "the original request should not be modified")
+	require.Equal(t, "set", w.Header().Get("X-Set"))
+	require.Equal(t, []string{"backend", "added"}, w.Header().Values("X-Add"))
+	require.Empty(t, w.Header().Get("X-Remove"))
+}
+
+func TestLoadIngressStateConditionalPaths(t *testing.T) {
+	prefix := v1Net.PathTypePrefix
+	newBackendPath := func(serviceName string, conditions *state.RequestConditions) *state.BackendPath {
+		return &state.BackendPath{
+			PathType:   &prefix,
+			Path:       prefixPath,
+			Conditions: conditions,
+			WeightedBackends: []*state.WeightedBackend{{
+				Backend: &state.BackendPath{Namespace: "default", ServiceName: serviceName, ServicePort: 8080, Endpoints: []string{"10.0.0.1:8080"}},
+				Weight:  1,
+			}},
+		}
+	}
+	inputState := state.IngressState{dummyHost: {BackendPaths: []*state.BackendPath{
+		newBackendPath("conditional", &state.RequestConditions{Method: http.MethodPost}),
+		newBackendPath("unconditional", nil),
+	}}}
+	reverseProxy := New()
+	err := reverseProxy.LoadIngressState(inputState)
+	require.NoError(t, err)
+	proxyState := reverseProxy.state.Load()
+	require.Len(t, proxyState.loadBalancers, 2)
+
+	pathHandler, ok := proxyState.backendPathHandlers[dummyHost].pathHandlers.match(prefixPath)
+	require.True(t, ok)
+	conditional, ok := pathHandler.ProxyHandler.(*conditionalHandler)
+	require.True(t, ok)
+	require.Len(t, conditional.routes, 1)
+	require.Equal(t, http.MethodPost, conditional.routes[0].method)
+	require.NotNil(t, conditional.fallback)
+}
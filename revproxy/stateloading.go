@@ -1,13 +1,15 @@
 package revproxy
 
 import (
+	"cmp"
 	"crypto/tls"
+	"crypto/x509"
 	"github.com/ngergs/ingress/v2/state"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
-	"sort"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 	v1Net "k8s.io/api/networking/v1"
@@ -18,80 +20,317 @@ import (
 // while supporting concurrent requests.
 // Once applied the reverse proxy is then purely defined by the new state.
 func (proxy *ReverseProxy) LoadIngressState(state state.IngressState) error {
-	backendPathHandlers, err := getBackendPathHandlers(state, proxy.Transport)
+	var currentLoadBalancers map[string]*loadBalancer
+	if currentState := proxy.state.Load(); currentState != nil {
+		currentLoadBalancers = currentState.loadBalancers
+	}
+	backendPathHandlers, loadBalancerUpdates, err := proxy.getBackendPathHandlers(state, currentLoadBalancers)
 	if err != nil {
 		return err
 	}
-	tlsCerts, err := getTlsCerts(state)
+	tlsCerts := getTlsCerts(state)
+	tlsSettings, err := getTlsSettings(state)
 	if err != nil {
 		return err
 	}
+	// the load balancers are shared with the current state, hence their settings are only changed once the new state is valid
+	loadBalancers := make(map[string]*loadBalancer, len(loadBalancerUpdates))
+	for key, update := range loadBalancerUpdates {
+		update.apply()
+		loadBalancers[key] = update.lb
+	}
 	newProxyState := &reverseProxyState{
 		backendPathHandlers: backendPathHandlers,
 		tlsCerts:            tlsCerts,
+		loadBalancers:       loadBalancers,
+		clientAuthConfigs:   getClientAuthConfigs(state),
+		tlsSettings:         tlsSettings,
 	}
+	proxy.ocspStapler.load(tlsCerts)
+	proxy.metrics.setCertificateNotAfters(state)
 	proxy.state.Store(newProxyState)
+	// health checkers are only started and stopped for applied states to not leak them for failed loads
+	for _, lb := range loadBalancers {
+		lb.applyHealthChecks()
+	}
+	for key, lb := range currentLoadBalancers {
+		if loadBalancers[key] != lb {
+			lb.stopHealthChecks()
+		}
+	}
 	log.Info().Msg("Reverse proxy state updated")
 	return nil
 }
 
-// getBackendPathHandlers is an internal function which evaluates the ingress state and collects the path rules from it.
-// Furthermore, also the relevant reverse proxy clients are already setup.
-// Paths are matched based on the principle that exact matches take prevalence over prefix matches.
-// If no exact match has been found the longest matching prefix path takes prevalence.
-func getBackendPathHandlers(state state.IngressState, backendTransport http.RoundTripper) (BackendRouting, error) {
-	pathHandlerMap := make(BackendRouting)
-	for host, domainConfig := range state {
-		proxies := make([]*backendPathHandler, len(domainConfig.BackendPaths))
-		for i, pathRule := range domainConfig.BackendPaths {
+// loadBalancerUpdate holds the settings of a load balancer for a new state, see apply
+type loadBalancerUpdate struct {
+	lb               *loadBalancer
+	endpoints        []*endpoint
+	healthCheck      *state.HealthCheck
+	outlierDetection *state.OutlierDetection
+	limits           state.CircuitBreaker
+}
 
-			rawUrl := "http://" + pathRule.ServiceName +
-				"." + pathRule.Namespace +
-				".svc.cluster.local" +
-				":" + strconv.FormatInt(int64(pathRule.ServicePort), 10)
-			url, err := url.ParseRequestURI(rawUrl)
+// apply sets the endpoints, the health config and the request limits of the load balancer.
+// The health checks are started separately via applyHealthChecks once the new state is stored.
+func (update *loadBalancerUpdate) apply() {
+	update.lb.endpoints.Store(&update.endpoints)
+	update.lb.setHealthConfig(update.healthCheck, update.outlierDetection)
+	update.lb.requests.setLimits(update.limits.MaxRequests, update.limits.MaxPendingRequests)
+}
+
+// getBackendPathHandlers is an internal method which evaluates the ingress state and collects the path rules from it.
+// Furthermore, also the relevant load balancers for the backend endpoints are already setup. Their new settings are returned
+// as updates that have to be applied by the caller, as load balancers from currentLoadBalancers are still in use by the current state.
+// Load balancers from currentLoadBalancers are reused for the same backend service port and protocol to keep their connection state, see loadBalancerKey.
+// The health config of a load balancer is taken from the first backend path that references it.
+// Paths are matched according to the ingress spec, see backendPathHandlers.match.
+func (proxy *ReverseProxy) getBackendPathHandlers(ingressState state.IngressState,
+	currentLoadBalancers map[string]*loadBalancer) (BackendRouting, map[string]*loadBalancerUpdate, error) {
+	pathHandlerMap := make(BackendRouting)
+	loadBalancerUpdates := make(map[string]*loadBalancerUpdate)
+	// getLoadBalancer returns the load balancer for the backend path and its key, see connectionLimitedKey
+	getLoadBalancer := func(backendPath *state.BackendPath) (*loadBalancer, string, error) {
+		limits := proxy.circuitBreakerLimits(backendPath)
+		key := connectionLimitedKey(loadBalancerKey(backendPath), limits.MaxConnections)
+		if update, ok := loadBalancerUpdates[key]; ok {
+			return update.lb, key, nil
+		}
+		lb, ok := currentLoadBalancers[key]
+		if !ok {
+			var err error
+			lb, err = newBackendLoadBalancer(proxy.loadBalancing, proxy.Transport, backendPath, limits.MaxConnections)
 			if err != nil {
+				return nil, "", err
+			}
+		}
+		endpoints, err := lb.newEndpoints(backendPath.Endpoints)
+		if err != nil {
+			return nil, "", err
+		}
+		loadBalancerUpdates[key] = &loadBalancerUpdate{
+			lb:               lb,
+			endpoints:        endpoints,
+			healthCheck:      backendPath.HealthCheck,
+			outlierDetection: backendPath.OutlierDetection,
+			limits:           limits,
+		}
+		return lb, key, nil
+	}
+	// getServiceProxyHandler returns the load balancer for the backend path wrapped by a retryHandler if retries are enabled,
+	// by a timeoutHandler if route timeouts are set and by a pathRewriter if a rewrite target is set
+	getServiceProxyHandler := func(backendPath *state.BackendPath, pattern *regexp.Regexp) (http.Handler, error) {
+		lb, key, err := getLoadBalancer(backendPath)
+		if err != nil {
+			return nil, err
+		}
+		handler := proxy.withTimeouts(proxy.retrier.wrap(lb, key, backendPath.Retry), backendPath)
+		if backendPath.RewriteTarget == "" {
+			return handler, nil
+		}
+		return &pathRewriter{
+			pathType: *backendPath.PathType,
+			path:     backendPath.Path,
+			pattern:  pattern,
+			target:   backendPath.RewriteTarget,
+			next:     handler,
+		}, nil
+	}
+	// getPathProxyHandler returns the handler for the backend path: a redirect, a weighted split across multiple backends or a single backend service.
+	// The handler is wrapped by a headerModifierHandler if header modifications are set.
+	getPathProxyHandler := func(backendPath *state.BackendPath, pattern *regexp.Regexp) (http.Handler, error) {
+		var handler http.Handler
+		switch {
+		case backendPath.Redirect != nil:
+			handler = &redirectHandler{redirect: backendPath.Redirect, path: backendPath.Path}
+		case backendPath.WeightedBackends != nil:
+			weighted := &weightedHandler{}
+			for _, weightedBackend := range backendPath.WeightedBackends {
+				if weightedBackend.Backend == nil {
+					weighted.add(nil, weightedBackend.Weight)
+					continue
+				}
+				backendHandler, err := getServiceProxyHandler(weightedBackend.Backend, pattern)
+				if err != nil {
+					return nil, err
+				}
+				weighted.add(backendHandler, weightedBackend.Weight)
+			}
+			handler = weighted
+		default:
+			var err error
+			if handler, err = getServiceProxyHandler(backendPath, pattern); err != nil {
 				return nil, err
 			}
-			log.Info().Msgf("Loaded proxy backend path %s for host %s and path %s", url.String(), host, pathRule.Path)
-
-			revProxy := httputil.NewSingleHostReverseProxy(url)
-			revProxy.Transport = backendTransport
-			proxies[i] = &backendPathHandler{
-				PathType:     pathRule.PathType,
-				Path:         pathRule.Path,
-				ProxyHandler: revProxy,
+		}
+		if backendPath.RequestHeaders == nil && backendPath.ResponseHeaders == nil {
+			return handler, nil
+		}
+		return &headerModifierHandler{
+			request:  backendPath.RequestHeaders,
+			response: backendPath.ResponseHeaders,
+			next:     handler,
+		}, nil
+	}
+	for host, domainConfig := range ingressState {
+		routing := &hostRouting{}
+		if domainConfig.DefaultBackend != nil {
+			lb, key, err := getLoadBalancer(domainConfig.DefaultBackend)
+			if err != nil {
+				return nil, nil, err
 			}
+			log.Info().Msgf("Loaded default proxy backend %s with %d endpoints for host %s", backendServiceKey(domainConfig.DefaultBackend), len(domainConfig.DefaultBackend.Endpoints), host)
+			routing.defaultBackend = proxy.withTimeouts(proxy.retrier.wrap(lb, key, domainConfig.DefaultBackend.Retry), domainConfig.DefaultBackend)
+			routing.defaultBackend = proxy.withRateLimit(routing.defaultBackend, domainConfig.DefaultBackend, host, "")
 		}
-		// exact type match first, then the longest path
-		sort.Slice(proxies, func(i int, j int) bool {
-			if *proxies[i].PathType == v1Net.PathTypeExact {
-				return true
+		if domainConfig.PassthroughBackend != nil {
+			lb, _, err := getLoadBalancer(domainConfig.PassthroughBackend)
+			if err != nil {
+				return nil, nil, err
 			}
-			if *proxies[j].PathType == v1Net.PathTypeExact {
-				return false
+			log.Info().Msgf("Loaded tls passthrough backend %s with %d endpoints for host %s", backendServiceKey(domainConfig.PassthroughBackend), len(domainConfig.PassthroughBackend.Endpoints), host)
+			routing.passthrough = lb
+		}
+		routing.pathHandlers = newBackendPathHandlers()
+		// pathHandlers and conditionalHandlers are keyed by path type and path to merge the paths that only differ by their conditions
+		pathHandlers := make(map[string]*backendPathHandler)
+		conditionalHandlers := make(map[string]*conditionalHandler)
+		for _, pathRule := range domainConfig.BackendPaths {
+			pathHandler := &backendPathHandler{
+				PathType: pathRule.PathType,
+				Path:     pathRule.Path,
 			}
-			return len(proxies[i].Path) > len(proxies[j].Path)
-		})
-		pathHandlerMap[host] = proxies
+			var err error
+			if pathRule.PathType != nil && *pathRule.PathType == v1Net.PathTypeImplementationSpecific {
+				pathHandler.pattern, err = pathRule.CompilePathPattern()
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+			pathHandler.ProxyHandler, err = getPathProxyHandler(pathRule, pathHandler.pattern)
+			if err != nil {
+				return nil, nil, err
+			}
+			log.Info().Msgf("Loaded proxy backend %s for host %s and path %s", backendPathDescription(pathRule), host, pathRule.Path)
+			if pathRule.Canary != nil {
+				canaryHandler, err := getPathProxyHandler(pathRule.Canary.Backend, pathHandler.pattern)
+				if err != nil {
+					return nil, nil, err
+				}
+				log.Info().Msgf("Loaded canary proxy backend %s with %d endpoints for host %s and path %s", backendServiceKey(pathRule.Canary.Backend), len(pathRule.Canary.Backend.Endpoints), host, pathRule.Path)
+				pathHandler.ProxyHandler = &canarySplitter{
+					primary:     pathHandler.ProxyHandler,
+					canary:      canaryHandler,
+					weight:      pathRule.Canary.Weight,
+					header:      pathRule.Canary.Header,
+					headerValue: pathRule.Canary.HeaderValue,
+					cookie:      pathRule.Canary.Cookie,
+				}
+			}
+			key := string(*pathRule.PathType) + ":" + pathRule.Path
+			// the rate limit of the primary path also applies to the canary, the one of the canary ingress is ignored
+			pathHandler.ProxyHandler = proxy.withRateLimit(pathHandler.ProxyHandler, pathRule, host, key)
+			existing, ok := pathHandlers[key]
+			conditional, conditionalOk := conditionalHandlers[key]
+			if pathRule.Conditions == nil && !conditionalOk {
+				// the first path handler for the same path type and path is kept
+				if !ok {
+					pathHandlers[key] = pathHandler
+					routing.pathHandlers.add(pathHandler)
+				}
+				continue
+			}
+			if !conditionalOk {
+				conditional = &conditionalHandler{}
+				conditionalHandlers[key] = conditional
+				if existing != nil {
+					conditional.fallback = existing.ProxyHandler
+					existing.ProxyHandler = conditional
+				} else {
+					pathHandlers[key] = &backendPathHandler{
+						PathType:     pathHandler.PathType,
+						Path:         pathHandler.Path,
+						ProxyHandler: conditional,
+						pattern:      pathHandler.pattern,
+					}
+					routing.pathHandlers.add(pathHandlers[key])
+				}
+			}
+			route, err := newConditionalRoute(cmp.Or(pathRule.Conditions, &state.RequestConditions{}), pathHandler.ProxyHandler)
+			if err != nil {
+				return nil, nil, err
+			}
+			conditional.add(route)
+		}
+		pathHandlerMap[host] = routing
+	}
+	return pathHandlerMap, loadBalancerUpdates, nil
+}
+
+// backendServiceKey returns the identifier of the backend service port of the given path, formatted as namespace/service:port
+func backendServiceKey(backendPath *state.BackendPath) string {
+	return backendPath.Namespace + "/" + backendPath.ServiceName + ":" + strconv.FormatInt(int64(backendPath.ServicePort), 10)
+}
+
+// backendPathDescription returns a description of the backend of the given path for logging purposes
+func backendPathDescription(backendPath *state.BackendPath) string {
+	switch {
+	case backendPath.Redirect != nil:
+		return "redirect"
+	case backendPath.WeightedBackends != nil:
+		descriptions := make([]string, 0, len(backendPath.WeightedBackends))
+		for _, weightedBackend := range backendPath.WeightedBackends {
+			if weightedBackend.Backend == nil {
+				descriptions = append(descriptions, "<unresolved>")
+				continue
+			}
+			descriptions = append(descriptions, backendServiceKey(weightedBackend.Backend))
+		}
+		return strings.Join(descriptions, ",")
+	}
+	return backendServiceKey(backendPath) + " with " + strconv.Itoa(len(backendPath.Endpoints)) + " endpoints"
+}
+
+// rsaRank ranks RSA certificates after the other certificates
+func rsaRank(cert *tls.Certificate) int {
+	if cert.Leaf.PublicKeyAlgorithm == x509.RSA {
+		return 1
 	}
-	return pathHandlerMap, nil
+	return 0
 }
 
 // getTlsCerts is an internal function which collects the relevant tls-secrets
-// and also loads the certificates.
-func getTlsCerts(state state.IngressState) (TlsCerts, error) {
-	tlsCerts := make(map[string]*tls.Certificate)
-	for host, domainConfig := range state {
-		if domainConfig.TlsCert == nil {
+// and also loads the certificates. The certificates of a host are sorted so that ECDSA and Ed25519 certificates
+// with their smaller handshakes take precedence over RSA certificates, see selectCertificate.
+// Invalid certificates are logged and skipped, so that a single broken secret does not block the state of all hosts.
+func getTlsCerts(ingressState state.IngressState) TlsCerts {
+	tlsCerts := make(TlsCerts)
+	for host, domainConfig := range ingressState {
+		certs := make([]*tls.Certificate, 0, len(domainConfig.TlsCerts))
+		for _, tlsCert := range domainConfig.TlsCerts {
+			cert, err := tls.X509KeyPair(tlsCert.Cert, tlsCert.Key)
+			if err == nil {
+				// parsed once for tls.ClientHelloInfo.SupportsCertificate
+				cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+			}
+			if err != nil {
+				log.Error().Err(err).Msgf("skipping invalid certificate of secret %s for host %s", tlsCert.Secret, host)
+				continue
+			}
+			certs = append(certs, &cert)
+		}
+		if len(certs) == 0 {
 			continue
 		}
-		cert, err := tls.X509KeyPair(domainConfig.TlsCert.Cert, domainConfig.TlsCert.Key)
-		if err != nil {
-			return nil, err
+		slices.SortStableFunc(certs, func(a *tls.Certificate, b *tls.Certificate) int {
+			return cmp.Compare(rsaRank(a), rsaRank(b))
+		})
+		if host == state.CatchAllHost {
+			log.Info().Msg("Loaded default certificate")
+		} else {
+			log.Info().Msgf("Loaded %d certificates for host %s", len(certs), host)
 		}
-		log.Info().Msgf("Loaded certificate for host %s", host)
-		tlsCerts[host] = &cert
+		tlsCerts[host] = certs
 	}
-	return tlsCerts, nil
+	return tlsCerts
 }
@@ -2,6 +2,7 @@ package revproxy
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"github.com/ngergs/ingress/v2/state"
 	"os"
 	"testing"
@@ -17,19 +18,74 @@ func TestLoadIngressState(t *testing.T) {
 	require.NoError(t, err)
 	proxyState := reverseProxy.state.Load()
 	require.NotNil(t, proxyState)
-	require.Equal(t, cert, proxyState.tlsCerts[dummyHost])
+	require.Equal(t, []*tls.Certificate{cert}, proxyState.tlsCerts[dummyHost])
 
-	// expectedOrder in proxyState is 2->0->1 as exact paths take precedence over prefixes and the longest prefixes wins against other prefixes
-	requirePathEqual(t, inputState[dummyHost].BackendPaths[0], proxyState.backendPathHandlers[dummyHost][2])
-	requirePathEqual(t, inputState[dummyHost].BackendPaths[1], proxyState.backendPathHandlers[dummyHost][0])
-	requirePathEqual(t, inputState[dummyHost].BackendPaths[2], proxyState.backendPathHandlers[dummyHost][1])
+	// exact paths take precedence over prefixes and the longest prefixes wins against other prefixes
+	pathHandlers := proxyState.backendPathHandlers[dummyHost].pathHandlers
+	for path, expectedIndex := range map[string]int{"/": 0, "/other": 0, "/test123": 1, "/test123/sub": 0, "/test": 2, "/test/sub": 2} {
+		pathHandler, ok := pathHandlers.match(path)
+		require.True(t, ok)
+		requirePathEqual(t, inputState[dummyHost].BackendPaths[expectedIndex], pathHandler)
+	}
+}
+
+func TestLoadIngressStateReusesLoadBalancers(t *testing.T) {
+	inputState, _ := getValidDummyState(t)
+	reverseProxy := New(LoadBalancing(LeastConnections))
+	err := reverseProxy.LoadIngressState(inputState)
+	require.NoError(t, err)
+	lb := reverseProxy.state.Load().loadBalancers["default/svc:8080"]
+	require.NotNil(t, lb)
+	require.Equal(t, LeastConnections, lb.algorithm)
+	require.Len(t, *lb.endpoints.Load(), 1)
+	(*lb.endpoints.Load())[0].active.Store(1)
+
+	// all paths reference the same backend service port
+	for _, backendPath := range inputState[dummyHost].BackendPaths {
+		backendPath.Endpoints = append(backendPath.Endpoints, "10.0.0.2:8080")
+	}
+	err = reverseProxy.LoadIngressState(inputState)
+	require.NoError(t, err)
+	reloadedLb := reverseProxy.state.Load().loadBalancers["default/svc:8080"]
+	require.Same(t, lb, reloadedLb)
+	endpoints := *reloadedLb.endpoints.Load()
+	require.Len(t, endpoints, 2)
+	require.Equal(t, int64(1), endpoints[0].active.Load())
 }
 
 func TestLoadIngressStateCertError(t *testing.T) {
-	inputState := getDummyState(nil, nil)
+	inputState, cert := getValidDummyState(t)
+	inputState["other."+dummyHost] = &state.DomainConfig{TlsCerts: []*state.TlsCert{{Cert: inputState[dummyHost].TlsCerts[0].Cert}}}
 	reverseProxy := New()
+	// invalid certificates are skipped instead of rejecting the state of all hosts
 	err := reverseProxy.LoadIngressState(inputState)
+	require.NoError(t, err)
+	tlsCerts := reverseProxy.state.Load().tlsCerts
+	require.Equal(t, []*tls.Certificate{cert}, tlsCerts[dummyHost])
+	require.NotContains(t, tlsCerts, "other."+dummyHost)
+}
+
+func TestLoadIngressStateErrorKeepsLoadBalancers(t *testing.T) {
+	inputState, _ := getValidDummyState(t)
+	reverseProxy := New()
+	err := reverseProxy.LoadIngressState(inputState)
+	require.NoError(t, err)
+	lb := reverseProxy.state.Load().loadBalancers["default/svc:8080"]
+
+	// the endpoints and limits of the shared load balancer are only changed if the whole state is valid
+	for _, backendPath := range inputState[dummyHost].BackendPaths {
+		backendPath.Endpoints = append(backendPath.Endpoints, "10.0.0.2:8080")
+		backendPath.CircuitBreaker = &state.CircuitBreaker{MaxRequests: 1}
+		backendPath.HealthCheck = &state.HealthCheck{Path: "/health"}
+	}
+	implementationSpecific := v1.PathTypeImplementationSpecific
+	inputState[dummyHost].BackendPaths = append(inputState[dummyHost].BackendPaths,
+		&state.BackendPath{PathType: &implementationSpecific, Path: "(", PathMatching: state.PathMatchingRegex})
+	err = reverseProxy.LoadIngressState(inputState)
 	require.Error(t, err)
+	require.Len(t, *lb.endpoints.Load(), 1)
+	require.Nil(t, lb.requests.limits.Load())
+	require.Nil(t, lb.healthConfig.Load())
 }
 
 func requirePathEqual(t *testing.T, backendPath *state.BackendPath, proxyBackendPath *backendPathHandler) {
@@ -40,6 +96,8 @@ func requirePathEqual(t *testing.T, backendPath *state.BackendPath, proxyBackend
 func getValidDummyState(t *testing.T) (state.IngressState, *tls.Certificate) {
 	cert, err := tls.LoadX509KeyPair("../test/cert.pem", "../test/key.pem")
 	require.NoError(t, err)
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
 	certData, err := os.ReadFile("../test/cert.pem")
 	require.NoError(t, err)
 	certKey, err := os.ReadFile("../test/key.pem")
@@ -64,6 +122,12 @@ func getDummyState(cert []byte, certKey []byte) state.IngressState {
 			Path:     "/test",
 		},
 	}
+	for _, backendPath := range backendPaths {
+		backendPath.Namespace = "default"
+		backendPath.ServiceName = "svc"
+		backendPath.ServicePort = 8080
+		backendPath.Endpoints = []string{"10.0.0.1:8080"}
+	}
 
 	tlsCert := &state.TlsCert{
 		Cert: cert,
@@ -72,7 +136,7 @@ func getDummyState(cert []byte, certKey []byte) state.IngressState {
 	return state.IngressState{
 		dummyHost: {
 			BackendPaths: backendPaths,
-			TlsCert:      tlsCert,
+			TlsCerts:     []*state.TlsCert{tlsCert},
 		},
 	}
 }
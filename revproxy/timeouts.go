@@ -0,0 +1,185 @@
+package revproxy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrResponseHeaderTimeout = errors.New("timeout waiting for the response headers of the backend")
+	ErrStreamIdleTimeout     = errors.New("timeout without transfer of the request or response body")
+)
+
+//nolint:gomnd
+const (
+	// timeoutWriteGrace extends the server deadlines beyond the request timeout, so that the timeout response can be written
+	timeoutWriteGrace = time.Duration(1) * time.Second
+)
+
+// timeoutsKey is the context key of the route timeouts, see timeoutHandler
+type timeoutsKey struct{}
+
+// routeTimeouts returns the timeouts for the backend path, the annotations override the defaults of the config
+func (proxy *ReverseProxy) routeTimeouts(backendPath *state.BackendPath) state.Timeouts {
+	timeouts := proxy.timeouts
+	if override := backendPath.Timeouts; override != nil {
+		timeouts.Connect = cmp.Or(override.Connect, timeouts.Connect)
+		timeouts.ResponseHeader = cmp.Or(override.ResponseHeader, timeouts.ResponseHeader)
+		timeouts.Request = cmp.Or(override.Request, timeouts.Request)
+		timeouts.StreamIdle = cmp.Or(override.StreamIdle, timeouts.StreamIdle)
+	}
+	return timeouts
+}
+
+// withTimeouts wraps the handler by a timeoutHandler for the timeouts of the backend path.
+// The handler itself is returned if only the default connect timeout applies, which is part of the transport.
+func (proxy *ReverseProxy) withTimeouts(handler http.Handler, backendPath *state.BackendPath) http.Handler {
+	timeouts := proxy.routeTimeouts(backendPath)
+	if timeouts == (state.Timeouts{Connect: proxy.timeouts.Connect}) {
+		return handler
+	}
+	return &timeoutHandler{timeouts: timeouts, next: handler}
+}
+
+// dialBackend connects to an endpoint with the connect timeout of the route, see timeoutHandler
+func (proxy *ReverseProxy) dialBackend(ctx context.Context, network string, address string) (net.Conn, error) {
+	dialer := *proxy.dialer
+	if timeouts, ok := ctx.Value(timeoutsKey{}).(*state.Timeouts); ok {
+		dialer.Timeout = timeouts.Connect
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// proxyErrorStatus returns the HTTP status for a proxy error, 504 for timeouts and 502 otherwise
+func proxyErrorStatus(r *http.Request, err error) int {
+	if errors.Is(err, ErrResponseHeaderTimeout) || errors.Is(r.Context().Err(), context.DeadlineExceeded) ||
+		errors.Is(context.Cause(r.Context()), ErrStreamIdleTimeout) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// timeoutHandler applies the timeouts of a route. The timeouts are passed via the request context to the dial and the timeoutTransport.
+// The request and stream idle timeout replace the read and write deadlines of the server, so that e.g. long-polling routes
+// do not require large global server timeouts.
+type timeoutHandler struct {
+	timeouts state.Timeouts
+	next     http.Handler
+}
+
+func (handler *timeoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), timeoutsKey{}, &handler.timeouts)
+	if handler.timeouts.Request > 0 || handler.timeouts.StreamIdle > 0 {
+		// the zero deadline removes the server deadlines if only the stream idle timeout applies
+		var deadline time.Time
+		if handler.timeouts.Request > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, handler.timeouts.Request)
+			defer cancel()
+			deadline = time.Now().Add(handler.timeouts.Request + timeoutWriteGrace)
+		}
+		controller := http.NewResponseController(w)
+		if err := errors.Join(controller.SetReadDeadline(deadline), controller.SetWriteDeadline(deadline)); err != nil {
+			log.Debug().Err(err).Msg("could not replace the server deadlines by the route timeouts")
+		}
+	}
+	// the transfer of upgraded connections is not observable, hence they are not subject to the stream idle timeout
+	if handler.timeouts.StreamIdle > 0 && r.Header.Get("Upgrade") == "" {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		idle := time.AfterFunc(handler.timeouts.StreamIdle, func() {
+			cancel(ErrStreamIdleTimeout)
+		})
+		defer idle.Stop()
+		r = r.WithContext(ctx)
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = &idleBody{ReadCloser: r.Body, idle: idle, timeout: handler.timeouts.StreamIdle}
+		}
+		handler.next.ServeHTTP(&idleResponseWriter{ResponseWriter: w, idle: idle, timeout: handler.timeouts.StreamIdle}, r)
+		return
+	}
+	handler.next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// idleBody resets the stream idle timer on each read of the request body
+type idleBody struct {
+	io.ReadCloser
+	idle    *time.Timer
+	timeout time.Duration
+}
+
+func (body *idleBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	body.idle.Reset(body.timeout)
+	return n, err
+}
+
+// idleResponseWriter resets the stream idle timer on each write of the response body
+type idleResponseWriter struct {
+	http.ResponseWriter
+	idle    *time.Timer
+	timeout time.Duration
+}
+
+func (w *idleResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.idle.Reset(w.timeout)
+	return n, err
+}
+
+// Unwrap returns the wrapped response writer for the http.ResponseController, e.g. to flush streamed responses
+func (w *idleResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// timeoutTransport applies the response header timeout of the route to the round trips, see timeoutHandler
+type timeoutTransport struct {
+	next http.RoundTripper
+}
+
+func (transport *timeoutTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	timeouts, ok := r.Context().Value(timeoutsKey{}).(*state.Timeouts)
+	if !ok || timeouts.ResponseHeader == 0 {
+		return transport.next.RoundTrip(r)
+	}
+	ctx, cancel := context.WithCancelCause(r.Context())
+	timer := time.AfterFunc(timeouts.ResponseHeader, func() {
+		cancel(ErrResponseHeaderTimeout)
+	})
+	response, err := transport.next.RoundTrip(r.WithContext(ctx))
+	if !timer.Stop() {
+		if err == nil {
+			_ = response.Body.Close()
+		}
+		cancel(nil)
+		return nil, fmt.Errorf("%w after %s", ErrResponseHeaderTimeout, timeouts.ResponseHeader)
+	}
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	response.Body = &cancelBody{ReadCloser: response.Body, cancel: cancel}
+	return response, nil
+}
+
+// cancelBody cancels the context of the round trip once the response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelCauseFunc
+}
+
+func (body *cancelBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.cancel(nil)
+	return err
+}
@@ -0,0 +1,126 @@
+package revproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteTimeouts(t *testing.T) {
+	reverseProxy := New(Timeouts(state.Timeouts{Connect: time.Second, ResponseHeader: time.Minute}))
+	timeouts := reverseProxy.routeTimeouts(&state.BackendPath{Timeouts: &state.Timeouts{Connect: time.Duration(2) * time.Second, Request: time.Hour}})
+	require.Equal(t, state.Timeouts{Connect: time.Duration(2) * time.Second, ResponseHeader: time.Minute, Request: time.Hour}, timeouts)
+	require.IsType(t, &timeoutHandler{}, reverseProxy.withTimeouts(http.NotFoundHandler(), &state.BackendPath{}))
+	// the connect timeout is part of the transport, no timeoutHandler is needed
+	reverseProxy = New(Timeouts(state.Timeouts{Connect: time.Second}))
+	require.IsType(t, http.NotFoundHandler(), reverseProxy.withTimeouts(http.NotFoundHandler(), &state.BackendPath{Timeouts: &state.Timeouts{Connect: time.Second}}))
+	require.IsType(t, &timeoutHandler{}, reverseProxy.withTimeouts(http.NotFoundHandler(), &state.BackendPath{Timeouts: &state.Timeouts{Connect: time.Minute}}))
+}
+
+// getTimeoutProxy returns a reverse proxy without retries for the backend handler with the given route timeouts
+func getTimeoutProxy(t *testing.T, handler http.Handler, timeouts *state.Timeouts) *ReverseProxy {
+	backend := httptest.NewServer(handler)
+	t.Cleanup(backend.Close)
+	reverseProxy := New(Retries(0, state.RetryOnConnectFailure))
+	backendPath := &state.BackendPath{Namespace: "default", ServiceName: "svc", ServicePort: 8080, Endpoints: []string{backend.Listener.Addr().String()}, Timeouts: timeouts}
+	err := reverseProxy.LoadIngressState(state.IngressState{dummyHost: &state.DomainConfig{DefaultBackend: backendPath}})
+	require.NoError(t, err)
+	return reverseProxy
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+	reverseProxy := getTimeoutProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-unblock
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		_ = http.NewResponseController(w).Flush()
+		// the response header timeout does not apply to the body
+		time.Sleep(time.Duration(50) * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	}), &state.Timeouts{ResponseHeader: time.Duration(20) * time.Millisecond})
+
+	w := serveRetryRequest(reverseProxy, http.MethodGet)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "ok", w.Body.String())
+	w = httptest.NewRecorder()
+	reverseProxy.GetHandlerProxying().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://"+dummyHost+"/slow", nil))
+	require.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+	reverseProxy := getTimeoutProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}), &state.Timeouts{Request: time.Duration(20) * time.Millisecond})
+	w := serveRetryRequest(reverseProxy, http.MethodGet)
+	require.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
+
+// slowReader returns the data with a delay before each read
+type slowReader struct {
+	data  []byte
+	delay time.Duration
+}
+
+func (reader *slowReader) Read(p []byte) (int, error) {
+	if len(reader.data) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(reader.delay)
+	n := copy(p[:1], reader.data)
+	reader.data = reader.data[n:]
+	return n, nil
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+	reverseProxy := getTimeoutProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if bytes.Equal(body, []byte("block")) {
+			<-unblock
+		}
+		_, _ = w.Write(body)
+	}), &state.Timeouts{StreamIdle: time.Duration(50) * time.Millisecond})
+
+	// the transfer of the request body resets the idle timeout
+	w := httptest.NewRecorder()
+	body := &slowReader{data: []byte("streamed"), delay: time.Duration(20) * time.Millisecond}
+	reverseProxy.GetHandlerProxying().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://"+dummyHost+"/", io.NopCloser(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "streamed", w.Body.String())
+
+	w = httptest.NewRecorder()
+	reverseProxy.GetHandlerProxying().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://"+dummyHost+"/", bytes.NewReader([]byte("block"))))
+	require.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
+
+func TestConnectTimeoutFromContext(t *testing.T) {
+	reverseProxy := New(Timeouts(state.Timeouts{Connect: time.Hour}))
+	var deadline time.Time
+	reverseProxy.dialer.ControlContext = func(ctx context.Context, _ string, _ string, _ syscall.RawConn) error {
+		deadline, _ = ctx.Deadline()
+		return errors.New("dial aborted")
+	}
+	ctx := context.WithValue(context.Background(), timeoutsKey{}, &state.Timeouts{Connect: time.Second})
+	_, err := reverseProxy.dialBackend(ctx, "tcp", "127.0.0.1:80")
+	require.Error(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Second), deadline, time.Duration(500)*time.Millisecond)
+	_, err = reverseProxy.dialBackend(context.Background(), "tcp", "127.0.0.1:80")
+	require.Error(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Hour), deadline, time.Duration(500)*time.Millisecond)
+}
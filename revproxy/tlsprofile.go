@@ -0,0 +1,174 @@
+package revproxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ngergs/ingress/v2/state"
+)
+
+var (
+	ErrUnknownTlsProfile     = errors.New("unknown tls profile")
+	ErrUnknownTlsVersion     = errors.New("unknown tls version")
+	ErrUnknownCipherSuite    = errors.New("unknown cipher suite")
+	ErrCipherSuiteNotAllowed = errors.New("TLS 1.3 cipher suites are not configurable")
+	ErrUnknownCurve          = errors.New("unknown curve")
+)
+
+// TlsSettings are the protocol settings of a TLS listener, see state.TlsProfile
+type TlsSettings struct {
+	MinVersion uint16
+	// MaxVersion is the maximum TLS version, 0 for the highest supported version
+	MaxVersion uint16
+	// CipherSuites are the TLS 1.0-1.2 cipher suites, the TLS 1.3 cipher suites are not configurable
+	CipherSuites     []uint16
+	CurvePreferences []tls.CurveID
+}
+
+// tlsCurves are the supported curves ordered by preference
+var tlsCurves = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}
+
+// tlsProfiles are the settings of the state.TlsProfile values, loosely following the Mozilla server side TLS recommendations
+var tlsProfiles = map[state.TlsProfile]*TlsSettings{
+	state.TlsProfileModern: {
+		MinVersion:       tls.VersionTLS13,
+		CurvePreferences: tlsCurves,
+	},
+	state.TlsProfileIntermediate: {
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		CurvePreferences: tlsCurves,
+	},
+	state.TlsProfileLegacy: {
+		MinVersion: tls.VersionTLS10,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521},
+	},
+}
+
+// GetTlsSettings returns a copy of the settings of the TLS profile
+func GetTlsSettings(profile state.TlsProfile) (*TlsSettings, error) {
+	settings, ok := tlsProfiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTlsProfile, profile)
+	}
+	return settings.clone(), nil
+}
+
+// Apply sets the settings in the tls config
+func (settings *TlsSettings) Apply(config *tls.Config) {
+	config.MinVersion = settings.MinVersion
+	config.MaxVersion = settings.MaxVersion
+	config.CipherSuites = slices.Clone(settings.CipherSuites)
+	config.CurvePreferences = slices.Clone(settings.CurvePreferences)
+}
+
+// clone creates a deep copy of the settings
+func (settings *TlsSettings) clone() *TlsSettings {
+	return &TlsSettings{
+		MinVersion:       settings.MinVersion,
+		MaxVersion:       settings.MaxVersion,
+		CipherSuites:     slices.Clone(settings.CipherSuites),
+		CurvePreferences: slices.Clone(settings.CurvePreferences),
+	}
+}
+
+// ParseTlsVersion parses the TLS version from its number, e.g. 1.2
+func ParseTlsVersion(value string) (uint16, error) {
+	switch value {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrUnknownTlsVersion, value)
+	}
+}
+
+// ParseCipherSuites parses a comma separated list of cipher suite names, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256.
+// Insecure cipher suites are supported as well, TLS 1.3 cipher suites are rejected.
+func ParseCipherSuites(value string) ([]uint16, error) {
+	suites := slices.Concat(tls.CipherSuites(), tls.InsecureCipherSuites())
+	result := make([]uint16, 0)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		i := slices.IndexFunc(suites, func(suite *tls.CipherSuite) bool { return suite.Name == name })
+		if i == -1 {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownCipherSuite, name)
+		}
+		if slices.Equal(suites[i].SupportedVersions, []uint16{tls.VersionTLS13}) {
+			return nil, fmt.Errorf("%w: %s", ErrCipherSuiteNotAllowed, name)
+		}
+		result = append(result, suites[i].ID)
+	}
+	return result, nil
+}
+
+// ParseCurves parses a comma separated list of curve names ordered by preference. Supported are X25519, P256, P384 and P521.
+func ParseCurves(value string) ([]tls.CurveID, error) {
+	result := make([]tls.CurveID, 0)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "X25519":
+			result = append(result, tls.X25519)
+		case "P256":
+			result = append(result, tls.CurveP256)
+		case "P384":
+			result = append(result, tls.CurveP384)
+		case "P521":
+			result = append(result, tls.CurveP521)
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownCurve, name)
+		}
+	}
+	return result, nil
+}
+
+// getTlsSettings collects the TLS settings of the hosts with a TLS profile from the ingress state keyed by host
+func getTlsSettings(ingressState state.IngressState) (map[string]*TlsSettings, error) {
+	result := make(map[string]*TlsSettings)
+	for host, domainConfig := range ingressState {
+		if domainConfig.TlsProfile == "" {
+			continue
+		}
+		settings, ok := tlsProfiles[domainConfig.TlsProfile]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s for host %s", ErrUnknownTlsProfile, domainConfig.TlsProfile, host)
+		}
+		result[host] = settings
+	}
+	return result, nil
+}
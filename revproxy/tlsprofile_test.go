@@ -0,0 +1,88 @@
+package revproxy
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/ngergs/ingress/v2/state"
+	"github.com/stretchr/testify/require"
+)
+
+const tlsProfileHost = "modern.example.com"
+
+func TestGetTlsSettings(t *testing.T) {
+	settings, err := GetTlsSettings(state.TlsProfileModern)
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS13), settings.MinVersion)
+	require.Empty(t, settings.CipherSuites)
+	// a copy is returned
+	settings.CurvePreferences[0] = tls.CurveP521
+	settings, err = GetTlsSettings(state.TlsProfileModern)
+	require.NoError(t, err)
+	require.Equal(t, tls.X25519, settings.CurvePreferences[0])
+
+	settings, err = GetTlsSettings(state.TlsProfileIntermediate)
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS12), settings.MinVersion)
+	require.Contains(t, settings.CipherSuites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)
+	require.NotContains(t, settings.CipherSuites, tls.TLS_RSA_WITH_AES_128_CBC_SHA)
+
+	settings, err = GetTlsSettings(state.TlsProfileLegacy)
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS10), settings.MinVersion)
+	require.Contains(t, settings.CipherSuites, tls.TLS_RSA_WITH_AES_128_CBC_SHA)
+
+	_, err = GetTlsSettings("unknown")
+	require.ErrorIs(t, err, ErrUnknownTlsProfile)
+}
+
+func TestParseTlsVersion(t *testing.T) {
+	version, err := ParseTlsVersion("1.3")
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS13), version)
+	_, err = ParseTlsVersion("1.4")
+	require.ErrorIs(t, err, ErrUnknownTlsVersion)
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	suites, err := ParseCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_RSA_WITH_AES_128_CBC_SHA")
+	require.NoError(t, err)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA}, suites)
+	_, err = ParseCipherSuites("TLS_AES_128_GCM_SHA256")
+	require.ErrorIs(t, err, ErrCipherSuiteNotAllowed)
+	_, err = ParseCipherSuites("unknown")
+	require.ErrorIs(t, err, ErrUnknownCipherSuite)
+}
+
+func TestParseCurves(t *testing.T) {
+	curves, err := ParseCurves("P384,X25519")
+	require.NoError(t, err)
+	require.Equal(t, []tls.CurveID{tls.CurveP384, tls.X25519}, curves)
+	_, err = ParseCurves("P224")
+	require.ErrorIs(t, err, ErrUnknownCurve)
+}
+
+func TestGetConfigForClientTlsSettings(t *testing.T) {
+	reverseProxy := getDummyReverseProxy(t, nil)
+	err := reverseProxy.LoadIngressState(state.IngressState{
+		tlsProfileHost: &state.DomainConfig{TlsProfile: state.TlsProfileModern},
+	})
+	require.NoError(t, err)
+	base := &tls.Config{MinVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}}
+	base.GetConfigForClient = reverseProxy.GetConfigForClientFunc(base)
+
+	config, err := base.GetConfigForClient(&tls.ClientHelloInfo{ServerName: dummyHost})
+	require.NoError(t, err)
+	require.Nil(t, config)
+	config, err = base.GetConfigForClient(&tls.ClientHelloInfo{ServerName: tlsProfileHost})
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+	require.Empty(t, config.CipherSuites)
+	require.Nil(t, config.GetConfigForClient)
+	require.Equal(t, uint16(tls.VersionTLS12), base.MinVersion)
+
+	err = reverseProxy.LoadIngressState(state.IngressState{
+		tlsProfileHost: &state.DomainConfig{TlsProfile: "unknown"},
+	})
+	require.ErrorIs(t, err, ErrUnknownTlsProfile)
+}
@@ -0,0 +1,353 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	v1Net "k8s.io/api/networking/v1"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AnnotationPrefix is the prefix of all ingress annotations evaluated by this ingress controller
+const AnnotationPrefix = "ingress.ngergs.github.io/"
+
+// AnnotationPathMatching selects how paths of type ImplementationSpecific are interpreted, see PathMatching.
+const AnnotationPathMatching = AnnotationPrefix + "path-matching"
+
+// AnnotationRewriteTarget rewrites the matched part of the request path before it is forwarded to the backend.
+// For paths of the type ImplementationSpecific with PathMatchingRegex the capture groups can be referenced, e.g. /$1.
+const AnnotationRewriteTarget = AnnotationPrefix + "rewrite-target"
+
+// AnnotationStripPrefix removes the matched part of the request path before it is forwarded to the backend if set to true.
+// Equivalent to AnnotationRewriteTarget set to "/", the latter takes precedence.
+const AnnotationStripPrefix = AnnotationPrefix + "strip-prefix"
+
+// AnnotationCanary marks the ingress as canary if set to true. The paths of a canary ingress are not routed on their own,
+// but receive a part of the traffic of the paths with the same host, path and path type from the other (primary) ingresses.
+const AnnotationCanary = AnnotationPrefix + "canary"
+
+// AnnotationCanaryWeight is the percentage (0-100) of the requests that are routed to the canary backend. Defaults to 0.
+const AnnotationCanaryWeight = AnnotationPrefix + "canary-weight"
+
+// AnnotationCanaryByHeader is the name of a request header that selects the canary backend if set to "always" and the primary backend if set to "never".
+// Takes precedence over the cookie and the weight.
+const AnnotationCanaryByHeader = AnnotationPrefix + "canary-by-header"
+
+// AnnotationCanaryByHeaderValue replaces the "always" value for AnnotationCanaryByHeader.
+const AnnotationCanaryByHeaderValue = AnnotationPrefix + "canary-by-header-value"
+
+// AnnotationCanaryByCookie is the name of a cookie that selects the canary backend if set to "always" and the primary backend if set to "never".
+// Takes precedence over the weight.
+const AnnotationCanaryByCookie = AnnotationPrefix + "canary-by-cookie"
+
+// AnnotationAcme marks the TLS secrets of the ingress to be issued and renewed by the built-in ACME client if set to true.
+// Missing secrets are then not reported as error, as they are created once the certificate is issued.
+const AnnotationAcme = AnnotationPrefix + "acme"
+
+// AnnotationAuthTlsSecret is the name of a secret in the namespace of the ingress with the CA certificates (ca.crt) for the mutual TLS client authentication.
+// Applies to the hosts of the spec.tls entries of the ingress. If several ingresses configure it for the same host, the oldest ingress wins.
+const AnnotationAuthTlsSecret = AnnotationPrefix + "auth-tls-secret"
+
+// AnnotationAuthTlsVerifyClient is the ClientAuthMode for the AnnotationAuthTlsSecret. Defaults to ClientAuthRequired.
+const AnnotationAuthTlsVerifyClient = AnnotationPrefix + "auth-tls-verify-client"
+
+// AnnotationTlsProfile overrides the TlsProfile for the hosts of the spec.tls entries of the ingress.
+// If several ingresses configure it for the same host, the oldest ingress wins.
+const AnnotationTlsProfile = AnnotationPrefix + "tls-profile"
+
+// AnnotationSslPassthrough forwards the TLS connections for the hosts of the ingress rules to the backend without terminating them if set to true.
+// The backend of the first path of a rule (or the spec.defaultBackend) receives the raw TCP stream, all other paths and the spec.tls entries are ignored.
+const AnnotationSslPassthrough = AnnotationPrefix + "ssl-passthrough"
+
+// AnnotationBackendProtocol is the BackendProtocol used to connect to the backends of the ingress. Defaults to BackendProtocolHttp.
+const AnnotationBackendProtocol = AnnotationPrefix + "backend-protocol"
+
+// AnnotationBackendTlsSecret is the name of a secret in the namespace of the ingress with the CA certificates (ca.crt) that the certificates
+// of HTTPS and gRPCs backends are verified against. The backend certificates are not verified if it is not set.
+const AnnotationBackendTlsSecret = AnnotationPrefix + "backend-tls-secret"
+
+// AnnotationBackendTlsServerName overrides the server name that is sent as SNI to HTTPS and gRPCs backends and that their certificates are verified for.
+// Defaults to the DNS name of the service (<service>.<namespace>.svc).
+const AnnotationBackendTlsServerName = AnnotationPrefix + "backend-tls-server-name"
+
+// AnnotationHealthCheckPath enables active HTTP health probes of the backend endpoints with GET requests for this path, see HealthCheck.
+const AnnotationHealthCheckPath = AnnotationPrefix + "health-check-path"
+
+// AnnotationHealthCheckInterval is the interval between the health probes as duration, e.g. 5s. Defaults to 10s.
+const AnnotationHealthCheckInterval = AnnotationPrefix + "health-check-interval"
+
+// AnnotationHealthCheckHealthyThreshold is the number of consecutive successful probes after which an unhealthy endpoint is healthy again. Defaults to 2.
+const AnnotationHealthCheckHealthyThreshold = AnnotationPrefix + "health-check-healthy-threshold"
+
+// AnnotationHealthCheckUnhealthyThreshold is the number of consecutive failed probes after which an endpoint is unhealthy. Defaults to 3.
+const AnnotationHealthCheckUnhealthyThreshold = AnnotationPrefix + "health-check-unhealthy-threshold"
+
+// AnnotationOutlierConsecutiveErrors enables the passive outlier detection. Endpoints are ejected after this number of consecutive
+// 5xx responses or connection errors, see OutlierDetection.
+const AnnotationOutlierConsecutiveErrors = AnnotationPrefix + "outlier-consecutive-errors"
+
+// AnnotationOutlierBaseEjectionTime is the duration of the first ejection of an endpoint, e.g. 30s. Defaults to 30s.
+// Repeated ejections last a multiple of it.
+const AnnotationOutlierBaseEjectionTime = AnnotationPrefix + "outlier-base-ejection-time"
+
+// AnnotationRetries is the maximum number of retries of idempotent requests without body, 0 disables retries.
+// Overrides the default of the reverse proxy, see RetryPolicy.
+const AnnotationRetries = AnnotationPrefix + "retries"
+
+// AnnotationRetryOn determines which failures are retried, either connect-failure or gateway-error, see RetryOn.
+// Overrides the default of the reverse proxy.
+const AnnotationRetryOn = AnnotationPrefix + "retry-on"
+
+// AnnotationMaxRequests is the maximum number of concurrent requests to a backend of the ingress, see CircuitBreaker.
+// Overrides the default of the reverse proxy.
+const AnnotationMaxRequests = AnnotationPrefix + "max-requests"
+
+// AnnotationMaxPendingRequests is the maximum number of requests that wait if the max-requests are reached, further requests are rejected.
+// Overrides the default of the reverse proxy.
+const AnnotationMaxPendingRequests = AnnotationPrefix + "max-pending-requests"
+
+// AnnotationMaxConnections is the maximum number of open connections to a backend of the ingress. Overrides the default of the reverse proxy.
+const AnnotationMaxConnections = AnnotationPrefix + "max-connections"
+
+// AnnotationConnectTimeout is the timeout to connect to an endpoint of the backends of the ingress as duration, e.g. 5s.
+// Overrides the default of the reverse proxy, see Timeouts.
+const AnnotationConnectTimeout = AnnotationPrefix + "connect-timeout"
+
+// AnnotationResponseHeaderTimeout is the timeout for the response headers of the backends of the ingress after the request has been sent.
+// Overrides the default of the reverse proxy.
+const AnnotationResponseHeaderTimeout = AnnotationPrefix + "response-header-timeout"
+
+// AnnotationRequestTimeout is the total timeout of the requests to the backends of the ingress including the request and response bodies.
+// Replaces the read and write timeouts of the server for the requests. Overrides the default of the reverse proxy.
+const AnnotationRequestTimeout = AnnotationPrefix + "request-timeout"
+
+// AnnotationStreamIdleTimeout is the timeout without any transfer of the request or response body, e.g. for long-polling or uploads.
+// Without request timeout it replaces the read and write timeouts of the server for the requests. Overrides the default of the reverse proxy.
+const AnnotationStreamIdleTimeout = AnnotationPrefix + "stream-idle-timeout"
+
+// AnnotationRateLimit is the number of requests per rate-limit-period that each client may send to the paths of the ingress, see RateLimit.
+// Requests beyond the limit are answered with 429.
+const AnnotationRateLimit = AnnotationPrefix + "rate-limit"
+
+// AnnotationRateLimitPeriod is the period of the rate-limit as duration, e.g. 1m. Defaults to 1s.
+const AnnotationRateLimitPeriod = AnnotationPrefix + "rate-limit-period"
+
+// AnnotationRateLimitBurst is the maximum number of requests that a client may send at once. Defaults to the rate-limit.
+const AnnotationRateLimitBurst = AnnotationPrefix + "rate-limit-burst"
+
+// AnnotationRateLimitKey identifies the clients, either ip, header:<name> or jwt-claim:<name>. Defaults to ip, see RateLimitKey.
+const AnnotationRateLimitKey = AnnotationPrefix + "rate-limit-key"
+
+// AnnotationRateLimitIp is the number of requests per rate-limit-period from each IP address for the header and jwt-claim keys,
+// in addition to the rate-limit per key. Defaults to 10 times the rate-limit.
+const AnnotationRateLimitIp = AnnotationPrefix + "rate-limit-ip"
+
+// AnnotationRateLimitScope determines whether the limit applies per path (route) or across all paths of the host (host). Defaults to route.
+const AnnotationRateLimitScope = AnnotationPrefix + "rate-limit-scope"
+
+var (
+	ErrInvalidAnnotation = errors.New("invalid annotation value")
+	ErrUnknownAnnotation = errors.New("unknown annotation")
+)
+
+// knownAnnotations are all annotations with the AnnotationPrefix that are evaluated by this ingress controller
+var knownAnnotations = []string{
+	AnnotationPathMatching,
+	AnnotationRewriteTarget,
+	AnnotationStripPrefix,
+	AnnotationCanary,
+	AnnotationCanaryWeight,
+	AnnotationCanaryByHeader,
+	AnnotationCanaryByHeaderValue,
+	AnnotationCanaryByCookie,
+	AnnotationAcme,
+	AnnotationAuthTlsSecret,
+	AnnotationAuthTlsVerifyClient,
+	AnnotationTlsProfile,
+	AnnotationSslPassthrough,
+	AnnotationBackendProtocol,
+	AnnotationBackendTlsSecret,
+	AnnotationBackendTlsServerName,
+	AnnotationHealthCheckPath,
+	AnnotationHealthCheckInterval,
+	AnnotationHealthCheckHealthyThreshold,
+	AnnotationHealthCheckUnhealthyThreshold,
+	AnnotationOutlierConsecutiveErrors,
+	AnnotationOutlierBaseEjectionTime,
+	AnnotationRetries,
+	AnnotationRetryOn,
+	AnnotationMaxRequests,
+	AnnotationMaxPendingRequests,
+	AnnotationMaxConnections,
+	AnnotationConnectTimeout,
+	AnnotationResponseHeaderTimeout,
+	AnnotationRequestTimeout,
+	AnnotationStreamIdleTimeout,
+	AnnotationRateLimit,
+	AnnotationRateLimitPeriod,
+	AnnotationRateLimitBurst,
+	AnnotationRateLimitKey,
+	AnnotationRateLimitIp,
+	AnnotationRateLimitScope,
+}
+
+// PathMatching determines how paths of type ImplementationSpecific are interpreted
+type PathMatching string
+
+const (
+	// PathMatchingRegex interprets the path as regular expression (RE2 syntax) that has to match the beginning of the request path.
+	// This is the default.
+	PathMatchingRegex PathMatching = "regex"
+	// PathMatchingGlob interprets the path as glob pattern that has to match the complete request path.
+	// "*" matches any sequence of characters except "/", "**" matches any sequence of characters and "?" a single character except "/".
+	PathMatchingGlob PathMatching = "glob"
+)
+
+// TlsProfile is a named set of TLS versions, cipher suites and curves, see revproxy.GetTlsSettings
+type TlsProfile string
+
+const (
+	// TlsProfileModern only supports TLS 1.3
+	TlsProfileModern TlsProfile = "modern"
+	// TlsProfileIntermediate supports TLS 1.2 and 1.3 with forward secret AEAD cipher suites
+	TlsProfileIntermediate TlsProfile = "intermediate"
+	// TlsProfileLegacy additionally supports TLS 1.0, TLS 1.1 and CBC cipher suites for old clients
+	TlsProfileLegacy TlsProfile = "legacy"
+)
+
+// validateAnnotations returns an error for each annotation with the AnnotationPrefix that is unknown, e.g. due to a typo
+func validateAnnotations(ingress *v1Net.Ingress) []error {
+	errs := make([]error, 0)
+	for key := range ingress.Annotations {
+		if strings.HasPrefix(key, AnnotationPrefix) && !slices.Contains(knownAnnotations, key) {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrUnknownAnnotation, key))
+		}
+	}
+	// sorted for a stable status message
+	slices.SortFunc(errs, func(a error, b error) int {
+		return strings.Compare(a.Error(), b.Error())
+	})
+	return errs
+}
+
+// pathMatchingFromAnnotations returns the PathMatching set via annotation for the ingress. Defaults to PathMatchingRegex.
+func pathMatchingFromAnnotations(ingress *v1Net.Ingress) (PathMatching, error) {
+	value, ok := ingress.Annotations[AnnotationPathMatching]
+	if !ok {
+		return PathMatchingRegex, nil
+	}
+	pathMatching := PathMatching(value)
+	switch pathMatching {
+	case PathMatchingRegex, PathMatchingGlob:
+		return pathMatching, nil
+	default:
+		return PathMatchingRegex, fmt.Errorf("%w: %s for annotation %s", ErrInvalidAnnotation, value, AnnotationPathMatching)
+	}
+}
+
+// rewriteTargetFromAnnotations returns the rewrite target set via annotation for the ingress. Empty if no rewrite is configured.
+func rewriteTargetFromAnnotations(ingress *v1Net.Ingress) (string, error) {
+	if target, ok := ingress.Annotations[AnnotationRewriteTarget]; ok {
+		if !strings.HasPrefix(target, "/") {
+			return "", fmt.Errorf("%w: %s for annotation %s has to start with /", ErrInvalidAnnotation, target, AnnotationRewriteTarget)
+		}
+		return target, nil
+	}
+	value, ok := ingress.Annotations[AnnotationStripPrefix]
+	if !ok {
+		return "", nil
+	}
+	stripPrefix, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s for annotation %s", ErrInvalidAnnotation, value, AnnotationStripPrefix)
+	}
+	if stripPrefix {
+		return "/", nil
+	}
+	return "", nil
+}
+
+// tlsProfileFromAnnotations returns the TlsProfile set via annotation for the ingress. Empty if none is set.
+func tlsProfileFromAnnotations(ingress *v1Net.Ingress) (TlsProfile, error) {
+	value, ok := ingress.Annotations[AnnotationTlsProfile]
+	if !ok {
+		return "", nil
+	}
+	profile := TlsProfile(value)
+	switch profile {
+	case TlsProfileModern, TlsProfileIntermediate, TlsProfileLegacy:
+		return profile, nil
+	default:
+		return "", fmt.Errorf("%w: %s for annotation %s", ErrInvalidAnnotation, value, AnnotationTlsProfile)
+	}
+}
+
+// positiveIntFromAnnotation returns the positive integer value of the annotation, the defaultValue if it is not set
+func positiveIntFromAnnotation(ingress *v1Net.Ingress, annotation string, defaultValue int) (int, error) {
+	value, ok := ingress.Annotations[annotation]
+	if !ok {
+		return defaultValue, nil
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil || result <= 0 {
+		return defaultValue, fmt.Errorf("%w: %s for annotation %s has to be a positive number", ErrInvalidAnnotation, value, annotation)
+	}
+	return result, nil
+}
+
+// durationFromAnnotation returns the positive duration value of the annotation, e.g. 10s, the defaultValue if it is not set
+func durationFromAnnotation(ingress *v1Net.Ingress, annotation string, defaultValue time.Duration) (time.Duration, error) {
+	value, ok := ingress.Annotations[annotation]
+	if !ok {
+		return defaultValue, nil
+	}
+	result, err := time.ParseDuration(value)
+	if err != nil || result <= 0 {
+		return defaultValue, fmt.Errorf("%w: %s for annotation %s has to be a positive duration", ErrInvalidAnnotation, value, annotation)
+	}
+	return result, nil
+}
+
+// isCanary returns whether the ingress is marked as canary via annotation
+func isCanary(ingress *v1Net.Ingress) bool {
+	canary, err := strconv.ParseBool(ingress.Annotations[AnnotationCanary])
+	return err == nil && canary
+}
+
+// isAcme returns whether the TLS secrets of the ingress are managed by the built-in ACME client, see AnnotationAcme
+func isAcme(ingress *v1Net.Ingress) bool {
+	acme, err := strconv.ParseBool(ingress.Annotations[AnnotationAcme])
+	return err == nil && acme
+}
+
+// isSslPassthrough returns whether the TLS connections for the ingress hosts are passed through to the backend, see AnnotationSslPassthrough
+func isSslPassthrough(ingress *v1Net.Ingress) bool {
+	passthrough, err := strconv.ParseBool(ingress.Annotations[AnnotationSslPassthrough])
+	return err == nil && passthrough
+}
+
+// canaryFromAnnotations returns the canary configuration set via annotations for the ingress, the Canary.Backend is not set.
+// Returns nil if the ingress is not marked as canary.
+func canaryFromAnnotations(ingress *v1Net.Ingress) (*Canary, error) {
+	if !isCanary(ingress) {
+		return nil, nil //nolint:nilnil // not being a canary is no error
+	}
+	canary := &Canary{
+		Header:      ingress.Annotations[AnnotationCanaryByHeader],
+		HeaderValue: ingress.Annotations[AnnotationCanaryByHeaderValue],
+		Cookie:      ingress.Annotations[AnnotationCanaryByCookie],
+	}
+	if canary.HeaderValue == "" {
+		canary.HeaderValue = CanaryAlways
+	}
+	if value, ok := ingress.Annotations[AnnotationCanaryWeight]; ok {
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 0 || weight > 100 {
+			return nil, fmt.Errorf("%w: %s for annotation %s has to be a number between 0 and 100", ErrInvalidAnnotation, value, AnnotationCanaryWeight)
+		}
+		canary.Weight = weight
+	}
+	return canary, nil
+}
@@ -0,0 +1,86 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPathMatchingFromAnnotations(t *testing.T) {
+	ingress := &v1Net.Ingress{}
+	pathMatching, err := pathMatchingFromAnnotations(ingress)
+	require.NoError(t, err)
+	require.Equal(t, PathMatchingRegex, pathMatching)
+	ingress.Annotations = map[string]string{AnnotationPathMatching: "glob"}
+	pathMatching, err = pathMatchingFromAnnotations(ingress)
+	require.NoError(t, err)
+	require.Equal(t, PathMatchingGlob, pathMatching)
+	ingress.Annotations[AnnotationPathMatching] = "none"
+	_, err = pathMatchingFromAnnotations(ingress)
+	require.ErrorIs(t, err, ErrInvalidAnnotation)
+}
+
+func TestTlsProfileFromAnnotations(t *testing.T) {
+	ingress := &v1Net.Ingress{}
+	profile, err := tlsProfileFromAnnotations(ingress)
+	require.NoError(t, err)
+	require.Equal(t, TlsProfile(""), profile)
+	ingress.Annotations = map[string]string{AnnotationTlsProfile: "modern"}
+	profile, err = tlsProfileFromAnnotations(ingress)
+	require.NoError(t, err)
+	require.Equal(t, TlsProfileModern, profile)
+	ingress.Annotations[AnnotationTlsProfile] = "none"
+	_, err = tlsProfileFromAnnotations(ingress)
+	require.ErrorIs(t, err, ErrInvalidAnnotation)
+}
+
+func TestRewriteTargetFromAnnotations(t *testing.T) {
+	for _, testCase := range []struct {
+		annotations    map[string]string
+		expectedTarget string
+		expectedErr    error
+	}{
+		{nil, "", nil},
+		{map[string]string{AnnotationStripPrefix: "true"}, "/", nil},
+		{map[string]string{AnnotationStripPrefix: "false"}, "", nil},
+		{map[string]string{AnnotationStripPrefix: "yes"}, "", ErrInvalidAnnotation},
+		{map[string]string{AnnotationRewriteTarget: "/$1", AnnotationStripPrefix: "true"}, "/$1", nil},
+		{map[string]string{AnnotationRewriteTarget: "$1"}, "", ErrInvalidAnnotation},
+	} {
+		target, err := rewriteTargetFromAnnotations(&v1Net.Ingress{ObjectMeta: v1Meta.ObjectMeta{Annotations: testCase.annotations}})
+		require.ErrorIs(t, err, testCase.expectedErr)
+		require.Equal(t, testCase.expectedTarget, target)
+	}
+}
+
+func TestCanaryFromAnnotations(t *testing.T) {
+	canary, err := canaryFromAnnotations(&v1Net.Ingress{})
+	require.NoError(t, err)
+	require.Nil(t, canary)
+	ingress := &v1Net.Ingress{ObjectMeta: v1Meta.ObjectMeta{Annotations: map[string]string{
+		AnnotationCanary:              "true",
+		AnnotationCanaryWeight:        "30",
+		AnnotationCanaryByHeader:      "X-Canary",
+		AnnotationCanaryByHeaderValue: "yes",
+		AnnotationCanaryByCookie:      "canary",
+	}}}
+	canary, err = canaryFromAnnotations(ingress)
+	require.NoError(t, err)
+	require.Equal(t, &Canary{Weight: 30, Header: "X-Canary", HeaderValue: "yes", Cookie: "canary"}, canary)
+	ingress.Annotations[AnnotationCanaryWeight] = "101"
+	_, err = canaryFromAnnotations(ingress)
+	require.ErrorIs(t, err, ErrInvalidAnnotation)
+}
+
+func TestValidateAnnotations(t *testing.T) {
+	ingress := &v1Net.Ingress{ObjectMeta: v1Meta.ObjectMeta{Annotations: map[string]string{
+		AnnotationRewriteTarget:          "/",
+		AnnotationPrefix + "strip-prefx": "true",
+		"kubernetes.io/ingress.class":    "ingress",
+	}}}
+	errs := validateAnnotations(ingress)
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], ErrUnknownAnnotation)
+}
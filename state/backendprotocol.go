@@ -0,0 +1,75 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+
+	v1Net "k8s.io/api/networking/v1"
+)
+
+var (
+	ErrBackendCaSecretNotFound = errors.New("referenced secret for backend certificate authority not found")
+	ErrInvalidBackendCa        = errors.New("no valid PEM encoded certificate in ca.crt of the backend certificate authority secret")
+)
+
+// BackendProtocol is the protocol used to connect to the backend endpoints, see AnnotationBackendProtocol
+type BackendProtocol string
+
+const (
+	// BackendProtocolHttp uses HTTP/1.1 without TLS. This is the default.
+	BackendProtocolHttp BackendProtocol = "http"
+	// BackendProtocolHttps uses TLS, HTTP/2 is used if the backend supports it.
+	BackendProtocolHttps BackendProtocol = "https"
+	// BackendProtocolH2c uses HTTP/2 without TLS (prior knowledge).
+	BackendProtocolH2c BackendProtocol = "h2c"
+	// BackendProtocolGrpc is gRPC without TLS, same transport as BackendProtocolH2c.
+	BackendProtocolGrpc BackendProtocol = "grpc"
+	// BackendProtocolGrpcs is gRPC with TLS, same transport as BackendProtocolHttps.
+	BackendProtocolGrpcs BackendProtocol = "grpcs"
+)
+
+// UsesTls returns whether the connections to the backend are encrypted
+func (protocol BackendProtocol) UsesTls() bool {
+	return protocol == BackendProtocolHttps || protocol == BackendProtocolGrpcs
+}
+
+// UsesH2c returns whether HTTP/2 without TLS is used to connect to the backend
+func (protocol BackendProtocol) UsesH2c() bool {
+	return protocol == BackendProtocolH2c || protocol == BackendProtocolGrpc
+}
+
+// BackendTls configures the TLS connections to the backends, see AnnotationBackendTlsSecret
+type BackendTls struct {
+	// CaCerts are the PEM encoded CA certificates that the backend certificates are verified against.
+	// The backend certificates are not verified if empty.
+	CaCerts []byte
+	// ServerName is sent as SNI and the backend certificates are verified for it. Defaults to the DNS name of the service if empty.
+	ServerName string
+}
+
+// backendProtocolFromAnnotations returns the backend protocol and for protocols with TLS the backend TLS configuration set via annotations for the ingress.
+// The protocol is empty if none is set. Backend paths must not be routed on errors, as the backend certificates could not be verified as intended.
+func (r *IngressReconciler) backendProtocolFromAnnotations(ingress *v1Net.Ingress) (BackendProtocol, *BackendTls, error) {
+	value, ok := ingress.Annotations[AnnotationBackendProtocol]
+	if !ok {
+		return "", nil, nil
+	}
+	protocol := BackendProtocol(value)
+	switch protocol {
+	case BackendProtocolHttp, BackendProtocolHttps, BackendProtocolH2c, BackendProtocolGrpc, BackendProtocolGrpcs:
+	default:
+		return "", nil, fmt.Errorf("%w: %s for annotation %s", ErrInvalidAnnotation, value, AnnotationBackendProtocol)
+	}
+	if !protocol.UsesTls() {
+		return protocol, nil, nil
+	}
+	backendTls := &BackendTls{ServerName: ingress.Annotations[AnnotationBackendTlsServerName]}
+	if secretName, ok := ingress.Annotations[AnnotationBackendTlsSecret]; ok {
+		caCerts, err := r.getCaCerts(ingress.Namespace, secretName, ErrBackendCaSecretNotFound, ErrInvalidBackendCa)
+		if err != nil {
+			return "", nil, err
+		}
+		backendTls.CaCerts = caCerts
+	}
+	return protocol, backendTls, nil
+}
@@ -0,0 +1,100 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	v1Net "k8s.io/api/networking/v1"
+	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const backendCaSecretName = "backend-ca"
+
+// processBackendProtocol processes the dummy ingress with the given annotations and the backend CA secret data
+func processBackendProtocol(t *testing.T, annotations map[string]string, caData []byte) (IngressState, []error) {
+	ctx := context.Background()
+	service := getDummyService()
+	service.Namespace = namespace
+	client := fake.NewSimpleClientset(service, &v1.Secret{
+		ObjectMeta: v1Meta.ObjectMeta{Namespace: namespace, Name: backendCaSecretName},
+		Type:       v1.SecretTypeOpaque,
+		Data:       map[string][]byte{"ca.crt": caData},
+	})
+	ingress := getDummyIngress()
+	ingress.Namespace = namespace
+	ingress.Annotations = annotations
+	ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port.Number = servicePort
+	stateReconciler := &IngressReconciler{
+		ingressClassName: ingressClassName,
+		ingressState: map[types.NamespacedName]*v1Net.Ingress{
+			{Namespace: namespace, Name: ingress.Name}: ingress,
+		},
+		k8sClients: newKubernetesClients(client)}
+	err := stateReconciler.k8sClients.startInformers(ctx)
+	require.NoError(t, err)
+	stateReconciler.k8sClients.waitForSync(ctx)
+	state, statusUpdates := stateReconciler.processState()
+	require.Len(t, statusUpdates, 1)
+	return state, statusUpdates[0].Errors
+}
+
+func TestBackendProtocol(t *testing.T) {
+	caCert := getDummyClientCa(t)
+	state, errs := processBackendProtocol(t, nil, caCert)
+	require.Empty(t, errs)
+	require.Empty(t, state[host].BackendPaths[0].Protocol)
+	require.Nil(t, state[host].BackendPaths[0].BackendTls)
+
+	state, errs = processBackendProtocol(t, map[string]string{AnnotationBackendProtocol: string(BackendProtocolGrpc)}, caCert)
+	require.Empty(t, errs)
+	require.Equal(t, BackendProtocolGrpc, state[host].BackendPaths[0].Protocol)
+	require.Nil(t, state[host].BackendPaths[0].BackendTls)
+
+	state, errs = processBackendProtocol(t, map[string]string{
+		AnnotationBackendProtocol:      string(BackendProtocolHttps),
+		AnnotationBackendTlsSecret:     backendCaSecretName,
+		AnnotationBackendTlsServerName: "backend.example.com",
+	}, caCert)
+	require.Empty(t, errs)
+	require.Equal(t, BackendProtocolHttps, state[host].BackendPaths[0].Protocol)
+	require.Equal(t, &BackendTls{CaCerts: caCert, ServerName: "backend.example.com"}, state[host].BackendPaths[0].BackendTls)
+}
+
+func TestBackendProtocolErrors(t *testing.T) {
+	for _, testCase := range []struct {
+		name        string
+		annotations map[string]string
+		caData      []byte
+		expectedErr error
+	}{
+		{"unknown protocol", map[string]string{AnnotationBackendProtocol: "ftp"}, nil, ErrInvalidAnnotation},
+		{"missing ca secret", map[string]string{AnnotationBackendProtocol: string(BackendProtocolGrpcs), AnnotationBackendTlsSecret: "missing"}, nil, ErrBackendCaSecretNotFound},
+		{"invalid ca", map[string]string{AnnotationBackendProtocol: string(BackendProtocolHttps), AnnotationBackendTlsSecret: backendCaSecretName}, []byte("dummy"), ErrInvalidBackendCa},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			state, errs := processBackendProtocol(t, testCase.annotations, testCase.caData)
+			require.Len(t, errs, 1)
+			require.ErrorIs(t, errs[0], testCase.expectedErr)
+			// not routed at all as the backend can not be connected to as intended
+			require.NotContains(t, state, host)
+		})
+	}
+}
+
+func TestFindIngressForBackendCaSecret(t *testing.T) {
+	ingress := getDummyIngress()
+	ingress.Namespace = namespace
+	ingress.Annotations = map[string]string{AnnotationBackendTlsSecret: backendCaSecretName}
+	ingressName := types.NamespacedName{Namespace: namespace, Name: ingress.Name}
+	stateReconciler := &IngressReconciler{ingressState: map[types.NamespacedName]*v1Net.Ingress{ingressName: ingress}}
+
+	secret := &v1.Secret{ObjectMeta: v1Meta.ObjectMeta{Namespace: namespace, Name: backendCaSecretName}}
+	require.Equal(t, []reconcile.Request{{NamespacedName: ingressName}}, stateReconciler.findIngressForSecret(context.Background(), secret))
+	secret.Name = "other"
+	require.Empty(t, stateReconciler.findIngressForSecret(context.Background(), secret))
+}
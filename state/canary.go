@@ -0,0 +1,52 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrCanaryPrimaryNotFound = errors.New("no primary ingress path found for canary path")
+	ErrCanaryAlreadySet      = errors.New("primary ingress path has already a canary")
+)
+
+const (
+	// CanaryAlways is the header or cookie value that selects the canary backend
+	CanaryAlways = "always"
+	// CanaryNever is the header or cookie value that selects the primary backend
+	CanaryNever = "never"
+)
+
+// Canary is the alternative backend for a BackendPath that receives a part of the traffic, see AnnotationCanary.
+// The header takes precedence over the cookie, which takes precedence over the weight.
+type Canary struct {
+	Backend *BackendPath
+	// Weight is the percentage of the requests that are routed to the canary backend
+	Weight int
+	// Header is the name of the request header that selects the canary backend if set to HeaderValue and the primary backend if set to CanaryNever. Optional.
+	Header      string
+	HeaderValue string
+	// Cookie is the name of the cookie that selects the canary backend if set to CanaryAlways and the primary backend if set to CanaryNever. Optional.
+	Cookie string
+}
+
+// addCanary sets the canary backend path for the primary backend path with the same host, path and path type.
+func (state IngressState) addCanary(host string, backendPath *BackendPath, canary *Canary) error {
+	domainConfig, ok := state[host]
+	if !ok {
+		return fmt.Errorf("%w: host %s path %s", ErrCanaryPrimaryNotFound, host, backendPath.Path)
+	}
+	for _, primary := range domainConfig.BackendPaths {
+		if primary.Path != backendPath.Path || primary.PathType == nil || backendPath.PathType == nil || *primary.PathType != *backendPath.PathType {
+			continue
+		}
+		if primary.Canary != nil {
+			return fmt.Errorf("%w: host %s path %s", ErrCanaryAlreadySet, host, backendPath.Path)
+		}
+		primaryCanary := *canary
+		primaryCanary.Backend = backendPath
+		primary.Canary = &primaryCanary
+		return nil
+	}
+	return fmt.Errorf("%w: host %s path %s", ErrCanaryPrimaryNotFound, host, backendPath.Path)
+}
@@ -0,0 +1,121 @@
+package state
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const canaryServiceName = "svc-canary"
+
+// getCanaryReconciler returns a reconciler for the primary dummy ingress and a canary ingress for canaryHost in the canaryNamespace
+func getCanaryReconciler(t *testing.T, canaryHost string, canaryNamespace string) *IngressReconciler {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	ingress := getDummyIngress()
+	ingress.Namespace = namespace
+	ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.Service.Port.Number = servicePort
+	canaryIngress := ingress.DeepCopy()
+	canaryIngress.Name = "canary"
+	canaryIngress.Namespace = canaryNamespace
+	canaryIngress.Annotations = map[string]string{
+		AnnotationCanary:         "true",
+		AnnotationCanaryWeight:   "20",
+		AnnotationCanaryByHeader: "X-Canary",
+	}
+	canaryIngress.Spec.Rules[0].Host = canaryHost
+	canaryIngress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.Service.Name = canaryServiceName
+	canaryService := getDummyService()
+	canaryService.Name = canaryServiceName
+	_, err := client.CoreV1().Services(namespace).Create(ctx, getDummyService(), v1Meta.CreateOptions{})
+	require.NoError(t, err)
+	_, err = client.CoreV1().Services(canaryNamespace).Create(ctx, canaryService, v1Meta.CreateOptions{})
+	require.NoError(t, err)
+
+	stateReconciler := &IngressReconciler{
+		ingressClassName: ingressClassName,
+		ingressState: map[types.NamespacedName]*v1Net.Ingress{
+			{Namespace: canaryNamespace, Name: canaryIngress.Name}: canaryIngress,
+			{Namespace: namespace, Name: ingress.Name}:             ingress,
+		},
+		ingressProcessedStateChan: make(chan IngressState),
+		hostIp:                    net.IPv4(127, 0, 0, 1),
+		k8sClients:                newKubernetesClients(client)}
+	err = stateReconciler.k8sClients.startInformers(ctx)
+	require.NoError(t, err)
+	stateReconciler.k8sClients.waitForSync(ctx)
+	return stateReconciler
+}
+
+func TestCanary(t *testing.T) {
+	state, statusUpdates := getCanaryReconciler(t, host, namespace).processState()
+
+	require.Len(t, state, 1)
+	require.Len(t, state[host].BackendPaths, 1)
+	backendPath := state[host].BackendPaths[0]
+	require.Equal(t, serviceName, backendPath.ServiceName)
+	require.NotNil(t, backendPath.Canary)
+	require.Equal(t, canaryServiceName, backendPath.Canary.Backend.ServiceName)
+	require.Equal(t, 20, backendPath.Canary.Weight)
+	require.Equal(t, "X-Canary", backendPath.Canary.Header)
+	require.Equal(t, CanaryAlways, backendPath.Canary.HeaderValue)
+	for _, statusUpdate := range statusUpdates {
+		require.Nil(t, statusUpdate.Status.Ports[0].Error)
+	}
+}
+
+func TestCanaryPrimaryNotFound(t *testing.T) {
+	state, statusUpdates := getCanaryReconciler(t, "other."+host, namespace).processState()
+
+	require.Len(t, state, 1)
+	require.Nil(t, state[host].BackendPaths[0].Canary)
+	require.Len(t, statusUpdates, 2)
+	for _, statusUpdate := range statusUpdates {
+		errMsg := statusUpdate.Status.Ports[0].Error
+		if statusUpdate.Ingress.Name != "canary" {
+			require.Nil(t, errMsg)
+			continue
+		}
+		require.NotNil(t, errMsg)
+		require.Contains(t, *errMsg, ErrCanaryPrimaryNotFound.Error())
+	}
+}
+
+func TestCanaryOtherNamespace(t *testing.T) {
+	state, statusUpdates := getCanaryReconciler(t, host, "other").processState()
+
+	require.Nil(t, state[host].BackendPaths[0].Canary)
+	for _, statusUpdate := range statusUpdates {
+		if statusUpdate.Ingress.Name != "canary" {
+			require.Empty(t, statusUpdate.Conflicts)
+			continue
+		}
+		require.Len(t, statusUpdate.Conflicts, 1)
+		require.ErrorIs(t, statusUpdate.Conflicts[0], ErrCanaryConflict)
+	}
+}
+
+func TestCanaryAlreadySet(t *testing.T) {
+	stateReconciler := getCanaryReconciler(t, host, namespace)
+	secondCanary := stateReconciler.ingressState[types.NamespacedName{Namespace: namespace, Name: "canary"}].DeepCopy()
+	secondCanary.Name = "second-canary"
+	secondCanary.Annotations[AnnotationCanaryWeight] = "100"
+	stateReconciler.ingressState[types.NamespacedName{Namespace: namespace, Name: secondCanary.Name}] = secondCanary
+	state, statusUpdates := stateReconciler.processState()
+
+	require.Equal(t, 20, state[host].BackendPaths[0].Canary.Weight)
+	for _, statusUpdate := range statusUpdates {
+		if statusUpdate.Ingress.Name != secondCanary.Name {
+			require.Empty(t, statusUpdate.Conflicts)
+			continue
+		}
+		require.Len(t, statusUpdate.Conflicts, 1)
+		require.ErrorIs(t, statusUpdate.Conflicts[0], ErrCanaryAlreadySet)
+	}
+}
@@ -0,0 +1,81 @@
+package state
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCertificate      = errors.New("tls secret does not contain a valid certificate")
+	ErrCertificateExpired      = errors.New("tls certificate is expired")
+	ErrCertificateNotYetValid  = errors.New("tls certificate is not yet valid")
+	ErrCertificateHostMismatch = errors.New("tls certificate does not cover host")
+)
+
+// ParseLeaf parses the first certificate of the PEM encoded certificate chain
+func (tlsCert *TlsCert) ParseLeaf() (*x509.Certificate, error) {
+	certPem := tlsCert.Cert
+	for {
+		var block *pem.Block
+		block, certPem = pem.Decode(certPem)
+		if block == nil {
+			return nil, ErrInvalidCertificate
+		}
+		if block.Type == "CERTIFICATE" {
+			leaf, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidCertificate, err)
+			}
+			return leaf, nil
+		}
+	}
+}
+
+// validateCertificate checks that the private key of the TlsCert matches the certificate
+// and that the leaf certificate is valid at the given time and covers the hosts
+func validateCertificate(tlsCert *TlsCert, hosts []string, now time.Time) []error {
+	if _, err := tls.X509KeyPair(tlsCert.Cert, tlsCert.Key); err != nil {
+		return []error{fmt.Errorf("%w: secret %s: %w", ErrInvalidCertificate, tlsCert.Secret.Name, err)}
+	}
+	leaf, err := tlsCert.ParseLeaf()
+	if err != nil {
+		return []error{fmt.Errorf("%w: secret %s", err, tlsCert.Secret.Name)}
+	}
+	errs := make([]error, 0)
+	if now.After(leaf.NotAfter) {
+		errs = append(errs, fmt.Errorf("%w: secret %s expired at %s", ErrCertificateExpired, tlsCert.Secret.Name, leaf.NotAfter.Format(time.RFC3339)))
+	}
+	if now.Before(leaf.NotBefore) {
+		errs = append(errs, fmt.Errorf("%w: secret %s is valid from %s", ErrCertificateNotYetValid, tlsCert.Secret.Name, leaf.NotBefore.Format(time.RFC3339)))
+	}
+	mismatchedHosts := make([]string, 0)
+	for _, host := range hosts {
+		if leaf.VerifyHostname(host) != nil {
+			mismatchedHosts = append(mismatchedHosts, host)
+		}
+	}
+	if len(mismatchedHosts) > 0 {
+		errs = append(errs, fmt.Errorf("%w: secret %s does not cover %s", ErrCertificateHostMismatch, tlsCert.Secret.Name, strings.Join(mismatchedHosts, ",")))
+	}
+	return errs
+}
+
+// skipCertificate returns whether the certificate is not served due to its validation errors, see validateCertificate.
+// Invalid certificates are never served, expired ones not if rejectExpiredCerts is set. The hosts fall back to the default certificate.
+func (r *IngressReconciler) skipCertificate(certErrs []error) bool {
+	return slices.ContainsFunc(certErrs, func(err error) bool {
+		return errors.Is(err, ErrInvalidCertificate) || (r.rejectExpiredCerts && errors.Is(err, ErrCertificateExpired))
+	})
+}
+
+// isCertificateError returns whether the error is a validation error of a tls certificate, see validateCertificate
+func isCertificateError(err error) bool {
+	return errors.Is(err, ErrInvalidCertificate) || errors.Is(err, ErrCertificateExpired) ||
+		errors.Is(err, ErrCertificateNotYetValid) || errors.Is(err, ErrCertificateHostMismatch)
+}
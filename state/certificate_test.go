@@ -0,0 +1,116 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateCertificate(t *testing.T) {
+	now := time.Now()
+	getTlsCert := func(hosts []string, notBefore time.Time, notAfter time.Time) *TlsCert {
+		cert, certKey := getDummyCertificate(t, hosts, notBefore, notAfter)
+		return &TlsCert{Secret: types.NamespacedName{Namespace: namespace, Name: secretName}, Cert: cert, Key: certKey}
+	}
+	mismatchedKey := getTlsCert([]string{host}, now.Add(-time.Hour), now.Add(time.Hour))
+	mismatchedKey.Key = getTlsCert([]string{host}, now.Add(-time.Hour), now.Add(time.Hour)).Key
+	for _, testCase := range []struct {
+		name         string
+		tlsCert      *TlsCert
+		hosts        []string
+		expectedErrs []error
+	}{
+		{"valid", getTlsCert([]string{host}, now.Add(-time.Hour), now.Add(time.Hour)), []string{host}, nil},
+		{"wildcard", getTlsCert([]string{"*.example.com"}, now.Add(-time.Hour), now.Add(time.Hour)), []string{"a.example.com", "*.example.com"}, nil},
+		{"invalid", &TlsCert{Cert: []byte("dummy")}, []string{host}, []error{ErrInvalidCertificate}},
+		{"mismatched key", mismatchedKey, []string{host}, []error{ErrInvalidCertificate}},
+		{"expired", getTlsCert([]string{host}, now.Add(-time.Hour), now.Add(-time.Minute)), []string{host}, []error{ErrCertificateExpired}},
+		{"not yet valid", getTlsCert([]string{host}, now.Add(time.Minute), now.Add(time.Hour)), []string{host}, []error{ErrCertificateNotYetValid}},
+		{"host mismatch", getTlsCert([]string{host}, now.Add(-time.Hour), now.Add(time.Hour)), []string{host, "other"}, []error{ErrCertificateHostMismatch}},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			errs := validateCertificate(testCase.tlsCert, testCase.hosts, now)
+			require.Len(t, errs, len(testCase.expectedErrs))
+			for i, expectedErr := range testCase.expectedErrs {
+				require.ErrorIs(t, errs[i], expectedErr)
+				require.True(t, isCertificateError(errs[i]))
+			}
+		})
+	}
+}
+
+func TestRejectExpiredCertificates(t *testing.T) {
+	ctx := context.Background()
+	secret, _, _ := getDummySecret(t)
+	secret.Namespace = namespace
+	cert, certKey := getDummyCertificate(t, []string{host}, time.Now().Add(-time.Hour), time.Now().Add(-time.Minute))
+	secret.Data["tls.crt"] = cert
+	secret.Data["tls.key"] = certKey
+	ingress := getDummyIngressSecretRef()
+	ingress.Namespace = namespace
+	stateReconciler := &IngressReconciler{
+		ingressClassName: ingressClassName,
+		ingressState: map[types.NamespacedName]*v1Net.Ingress{
+			{Namespace: namespace, Name: ingress.Name}: ingress,
+		},
+		k8sClients: newKubernetesClients(fake.NewSimpleClientset(secret))}
+	err := stateReconciler.k8sClients.startInformers(ctx)
+	require.NoError(t, err)
+	stateReconciler.k8sClients.waitForSync(ctx)
+
+	state, statusUpdates := stateReconciler.processState()
+	require.Equal(t, cert, state[host].TlsCerts[0].Cert)
+	require.Len(t, statusUpdates, 1)
+	require.Len(t, statusUpdates[0].Errors, 1)
+	require.ErrorIs(t, statusUpdates[0].Errors[0], ErrCertificateExpired)
+
+	stateReconciler.SetRejectExpiredCertificates(true)
+	state, statusUpdates = stateReconciler.processState()
+	require.NotContains(t, state, host)
+	require.Len(t, statusUpdates, 1)
+	require.ErrorIs(t, statusUpdates[0].Errors[0], ErrCertificateExpired)
+}
+
+func TestInvalidCertificateSkipped(t *testing.T) {
+	ctx := context.Background()
+	secret, cert, _ := getDummySecret(t)
+	secret.Namespace = namespace
+	corruptSecret := secret.DeepCopy()
+	corruptSecret.Name = "corrupt-" + secretName
+	corruptSecret.Data["tls.crt"] = []byte("dummy")
+	ingress := getDummyIngressSecretRef()
+	ingress.Namespace = namespace
+	corruptIngress := getDummyIngressSecretRef()
+	corruptIngress.Namespace = namespace
+	corruptIngress.Name = "corrupt-" + ingress.Name
+	corruptIngress.Spec.TLS[0].Hosts = []string{"corrupt." + host}
+	corruptIngress.Spec.TLS[0].SecretName = corruptSecret.Name
+	stateReconciler := &IngressReconciler{
+		ingressClassName: ingressClassName,
+		ingressState: map[types.NamespacedName]*v1Net.Ingress{
+			{Namespace: namespace, Name: ingress.Name}:        ingress,
+			{Namespace: namespace, Name: corruptIngress.Name}: corruptIngress,
+		},
+		k8sClients: newKubernetesClients(fake.NewSimpleClientset(secret, corruptSecret))}
+	err := stateReconciler.k8sClients.startInformers(ctx)
+	require.NoError(t, err)
+	stateReconciler.k8sClients.waitForSync(ctx)
+
+	state, statusUpdates := stateReconciler.processState()
+	require.Equal(t, cert, state[host].TlsCerts[0].Cert)
+	require.NotContains(t, state, "corrupt."+host)
+	require.Len(t, statusUpdates, 2)
+	for _, update := range statusUpdates {
+		if update.Ingress.Name == corruptIngress.Name {
+			require.Len(t, update.Errors, 1)
+			require.ErrorIs(t, update.Errors[0], ErrInvalidCertificate)
+		} else {
+			require.Empty(t, update.Errors)
+		}
+	}
+}
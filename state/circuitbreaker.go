@@ -0,0 +1,30 @@
+package state
+
+import (
+	"errors"
+
+	v1Net "k8s.io/api/networking/v1"
+)
+
+// CircuitBreaker limits the load on a backend, requests beyond the limits are rejected. Zero values are the defaults of the reverse proxy.
+type CircuitBreaker struct {
+	// MaxRequests is the maximum number of concurrent requests to the backend, see AnnotationMaxRequests
+	MaxRequests int
+	// MaxPendingRequests is the maximum number of requests that wait for one of the MaxRequests, see AnnotationMaxPendingRequests
+	MaxPendingRequests int
+	// MaxConnections is the maximum number of open connections to the backend, see AnnotationMaxConnections
+	MaxConnections int
+}
+
+// circuitBreakerFromAnnotations returns the circuit breaker configured via annotations for the ingress, nil if not configured.
+// On errors the invalid values are left to the defaults of the reverse proxy.
+func circuitBreakerFromAnnotations(ingress *v1Net.Ingress) (*CircuitBreaker, error) {
+	maxRequests, errRequests := positiveIntFromAnnotation(ingress, AnnotationMaxRequests, 0)
+	maxPendingRequests, errPending := positiveIntFromAnnotation(ingress, AnnotationMaxPendingRequests, 0)
+	maxConnections, errConnections := positiveIntFromAnnotation(ingress, AnnotationMaxConnections, 0)
+	err := errors.Join(errRequests, errPending, errConnections)
+	if maxRequests == 0 && maxPendingRequests == 0 && maxConnections == 0 {
+		return nil, err
+	}
+	return &CircuitBreaker{MaxRequests: maxRequests, MaxPendingRequests: maxPendingRequests, MaxConnections: maxConnections}, err
+}
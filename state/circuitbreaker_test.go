@@ -0,0 +1,27 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCircuitBreakerFromAnnotations(t *testing.T) {
+	for _, testCase := range []struct {
+		annotations     map[string]string
+		expectedBreaker *CircuitBreaker
+		expectedErr     error
+	}{
+		{nil, nil, nil},
+		{map[string]string{AnnotationMaxRequests: "100", AnnotationMaxPendingRequests: "10"}, &CircuitBreaker{MaxRequests: 100, MaxPendingRequests: 10}, nil},
+		{map[string]string{AnnotationMaxConnections: "50"}, &CircuitBreaker{MaxConnections: 50}, nil},
+		{map[string]string{AnnotationMaxRequests: "0"}, nil, ErrInvalidAnnotation},
+		{map[string]string{AnnotationMaxRequests: "100", AnnotationMaxConnections: "many"}, &CircuitBreaker{MaxRequests: 100}, ErrInvalidAnnotation},
+	} {
+		breaker, err := circuitBreakerFromAnnotations(&v1Net.Ingress{ObjectMeta: v1Meta.ObjectMeta{Annotations: testCase.annotations}})
+		require.ErrorIs(t, err, testCase.expectedErr)
+		require.Equal(t, testCase.expectedBreaker, breaker)
+	}
+}
@@ -0,0 +1,86 @@
+package state
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	v1Net "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	ErrClientCaSecretNotFound = errors.New("referenced secret for client certificate authority not found")
+	ErrInvalidClientCa        = errors.New("no valid PEM encoded certificate in ca.crt of the client certificate authority secret")
+)
+
+const (
+	// caCertsKey is the secret data key of the CA certificates for the client authentication and the backend TLS verification
+	caCertsKey = "ca.crt"
+	// secretGetTimeout limits the time to fetch a referenced secret that is not in the secret lister
+	secretGetTimeout = time.Duration(5) * time.Second
+)
+
+// ClientAuthMode determines whether clients have to present a certificate
+type ClientAuthMode string
+
+const (
+	// ClientAuthRequired rejects the TLS handshake if the client presents no valid certificate. This is the default.
+	ClientAuthRequired ClientAuthMode = "required"
+	// ClientAuthOptional verifies the client certificate if one is presented.
+	ClientAuthOptional ClientAuthMode = "optional"
+)
+
+// ClientAuth is the mutual TLS client authentication of a host, see AnnotationAuthTlsSecret
+type ClientAuth struct {
+	// CaCerts are the PEM encoded CA certificates that the client certificates are verified against.
+	// Empty if the referenced secret is not valid, all client certificates are rejected then.
+	CaCerts []byte
+	Mode    ClientAuthMode
+}
+
+// getClientAuth returns the client authentication configured via annotations for the ingress, nil if none is configured.
+// On errors the returned client authentication fails closed, i.e. it is required and has no CA certificates.
+func (r *IngressReconciler) getClientAuth(ingress *v1Net.Ingress) (*ClientAuth, error) {
+	secretName, ok := ingress.Annotations[AnnotationAuthTlsSecret]
+	if !ok {
+		return nil, nil //nolint:nilnil // no client authentication is no error
+	}
+	clientAuth := &ClientAuth{Mode: ClientAuthRequired}
+	if value, ok := ingress.Annotations[AnnotationAuthTlsVerifyClient]; ok {
+		mode := ClientAuthMode(value)
+		if mode != ClientAuthRequired && mode != ClientAuthOptional {
+			return clientAuth, fmt.Errorf("%w: %s for annotation %s", ErrInvalidAnnotation, value, AnnotationAuthTlsVerifyClient)
+		}
+		clientAuth.Mode = mode
+	}
+	caCerts, err := r.getCaCerts(ingress.Namespace, secretName, ErrClientCaSecretNotFound, ErrInvalidClientCa)
+	if err != nil {
+		return clientAuth, err
+	}
+	clientAuth.CaCerts = caCerts
+	return clientAuth, nil
+}
+
+// getCaCerts returns the validated ca.crt of the secret. Both kubernetes.io/tls and Opaque secrets are supported.
+// The secret lister only contains kubernetes.io/tls secrets, other secrets are fetched directly via the Kubernetes API like in the webhook.
+// The errNotFound is wrapped if the secret does not exist, the errInvalid if it contains no valid certificate.
+func (r *IngressReconciler) getCaCerts(namespace string, secretName string, errNotFound error, errInvalid error) ([]byte, error) {
+	secret, err := r.k8sClients.SecretLister.Secrets(namespace).Get(secretName)
+	if apierrors.IsNotFound(err) {
+		ctx, cancel := context.WithTimeout(context.Background(), secretGetTimeout)
+		defer cancel()
+		secret, err = r.k8sClients.client.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", errNotFound, secretName, err)
+	}
+	caCerts := secret.Data[caCertsKey]
+	if !x509.NewCertPool().AppendCertsFromPEM(caCerts) {
+		return nil, fmt.Errorf("%w: secret %s", errInvalid, secretName)
+	}
+	return caCerts, nil
+}
@@ -0,0 +1,108 @@
+package state
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	v1Net "k8s.io/api/networking/v1"
+	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const clientCaSecretName = "client-ca"
+
+// getDummyClientCa returns a PEM encoded self-signed CA certificate
+func getDummyClientCa(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// processClientAuth processes the dummy ingress with TLS and the given annotations with the client CA secret data
+func processClientAuth(t *testing.T, annotations map[string]string, caData []byte) (IngressState, []error) {
+	ctx := context.Background()
+	tlsSecret, _, _ := getDummySecret(t)
+	tlsSecret.Namespace = namespace
+	client := fake.NewSimpleClientset(tlsSecret, &v1.Secret{
+		ObjectMeta: v1Meta.ObjectMeta{Namespace: namespace, Name: clientCaSecretName},
+		Type:       v1.SecretTypeOpaque,
+		Data:       map[string][]byte{"ca.crt": caData},
+	})
+	ingress := getDummyIngressSecretRef()
+	ingress.Namespace = namespace
+	ingress.Annotations = annotations
+	stateReconciler := &IngressReconciler{
+		ingressClassName: ingressClassName,
+		ingressState: map[types.NamespacedName]*v1Net.Ingress{
+			{Namespace: namespace, Name: ingress.Name}: ingress,
+		},
+		k8sClients: newKubernetesClients(client)}
+	err := stateReconciler.k8sClients.startInformers(ctx)
+	require.NoError(t, err)
+	stateReconciler.k8sClients.waitForSync(ctx)
+	state, statusUpdates := stateReconciler.processState()
+	require.Len(t, statusUpdates, 1)
+	return state, statusUpdates[0].Errors
+}
+
+func TestClientAuth(t *testing.T) {
+	caCert := getDummyClientCa(t)
+	state, errs := processClientAuth(t, map[string]string{AnnotationAuthTlsSecret: clientCaSecretName}, caCert)
+	require.Empty(t, errs)
+	require.Equal(t, &ClientAuth{CaCerts: caCert, Mode: ClientAuthRequired}, state[host].ClientAuth)
+
+	state, errs = processClientAuth(t, map[string]string{
+		AnnotationAuthTlsSecret:       clientCaSecretName,
+		AnnotationAuthTlsVerifyClient: string(ClientAuthOptional),
+	}, caCert)
+	require.Empty(t, errs)
+	require.Equal(t, &ClientAuth{CaCerts: caCert, Mode: ClientAuthOptional}, state[host].ClientAuth)
+
+	state, errs = processClientAuth(t, nil, caCert)
+	require.Empty(t, errs)
+	require.Nil(t, state[host].ClientAuth)
+}
+
+func TestClientAuthInvalid(t *testing.T) {
+	caCert := getDummyClientCa(t)
+	failClosed := &ClientAuth{Mode: ClientAuthRequired}
+
+	state, errs := processClientAuth(t, map[string]string{
+		AnnotationAuthTlsSecret:       clientCaSecretName,
+		AnnotationAuthTlsVerifyClient: "invalid",
+	}, caCert)
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], ErrInvalidAnnotation)
+	require.Equal(t, failClosed, state[host].ClientAuth)
+
+	state, errs = processClientAuth(t, map[string]string{AnnotationAuthTlsSecret: "missing"}, caCert)
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], ErrClientCaSecretNotFound)
+	require.Equal(t, failClosed, state[host].ClientAuth)
+
+	state, errs = processClientAuth(t, map[string]string{AnnotationAuthTlsSecret: clientCaSecretName}, []byte("invalid"))
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], ErrInvalidClientCa)
+	require.Equal(t, failClosed, state[host].ClientAuth)
+}
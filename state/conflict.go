@@ -0,0 +1,181 @@
+package state
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+
+	v1Net "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var (
+	ErrPathConflict = errors.New("host and path are already used by an older resource")
+	ErrTlsConflict  = errors.New("host already uses a different tls secret from an older resource")
+	// ErrPassthroughConflict is returned if a host is used for TLS passthrough and TLS termination or by several passthrough ingresses
+	ErrPassthroughConflict = errors.New("host is already used for tls by an older resource")
+	// ErrCanaryConflict is returned if a canary path belongs to a primary path of another namespace
+	ErrCanaryConflict = errors.New("canary path belongs to a resource in another namespace")
+)
+
+// sortByPrecedence sorts the ingresses in the order in which they are processed.
+// Canary ingresses are attached to the paths of the primary ingresses and are therefore sorted last.
+// Otherwise, the oldest ingress comes first and wins conflicts, the namespace and name break ties.
+func sortByPrecedence(ingresses []*v1Net.Ingress) {
+	slices.SortFunc(ingresses, func(a *v1Net.Ingress, b *v1Net.Ingress) int {
+		if isCanary(a) != isCanary(b) {
+			if isCanary(a) {
+				return 1
+			}
+			return -1
+		}
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			if a.CreationTimestamp.Before(&b.CreationTimestamp) {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Name, b.Name))
+	})
+}
+
+// routeClaims tracks which ingress (or Gateway API resource) has first used a host and path or the tls secret for a host.
+// Ingresses have to be processed in the order of sortByPrecedence so that the oldest one wins.
+type routeClaims struct {
+	paths map[pathClaimKey]claimOwner
+	tls   map[string]*tlsClaim
+	// passthrough are the hosts whose TLS connections are passed through to the backend, see AnnotationSslPassthrough
+	passthrough map[string]claimOwner
+	// canaries are the owners of the canary paths attached to the claimed paths, see AnnotationCanary
+	canaries map[pathClaimKey]claimOwner
+}
+
+// claimOwner identifies the resource that claimed a route
+type claimOwner struct {
+	kind string
+	types.NamespacedName
+}
+
+// String returns the kind, namespace and name of the owner
+func (owner claimOwner) String() string {
+	return owner.kind + " " + owner.NamespacedName.String()
+}
+
+// ingressOwner returns the claimOwner for the ingress
+func ingressOwner(ingress *v1Net.Ingress) claimOwner {
+	return claimOwner{kind: "ingress", NamespacedName: types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name}}
+}
+
+type pathClaimKey struct {
+	host       string
+	pathType   v1Net.PathType
+	path       string
+	conditions string
+}
+
+// tlsClaim are the tls secrets that the owner uses for a host, e.g. for an ECDSA and an RSA certificate
+type tlsClaim struct {
+	owner   claimOwner
+	secrets []types.NamespacedName
+}
+
+func newRouteClaims() *routeClaims {
+	return &routeClaims{
+		paths:       make(map[pathClaimKey]claimOwner),
+		tls:         make(map[string]*tlsClaim),
+		passthrough: make(map[string]claimOwner),
+		canaries:    make(map[pathClaimKey]claimOwner),
+	}
+}
+
+// claimPath claims the host and path with the optional request conditions for the owner.
+// The default backend of a host is claimed with an empty path and path type.
+// Returns an error if another owner has already claimed them. Claiming the same path again for the same owner is no conflict.
+func (claims *routeClaims) claimPath(claimant claimOwner, host string, pathType *v1Net.PathType, path string, conditions *RequestConditions) error {
+	key := newPathClaimKey(host, pathType, path, conditions)
+	owner, ok := claims.paths[key]
+	if !ok {
+		claims.paths[key] = claimant
+		return nil
+	}
+	if owner == claimant {
+		return nil
+	}
+	if path == "" {
+		return fmt.Errorf("%w: default backend for host %s is used by %s", ErrPathConflict, host, owner)
+	}
+	return fmt.Errorf("%w: host %s path %s is used by %s", ErrPathConflict, host, path, owner)
+}
+
+// claimCanary claims the canary of the primary path with the host, path type and path for the owner.
+// The primary path has to be claimed by an owner in the same namespace, so that canaries can not divert the traffic of other namespaces.
+// Returns an error if the namespace differs or another owner has already claimed the canary. Missing primary paths are no conflict, see addCanary.
+func (claims *routeClaims) claimCanary(claimant claimOwner, host string, pathType *v1Net.PathType, path string) error {
+	key := newPathClaimKey(host, pathType, path, nil)
+	primaryOwner, ok := claims.paths[key]
+	if !ok {
+		return nil
+	}
+	if primaryOwner.Namespace != claimant.Namespace {
+		return fmt.Errorf("%w: host %s path %s is used by %s", ErrCanaryConflict, host, path, primaryOwner)
+	}
+	owner, ok := claims.canaries[key]
+	if !ok {
+		claims.canaries[key] = claimant
+		return nil
+	}
+	if owner == claimant {
+		return nil
+	}
+	return fmt.Errorf("%w: host %s path %s has the canary of %s", ErrCanaryAlreadySet, host, path, owner)
+}
+
+// newPathClaimKey returns the key of the host and path with the optional request conditions
+func newPathClaimKey(host string, pathType *v1Net.PathType, path string, conditions *RequestConditions) pathClaimKey {
+	key := pathClaimKey{host: host, path: path, conditions: conditions.key()}
+	if pathType != nil {
+		key.pathType = *pathType
+	}
+	return key
+}
+
+// claimTls claims the tls secret in the namespace of the owner for the host.
+// The owner that claims a host first may claim further secrets for it. Returns an error if another owner
+// has already claimed the host with different secrets, using one of the claimed secrets is no conflict.
+func (claims *routeClaims) claimTls(claimant claimOwner, host string, secretName string) error {
+	secret := types.NamespacedName{Namespace: claimant.Namespace, Name: secretName}
+	if owner, ok := claims.passthrough[host]; ok && owner != claimant {
+		return fmt.Errorf("%w: host %s is passed through by %s", ErrPassthroughConflict, host, owner)
+	}
+	claim, ok := claims.tls[host]
+	if !ok {
+		claims.tls[host] = &tlsClaim{owner: claimant, secrets: []types.NamespacedName{secret}}
+		return nil
+	}
+	if slices.Contains(claim.secrets, secret) {
+		return nil
+	}
+	if claim.owner == claimant {
+		claim.secrets = append(claim.secrets, secret)
+		return nil
+	}
+	return fmt.Errorf("%w: host %s uses secret %s of %s", ErrTlsConflict, host, claim.secrets[0], claim.owner)
+}
+
+// claimPassthrough claims the TLS connections of the host to be passed through to the backend of the owner.
+// Returns an error if another owner has already claimed the host for TLS termination or passthrough.
+func (claims *routeClaims) claimPassthrough(claimant claimOwner, host string) error {
+	if claim, ok := claims.tls[host]; ok && claim.owner != claimant {
+		return fmt.Errorf("%w: host %s uses secret %s of %s", ErrPassthroughConflict, host, claim.secrets[0], claim.owner)
+	}
+	owner, ok := claims.passthrough[host]
+	if !ok {
+		claims.passthrough[host] = claimant
+		return nil
+	}
+	if owner == claimant {
+		return nil
+	}
+	return fmt.Errorf("%w: host %s is passed through by %s", ErrPassthroughConflict, host, owner)
+}
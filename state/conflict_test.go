@@ -0,0 +1,135 @@
+package state
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// getConflictReconciler returns a reconciler for the given ingresses with a fake event recorder
+func getConflictReconciler(t *testing.T, client *fake.Clientset, ingresses ...*v1Net.Ingress) (*IngressReconciler, *record.FakeRecorder) {
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(10)
+	stateReconciler := &IngressReconciler{
+		ingressClassName:          ingressClassName,
+		ingressState:              make(map[types.NamespacedName]*v1Net.Ingress),
+		ingressProcessedStateChan: make(chan IngressState),
+		hostIp:                    net.IPv4(127, 0, 0, 1),
+		k8sClients:                newKubernetesClients(client),
+		eventRecorder:             recorder,
+	}
+	for _, ingress := range ingresses {
+		stateReconciler.ingressState[types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name}] = ingress
+	}
+	err := stateReconciler.k8sClients.startInformers(ctx)
+	require.NoError(t, err)
+	stateReconciler.k8sClients.waitForSync(ctx)
+	return stateReconciler, recorder
+}
+
+// requireConflict checks that only the ingress with the given name has a status error and an event for the expected conflict
+func requireConflict(t *testing.T, stateReconciler *IngressReconciler, recorder *record.FakeRecorder,
+	statusUpdates []*ingressStatusUpdate, name string, expectedErr error) {
+	require.Len(t, statusUpdates, 2)
+	for _, statusUpdate := range statusUpdates {
+		errMsg := statusUpdate.Status.Ports[0].Error
+		if statusUpdate.Ingress.Name != name {
+			require.Nil(t, errMsg)
+			require.Empty(t, statusUpdate.Conflicts)
+			continue
+		}
+		require.NotNil(t, errMsg)
+		require.Contains(t, *errMsg, expectedErr.Error())
+		require.Len(t, statusUpdate.Conflicts, 1)
+	}
+	stateReconciler.recordConflicts(statusUpdates)
+	require.Len(t, recorder.Events, 1)
+	require.Contains(t, <-recorder.Events, eventReasonConflict)
+}
+
+func TestPathConflict(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	_, err := client.CoreV1().Services(namespace).Create(context.Background(), getDummyService(), v1Meta.CreateOptions{})
+	require.NoError(t, err)
+	olderIngress := getDummyIngress()
+	olderIngress.Name = "b"
+	olderIngress.Namespace = namespace
+	olderIngress.CreationTimestamp = v1Meta.NewTime(time.Now().Add(-time.Hour))
+	olderIngress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.Service.Port.Number = servicePort
+	newerIngress := olderIngress.DeepCopy()
+	newerIngress.Name = "a"
+	newerIngress.CreationTimestamp = v1Meta.Now()
+
+	stateReconciler, recorder := getConflictReconciler(t, client, olderIngress, newerIngress)
+	state, statusUpdates := stateReconciler.processState()
+
+	require.Len(t, state[host].BackendPaths, 1)
+	requireConflict(t, stateReconciler, recorder, statusUpdates, newerIngress.Name, ErrPathConflict)
+}
+
+func TestTlsConflict(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	secret, cert, _ := getDummySecret(t)
+	newerSecret, _, _ := getDummySecret(t)
+	newerSecret.Name = "newer-" + secretName
+	_, err := client.CoreV1().Secrets(namespace).Create(ctx, secret, v1Meta.CreateOptions{})
+	require.NoError(t, err)
+	_, err = client.CoreV1().Secrets(namespace).Create(ctx, newerSecret, v1Meta.CreateOptions{})
+	require.NoError(t, err)
+	olderIngress := getDummyIngressSecretRef()
+	olderIngress.Name = "b"
+	olderIngress.Namespace = namespace
+	olderIngress.CreationTimestamp = v1Meta.NewTime(time.Now().Add(-time.Hour))
+	newerIngress := olderIngress.DeepCopy()
+	newerIngress.Name = "a"
+	newerIngress.CreationTimestamp = v1Meta.Now()
+	newerIngress.Spec.TLS[0].SecretName = newerSecret.Name
+
+	stateReconciler, recorder := getConflictReconciler(t, client, olderIngress, newerIngress)
+	state, statusUpdates := stateReconciler.processState()
+
+	require.Len(t, state[host].TlsCerts, 1)
+	require.Equal(t, cert, state[host].TlsCerts[0].Cert)
+	requireConflict(t, stateReconciler, recorder, statusUpdates, newerIngress.Name, ErrTlsConflict)
+}
+
+func TestTlsMultipleSecrets(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	secret, cert, _ := getDummySecret(t)
+	otherSecret, otherCert, _ := getDummySecret(t)
+	otherSecret.Name = "other-" + secretName
+	_, err := client.CoreV1().Secrets(namespace).Create(ctx, secret, v1Meta.CreateOptions{})
+	require.NoError(t, err)
+	_, err = client.CoreV1().Secrets(namespace).Create(ctx, otherSecret, v1Meta.CreateOptions{})
+	require.NoError(t, err)
+	olderIngress := getDummyIngressSecretRef()
+	olderIngress.Name = "b"
+	olderIngress.Namespace = namespace
+	olderIngress.CreationTimestamp = v1Meta.NewTime(time.Now().Add(-time.Hour))
+	olderIngress.Spec.TLS = append(olderIngress.Spec.TLS, v1Net.IngressTLS{Hosts: []string{host}, SecretName: otherSecret.Name})
+	// using one of the secrets of the older ingress is no conflict
+	newerIngress := getDummyIngressSecretRef()
+	newerIngress.Name = "a"
+	newerIngress.Namespace = namespace
+	newerIngress.CreationTimestamp = v1Meta.Now()
+
+	stateReconciler, _ := getConflictReconciler(t, client, olderIngress, newerIngress)
+	state, statusUpdates := stateReconciler.processState()
+
+	require.Len(t, state[host].TlsCerts, 2)
+	require.Equal(t, cert, state[host].TlsCerts[0].Cert)
+	require.Equal(t, otherCert, state[host].TlsCerts[1].Cert)
+	for _, statusUpdate := range statusUpdates {
+		require.Empty(t, statusUpdate.Conflicts)
+	}
+}
@@ -0,0 +1,258 @@
+package state
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	v1Core "k8s.io/api/core/v1"
+	v1Discovery "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+// gatewayReconcileRequest is the single request for the Gateway API controller, as all resources are processed together
+var gatewayReconcileRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "gateway-api"}}
+
+// gatewayReconciler reconciles the Gateway API resources and merges their routes into the state of the ingress reconciler
+type gatewayReconciler struct {
+	ingressReconciler *IngressReconciler
+	client            client.Client
+	controllerName    string
+}
+
+// SetupGatewayApiWithManager registers a controller for the Gateway API resources GatewayClass, Gateway and HTTPRoute with the manager.
+// Only GatewayClasses with the given controller name are processed, the routes are merged into the processed ingress state.
+// The Gateway API types have to be installed in the scheme of the manager, see gatewayv1.Install.
+func (r *IngressReconciler) SetupGatewayApiWithManager(mgr ctrl.Manager, controllerName string) error {
+	r.ingressStateLock.Lock()
+	r.gatewayResources = &gatewayResources{controllerName: controllerName}
+	r.ingressStateLock.Unlock()
+	reconciler := &gatewayReconciler{
+		ingressReconciler: r,
+		client:            mgr.GetClient(),
+		controllerName:    controllerName,
+	}
+	enqueue := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+		return []reconcile.Request{gatewayReconcileRequest}
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("gateway-api").
+		// status updates change no generation, we do not want to reprocess for our own status updates
+		Watches(&gatewayv1.GatewayClass{}, enqueue, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&gatewayv1.Gateway{}, enqueue, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&gatewayv1.HTTPRoute{}, enqueue, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// secrets, services and endpoint slices only trigger a reconcile if the known gateways or routes reference them
+		Watches(&v1Core.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.findGatewayForSecret),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
+		Watches(&v1Core.Service{},
+			handler.EnqueueRequestsFromMapFunc(r.findGatewayForService),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
+		Watches(&v1Discovery.EndpointSlice{},
+			handler.EnqueueRequestsFromMapFunc(r.findGatewayForEndpointSlice),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
+		Complete(reconciler)
+}
+
+// findGatewayForSecret returns the gateway reconcile request if a listener of a known gateway references the secret
+func (r *IngressReconciler) findGatewayForSecret(_ context.Context, secret client.Object) []reconcile.Request {
+	r.ingressStateLock.RLock()
+	defer r.ingressStateLock.RUnlock()
+	if r.gatewayResources == nil {
+		return []reconcile.Request{}
+	}
+	for _, gateway := range r.gatewayResources.gateways {
+		if referencesCertificate(gateway, secret) {
+			log.Debug().Msgf("gateway reconcile queued due to secret update for gateway %s in namespace %s", gateway.Name, gateway.Namespace)
+			return []reconcile.Request{gatewayReconcileRequest}
+		}
+	}
+	return []reconcile.Request{}
+}
+
+// referencesCertificate returns whether a listener of the gateway references the given secret as certificate
+func referencesCertificate(gateway *gatewayv1.Gateway, secret client.Object) bool {
+	for _, listener := range gateway.Spec.Listeners {
+		if listener.TLS == nil {
+			continue
+		}
+		for _, certRef := range listener.TLS.CertificateRefs {
+			namespace := gateway.Namespace
+			if certRef.Namespace != nil {
+				namespace = string(*certRef.Namespace)
+			}
+			if namespace == secret.GetNamespace() && string(certRef.Name) == secret.GetName() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *IngressReconciler) findGatewayForService(_ context.Context, service client.Object) []reconcile.Request {
+	return r.findGatewayForServiceName(service.GetNamespace(), service.GetName())
+}
+
+func (r *IngressReconciler) findGatewayForEndpointSlice(_ context.Context, endpointSlice client.Object) []reconcile.Request {
+	serviceName, ok := endpointSlice.GetLabels()[v1Discovery.LabelServiceName]
+	if !ok {
+		return []reconcile.Request{}
+	}
+	return r.findGatewayForServiceName(endpointSlice.GetNamespace(), serviceName)
+}
+
+// findGatewayForServiceName returns the gateway reconcile request if a known route references the service with the given name
+func (r *IngressReconciler) findGatewayForServiceName(namespace string, serviceName string) []reconcile.Request {
+	r.ingressStateLock.RLock()
+	defer r.ingressStateLock.RUnlock()
+	if r.gatewayResources == nil {
+		return []reconcile.Request{}
+	}
+	for _, route := range r.gatewayResources.httpRoutes {
+		if referencesBackendService(route, namespace, serviceName) {
+			log.Debug().Msgf("gateway reconcile queued due to service update for httproute %s in namespace %s", route.Name, route.Namespace)
+			return []reconcile.Request{gatewayReconcileRequest}
+		}
+	}
+	return []reconcile.Request{}
+}
+
+// referencesBackendService returns whether a backend reference of the route references the service with the given namespace and name
+func referencesBackendService(route *gatewayv1.HTTPRoute, namespace string, serviceName string) bool {
+	for _, rule := range route.Spec.Rules {
+		for _, backendRef := range rule.BackendRefs {
+			if (backendRef.Group != nil && *backendRef.Group != "") || (backendRef.Kind != nil && *backendRef.Kind != kindService) {
+				continue
+			}
+			refNamespace := route.Namespace
+			if backendRef.Namespace != nil {
+				refNamespace = string(*backendRef.Namespace)
+			}
+			if refNamespace == namespace && string(backendRef.Name) == serviceName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reconcile loads the current Gateway API resources, processes them together with the ingresses and updates their status.
+func (g *gatewayReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	log.Debug().Msg("reconciling gateway api resources")
+	resources, err := g.loadResources(ctx)
+	if err != nil {
+		return ctrl.Result{Requeue: true}, err
+	}
+	r := g.ingressReconciler
+	r.ingressStateLock.Lock()
+	defer r.ingressStateLock.Unlock()
+	r.gatewayResources = resources
+	processedState, updates, gatewayStatus := r.processIngresses(r.ingressState, r.gatewayResources)
+	r.ingressProcessedStateChan <- processedState
+	r.recordConflicts(updates)
+	g.recordConflicts(gatewayStatus)
+	for _, err := range g.updateStatus(ctx, resources, gatewayStatus) {
+		log.Error().Err(err).Msg("failed to update gateway api status")
+	}
+	return ctrl.Result{}, nil
+}
+
+// loadResources lists the Gateway API resources from the cache of the manager
+func (g *gatewayReconciler) loadResources(ctx context.Context) (*gatewayResources, error) {
+	var gatewayClasses gatewayv1.GatewayClassList
+	if err := g.client.List(ctx, &gatewayClasses); err != nil {
+		return nil, fmt.Errorf("failed to list gateway classes: %w", err)
+	}
+	var gateways gatewayv1.GatewayList
+	if err := g.client.List(ctx, &gateways); err != nil {
+		return nil, fmt.Errorf("failed to list gateways: %w", err)
+	}
+	var httpRoutes gatewayv1.HTTPRouteList
+	if err := g.client.List(ctx, &httpRoutes); err != nil {
+		return nil, fmt.Errorf("failed to list http routes: %w", err)
+	}
+	resources := &gatewayResources{controllerName: g.controllerName}
+	for i := range gatewayClasses.Items {
+		resources.gatewayClasses = append(resources.gatewayClasses, &gatewayClasses.Items[i])
+	}
+	for i := range gateways.Items {
+		resources.gateways = append(resources.gateways, &gateways.Items[i])
+	}
+	for i := range httpRoutes.Items {
+		resources.httpRoutes = append(resources.httpRoutes, &httpRoutes.Items[i])
+	}
+	return resources, nil
+}
+
+// recordConflicts emits a warning event for each conflict of the routes
+func (g *gatewayReconciler) recordConflicts(gatewayStatus *gatewayStatusUpdate) {
+	if g.ingressReconciler.eventRecorder == nil {
+		return
+	}
+	for route, conflicts := range gatewayStatus.conflicts {
+		for _, conflict := range conflicts {
+			g.ingressReconciler.eventRecorder.Event(route, v1Core.EventTypeWarning, eventReasonConflict, conflict.Error())
+		}
+	}
+}
+
+// updateStatus writes the desired status of the Gateway API resources if it differs from the current one
+func (g *gatewayReconciler) updateStatus(ctx context.Context, resources *gatewayResources, gatewayStatus *gatewayStatusUpdate) []error {
+	errs := make([]error, 0)
+	current := make(map[types.UID]client.Object)
+	for _, el := range resources.gatewayClasses {
+		current[el.UID] = el
+	}
+	for _, el := range resources.gateways {
+		current[el.UID] = el
+	}
+	for _, el := range resources.httpRoutes {
+		current[el.UID] = el
+	}
+	desired := make([]client.Object, 0, len(gatewayStatus.gatewayClasses)+len(gatewayStatus.gateways)+len(gatewayStatus.httpRoutes))
+	for _, el := range gatewayStatus.gatewayClasses {
+		desired = append(desired, el)
+	}
+	for _, el := range gatewayStatus.gateways {
+		desired = append(desired, el)
+	}
+	for _, el := range gatewayStatus.httpRoutes {
+		desired = append(desired, el)
+	}
+	for _, obj := range desired {
+		if statusEqualTo(current[obj.GetUID()], obj) {
+			continue
+		}
+		log.Debug().Msgf("Updating status of %T %s in namespace %s", obj, obj.GetName(), obj.GetNamespace())
+		if err := g.client.Status().Update(ctx, obj); err != nil {
+			errs = append(errs, fmt.Errorf("failed to update status of %s in namespace %s: %w", obj.GetName(), obj.GetNamespace(), err))
+		}
+	}
+	return errs
+}
+
+// statusEqualTo returns whether the status of both Gateway API resources is semantically equal
+func statusEqualTo(current client.Object, desired client.Object) bool {
+	switch desiredObj := desired.(type) {
+	case *gatewayv1.GatewayClass:
+		currentObj, ok := current.(*gatewayv1.GatewayClass)
+		return ok && equality.Semantic.DeepEqual(currentObj.Status, desiredObj.Status)
+	case *gatewayv1.Gateway:
+		currentObj, ok := current.(*gatewayv1.Gateway)
+		return ok && equality.Semantic.DeepEqual(currentObj.Status, desiredObj.Status)
+	case *gatewayv1.HTTPRoute:
+		currentObj, ok := current.(*gatewayv1.HTTPRoute)
+		return ok && equality.Semantic.DeepEqual(currentObj.Status, desiredObj.Status)
+	}
+	return false
+}
+
+var _ reconcile.Reconciler = &gatewayReconciler{}
@@ -0,0 +1,632 @@
+package state
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1Core "k8s.io/api/core/v1"
+	v1Net "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+var (
+	ErrUnsupportedFilter       = errors.New("unsupported filter")
+	ErrUnsupportedMatch        = errors.New("unsupported match")
+	ErrBackendRefNotPermitted  = errors.New("backend references to other namespaces are not supported")
+	ErrBackendRefPortMissing   = errors.New("backend reference to a service requires a port")
+	ErrCertificateRefInvalid   = errors.New("invalid certificate reference")
+	ErrUnsupportedListener     = errors.New("unsupported listener protocol or tls mode")
+	ErrRouteNamespaceSelection = errors.New("namespace selectors for allowed routes are not supported")
+)
+
+const (
+	kindGateway   = "Gateway"
+	kindHTTPRoute = "HTTPRoute"
+	kindService   = "Service"
+	kindSecret    = "Secret"
+)
+
+// gatewayResources is a snapshot of the Gateway API resources that are relevant for the controller name
+type gatewayResources struct {
+	controllerName string
+	gatewayClasses []*gatewayv1.GatewayClass
+	gateways       []*gatewayv1.Gateway
+	httpRoutes     []*gatewayv1.HTTPRoute
+}
+
+// gatewayStatusUpdate holds copies of the Gateway API resources with the desired status
+type gatewayStatusUpdate struct {
+	gatewayClasses []*gatewayv1.GatewayClass
+	gateways       []*gatewayv1.Gateway
+	httpRoutes     []*gatewayv1.HTTPRoute
+	// conflicts are the errors for routes that are not active as older resources use them
+	conflicts map[*gatewayv1.HTTPRoute][]error
+}
+
+// gatewayListeners is the processing state of an accepted gateway
+type gatewayListeners struct {
+	gateway *gatewayv1.Gateway
+	// valid holds the listeners that routes can attach to
+	valid map[gatewayv1.SectionName]*gatewayv1.Listener
+	// attachedRoutes counts the attached routes per listener
+	attachedRoutes map[gatewayv1.SectionName]int32
+}
+
+// processGateways adds the routes of the HTTPRoutes that are attached to gateways of our gateway classes to the ingress state.
+// The tls certificates of the HTTPS listeners are also added. Returns copies of the resources with the desired status.
+func (r *IngressReconciler) processGateways(resources *gatewayResources, result IngressState, claims *routeClaims) *gatewayStatusUpdate {
+	update := &gatewayStatusUpdate{conflicts: make(map[*gatewayv1.HTTPRoute][]error)}
+	if resources == nil {
+		return update
+	}
+	gatewayClasses := make(map[gatewayv1.ObjectName]bool)
+	for _, gatewayClass := range resources.gatewayClasses {
+		if string(gatewayClass.Spec.ControllerName) != resources.controllerName {
+			continue
+		}
+		gatewayClass = gatewayClass.DeepCopy()
+		setCondition(&gatewayClass.Status.Conditions, gatewayClass.Generation, string(gatewayv1.GatewayClassConditionStatusAccepted),
+			nil, string(gatewayv1.GatewayClassReasonAccepted))
+		update.gatewayClasses = append(update.gatewayClasses, gatewayClass)
+		gatewayClasses[gatewayv1.ObjectName(gatewayClass.Name)] = true
+	}
+
+	gateways := make(map[types.NamespacedName]*gatewayListeners)
+	for _, gateway := range sortedByAge(resources.gateways) {
+		if !gatewayClasses[gateway.Spec.GatewayClassName] {
+			continue
+		}
+		gateway = gateway.DeepCopy()
+		gateways[types.NamespacedName{Namespace: gateway.Namespace, Name: gateway.Name}] = r.processGateway(gateway, result, claims)
+		update.gateways = append(update.gateways, gateway)
+	}
+
+	for _, route := range sortedByAge(resources.httpRoutes) {
+		route = route.DeepCopy()
+		parentStatus, hosts := processRouteParents(route, gateways, resources.controllerName)
+		if len(parentStatus) == 0 {
+			continue
+		}
+		if len(hosts) > 0 {
+			errs, conflicts := r.collectRouteBackendPaths(route, hosts, result, claims)
+			for _, status := range parentStatus {
+				setRouteRefConditions(status, route.Generation, errs)
+			}
+			if len(conflicts) > 0 {
+				update.conflicts[route] = conflicts
+			}
+		}
+		route.Status.Parents = slices.DeleteFunc(route.Status.Parents, func(status gatewayv1.RouteParentStatus) bool {
+			return string(status.ControllerName) == resources.controllerName
+		})
+		for _, status := range parentStatus {
+			route.Status.Parents = append(route.Status.Parents, *status)
+		}
+		update.httpRoutes = append(update.httpRoutes, route)
+	}
+
+	for _, listeners := range gateways {
+		for i := range listeners.gateway.Status.Listeners {
+			listenerStatus := &listeners.gateway.Status.Listeners[i]
+			listenerStatus.AttachedRoutes = listeners.attachedRoutes[listenerStatus.Name]
+		}
+	}
+	return update
+}
+
+// processGateway sets the status of the gateway and its listeners and adds the tls certificates of the HTTPS listeners to the ingress state.
+// Returns the listeners that routes can attach to.
+func (r *IngressReconciler) processGateway(gateway *gatewayv1.Gateway, result IngressState, claims *routeClaims) *gatewayListeners {
+	listeners := &gatewayListeners{
+		gateway:        gateway,
+		valid:          make(map[gatewayv1.SectionName]*gatewayv1.Listener),
+		attachedRoutes: make(map[gatewayv1.SectionName]int32),
+	}
+	owner := claimOwner{kind: "gateway", NamespacedName: types.NamespacedName{Namespace: gateway.Namespace, Name: gateway.Name}}
+	listenerStatus := make([]gatewayv1.ListenerStatus, 0, len(gateway.Spec.Listeners))
+	for i := range gateway.Spec.Listeners {
+		listener := &gateway.Spec.Listeners[i]
+		status := gatewayv1.ListenerStatus{
+			Name:           listener.Name,
+			SupportedKinds: []gatewayv1.RouteGroupKind{{Group: ptr(gatewayv1.Group(gatewayv1.GroupName)), Kind: kindHTTPRoute}},
+			Conditions:     findListenerConditions(gateway.Status.Listeners, listener.Name),
+		}
+		var err error
+		switch {
+		case listener.Protocol != gatewayv1.HTTPProtocolType && listener.Protocol != gatewayv1.HTTPSProtocolType:
+			err = fmt.Errorf("%w: %s", ErrUnsupportedListener, listener.Protocol)
+			setCondition(&status.Conditions, gateway.Generation, string(gatewayv1.ListenerConditionAccepted), err, string(gatewayv1.ListenerReasonUnsupportedProtocol))
+		case listener.AllowedRoutes != nil && listener.AllowedRoutes.Namespaces != nil && listener.AllowedRoutes.Namespaces.From != nil &&
+			*listener.AllowedRoutes.Namespaces.From == gatewayv1.NamespacesFromSelector:
+			err = ErrRouteNamespaceSelection
+			setCondition(&status.Conditions, gateway.Generation, string(gatewayv1.ListenerConditionAccepted), err, string(gatewayv1.ListenerReasonInvalid))
+		default:
+			setCondition(&status.Conditions, gateway.Generation, string(gatewayv1.ListenerConditionAccepted), nil, string(gatewayv1.ListenerReasonAccepted))
+		}
+		if err == nil && listener.Protocol == gatewayv1.HTTPSProtocolType {
+			var reason gatewayv1.ListenerConditionReason
+			reason, err = r.collectListenerTlsCert(owner, listener, result, claims)
+			setCondition(&status.Conditions, gateway.Generation, string(gatewayv1.ListenerConditionResolvedRefs), err, string(reason))
+			if errors.Is(err, ErrTlsConflict) {
+				setCondition(&status.Conditions, gateway.Generation, string(gatewayv1.ListenerConditionConflicted), nil, string(gatewayv1.ListenerReasonHostnameConflict))
+			}
+		} else if err == nil {
+			setCondition(&status.Conditions, gateway.Generation, string(gatewayv1.ListenerConditionResolvedRefs), nil, string(gatewayv1.ListenerReasonResolvedRefs))
+		}
+		if !errors.Is(err, ErrTlsConflict) {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               string(gatewayv1.ListenerConditionConflicted),
+				Status:             metav1.ConditionFalse,
+				Reason:             string(gatewayv1.ListenerReasonNoConflicts),
+				ObservedGeneration: gateway.Generation,
+			})
+		}
+		if err != nil {
+			log.Warn().Err(err).Msgf("invalid listener %s of gateway %s in namespace %s", listener.Name, gateway.Name, gateway.Namespace)
+			setCondition(&status.Conditions, gateway.Generation, string(gatewayv1.ListenerConditionProgrammed), err, string(gatewayv1.ListenerReasonInvalid))
+		} else {
+			setCondition(&status.Conditions, gateway.Generation, string(gatewayv1.ListenerConditionProgrammed), nil, string(gatewayv1.ListenerReasonProgrammed))
+			listeners.valid[listener.Name] = listener
+		}
+		listenerStatus = append(listenerStatus, status)
+	}
+	gateway.Status.Listeners = listenerStatus
+
+	if len(listeners.valid) == 0 {
+		err := fmt.Errorf("%w: no valid listeners", ErrUnsupportedListener)
+		setCondition(&gateway.Status.Conditions, gateway.Generation, string(gatewayv1.GatewayConditionAccepted), err, string(gatewayv1.GatewayReasonListenersNotValid))
+		setCondition(&gateway.Status.Conditions, gateway.Generation, string(gatewayv1.GatewayConditionProgrammed), err, string(gatewayv1.GatewayReasonInvalid))
+	} else {
+		setCondition(&gateway.Status.Conditions, gateway.Generation, string(gatewayv1.GatewayConditionAccepted), nil, string(gatewayv1.GatewayReasonAccepted))
+		setCondition(&gateway.Status.Conditions, gateway.Generation, string(gatewayv1.GatewayConditionProgrammed), nil, string(gatewayv1.GatewayReasonProgrammed))
+	}
+	if r.hostIp != nil {
+		gateway.Status.Addresses = []gatewayv1.GatewayStatusAddress{{Type: ptr(gatewayv1.IPAddressType), Value: r.hostIp.String()}}
+	}
+	return listeners
+}
+
+// collectListenerTlsCert adds the tls certificate of the HTTPS listener to the ingress state.
+// Only kubernetes.io/tls secrets in the namespace of the gateway are supported.
+func (r *IngressReconciler) collectListenerTlsCert(owner claimOwner, listener *gatewayv1.Listener, result IngressState,
+	claims *routeClaims) (gatewayv1.ListenerConditionReason, error) {
+	if listener.TLS == nil || len(listener.TLS.CertificateRefs) == 0 ||
+		(listener.TLS.Mode != nil && *listener.TLS.Mode != gatewayv1.TLSModeTerminate) {
+		return gatewayv1.ListenerReasonInvalidCertificateRef, fmt.Errorf("%w: HTTPS listeners require certificate references and the tls mode Terminate", ErrCertificateRefInvalid)
+	}
+	if listener.Hostname == nil {
+		return gatewayv1.ListenerReasonInvalidCertificateRef, fmt.Errorf("%w: HTTPS listeners require a hostname", ErrCertificateRefInvalid)
+	}
+	certRef := listener.TLS.CertificateRefs[0]
+	if (certRef.Group != nil && *certRef.Group != "") || (certRef.Kind != nil && *certRef.Kind != kindSecret) {
+		return gatewayv1.ListenerReasonInvalidCertificateRef, fmt.Errorf("%w: only secrets are supported", ErrCertificateRefInvalid)
+	}
+	if certRef.Namespace != nil && string(*certRef.Namespace) != owner.Namespace {
+		return gatewayv1.ListenerReasonRefNotPermitted, fmt.Errorf("%w: secrets in other namespaces are not supported", ErrCertificateRefInvalid)
+	}
+	secretName := string(certRef.Name)
+	host := string(*listener.Hostname)
+	if err := claims.claimTls(owner, host, secretName); err != nil {
+		return gatewayv1.ListenerReasonInvalidCertificateRef, err
+	}
+	// the secret lister only contains kubernetes.io/tls secrets
+	secret, err := r.k8sClients.SecretLister.Secrets(owner.Namespace).Get(secretName)
+	if err != nil || secret.Type != v1Core.SecretTypeTLS {
+		return gatewayv1.ListenerReasonInvalidCertificateRef, fmt.Errorf("%w: %s", ErrTlsSecretNotFound, secretName)
+	}
+	tlsCert := &TlsCert{
+		Secret: types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name},
+		Cert:   secret.Data["tls.crt"],
+		Key:    secret.Data["tls.key"],
+	}
+	// the host coverage is not checked as listener hostnames may be wildcards
+	certErrs := validateCertificate(tlsCert, nil, time.Now())
+	if r.skipCertificate(certErrs) {
+		return gatewayv1.ListenerReasonInvalidCertificateRef, errors.Join(certErrs...)
+	}
+	if len(certErrs) > 0 {
+		log.Warn().Err(errors.Join(certErrs...)).Msgf("invalid TLS certificate secret %s in namespace %s", secret.Name, secret.Namespace)
+	}
+	result.getOrAddEmpty(host).addTlsCert(tlsCert)
+	return gatewayv1.ListenerReasonResolvedRefs, nil
+}
+
+// processRouteParents returns the status for each parent reference of the route to one of our gateways
+// as well as the hosts of the accepted listeners that the route is attached to.
+func processRouteParents(route *gatewayv1.HTTPRoute, gateways map[types.NamespacedName]*gatewayListeners,
+	controllerName string) (parentStatus []*gatewayv1.RouteParentStatus, hosts []string) {
+	for _, parentRef := range route.Spec.ParentRefs {
+		if (parentRef.Group != nil && *parentRef.Group != gatewayv1.GroupName) || (parentRef.Kind != nil && *parentRef.Kind != kindGateway) {
+			continue
+		}
+		namespace := route.Namespace
+		if parentRef.Namespace != nil {
+			namespace = string(*parentRef.Namespace)
+		}
+		listeners, ok := gateways[types.NamespacedName{Namespace: namespace, Name: string(parentRef.Name)}]
+		if !ok {
+			continue
+		}
+		status := &gatewayv1.RouteParentStatus{
+			ParentRef:      parentRef,
+			ControllerName: gatewayv1.GatewayController(controllerName),
+			Conditions:     findRouteParentConditions(route.Status.Parents, parentRef, controllerName),
+		}
+		parentStatus = append(parentStatus, status)
+
+		allowed := false
+		parentHosts := make([]string, 0)
+		for name, listener := range listeners.valid {
+			if parentRef.SectionName != nil && *parentRef.SectionName != name {
+				continue
+			}
+			if !routeAllowed(listener, listeners.gateway.Namespace, route.Namespace) {
+				continue
+			}
+			allowed = true
+			listenerHosts := intersectHostnames(listener.Hostname, route.Spec.Hostnames)
+			if len(listenerHosts) > 0 {
+				listeners.attachedRoutes[name]++
+			}
+			parentHosts = append(parentHosts, listenerHosts...)
+		}
+		switch {
+		case !allowed:
+			setCondition(&status.Conditions, route.Generation, string(gatewayv1.RouteConditionAccepted),
+				errors.New("no listener allows the route"), string(gatewayv1.RouteReasonNotAllowedByListeners))
+		case len(parentHosts) == 0:
+			setCondition(&status.Conditions, route.Generation, string(gatewayv1.RouteConditionAccepted),
+				errors.New("no listener hostname matches the route hostnames"), string(gatewayv1.RouteReasonNoMatchingListenerHostname))
+		default:
+			setCondition(&status.Conditions, route.Generation, string(gatewayv1.RouteConditionAccepted), nil, string(gatewayv1.RouteReasonAccepted))
+			hosts = append(hosts, parentHosts...)
+		}
+	}
+	slices.Sort(hosts)
+	return parentStatus, slices.Compact(hosts)
+}
+
+// routeAllowed returns whether the listener allows HTTPRoutes from the route namespace
+func routeAllowed(listener *gatewayv1.Listener, gatewayNamespace string, routeNamespace string) bool {
+	if listener.AllowedRoutes != nil && len(listener.AllowedRoutes.Kinds) > 0 {
+		if !slices.ContainsFunc(listener.AllowedRoutes.Kinds, func(kind gatewayv1.RouteGroupKind) bool {
+			return kind.Kind == kindHTTPRoute && (kind.Group == nil || *kind.Group == gatewayv1.GroupName)
+		}) {
+			return false
+		}
+	}
+	if listener.AllowedRoutes != nil && listener.AllowedRoutes.Namespaces != nil && listener.AllowedRoutes.Namespaces.From != nil &&
+		*listener.AllowedRoutes.Namespaces.From == gatewayv1.NamespacesFromAll {
+		return true
+	}
+	return gatewayNamespace == routeNamespace
+}
+
+// intersectHostnames returns the hostnames that match both the listener hostname and the route hostnames.
+// A wildcard hostname matches all hostnames with the same suffix. Missing hostnames match everything, this results in the CatchAllHost.
+func intersectHostnames(listenerHostname *gatewayv1.Hostname, routeHostnames []gatewayv1.Hostname) []string {
+	if listenerHostname == nil || *listenerHostname == "" {
+		if len(routeHostnames) == 0 {
+			return []string{CatchAllHost}
+		}
+		hosts := make([]string, 0, len(routeHostnames))
+		for _, routeHostname := range routeHostnames {
+			hosts = append(hosts, string(routeHostname))
+		}
+		return hosts
+	}
+	listenerHost := string(*listenerHostname)
+	if len(routeHostnames) == 0 {
+		return []string{listenerHost}
+	}
+	hosts := make([]string, 0)
+	for _, routeHostname := range routeHostnames {
+		routeHost := string(routeHostname)
+		switch {
+		case routeHost == listenerHost:
+			hosts = append(hosts, routeHost)
+		case strings.HasPrefix(listenerHost, "*.") && strings.HasSuffix(routeHost, listenerHost[1:]):
+			hosts = append(hosts, routeHost)
+		case strings.HasPrefix(routeHost, "*.") && strings.HasSuffix(listenerHost, routeHost[1:]):
+			hosts = append(hosts, listenerHost)
+		}
+	}
+	return hosts
+}
+
+// collectRouteBackendPaths adds the rules of the HTTPRoute for all given hosts to the ingress state.
+// Returns the errors of the route as well as the conflicts with older resources.
+func (r *IngressReconciler) collectRouteBackendPaths(route *gatewayv1.HTTPRoute, hosts []string, result IngressState,
+	claims *routeClaims) (errs []error, conflicts []error) {
+	owner := claimOwner{kind: "httproute", NamespacedName: types.NamespacedName{Namespace: route.Namespace, Name: route.Name}}
+	for _, rule := range route.Spec.Rules {
+		template, ruleErrs := r.getRouteRuleBackendPath(route, &rule)
+		errs = append(errs, ruleErrs...)
+		if template == nil {
+			continue
+		}
+		matches := rule.Matches
+		if len(matches) == 0 {
+			matches = []gatewayv1.HTTPRouteMatch{{}}
+		}
+		for _, match := range matches {
+			backendPath, err := applyRouteMatch(template, &match)
+			if err != nil {
+				log.Warn().Err(err).Msgf("invalid match in httproute %s in namespace %s", route.Name, route.Namespace)
+				errs = append(errs, err)
+				continue
+			}
+			for _, host := range hosts {
+				if err = claims.claimPath(owner, host, backendPath.PathType, backendPath.Path, backendPath.Conditions); err != nil {
+					log.Warn().Err(err).Msgf("conflicting match in httproute %s in namespace %s", route.Name, route.Namespace)
+					conflicts = append(conflicts, err)
+					continue
+				}
+				// copied per host as canaries are attached per host
+				hostBackendPath := *backendPath
+				domainConfig := result.getOrAddEmpty(host)
+				domainConfig.BackendPaths = append(domainConfig.BackendPaths, &hostBackendPath)
+			}
+		}
+	}
+	return errs, conflicts
+}
+
+// getRouteRuleBackendPath converts the filters and backend references of the route rule into a BackendPath without path and conditions.
+// Returns nil if the rule cannot be routed at all.
+func (r *IngressReconciler) getRouteRuleBackendPath(route *gatewayv1.HTTPRoute, rule *gatewayv1.HTTPRouteRule) (*BackendPath, []error) {
+	errs := make([]error, 0)
+	backendPath := &BackendPath{Namespace: route.Namespace}
+	for _, filter := range rule.Filters {
+		switch {
+		case filter.Type == gatewayv1.HTTPRouteFilterRequestHeaderModifier && filter.RequestHeaderModifier != nil:
+			backendPath.RequestHeaders = toHeaderModifier(filter.RequestHeaderModifier)
+		case filter.Type == gatewayv1.HTTPRouteFilterResponseHeaderModifier && filter.ResponseHeaderModifier != nil:
+			backendPath.ResponseHeaders = toHeaderModifier(filter.ResponseHeaderModifier)
+		case filter.Type == gatewayv1.HTTPRouteFilterRequestRedirect && filter.RequestRedirect != nil:
+			backendPath.Redirect = toRedirect(filter.RequestRedirect)
+		default:
+			// the rule would behave differently than specified, therefore it is not routed
+			return nil, append(errs, fmt.Errorf("%w: %s", ErrUnsupportedFilter, filter.Type))
+		}
+	}
+	if backendPath.Redirect != nil {
+		return backendPath, errs
+	}
+	backendPath.WeightedBackends = make([]*WeightedBackend, 0, len(rule.BackendRefs))
+	for _, backendRef := range rule.BackendRefs {
+		weightedBackend := &WeightedBackend{Weight: 1}
+		if backendRef.Weight != nil {
+			weightedBackend.Weight = *backendRef.Weight
+		}
+		// unresolved backends keep their share of the traffic, those requests fail
+		backend, err := r.getRouteBackend(route, &backendRef)
+		if err != nil {
+			log.Warn().Err(err).Msgf("invalid backend reference in httproute %s in namespace %s", route.Name, route.Namespace)
+			errs = append(errs, err)
+		}
+		weightedBackend.Backend = backend
+		backendPath.WeightedBackends = append(backendPath.WeightedBackends, weightedBackend)
+	}
+	return backendPath, errs
+}
+
+// getRouteBackend resolves the service of the backend reference
+func (r *IngressReconciler) getRouteBackend(route *gatewayv1.HTTPRoute, backendRef *gatewayv1.HTTPBackendRef) (*BackendPath, error) {
+	if (backendRef.Group != nil && *backendRef.Group != "") || (backendRef.Kind != nil && *backendRef.Kind != kindService) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedBackend, backendRef.Name)
+	}
+	if backendRef.Namespace != nil && string(*backendRef.Namespace) != route.Namespace {
+		return nil, fmt.Errorf("%w: %s/%s", ErrBackendRefNotPermitted, *backendRef.Namespace, backendRef.Name)
+	}
+	if backendRef.Port == nil {
+		return nil, fmt.Errorf("%w: %s", ErrBackendRefPortMissing, backendRef.Name)
+	}
+	if len(backendRef.Filters) > 0 {
+		return nil, fmt.Errorf("%w: filters for backend references", ErrUnsupportedFilter)
+	}
+	return r.getServiceBackendPath(route.Namespace, nil, "", &v1Net.IngressServiceBackend{
+		Name: string(backendRef.Name),
+		Port: v1Net.ServiceBackendPort{Number: int32(*backendRef.Port)},
+	})
+}
+
+// applyRouteMatch returns a copy of the backend path with the path and conditions of the route match
+func applyRouteMatch(template *BackendPath, match *gatewayv1.HTTPRouteMatch) (*BackendPath, error) {
+	backendPath := *template
+	pathType := v1Net.PathTypePrefix
+	backendPath.PathType = &pathType
+	backendPath.Path = "/"
+	if match.Path != nil {
+		if match.Path.Value != nil {
+			backendPath.Path = *match.Path.Value
+		}
+		if match.Path.Type != nil {
+			switch *match.Path.Type {
+			case gatewayv1.PathMatchExact:
+				pathType = v1Net.PathTypeExact
+			case gatewayv1.PathMatchPathPrefix:
+				pathType = v1Net.PathTypePrefix
+			case gatewayv1.PathMatchRegularExpression:
+				pathType = v1Net.PathTypeImplementationSpecific
+				backendPath.PathMatching = PathMatchingRegex
+				if _, err := backendPath.CompilePathPattern(); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, fmt.Errorf("%w: path type %s", ErrUnsupportedMatch, *match.Path.Type)
+			}
+		}
+	}
+	if backendPath.Redirect != nil && backendPath.Redirect.PrefixPath != nil && pathType != v1Net.PathTypePrefix {
+		return nil, fmt.Errorf("%w: prefix path replacement requires a path prefix match", ErrUnsupportedFilter)
+	}
+
+	conditions := &RequestConditions{}
+	if match.Method != nil {
+		conditions.Method = string(*match.Method)
+	}
+	for _, header := range match.Headers {
+		conditions.Headers = append(conditions.Headers, &ValueMatch{
+			Name:  http.CanonicalHeaderKey(string(header.Name)),
+			Value: header.Value,
+			Regex: header.Type != nil && *header.Type == gatewayv1.HeaderMatchRegularExpression,
+		})
+	}
+	for _, queryParam := range match.QueryParams {
+		conditions.QueryParams = append(conditions.QueryParams, &ValueMatch{
+			Name:  string(queryParam.Name),
+			Value: queryParam.Value,
+			Regex: queryParam.Type != nil && *queryParam.Type == gatewayv1.QueryParamMatchRegularExpression,
+		})
+	}
+	for _, valueMatch := range slices.Concat(conditions.Headers, conditions.QueryParams) {
+		if _, err := valueMatch.Compile(); err != nil {
+			return nil, err
+		}
+	}
+	if conditions.Method != "" || len(conditions.Headers) > 0 || len(conditions.QueryParams) > 0 {
+		backendPath.Conditions = conditions
+	}
+	return &backendPath, nil
+}
+
+// toHeaderModifier converts the Gateway API header filter
+func toHeaderModifier(filter *gatewayv1.HTTPHeaderFilter) *HeaderModifier {
+	modifier := &HeaderModifier{
+		Set:    make(map[string]string),
+		Add:    make(map[string]string),
+		Remove: filter.Remove,
+	}
+	for _, header := range filter.Set {
+		modifier.Set[string(header.Name)] = header.Value
+	}
+	for _, header := range filter.Add {
+		modifier.Add[string(header.Name)] = header.Value
+	}
+	return modifier
+}
+
+// toRedirect converts the Gateway API redirect filter
+func toRedirect(filter *gatewayv1.HTTPRequestRedirectFilter) *Redirect {
+	redirect := &Redirect{StatusCode: http.StatusFound}
+	if filter.Scheme != nil {
+		redirect.Scheme = *filter.Scheme
+	}
+	if filter.Hostname != nil {
+		redirect.Hostname = string(*filter.Hostname)
+	}
+	if filter.Port != nil {
+		redirect.Port = int32(*filter.Port)
+	}
+	if filter.StatusCode != nil {
+		redirect.StatusCode = *filter.StatusCode
+	}
+	if filter.Path != nil {
+		switch filter.Path.Type {
+		case gatewayv1.FullPathHTTPPathModifier:
+			redirect.FullPath = filter.Path.ReplaceFullPath
+		case gatewayv1.PrefixMatchHTTPPathModifier:
+			redirect.PrefixPath = filter.Path.ReplacePrefixMatch
+		}
+	}
+	return redirect
+}
+
+// setRouteRefConditions sets the ResolvedRefs condition and for unsupported values the Accepted condition of the route parent status
+func setRouteRefConditions(status *gatewayv1.RouteParentStatus, generation int64, errs []error) {
+	reason := gatewayv1.RouteReasonResolvedRefs
+	var refErrs, valueErrs []error
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, ErrUnsupportedFilter), errors.Is(err, ErrUnsupportedMatch), errors.Is(err, ErrInvalidPathPattern), errors.Is(err, ErrInvalidValueMatch):
+			valueErrs = append(valueErrs, err)
+			continue
+		case errors.Is(err, ErrBackendRefNotPermitted):
+			reason = gatewayv1.RouteReasonRefNotPermitted
+		case errors.Is(err, ErrUnsupportedBackend):
+			reason = gatewayv1.RouteReasonInvalidKind
+		default:
+			reason = gatewayv1.RouteReasonBackendNotFound
+		}
+		refErrs = append(refErrs, err)
+	}
+	setCondition(&status.Conditions, generation, string(gatewayv1.RouteConditionResolvedRefs), errors.Join(refErrs...), string(reason))
+	if len(valueErrs) > 0 && meta.IsStatusConditionTrue(status.Conditions, string(gatewayv1.RouteConditionAccepted)) {
+		setCondition(&status.Conditions, generation, string(gatewayv1.RouteConditionAccepted), errors.Join(valueErrs...), string(gatewayv1.RouteReasonUnsupportedValue))
+	}
+}
+
+// setCondition sets the condition of the given type. Its status is true if err is nil, the reason is used for both cases.
+func setCondition(conditions *[]metav1.Condition, generation int64, conditionType string, err error, reason string) {
+	condition := metav1.Condition{
+		Type:               conditionType,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason,
+		ObservedGeneration: generation,
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Message = err.Error()
+	}
+	meta.SetStatusCondition(conditions, condition)
+}
+
+// findListenerConditions returns a copy of the current conditions of the listener to keep their transition times
+func findListenerConditions(listenerStatus []gatewayv1.ListenerStatus, name gatewayv1.SectionName) []metav1.Condition {
+	for _, status := range listenerStatus {
+		if status.Name == name {
+			return slices.Clone(status.Conditions)
+		}
+	}
+	return make([]metav1.Condition, 0)
+}
+
+// findRouteParentConditions returns a copy of the current conditions of the route parent to keep their transition times
+func findRouteParentConditions(parentStatus []gatewayv1.RouteParentStatus, parentRef gatewayv1.ParentReference, controllerName string) []metav1.Condition {
+	for _, status := range parentStatus {
+		if string(status.ControllerName) == controllerName && parentRefEqual(status.ParentRef, parentRef) {
+			return slices.Clone(status.Conditions)
+		}
+	}
+	return make([]metav1.Condition, 0)
+}
+
+// parentRefEqual returns whether both parent references are equal
+func parentRefEqual(a gatewayv1.ParentReference, b gatewayv1.ParentReference) bool {
+	return a.Name == b.Name && ptrEqual(a.Namespace, b.Namespace) && ptrEqual(a.SectionName, b.SectionName) &&
+		ptrEqual(a.Group, b.Group) && ptrEqual(a.Kind, b.Kind) && ptrEqual(a.Port, b.Port)
+}
+
+// sortedByAge returns a copy of the objects sorted by creation timestamp, namespace and name
+func sortedByAge[T metav1.Object](objects []T) []T {
+	sorted := slices.Clone(objects)
+	slices.SortFunc(sorted, func(a T, b T) int {
+		aTime, bTime := a.GetCreationTimestamp(), b.GetCreationTimestamp()
+		if !aTime.Equal(&bTime) {
+			if aTime.Before(&bTime) {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(cmp.Compare(a.GetNamespace(), b.GetNamespace()), cmp.Compare(a.GetName(), b.GetName()))
+	})
+	return sorted
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func ptrEqual[T comparable](a *T, b *T) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
@@ -0,0 +1,336 @@
+package state
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1Core "k8s.io/api/core/v1"
+	v1Discovery "k8s.io/api/discovery/v1"
+	v1Net "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+const gatewayControllerName = "ngergs.github.io/ingress"
+const gatewayClassName = "gateway-class"
+const gatewayName = "gateway"
+
+// getDummyGatewayResources returns a gateway class, a gateway with a HTTP listener for the dummy host and a route with a single rule
+func getDummyGatewayResources() *gatewayResources {
+	listenerHost := gatewayv1.Hostname(host)
+	port := gatewayv1.PortNumber(servicePort)
+	pathType := gatewayv1.PathMatchPathPrefix
+	return &gatewayResources{
+		controllerName: gatewayControllerName,
+		gatewayClasses: []*gatewayv1.GatewayClass{{
+			ObjectMeta: v1Meta.ObjectMeta{Name: gatewayClassName},
+			Spec:       gatewayv1.GatewayClassSpec{ControllerName: gatewayControllerName},
+		}},
+		gateways: []*gatewayv1.Gateway{{
+			ObjectMeta: v1Meta.ObjectMeta{Namespace: namespace, Name: gatewayName},
+			Spec: gatewayv1.GatewaySpec{
+				GatewayClassName: gatewayClassName,
+				Listeners: []gatewayv1.Listener{{
+					Name:     "http",
+					Hostname: &listenerHost,
+					Port:     80,
+					Protocol: gatewayv1.HTTPProtocolType,
+				}},
+			},
+		}},
+		httpRoutes: []*gatewayv1.HTTPRoute{{
+			ObjectMeta: v1Meta.ObjectMeta{Namespace: namespace, Name: "route"},
+			Spec: gatewayv1.HTTPRouteSpec{
+				CommonRouteSpec: gatewayv1.CommonRouteSpec{ParentRefs: []gatewayv1.ParentReference{{Name: gatewayName}}},
+				Rules: []gatewayv1.HTTPRouteRule{{
+					Matches: []gatewayv1.HTTPRouteMatch{{Path: &gatewayv1.HTTPPathMatch{Type: &pathType, Value: ptr(path)}}},
+					BackendRefs: []gatewayv1.HTTPBackendRef{{BackendRef: gatewayv1.BackendRef{BackendObjectReference: gatewayv1.BackendObjectReference{
+						Name: serviceName,
+						Port: &port,
+					}}}},
+				}},
+			},
+		}},
+	}
+}
+
+// getGatewayReconciler returns a reconciler without ingresses for the given gateway resources
+func getGatewayReconciler(t *testing.T, resources *gatewayResources, objects ...runtime.Object) *IngressReconciler {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(objects...)
+	_, err := client.CoreV1().Services(namespace).Create(ctx, getDummyService(), v1Meta.CreateOptions{})
+	require.NoError(t, err)
+	stateReconciler := &IngressReconciler{
+		ingressClassName:          ingressClassName,
+		ingressState:              make(map[types.NamespacedName]*v1Net.Ingress),
+		gatewayResources:          resources,
+		ingressProcessedStateChan: make(chan IngressState),
+		hostIp:                    net.IPv4(127, 0, 0, 1),
+		k8sClients:                newKubernetesClients(client)}
+	err = stateReconciler.k8sClients.startInformers(ctx)
+	require.NoError(t, err)
+	stateReconciler.k8sClients.waitForSync(ctx)
+	return stateReconciler
+}
+
+// processGatewayResources processes the gateway resources without ingresses
+func processGatewayResources(t *testing.T, resources *gatewayResources) (IngressState, *gatewayStatusUpdate) {
+	r := getGatewayReconciler(t, resources)
+	state, _, gatewayStatus := r.processIngresses(r.ingressState, r.gatewayResources)
+	return state, gatewayStatus
+}
+
+func TestGatewayRoute(t *testing.T) {
+	state, gatewayStatus := processGatewayResources(t, getDummyGatewayResources())
+
+	require.Len(t, state, 1)
+	require.Len(t, state[host].BackendPaths, 1)
+	backendPath := state[host].BackendPaths[0]
+	require.Equal(t, v1Net.PathTypePrefix, *backendPath.PathType)
+	require.Equal(t, path, backendPath.Path)
+	require.Nil(t, backendPath.Conditions)
+	require.Len(t, backendPath.WeightedBackends, 1)
+	require.Equal(t, int32(1), backendPath.WeightedBackends[0].Weight)
+	require.Equal(t, serviceName, backendPath.WeightedBackends[0].Backend.ServiceName)
+	require.Equal(t, servicePort, backendPath.WeightedBackends[0].Backend.ServicePort)
+
+	require.Len(t, gatewayStatus.gatewayClasses, 1)
+	require.True(t, meta.IsStatusConditionTrue(gatewayStatus.gatewayClasses[0].Status.Conditions, string(gatewayv1.GatewayClassConditionStatusAccepted)))
+	require.Len(t, gatewayStatus.gateways, 1)
+	gateway := gatewayStatus.gateways[0]
+	require.True(t, meta.IsStatusConditionTrue(gateway.Status.Conditions, string(gatewayv1.GatewayConditionProgrammed)))
+	require.Equal(t, "127.0.0.1", gateway.Status.Addresses[0].Value)
+	require.Equal(t, int32(1), gateway.Status.Listeners[0].AttachedRoutes)
+	require.Len(t, gatewayStatus.httpRoutes, 1)
+	parents := gatewayStatus.httpRoutes[0].Status.Parents
+	require.Len(t, parents, 1)
+	require.True(t, meta.IsStatusConditionTrue(parents[0].Conditions, string(gatewayv1.RouteConditionAccepted)))
+	require.True(t, meta.IsStatusConditionTrue(parents[0].Conditions, string(gatewayv1.RouteConditionResolvedRefs)))
+}
+
+func TestGatewayRouteOtherController(t *testing.T) {
+	resources := getDummyGatewayResources()
+	resources.gatewayClasses[0].Spec.ControllerName = "other"
+	state, gatewayStatus := processGatewayResources(t, resources)
+
+	require.Empty(t, state)
+	require.Empty(t, gatewayStatus.gatewayClasses)
+	require.Empty(t, gatewayStatus.gateways)
+	require.Empty(t, gatewayStatus.httpRoutes)
+}
+
+func TestGatewayRouteHostnames(t *testing.T) {
+	listenerHost := gatewayv1.Hostname("*.example.com")
+	for _, tc := range []struct {
+		name          string
+		routeHosts    []gatewayv1.Hostname
+		expectedHosts []string
+	}{
+		{name: "no route hostnames", routeHosts: nil, expectedHosts: []string{"*.example.com"}},
+		{name: "matching hostname", routeHosts: []gatewayv1.Hostname{"foo.example.com", "foo.other.com"}, expectedHosts: []string{"foo.example.com"}},
+		{name: "no matching hostname", routeHosts: []gatewayv1.Hostname{"foo.other.com"}, expectedHosts: nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resources := getDummyGatewayResources()
+			resources.gateways[0].Spec.Listeners[0].Hostname = &listenerHost
+			resources.httpRoutes[0].Spec.Hostnames = tc.routeHosts
+			state, gatewayStatus := processGatewayResources(t, resources)
+
+			hosts := make([]string, 0)
+			for host := range state {
+				hosts = append(hosts, host)
+			}
+			require.ElementsMatch(t, tc.expectedHosts, hosts)
+			accepted := meta.FindStatusCondition(gatewayStatus.httpRoutes[0].Status.Parents[0].Conditions, string(gatewayv1.RouteConditionAccepted))
+			require.NotNil(t, accepted)
+			if tc.expectedHosts == nil {
+				require.Equal(t, v1Meta.ConditionFalse, accepted.Status)
+				require.Equal(t, string(gatewayv1.RouteReasonNoMatchingListenerHostname), accepted.Reason)
+			} else {
+				require.Equal(t, v1Meta.ConditionTrue, accepted.Status)
+			}
+		})
+	}
+}
+
+func TestGatewayRouteNotAllowed(t *testing.T) {
+	resources := getDummyGatewayResources()
+	resources.httpRoutes[0].Namespace = "other"
+	resources.httpRoutes[0].Spec.ParentRefs[0].Namespace = ptr(gatewayv1.Namespace(namespace))
+	state, gatewayStatus := processGatewayResources(t, resources)
+
+	require.Empty(t, state)
+	accepted := meta.FindStatusCondition(gatewayStatus.httpRoutes[0].Status.Parents[0].Conditions, string(gatewayv1.RouteConditionAccepted))
+	require.Equal(t, v1Meta.ConditionFalse, accepted.Status)
+	require.Equal(t, string(gatewayv1.RouteReasonNotAllowedByListeners), accepted.Reason)
+}
+
+func TestGatewayRouteWeightedBackends(t *testing.T) {
+	resources := getDummyGatewayResources()
+	backendRefs := &resources.httpRoutes[0].Spec.Rules[0].BackendRefs
+	(*backendRefs)[0].Weight = ptr(int32(3))
+	missing := (*backendRefs)[0].DeepCopy()
+	missing.Name = "missing"
+	missing.Weight = ptr(int32(1))
+	*backendRefs = append(*backendRefs, *missing)
+	state, gatewayStatus := processGatewayResources(t, resources)
+
+	weightedBackends := state[host].BackendPaths[0].WeightedBackends
+	require.Len(t, weightedBackends, 2)
+	require.Equal(t, int32(3), weightedBackends[0].Weight)
+	require.NotNil(t, weightedBackends[0].Backend)
+	require.Equal(t, int32(1), weightedBackends[1].Weight)
+	require.Nil(t, weightedBackends[1].Backend)
+	resolvedRefs := meta.FindStatusCondition(gatewayStatus.httpRoutes[0].Status.Parents[0].Conditions, string(gatewayv1.RouteConditionResolvedRefs))
+	require.Equal(t, v1Meta.ConditionFalse, resolvedRefs.Status)
+	require.Equal(t, string(gatewayv1.RouteReasonBackendNotFound), resolvedRefs.Reason)
+}
+
+func TestGatewayRouteMatchConditions(t *testing.T) {
+	resources := getDummyGatewayResources()
+	rule := &resources.httpRoutes[0].Spec.Rules[0]
+	unconditional := rule.Matches[0]
+	conditional := *unconditional.DeepCopy()
+	conditional.Method = ptr(gatewayv1.HTTPMethodGet)
+	conditional.Headers = []gatewayv1.HTTPHeaderMatch{{Name: "x-version", Value: "v[0-9]+", Type: ptr(gatewayv1.HeaderMatchRegularExpression)}}
+	conditional.QueryParams = []gatewayv1.HTTPQueryParamMatch{{Name: "debug", Value: "true"}}
+	rule.Matches = []gatewayv1.HTTPRouteMatch{unconditional, conditional}
+	state, _ := processGatewayResources(t, resources)
+
+	backendPaths := state[host].BackendPaths
+	require.Len(t, backendPaths, 2)
+	require.Nil(t, backendPaths[0].Conditions)
+	conditions := backendPaths[1].Conditions
+	require.NotNil(t, conditions)
+	require.Equal(t, "GET", conditions.Method)
+	require.Equal(t, []*ValueMatch{{Name: "X-Version", Value: "v[0-9]+", Regex: true}}, conditions.Headers)
+	require.Equal(t, []*ValueMatch{{Name: "debug", Value: "true"}}, conditions.QueryParams)
+}
+
+func TestGatewayRouteRedirect(t *testing.T) {
+	resources := getDummyGatewayResources()
+	rule := &resources.httpRoutes[0].Spec.Rules[0]
+	rule.BackendRefs = nil
+	rule.Filters = []gatewayv1.HTTPRouteFilter{{
+		Type: gatewayv1.HTTPRouteFilterRequestRedirect,
+		RequestRedirect: &gatewayv1.HTTPRequestRedirectFilter{
+			Scheme:     ptr("https"),
+			StatusCode: ptr(301),
+			Path: &gatewayv1.HTTPPathModifier{
+				Type:               gatewayv1.PrefixMatchHTTPPathModifier,
+				ReplacePrefixMatch: ptr("/new"),
+			},
+		},
+	}}
+	state, _ := processGatewayResources(t, resources)
+
+	backendPath := state[host].BackendPaths[0]
+	require.Nil(t, backendPath.WeightedBackends)
+	require.Equal(t, &Redirect{Scheme: "https", StatusCode: 301, PrefixPath: ptr("/new")}, backendPath.Redirect)
+}
+
+func TestGatewayRouteUnsupportedFilter(t *testing.T) {
+	resources := getDummyGatewayResources()
+	resources.httpRoutes[0].Spec.Rules[0].Filters = []gatewayv1.HTTPRouteFilter{{Type: gatewayv1.HTTPRouteFilterRequestMirror}}
+	state, gatewayStatus := processGatewayResources(t, resources)
+
+	require.Empty(t, state)
+	accepted := meta.FindStatusCondition(gatewayStatus.httpRoutes[0].Status.Parents[0].Conditions, string(gatewayv1.RouteConditionAccepted))
+	require.Equal(t, v1Meta.ConditionFalse, accepted.Status)
+	require.Equal(t, string(gatewayv1.RouteReasonUnsupportedValue), accepted.Reason)
+}
+
+func TestGatewayRouteUnsupportedBackendFilter(t *testing.T) {
+	resources := getDummyGatewayResources()
+	resources.httpRoutes[0].Spec.Rules[0].BackendRefs[0].Filters = []gatewayv1.HTTPRouteFilter{{Type: gatewayv1.HTTPRouteFilterRequestMirror}}
+	state, gatewayStatus := processGatewayResources(t, resources)
+
+	// the backend keeps its share of the traffic, those requests fail
+	require.Len(t, state[host].BackendPaths, 1)
+	require.Nil(t, state[host].BackendPaths[0].WeightedBackends[0].Backend)
+	accepted := meta.FindStatusCondition(gatewayStatus.httpRoutes[0].Status.Parents[0].Conditions, string(gatewayv1.RouteConditionAccepted))
+	require.Equal(t, v1Meta.ConditionFalse, accepted.Status)
+	require.Equal(t, string(gatewayv1.RouteReasonUnsupportedValue), accepted.Reason)
+}
+
+func TestFindGatewayForReferencedObjects(t *testing.T) {
+	ctx := context.Background()
+	resources := getDummyGatewayResources()
+	resources.gateways[0].Spec.Listeners[0].TLS = &gatewayv1.GatewayTLSConfig{
+		CertificateRefs: []gatewayv1.SecretObjectReference{{Name: secretName}},
+	}
+	r := &IngressReconciler{gatewayResources: resources}
+	expected := []reconcile.Request{gatewayReconcileRequest}
+
+	secret := &v1Core.Secret{ObjectMeta: v1Meta.ObjectMeta{Namespace: namespace, Name: secretName}}
+	require.Equal(t, expected, r.findGatewayForSecret(ctx, secret))
+	secret.Name = "other"
+	require.Empty(t, r.findGatewayForSecret(ctx, secret))
+
+	service := &v1Core.Service{ObjectMeta: v1Meta.ObjectMeta{Namespace: namespace, Name: serviceName}}
+	require.Equal(t, expected, r.findGatewayForService(ctx, service))
+	service.Namespace = "other"
+	require.Empty(t, r.findGatewayForService(ctx, service))
+
+	endpointSlice := &v1Discovery.EndpointSlice{ObjectMeta: v1Meta.ObjectMeta{Namespace: namespace, Name: serviceName + "-abc",
+		Labels: map[string]string{v1Discovery.LabelServiceName: serviceName}}}
+	require.Equal(t, expected, r.findGatewayForEndpointSlice(ctx, endpointSlice))
+	endpointSlice.Labels = nil
+	require.Empty(t, r.findGatewayForEndpointSlice(ctx, endpointSlice))
+}
+
+func TestGatewayRouteIngressConflict(t *testing.T) {
+	r := getGatewayReconciler(t, getDummyGatewayResources())
+	ingress := getDummyIngress()
+	ingress.Namespace = namespace
+	ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.Service.Port.Number = servicePort
+	r.ingressState[types.NamespacedName{Namespace: namespace, Name: ingress.Name}] = ingress
+	state, _, gatewayStatus := r.processIngresses(r.ingressState, r.gatewayResources)
+
+	require.Len(t, state[host].BackendPaths, 1)
+	require.Equal(t, serviceName, state[host].BackendPaths[0].ServiceName)
+	require.Nil(t, state[host].BackendPaths[0].WeightedBackends)
+	conflicts := gatewayStatus.conflicts[gatewayStatus.httpRoutes[0]]
+	require.Len(t, conflicts, 1)
+	require.ErrorIs(t, conflicts[0], ErrPathConflict)
+}
+
+func TestGatewayListenerCertificate(t *testing.T) {
+	secret, cert, _ := getDummySecret(t)
+	secret.Namespace = namespace
+	corruptSecret := secret.DeepCopy()
+	corruptSecret.Name = "corrupt-" + secretName
+	_, _, corruptSecret.Data["tls.key"] = getDummySecret(t)
+	for _, testCase := range []struct {
+		secretName     string
+		valid          bool
+		expectedReason gatewayv1.ListenerConditionReason
+	}{
+		{secretName, true, gatewayv1.ListenerReasonResolvedRefs},
+		// the private key does not match the certificate, the listener is invalid
+		{corruptSecret.Name, false, gatewayv1.ListenerReasonInvalidCertificateRef},
+	} {
+		resources := getDummyGatewayResources()
+		listener := &resources.gateways[0].Spec.Listeners[0]
+		listener.Protocol = gatewayv1.HTTPSProtocolType
+		listener.TLS = &gatewayv1.GatewayTLSConfig{CertificateRefs: []gatewayv1.SecretObjectReference{{Name: gatewayv1.ObjectName(testCase.secretName)}}}
+		r := getGatewayReconciler(t, resources, secret, corruptSecret)
+		state, _, gatewayStatus := r.processIngresses(r.ingressState, r.gatewayResources)
+
+		if testCase.valid {
+			require.Len(t, state[host].TlsCerts, 1)
+			require.Equal(t, cert, state[host].TlsCerts[0].Cert)
+		} else {
+			require.NotContains(t, state, host)
+		}
+		resolvedRefs := meta.FindStatusCondition(gatewayStatus.gateways[0].Status.Listeners[0].Conditions, string(gatewayv1.ListenerConditionResolvedRefs))
+		require.Equal(t, string(testCase.expectedReason), resolvedRefs.Reason)
+	}
+}
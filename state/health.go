@@ -0,0 +1,64 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	v1Net "k8s.io/api/networking/v1"
+)
+
+//nolint:gomnd
+const (
+	defaultHealthCheckInterval           = time.Duration(10) * time.Second
+	defaultHealthCheckHealthyThreshold   = 2
+	defaultHealthCheckUnhealthyThreshold = 3
+	defaultOutlierBaseEjectionTime       = time.Duration(30) * time.Second
+)
+
+// HealthCheck configures the active HTTP health probes of the endpoints of a backend, see AnnotationHealthCheckPath.
+// Endpoints are healthy if the probes are answered with a status below 400.
+type HealthCheck struct {
+	Path               string
+	Interval           time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// OutlierDetection configures the passive outlier detection of the endpoints of a backend, see AnnotationOutlierConsecutiveErrors
+type OutlierDetection struct {
+	// ConsecutiveErrors is the number of consecutive 5xx responses or connection errors after which an endpoint is ejected
+	ConsecutiveErrors int
+	// BaseEjectionTime is the duration of the first ejection, repeated ejections last a multiple of it
+	BaseEjectionTime time.Duration
+}
+
+// healthFromAnnotations returns the health check and outlier detection configured via annotations for the ingress, nil if not configured.
+// On errors the defaults are used for the invalid values.
+func healthFromAnnotations(ingress *v1Net.Ingress) (*HealthCheck, *OutlierDetection, error) {
+	var healthCheck *HealthCheck
+	var outlierDetection *OutlierDetection
+	var errs []error
+	if path, ok := ingress.Annotations[AnnotationHealthCheckPath]; ok && !strings.HasPrefix(path, "/") {
+		errs = append(errs, fmt.Errorf("%w: %s for annotation %s has to start with /", ErrInvalidAnnotation, path, AnnotationHealthCheckPath))
+	} else if ok {
+		interval, err := durationFromAnnotation(ingress, AnnotationHealthCheckInterval, defaultHealthCheckInterval)
+		errs = append(errs, err)
+		healthyThreshold, err := positiveIntFromAnnotation(ingress, AnnotationHealthCheckHealthyThreshold, defaultHealthCheckHealthyThreshold)
+		errs = append(errs, err)
+		unhealthyThreshold, err := positiveIntFromAnnotation(ingress, AnnotationHealthCheckUnhealthyThreshold, defaultHealthCheckUnhealthyThreshold)
+		errs = append(errs, err)
+		healthCheck = &HealthCheck{Path: path, Interval: interval, HealthyThreshold: healthyThreshold, UnhealthyThreshold: unhealthyThreshold}
+	}
+	if _, ok := ingress.Annotations[AnnotationOutlierConsecutiveErrors]; ok {
+		consecutiveErrors, err := positiveIntFromAnnotation(ingress, AnnotationOutlierConsecutiveErrors, 0)
+		errs = append(errs, err)
+		baseEjectionTime, err := durationFromAnnotation(ingress, AnnotationOutlierBaseEjectionTime, defaultOutlierBaseEjectionTime)
+		errs = append(errs, err)
+		if consecutiveErrors > 0 {
+			outlierDetection = &OutlierDetection{ConsecutiveErrors: consecutiveErrors, BaseEjectionTime: baseEjectionTime}
+		}
+	}
+	return healthCheck, outlierDetection, errors.Join(errs...)
+}
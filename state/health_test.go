@@ -0,0 +1,41 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHealthFromAnnotations(t *testing.T) {
+	healthCheck, outlierDetection, err := healthFromAnnotations(&v1Net.Ingress{})
+	require.NoError(t, err)
+	require.Nil(t, healthCheck)
+	require.Nil(t, outlierDetection)
+
+	ingress := &v1Net.Ingress{ObjectMeta: v1Meta.ObjectMeta{Annotations: map[string]string{
+		AnnotationHealthCheckPath:          "/healthz",
+		AnnotationHealthCheckInterval:      "5s",
+		AnnotationOutlierConsecutiveErrors: "5",
+	}}}
+	healthCheck, outlierDetection, err = healthFromAnnotations(ingress)
+	require.NoError(t, err)
+	require.Equal(t, &HealthCheck{Path: "/healthz", Interval: time.Duration(5) * time.Second,
+		HealthyThreshold: defaultHealthCheckHealthyThreshold, UnhealthyThreshold: defaultHealthCheckUnhealthyThreshold}, healthCheck)
+	require.Equal(t, &OutlierDetection{ConsecutiveErrors: 5, BaseEjectionTime: defaultOutlierBaseEjectionTime}, outlierDetection)
+}
+
+func TestHealthFromAnnotationsErrors(t *testing.T) {
+	for _, annotations := range []map[string]string{
+		{AnnotationHealthCheckPath: "healthz"},
+		{AnnotationHealthCheckPath: "/healthz", AnnotationHealthCheckInterval: "often"},
+		{AnnotationHealthCheckPath: "/healthz", AnnotationHealthCheckUnhealthyThreshold: "0"},
+		{AnnotationOutlierConsecutiveErrors: "-1"},
+		{AnnotationOutlierConsecutiveErrors: "5", AnnotationOutlierBaseEjectionTime: "-1s"},
+	} {
+		_, _, err := healthFromAnnotations(&v1Net.Ingress{ObjectMeta: v1Meta.ObjectMeta{Annotations: annotations}})
+		require.ErrorIs(t, err, ErrInvalidAnnotation, annotations)
+	}
+}
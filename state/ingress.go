@@ -5,13 +5,14 @@ import (
 	"fmt"
 	"github.com/rs/zerolog/log"
 	v1Core "k8s.io/api/core/v1"
+	v1Discovery "k8s.io/api/discovery/v1"
 	v1Net "k8s.io/api/networking/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"net"
-	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -29,6 +30,10 @@ import (
 	_ "sigs.k8s.io/controller-runtime/pkg/source"    // Required for Watching
 )
 
+// defaultCertReconcileRequest rebuilds the state after changes of the default certificate secret.
+// The empty namespace never matches an ingress, hence it works independent of the existing ingresses.
+var defaultCertReconcileRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "default-certificate"}}
+
 // IngressReconciler holds the main logic of the ingress controller regarding state updating
 type IngressReconciler struct {
 	k8sClients                *kubernetesClients
@@ -38,8 +43,19 @@ type IngressReconciler struct {
 	ingressClassName          string
 	hostIp                    net.IP
 	manager                   ctrl.Manager
+	// eventRecorder is used to report conflicts between ingresses. Optional.
+	eventRecorder record.EventRecorder
+	// gatewayResources is the current snapshot of the Gateway API resources, nil if the Gateway API support is disabled
+	gatewayResources *gatewayResources
+	// defaultCertSecret is the kubernetes.io/tls secret of the default certificate. Optional.
+	defaultCertSecret *types.NamespacedName
+	// rejectExpiredCerts skips expired certificates, so that the hosts use the default certificate
+	rejectExpiredCerts bool
 }
 
+// eventReasonConflict is the reason of the warning events for ingress routes that are not active due to conflicts with older ingresses
+const eventReasonConflict = "Conflict"
+
 // New creates a new Kubernetes Ingress reconsiler and registers it with the manager.
 // The hostIp is an optional argument. If and only if it is set the ingress status is updated.
 func New(mgr ctrl.Manager, ingressClassName string, hostIp net.IP) (*IngressReconciler, error) {
@@ -54,18 +70,35 @@ func New(mgr ctrl.Manager, ingressClassName string, hostIp net.IP) (*IngressReco
 		hostIp:                    hostIp,
 		k8sClients:                newKubernetesClients(k8sClients),
 		manager:                   mgr,
+		eventRecorder:             mgr.GetEventRecorderFor("ingress-controller"),
 	}
 	return r, ctrl.NewControllerManagedBy(mgr).
-		For(&v1Net.Ingress{}).
+		// status updates change neither generation nor annotations, we do not want to reprocess for our own status updates
+		For(&v1Net.Ingress{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
 		Watches(&v1Core.Secret{},
 			handler.EnqueueRequestsFromMapFunc(r.findIngressForSecret),
 			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
 		Watches(&v1Core.Service{},
 			handler.EnqueueRequestsFromMapFunc(r.findIngressForService),
 			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
+		Watches(&v1Discovery.EndpointSlice{},
+			handler.EnqueueRequestsFromMapFunc(r.findIngressForEndpointSlice),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
 		Complete(r)
 }
 
+// SetDefaultCertificateSecret sets the kubernetes.io/tls secret whose certificate is used as TlsCerts of the CatchAllHost.
+// The reverse proxy uses it for hosts without certificate and for clients without SNI. Has to be called before Start.
+func (r *IngressReconciler) SetDefaultCertificateSecret(secret types.NamespacedName) {
+	r.defaultCertSecret = &secret
+}
+
+// SetRejectExpiredCertificates sets whether expired ingress certificates are not served. Their hosts use the default certificate instead.
+// Expired certificates are reported in the ingress status independent of this setting. Has to be called before Start.
+func (r *IngressReconciler) SetRejectExpiredCertificates(reject bool) {
+	r.rejectExpiredCerts = reject
+}
+
 // GetStateChan returns a read-only channel that carries the current state
 func (r *IngressReconciler) GetStateChan() <-chan IngressState {
 	return r.ingressProcessedStateChan
@@ -77,6 +110,12 @@ func (r *IngressReconciler) GetStateChan() <-chan IngressState {
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.1/pkg/reconcile
 func (r *IngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log.Debug().Msgf("reconciling ingress: %v", req)
+	if req == defaultCertReconcileRequest {
+		r.ingressStateLock.Lock()
+		defer r.ingressStateLock.Unlock()
+		r.publishState(ctx)
+		return ctrl.Result{}, nil
+	}
 	ingress, err := r.k8sClients.client.NetworkingV1().Ingresses(req.Namespace).Get(ctx, req.Name, metav1.GetOptions{})
 	if err != nil && !apierrors.IsNotFound(err) {
 		return ctrl.Result{Requeue: true}, fmt.Errorf("error fetching ingress state: %w", err)
@@ -87,28 +126,49 @@ func (r *IngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		log.Debug().Msgf("reconcile deleting ingress reference: %v", req)
 		delete(r.ingressState, req.NamespacedName)
 	} else {
-		if ingress != nil && ((ingress.Spec.IngressClassName != nil && *ingress.Spec.IngressClassName != r.ingressClassName) ||
-			(ingress.Spec.IngressClassName == nil && ingress.Annotations["kubernetes.io/ingress.class"] != r.ingressClassName)) {
+		if ingress != nil && !r.isIngressClass(ingress) {
 			log.Debug().Msgf("reconciling ignoring ingress due to class-name: %v", req)
 			return ctrl.Result{}, nil
 		}
+		// no early return if the spec is unchanged, the reconcile may have been triggered by a referenced secret, service or endpoint slice
 		log.Debug().Msgf("reconcile adding/updating ingress: %v", req)
-		currentIngress, ok := r.ingressState[req.NamespacedName]
-		if ok && reflect.DeepEqual(currentIngress.Spec, ingress.Spec) {
-			// already processed, nothing to do
-			return ctrl.Result{}, nil
-		}
 		r.ingressState[req.NamespacedName] = ingress.DeepCopy()
 	}
+	r.publishState(ctx)
+	return ctrl.Result{}, nil
+}
 
+// publishState processes the current ingresses, sends the processed state to the state channel and updates the ingress status.
+// The ingressStateLock has to be held by the caller.
+func (r *IngressReconciler) publishState(ctx context.Context) {
 	processedState, updates := r.processState()
 	r.ingressProcessedStateChan <- processedState
+	r.recordConflicts(updates)
 	errors := r.updateStatus(ctx, updates)
 	for _, err := range errors {
 		// errors are missing values in the referenced services/secrets, no need to retry as we watch those resources
 		log.Error().Err(err).Msg("failed to update ingress status")
 	}
-	return ctrl.Result{}, nil
+}
+
+// isIngressClass returns whether the ingress belongs to the ingress class of this reconciler
+func (r *IngressReconciler) isIngressClass(ingress *v1Net.Ingress) bool {
+	if ingress.Spec.IngressClassName != nil {
+		return *ingress.Spec.IngressClassName == r.ingressClassName
+	}
+	return ingress.Annotations["kubernetes.io/ingress.class"] == r.ingressClassName
+}
+
+// recordConflicts emits a warning event for each conflict of the ingresses. Repeated events are aggregated by the event recorder.
+func (r *IngressReconciler) recordConflicts(updates []*ingressStatusUpdate) {
+	if r.eventRecorder == nil {
+		return
+	}
+	for _, update := range updates {
+		for _, conflict := range update.Conflicts {
+			r.eventRecorder.Event(update.Ingress, v1Core.EventTypeWarning, eventReasonConflict, conflict.Error())
+		}
+	}
 }
 
 // Start sets up the informers and the controller with the Manager, blocks till the context is cancelled or an error occurs.
@@ -126,6 +186,11 @@ func (r *IngressReconciler) findIngressForSecret(_ context.Context, secret clien
 	r.ingressStateLock.RLock()
 	defer r.ingressStateLock.RUnlock()
 	requests := make([]reconcile.Request, 0)
+	// the default certificate is part of the state independent of the ingresses
+	if r.defaultCertSecret != nil && r.defaultCertSecret.Namespace == secret.GetNamespace() && r.defaultCertSecret.Name == secret.GetName() {
+		log.Debug().Msg("reconcile queued due to default certificate secret update")
+		requests = append(requests, defaultCertReconcileRequest)
+	}
 	for _, el := range r.ingressState {
 		if el.Namespace != secret.GetNamespace() {
 			continue
@@ -151,19 +216,37 @@ func referencesSecret(el *v1Net.Ingress, secret client.Object) bool {
 			return true
 		}
 	}
-	return false
+	if clientCaSecret, ok := el.Annotations[AnnotationAuthTlsSecret]; ok && clientCaSecret == secret.GetName() {
+		return true
+	}
+	backendCaSecret, ok := el.Annotations[AnnotationBackendTlsSecret]
+	return ok && backendCaSecret == secret.GetName()
 }
 
 func (r *IngressReconciler) findIngressForService(_ context.Context, service client.Object) []reconcile.Request {
 	log.Debug().Msgf("watch triggered from service %s in namespace %s", service.GetName(), service.GetNamespace())
+	return r.findIngressForServiceName(service.GetNamespace(), service.GetName())
+}
+
+func (r *IngressReconciler) findIngressForEndpointSlice(_ context.Context, endpointSlice client.Object) []reconcile.Request {
+	log.Debug().Msgf("watch triggered from endpoint slice %s in namespace %s", endpointSlice.GetName(), endpointSlice.GetNamespace())
+	serviceName, ok := endpointSlice.GetLabels()[v1Discovery.LabelServiceName]
+	if !ok {
+		return []reconcile.Request{}
+	}
+	return r.findIngressForServiceName(endpointSlice.GetNamespace(), serviceName)
+}
+
+// findIngressForServiceName returns reconcile requests for all ingresses that reference the service with the given name
+func (r *IngressReconciler) findIngressForServiceName(namespace string, serviceName string) []reconcile.Request {
 	r.ingressStateLock.RLock()
 	defer r.ingressStateLock.RUnlock()
 	requests := make([]reconcile.Request, 0)
 	for _, el := range r.ingressState {
-		if el.Namespace != service.GetNamespace() {
+		if el.Namespace != namespace {
 			continue
 		}
-		if referencesService(el, service) {
+		if referencesService(el, serviceName) {
 			log.Debug().Msgf("reconcile queued due to service update for ingress %s in namespace %s", el.Name, el.Namespace)
 			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
 				Name:      el.Name,
@@ -174,11 +257,14 @@ func (r *IngressReconciler) findIngressForService(_ context.Context, service cli
 	return requests
 }
 
-// referencedService returns whether the ingress references the given service
-func referencesService(el *v1Net.Ingress, service client.Object) bool {
+// referencedService returns whether the ingress references the service with the given name
+func referencesService(el *v1Net.Ingress, serviceName string) bool {
 	if el == nil {
 		return false
 	}
+	if el.Spec.DefaultBackend != nil && el.Spec.DefaultBackend.Service != nil && el.Spec.DefaultBackend.Service.Name == serviceName {
+		return true
+	}
 	for _, rule := range el.Spec.Rules {
 		if rule.HTTP == nil {
 			continue
@@ -187,7 +273,7 @@ func referencesService(el *v1Net.Ingress, service client.Object) bool {
 			if path.Backend.Service == nil {
 				continue
 			}
-			if path.Backend.Service.Name == service.GetName() {
+			if path.Backend.Service.Name == serviceName {
 				return true
 			}
 		}
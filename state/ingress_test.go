@@ -8,7 +8,10 @@ import (
 	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
+	"net"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"strconv"
 	"sync"
 	"testing"
 )
@@ -24,6 +27,8 @@ func internalTestIngress(t *testing.T, setIngressPort func(*v1Net.Ingress)) {
 	setIngressPort(ingress)
 	_, err := client.CoreV1().Services(namespace).Create(ctx, service, v1Meta.CreateOptions{})
 	require.NoError(t, err)
+	_, err = client.DiscoveryV1().EndpointSlices(namespace).Create(ctx, getDummyEndpointSlice(), v1Meta.CreateOptions{})
+	require.NoError(t, err)
 	_, err = client.NetworkingV1().Ingresses(namespace).Create(ctx, ingress, v1Meta.CreateOptions{})
 	require.NoError(t, err)
 
@@ -55,6 +60,7 @@ func internalTestIngress(t *testing.T, setIngressPort func(*v1Net.Ingress)) {
 	require.Equal(t, path, backendPath.Path)
 	require.Equal(t, serviceName, backendPath.ServiceName)
 	require.Equal(t, servicePort, backendPath.ServicePort)
+	require.Equal(t, []string{net.JoinHostPort(readyEndpointAddress, strconv.Itoa(int(endpointPort)))}, backendPath.Endpoints)
 }
 
 func TestIngressServicePortNumber(t *testing.T) {
@@ -103,6 +109,148 @@ func TestSecret(t *testing.T) {
 	state := <-stateChan
 	domainConfig, ok := state[host]
 	require.True(t, ok)
-	require.Equal(t, cert, domainConfig.TlsCert.Cert)
-	require.Equal(t, certKey, domainConfig.TlsCert.Key)
+	require.Len(t, domainConfig.TlsCerts, 1)
+	require.Equal(t, cert, domainConfig.TlsCerts[0].Cert)
+	require.Equal(t, certKey, domainConfig.TlsCerts[0].Key)
+}
+
+func TestAcmeSecret(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	ingress := getDummyIngressSecretRef()
+	ingress.Namespace = namespace
+	ingress.Annotations = map[string]string{AnnotationAcme: "true"}
+	stateReconciler := &IngressReconciler{
+		ingressClassName: ingressClassName,
+		ingressState: map[types.NamespacedName]*v1Net.Ingress{
+			{Namespace: namespace, Name: ingress.Name}: ingress,
+		},
+		ingressProcessedStateChan: make(chan IngressState),
+		hostIp:                    net.IPv4(127, 0, 0, 1),
+		k8sClients:                newKubernetesClients(client)}
+	err := stateReconciler.k8sClients.startInformers(ctx)
+	require.NoError(t, err)
+	stateReconciler.k8sClients.waitForSync(ctx)
+
+	// the secret is not yet issued, this is no error
+	state, statusUpdates := stateReconciler.processState()
+	require.Equal(t, &types.NamespacedName{Namespace: namespace, Name: secretName}, state[host].AcmeSecret)
+	require.Empty(t, state[host].TlsCerts)
+	require.Len(t, statusUpdates, 1)
+	require.Empty(t, statusUpdates[0].Errors)
+}
+
+func TestDefaultBackend(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	ingress := getDummyIngress()
+	ingress.Namespace = namespace
+	ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.Service.Port.Number = servicePort
+	ingress.Spec.DefaultBackend = &v1Net.IngressBackend{Service: &v1Net.IngressServiceBackend{
+		Name: serviceName,
+		Port: v1Net.ServiceBackendPort{Name: servicePortName}}}
+	catchAllIngress := ingress.DeepCopy()
+	catchAllIngress.Name = "catch-all"
+	catchAllIngress.Spec.Rules = nil
+	_, err := client.CoreV1().Services(namespace).Create(ctx, getDummyService(), v1Meta.CreateOptions{})
+	require.NoError(t, err)
+
+	stateReconciler := &IngressReconciler{
+		ingressClassName:          ingressClassName,
+		ingressState:              map[types.NamespacedName]*v1Net.Ingress{{Namespace: namespace, Name: catchAllIngress.Name}: catchAllIngress},
+		ingressProcessedStateChan: make(chan IngressState),
+		k8sClients:                newKubernetesClients(client)}
+	err = stateReconciler.k8sClients.startInformers(ctx)
+	require.NoError(t, err)
+	stateReconciler.k8sClients.waitForSync(ctx)
+	stateReconciler.ingressState[types.NamespacedName{Namespace: namespace, Name: ingress.Name}] = ingress
+	state, _ := stateReconciler.processState()
+
+	domainConfig, ok := state[host]
+	require.True(t, ok)
+	require.Len(t, domainConfig.BackendPaths, 1)
+	require.NotNil(t, domainConfig.DefaultBackend)
+	require.Equal(t, serviceName, domainConfig.DefaultBackend.ServiceName)
+	require.Equal(t, servicePort, domainConfig.DefaultBackend.ServicePort)
+	catchAllConfig, ok := state[CatchAllHost]
+	require.True(t, ok)
+	require.Empty(t, catchAllConfig.BackendPaths)
+	require.NotNil(t, catchAllConfig.DefaultBackend)
+}
+
+func TestInvalidPathPattern(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	implementationSpecific := v1Net.PathTypeImplementationSpecific
+	ingress := getDummyIngress()
+	ingress.Namespace = namespace
+	ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].PathType = &implementationSpecific
+	ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Path = "/api/(v1"
+	_, err := client.CoreV1().Services(namespace).Create(ctx, getDummyService(), v1Meta.CreateOptions{})
+	require.NoError(t, err)
+
+	stateReconciler := &IngressReconciler{
+		ingressClassName:          ingressClassName,
+		ingressState:              map[types.NamespacedName]*v1Net.Ingress{{Namespace: namespace, Name: ingress.Name}: ingress},
+		ingressProcessedStateChan: make(chan IngressState),
+		hostIp:                    net.IPv4(127, 0, 0, 1),
+		k8sClients:                newKubernetesClients(client)}
+	err = stateReconciler.k8sClients.startInformers(ctx)
+	require.NoError(t, err)
+	stateReconciler.k8sClients.waitForSync(ctx)
+	state, statusUpdates := stateReconciler.processState()
+
+	require.Empty(t, state[host].BackendPaths)
+	require.Len(t, statusUpdates, 1)
+	errMsg := statusUpdates[0].Status.Ports[0].Error
+	require.NotNil(t, errMsg)
+	require.Contains(t, *errMsg, ErrInvalidPathPattern.Error())
+}
+
+func TestInvalidDefaultCertificateSecret(t *testing.T) {
+	ctx := context.Background()
+	secret, _, _ := getDummySecret(t)
+	secret.Namespace = namespace
+	// the private key does not match the certificate
+	_, _, secret.Data["tls.key"] = getDummySecret(t)
+	stateReconciler := &IngressReconciler{
+		ingressClassName: ingressClassName,
+		ingressState:     make(map[types.NamespacedName]*v1Net.Ingress),
+		k8sClients:       newKubernetesClients(fake.NewSimpleClientset(secret))}
+	stateReconciler.SetDefaultCertificateSecret(types.NamespacedName{Namespace: namespace, Name: secretName})
+	err := stateReconciler.k8sClients.startInformers(ctx)
+	require.NoError(t, err)
+	stateReconciler.k8sClients.waitForSync(ctx)
+
+	state, _ := stateReconciler.processState()
+	require.NotContains(t, state, CatchAllHost)
+}
+
+func TestDefaultCertificateSecret(t *testing.T) {
+	ctx := context.Background()
+	secret, cert, certKey := getDummySecret(t)
+	secret.Namespace = namespace
+	client := fake.NewSimpleClientset(secret)
+	stateReconciler := &IngressReconciler{
+		ingressClassName:          ingressClassName,
+		ingressState:              make(map[types.NamespacedName]*v1Net.Ingress),
+		ingressProcessedStateChan: make(chan IngressState),
+		k8sClients:                newKubernetesClients(client)}
+	stateReconciler.SetDefaultCertificateSecret(types.NamespacedName{Namespace: namespace, Name: secretName})
+	err := stateReconciler.k8sClients.startInformers(ctx)
+	require.NoError(t, err)
+	stateReconciler.k8sClients.waitForSync(ctx)
+
+	state, _ := stateReconciler.processState()
+	require.Equal(t, []*TlsCert{{Secret: types.NamespacedName{Namespace: namespace, Name: secretName}, Cert: cert, Key: certKey}}, state[CatchAllHost].TlsCerts)
+
+	// secret updates trigger a reconcile independent of the ingresses
+	requests := stateReconciler.findIngressForSecret(ctx, secret)
+	require.Equal(t, []reconcile.Request{defaultCertReconcileRequest}, requests)
+	go func() {
+		_, _ = stateReconciler.Reconcile(ctx, requests[0])
+	}()
+	state = <-stateReconciler.GetStateChan()
+	require.Len(t, state[CatchAllHost].TlsCerts, 1)
+	require.Empty(t, stateReconciler.ingressState)
 }
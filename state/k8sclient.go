@@ -12,6 +12,7 @@ import (
 
 	"k8s.io/client-go/kubernetes"
 	v1ClientCore "k8s.io/client-go/listers/core/v1"
+	v1ClientDiscovery "k8s.io/client-go/listers/discovery/v1"
 	"k8s.io/client-go/util/retry"
 	"net"
 	"sync"
@@ -19,10 +20,11 @@ import (
 
 // kubernetesClients provides informers and ingress kubernetes clients for ingress updates.
 type kubernetesClients struct {
-	client        kubernetes.Interface
-	ServiceLister v1ClientCore.ServiceLister
-	SecretLister  v1ClientCore.SecretLister
-	factories     []informers.SharedInformerFactory
+	client              kubernetes.Interface
+	ServiceLister       v1ClientCore.ServiceLister
+	SecretLister        v1ClientCore.SecretLister
+	EndpointSliceLister v1ClientDiscovery.EndpointSliceLister
+	factories           []informers.SharedInformerFactory
 }
 
 // newKubernetesClients creates a new kubernetesClients struct. The ctx can be used to cancel the listening to updates from the Kubernetes API.
@@ -35,12 +37,14 @@ func newKubernetesClients(client kubernetes.Interface) *kubernetesClients {
 
 	// we have to instantiate the informers once to register them
 	factoryService.Core().V1().Services().Informer()
+	factoryService.Discovery().V1().EndpointSlices().Informer()
 	factorySecrets.Core().V1().Secrets().Informer()
 	clients := &kubernetesClients{
-		client:        client,
-		factories:     []informers.SharedInformerFactory{factoryService, factorySecrets},
-		ServiceLister: factoryService.Core().V1().Services().Lister(),
-		SecretLister:  factorySecrets.Core().V1().Secrets().Lister(),
+		client:              client,
+		factories:           []informers.SharedInformerFactory{factoryService, factorySecrets},
+		ServiceLister:       factoryService.Core().V1().Services().Lister(),
+		SecretLister:        factorySecrets.Core().V1().Secrets().Lister(),
+		EndpointSliceLister: factoryService.Discovery().V1().EndpointSlices().Lister(),
 	}
 	return clients
 }
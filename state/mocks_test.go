@@ -1,11 +1,19 @@
 package state
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"github.com/stretchr/testify/require"
+	"math"
+	"math/big"
 	"testing"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	v1Discovery "k8s.io/api/discovery/v1"
 	v1Net "k8s.io/api/networking/v1"
 	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -17,6 +25,10 @@ const serviceName = "svc"
 const servicePort int32 = 8080
 const servicePortName = "port"
 const secretName = "secret"
+const endpointPort int32 = 8081
+
+var readyEndpointAddress = "10.0.0.1"
+var notReadyEndpointAddress = "10.0.0.2"
 
 // getDummyIngress returns a dummy Kubernetes IngressInformer API-Ressource. Neither ServiceInformer port nor port name are set and have to be set for tests.
 func getDummyIngress() *v1Net.Ingress {
@@ -66,22 +78,58 @@ func getDummyService() *v1.Service {
 			}}}}
 }
 
-// getDummyService returns a dummy Kubernetes ServiceInformer API-Ressource.
+// getDummyEndpointSlice returns a dummy Kubernetes EndpointSlice API-Ressource for the dummy service with a ready and a not ready endpoint.
+func getDummyEndpointSlice() *v1Discovery.EndpointSlice {
+	portName := servicePortName
+	port := endpointPort
+	ready := true
+	notReady := false
+	return &v1Discovery.EndpointSlice{
+		ObjectMeta: v1Meta.ObjectMeta{
+			Name:   serviceName + "-abcde",
+			Labels: map[string]string{v1Discovery.LabelServiceName: serviceName},
+		},
+		AddressType: v1Discovery.AddressTypeIPv4,
+		Endpoints: []v1Discovery.Endpoint{
+			{Addresses: []string{readyEndpointAddress}, Conditions: v1Discovery.EndpointConditions{Ready: &ready}},
+			{Addresses: []string{notReadyEndpointAddress}, Conditions: v1Discovery.EndpointConditions{Ready: &notReady}},
+		},
+		Ports: []v1Discovery.EndpointPort{{
+			Name: &portName,
+			Port: &port,
+		}}}
+}
+
+// getDummySecret returns a dummy kubernetes.io/tls secret with a valid certificate for the dummy host.
 func getDummySecret(t *testing.T) (secret *v1.Secret, cert []byte, certKey []byte) {
-	var secretDataCert [20]byte
-	var secretDataCertKey [20]byte
-	_, err := rand.Read(secretDataCert[:])
-	require.NoError(t, err)
-	_, err = rand.Read(secretDataCertKey[:])
-	require.NoError(t, err)
+	cert, certKey = getDummyCertificate(t, []string{host}, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
 	return &v1.Secret{
 		ObjectMeta: v1Meta.ObjectMeta{
 			Name: secretName,
 		},
 		Type: v1.SecretTypeTLS,
 		Data: map[string][]byte{
-			"tls.crt": secretDataCert[:],
-			"tls.key": secretDataCertKey[:],
+			"tls.crt": cert,
+			"tls.key": certKey,
 		},
-	}, secretDataCert[:], secretDataCertKey[:]
+	}, cert, certKey
+}
+
+// getDummyCertificate returns a PEM encoded self-signed certificate and private key for the hosts
+func getDummyCertificate(t *testing.T, hosts []string, notBefore time.Time, notAfter time.Time) (cert []byte, certKey []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serialNumber, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		DNSNames:     hosts,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
 }
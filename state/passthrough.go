@@ -0,0 +1,48 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	v1Net "k8s.io/api/networking/v1"
+)
+
+var (
+	ErrPassthroughHostMissing    = errors.New("tls passthrough requires a host for each rule")
+	ErrPassthroughBackendMissing = errors.New("tls passthrough requires a path or default backend for each rule")
+)
+
+// collectPassthroughBackends sets the PassthroughBackend for the rule hosts of an ingress with AnnotationSslPassthrough.
+// The backend of the first path of a rule is used, the spec.defaultBackend if the rule has no paths.
+// Hosts that are already claimed for TLS by another resource are skipped and returned as conflicts, see routeClaims.
+func (r *IngressReconciler) collectPassthroughBackends(ingress *v1Net.Ingress, result IngressState, claims *routeClaims) (errs []error, conflicts []error) {
+	errs = make([]error, 0)
+	conflicts = make([]error, 0)
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host == CatchAllHost {
+			errs = append(errs, ErrPassthroughHostMissing)
+			continue
+		}
+		backend := ingress.Spec.DefaultBackend
+		if rule.HTTP != nil && len(rule.HTTP.Paths) > 0 {
+			backend = &rule.HTTP.Paths[0].Backend
+		}
+		if backend == nil {
+			errs = append(errs, fmt.Errorf("%w: host %s", ErrPassthroughBackendMissing, rule.Host))
+			continue
+		}
+		if err := claims.claimPassthrough(ingressOwner(ingress), rule.Host); err != nil {
+			log.Warn().Err(err).Msgf("conflicting tls passthrough in ingress %s in namespace %s", ingress.Name, ingress.Namespace)
+			conflicts = append(conflicts, err)
+			continue
+		}
+		backendPath, err := r.getBackendPath(ingress, nil, "", backend)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		result.getOrAddEmpty(rule.Host).PassthroughBackend = backendPath
+	}
+	return errs, conflicts
+}
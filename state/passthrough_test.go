@@ -0,0 +1,71 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// getDummyPassthroughIngress returns the dummy ingress with the AnnotationSslPassthrough and a second path that is ignored
+func getDummyPassthroughIngress() *v1Net.Ingress {
+	ingress := getDummyIngress()
+	ingress.Namespace = namespace
+	ingress.Annotations = map[string]string{AnnotationSslPassthrough: "true"}
+	ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port.Number = servicePort
+	ignoredPath := ingress.Spec.Rules[0].HTTP.Paths[0].DeepCopy()
+	ignoredPath.Path = "/ignored"
+	ingress.Spec.Rules[0].HTTP.Paths = append(ingress.Spec.Rules[0].HTTP.Paths, *ignoredPath)
+	ingress.Spec.TLS = getDummyIngressSecretRef().Spec.TLS
+	return ingress
+}
+
+func TestSslPassthrough(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	_, err := client.CoreV1().Services(namespace).Create(context.Background(), getDummyService(), v1Meta.CreateOptions{})
+	require.NoError(t, err)
+	ingress := getDummyPassthroughIngress()
+	withoutHost := ingress.Spec.Rules[0].DeepCopy()
+	withoutHost.Host = ""
+	ingress.Spec.Rules = append(ingress.Spec.Rules, *withoutHost)
+
+	stateReconciler, _ := getConflictReconciler(t, client, ingress)
+	state, statusUpdates := stateReconciler.processState()
+
+	require.Len(t, state, 1)
+	require.Empty(t, state[host].BackendPaths)
+	require.Empty(t, state[host].TlsCerts)
+	require.Equal(t, &BackendPath{Namespace: namespace, ServiceName: serviceName, ServicePort: servicePort, Endpoints: []string{}},
+		state[host].PassthroughBackend)
+	require.Len(t, statusUpdates, 1)
+	require.Len(t, statusUpdates[0].Errors, 1)
+	require.ErrorIs(t, statusUpdates[0].Errors[0], ErrPassthroughHostMissing)
+}
+
+func TestPassthroughConflict(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	secret, cert, _ := getDummySecret(t)
+	_, err := client.CoreV1().Secrets(namespace).Create(ctx, secret, v1Meta.CreateOptions{})
+	require.NoError(t, err)
+	_, err = client.CoreV1().Services(namespace).Create(ctx, getDummyService(), v1Meta.CreateOptions{})
+	require.NoError(t, err)
+	olderIngress := getDummyIngressSecretRef()
+	olderIngress.Name = "b"
+	olderIngress.Namespace = namespace
+	olderIngress.CreationTimestamp = v1Meta.NewTime(time.Now().Add(-time.Hour))
+	newerIngress := getDummyPassthroughIngress()
+	newerIngress.Name = "a"
+	newerIngress.CreationTimestamp = v1Meta.Now()
+
+	stateReconciler, recorder := getConflictReconciler(t, client, olderIngress, newerIngress)
+	state, statusUpdates := stateReconciler.processState()
+
+	require.Nil(t, state[host].PassthroughBackend)
+	require.Equal(t, cert, state[host].TlsCerts[0].Cert)
+	requireConflict(t, stateReconciler, recorder, statusUpdates, newerIngress.Name, ErrPassthroughConflict)
+}
@@ -0,0 +1,55 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	v1Net "k8s.io/api/networking/v1"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrInvalidPathPattern = errors.New("invalid path pattern")
+	ErrNoPathPattern      = errors.New("path type is not ImplementationSpecific")
+)
+
+// CompilePathPattern compiles the path of a backend path with the type ImplementationSpecific into a regular expression
+// according to its PathMatching.
+func (backendPath *BackendPath) CompilePathPattern() (*regexp.Regexp, error) {
+	if backendPath.PathType == nil || *backendPath.PathType != v1Net.PathTypeImplementationSpecific {
+		return nil, fmt.Errorf("%w: %s", ErrNoPathPattern, backendPath.Path)
+	}
+	var expr string
+	if backendPath.PathMatching == PathMatchingGlob {
+		expr = globToRegex(backendPath.Path)
+	} else {
+		expr = "^(?:" + backendPath.Path + ")"
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidPathPattern, backendPath.Path, err)
+	}
+	return pattern, nil
+}
+
+// globToRegex converts the glob pattern into an anchored regular expression, see PathMatchingGlob.
+func globToRegex(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	runes := []rune(glob)
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case runes[i] == '*' && i+1 < len(runes) && runes[i+1] == '*':
+			sb.WriteString(".*")
+			i++
+		case runes[i] == '*':
+			sb.WriteString("[^/]*")
+		case runes[i] == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
@@ -0,0 +1,45 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+)
+
+func TestCompilePathPatternRegex(t *testing.T) {
+	implementationSpecific := v1Net.PathTypeImplementationSpecific
+	backendPath := &BackendPath{PathType: &implementationSpecific, Path: "/api/v[0-9]+/"}
+	pattern, err := backendPath.CompilePathPattern()
+	require.NoError(t, err)
+	require.True(t, pattern.MatchString("/api/v1/users"))
+	require.False(t, pattern.MatchString("/api/vx/users"))
+	require.False(t, pattern.MatchString("/prefix/api/v1/"))
+}
+
+func TestCompilePathPatternGlob(t *testing.T) {
+	implementationSpecific := v1Net.PathTypeImplementationSpecific
+	backendPath := &BackendPath{PathType: &implementationSpecific, Path: "/static/*.css", PathMatching: PathMatchingGlob}
+	pattern, err := backendPath.CompilePathPattern()
+	require.NoError(t, err)
+	require.True(t, pattern.MatchString("/static/main.css"))
+	require.False(t, pattern.MatchString("/static/sub/main.css"))
+	require.False(t, pattern.MatchString("/static/main.css.map"))
+
+	backendPath.Path = "/static/**/?.js"
+	pattern, err = backendPath.CompilePathPattern()
+	require.NoError(t, err)
+	require.True(t, pattern.MatchString("/static/sub/dir/a.js"))
+	require.False(t, pattern.MatchString("/static/sub/ab.js"))
+}
+
+func TestCompilePathPatternError(t *testing.T) {
+	implementationSpecific := v1Net.PathTypeImplementationSpecific
+	prefix := v1Net.PathTypePrefix
+	backendPath := &BackendPath{PathType: &implementationSpecific, Path: "/api/(v1"}
+	_, err := backendPath.CompilePathPattern()
+	require.ErrorIs(t, err, ErrInvalidPathPattern)
+	backendPath.PathType = &prefix
+	_, err = backendPath.CompilePathPattern()
+	require.ErrorIs(t, err, ErrNoPathPattern)
+}
@@ -6,10 +6,16 @@ import (
 	"fmt"
 	"github.com/rs/zerolog/log"
 	v1Core "k8s.io/api/core/v1"
+	v1Discovery "k8s.io/api/discovery/v1"
 	v1Net "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"net"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -21,6 +27,7 @@ var (
 	ErrServicePortNotFound     = errors.New("service port not found")
 	ErrServicePortNameNotFound = errors.New("port name specified but not found in service")
 	ErrInvalidBackendService   = errors.New("backend service does contain neither port name nor port number for path")
+	ErrUnsupportedBackend      = errors.New("only service backends are supported")
 	ErrTlsSecretNotFound       = errors.New("referenced secret for tls certificate not found")
 	ErrTlsSecretWrongType      = errors.New("referenced secret for tls certificate has wrong type has to be kubernetes.io/tls")
 )
@@ -32,20 +39,72 @@ type BackendPath struct {
 	Namespace   string
 	ServiceName string
 	ServicePort int32
+	// PathMatching determines how the Path is interpreted if the PathType is ImplementationSpecific, see CompilePathPattern
+	PathMatching PathMatching
+	// RewriteTarget replaces the matched part of the request path if set, see AnnotationRewriteTarget
+	RewriteTarget string
+	// Protocol is used to connect to the Endpoints. Empty is the same as BackendProtocolHttp.
+	Protocol BackendProtocol
+	// BackendTls configures the connections to the Endpoints for the protocols with TLS. Optional.
+	BackendTls *BackendTls
+	// HealthCheck configures active health probes of the Endpoints. Optional.
+	HealthCheck *HealthCheck
+	// OutlierDetection configures the ejection of Endpoints with consecutive errors. Optional.
+	OutlierDetection *OutlierDetection
+	// Retry overrides the default retries of failed requests. Optional.
+	Retry *RetryPolicy
+	// CircuitBreaker overrides the default limits of the load on the backend. Optional.
+	CircuitBreaker *CircuitBreaker
+	// Timeouts override the default timeouts of the requests to the backend. Optional.
+	Timeouts *Timeouts
+	// RateLimit limits the request rate of each client. Optional.
+	RateLimit *RateLimit
+	// Canary receives a part of the traffic for this path if set. Optional.
+	Canary *Canary
+	// Conditions restrict the requests for this path beyond the path match. Optional.
+	Conditions *RequestConditions
+	// RequestHeaders modifies the request headers before they are forwarded. Optional.
+	RequestHeaders *HeaderModifier
+	// ResponseHeaders modifies the response headers of the backend. Optional.
+	ResponseHeaders *HeaderModifier
+	// Redirect answers the requests with a redirect instead of proxying them. Optional.
+	Redirect *Redirect
+	// WeightedBackends split the requests across multiple backends. If set the service fields of the BackendPath are not set.
+	WeightedBackends []*WeightedBackend
+	// Endpoints are the sorted addresses (host:port) of the ready pod endpoints of the service port
+	Endpoints []string
 }
 
 // TlsCert is a data struct that holds a tls certificate and private kay
 type TlsCert struct {
-	Cert []byte
-	Key  []byte
+	// Secret is the kubernetes.io/tls secret from which the certificate has been loaded
+	Secret types.NamespacedName
+	Cert   []byte
+	Key    []byte
 }
 
 // DomainConfig is the Ingress state for a specific domain
 type DomainConfig struct {
 	BackendPaths []*BackendPath
-	TlsCert      *TlsCert
+	// DefaultBackend handles requests for this domain that match none of the BackendPaths. Path and PathType are not set. Optional.
+	DefaultBackend *BackendPath
+	// TlsCerts are the certificates of this domain, e.g. an ECDSA and an RSA certificate. The reverse proxy selects one that the client supports.
+	TlsCerts []*TlsCert
+	// TlsProfile overrides the default TLS profile for this domain, see AnnotationTlsProfile. Optional.
+	TlsProfile TlsProfile
+	// ClientAuth requires clients of this domain to authenticate with a certificate, see AnnotationAuthTlsSecret. Optional.
+	ClientAuth *ClientAuth
+	// PassthroughBackend receives the TLS connections for this domain without termination, see AnnotationSslPassthrough. Optional.
+	PassthroughBackend *BackendPath
+	// AcmeSecret is the kubernetes.io/tls secret in which the certificate for this domain is stored by the built-in ACME client, see AnnotationAcme. Optional.
+	AcmeSecret *types.NamespacedName
 }
 
+// CatchAllHost is the IngressState key for rules without host. Those apply to all hosts.
+// Its DefaultBackend is the ingress class wide fallback for all requests that no rule matches.
+// Its TlsCerts are the default certificate for hosts without certificate, see IngressReconciler.SetDefaultCertificateSecret.
+const CatchAllHost = ""
+
 // IngressState is the current state of the ingress configurations
 type IngressState map[string]*DomainConfig
 
@@ -63,28 +122,72 @@ func (state IngressState) getOrAddEmpty(key string) *DomainConfig {
 	return val
 }
 
+// addTlsCert adds the certificate to the TlsCerts unless a certificate from the same secret is already present
+func (domainConfig *DomainConfig) addTlsCert(tlsCert *TlsCert) {
+	if slices.ContainsFunc(domainConfig.TlsCerts, func(el *TlsCert) bool { return el.Secret == tlsCert.Secret }) {
+		return
+	}
+	domainConfig.TlsCerts = append(domainConfig.TlsCerts, tlsCert)
+}
+
 type ingressStatusUpdate struct {
 	Ingress *v1Net.Ingress
-	Status  *v1Net.IngressLoadBalancerIngress
+	// Status is only set if the ingress status is updated, i.e. the hostIp is set
+	Status *v1Net.IngressLoadBalancerIngress
+	// Errors are the problems of the ingress configuration, excluding the Conflicts
+	Errors []error
+	// Conflicts are the routes of the ingress that are not active as older ingresses use them
+	Conflicts []error
 }
 
 // processState processed the current input State and returns the processed state as well as
 // the curren desired ingress status
 func (r *IngressReconciler) processState() (state IngressState, desiredStatus []*ingressStatusUpdate) {
+	state, desiredStatus, _ = r.processIngresses(r.ingressState, r.gatewayResources)
+	return state, desiredStatus
+}
+
+// processIngresses processes the given ingresses and Gateway API resources and returns the processed state as well as the desired status.
+// Ingresses take precedence over Gateway API routes in case of conflicts. The gatewayResources are optional.
+func (r *IngressReconciler) processIngresses(ingressState map[types.NamespacedName]*v1Net.Ingress,
+	gatewayResources *gatewayResources) (state IngressState, desiredStatus []*ingressStatusUpdate, gatewayStatus *gatewayStatusUpdate) {
 	state = make(IngressState)
 	desiredStatus = make([]*ingressStatusUpdate, 0)
-	for _, ingress := range r.ingressState {
-		errors := r.collectBackendPaths(ingress, state)
-		errors = append(errors, r.collectTlsSecrets(ingress, state)...)
-		log.Debug().Msgf("ingress errors: %v", errors)
+	ingresses := make([]*v1Net.Ingress, 0, len(ingressState))
+	for _, ingress := range ingressState {
+		ingresses = append(ingresses, ingress)
+	}
+	// deterministic order as the oldest ingress wins conflicts
+	sortByPrecedence(ingresses)
+	claims := newRouteClaims()
+	for _, ingress := range ingresses {
+		errors := validateAnnotations(ingress)
+		var backendErrors, conflicts []error
+		if isSslPassthrough(ingress) {
+			backendErrors, conflicts = r.collectPassthroughBackends(ingress, state, claims)
+		} else {
+			backendErrors, conflicts = r.collectBackendPaths(ingress, state, claims)
+		}
+		errors = append(errors, backendErrors...)
+		if !isCanary(ingress) && !isSslPassthrough(ingress) {
+			tlsErrors, tlsConflicts := r.collectTlsSecrets(ingress, state, claims)
+			errors = append(errors, tlsErrors...)
+			conflicts = append(conflicts, tlsConflicts...)
+		}
+		log.Debug().Msgf("ingress errors: %v, conflicts: %v", errors, conflicts)
+		update := &ingressStatusUpdate{
+			Ingress:   ingress.DeepCopy(),
+			Errors:    errors,
+			Conflicts: conflicts,
+		}
 		if r.hostIp != nil {
-			desiredStatus = append(desiredStatus, &ingressStatusUpdate{
-				Ingress: ingress.DeepCopy(),
-				Status:  statusFromErrors(errors, r.hostIp),
-			})
+			update.Status = statusFromErrors(slices.Concat(errors, conflicts), r.hostIp)
 		}
+		desiredStatus = append(desiredStatus, update)
 	}
-	return state, desiredStatus
+	gatewayStatus = r.processGateways(gatewayResources, state, claims)
+	r.collectDefaultCertificate(state)
+	return state, desiredStatus, gatewayStatus
 }
 
 // updateStatus updates the k8s ingress status, blocks till finished.
@@ -93,8 +196,11 @@ func (r *IngressReconciler) updateStatus(ctx context.Context, updates []*ingress
 	var errorMu sync.Mutex
 	if r.hostIp != nil {
 		var wg sync.WaitGroup
-		wg.Add(len(updates))
 		for _, el := range updates {
+			if el.Status == nil {
+				continue
+			}
+			wg.Add(1)
 			go func(update *ingressStatusUpdate) {
 				err := r.k8sClients.updateIngressStatus(ctx, update.Ingress, update.Status)
 				if err != nil {
@@ -141,67 +247,314 @@ func statusFromErrors(errors []error, hostIp net.IP) *v1Net.IngressLoadBalancerI
 }
 
 // collectsBackendPaths collects the relevant backend path information and adds them to the ingress state. It also collects port numbers from referenced services.
-func (r *IngressReconciler) collectBackendPaths(ingress *v1Net.Ingress, result IngressState) []error {
-	errors := make([]error, 0)
+// The spec.defaultBackend is set as DomainConfig.DefaultBackend for all hosts of the ingress rules.
+// If the ingress has no rules the spec.defaultBackend is used as ingress class wide fallback.
+// For canary ingresses the paths are attached to the matching primary paths, see addCanary, and the spec.defaultBackend is ignored.
+// Paths that are already claimed by another ingress are skipped and returned as conflicts, see routeClaims.
+func (r *IngressReconciler) collectBackendPaths(ingress *v1Net.Ingress, result IngressState, claims *routeClaims) (errors []error, conflicts []error) {
+	errors = make([]error, 0)
+	conflicts = make([]error, 0)
+	pathMatching, err := pathMatchingFromAnnotations(ingress)
+	if err != nil {
+		errors = append(errors, err)
+	}
+	rewriteTarget, err := rewriteTargetFromAnnotations(ingress)
+	if err != nil {
+		errors = append(errors, err)
+	}
+	canary, err := canaryFromAnnotations(ingress)
+	if err != nil {
+		// no canary routing at all as the intended traffic split is unknown
+		return append(errors, err), conflicts
+	}
+	protocol, backendTls, err := r.backendProtocolFromAnnotations(ingress)
+	if err != nil {
+		// no routing at all as the backends can not be connected to as intended
+		return append(errors, err), conflicts
+	}
+	healthCheck, outlierDetection, err := healthFromAnnotations(ingress)
+	if err != nil {
+		errors = append(errors, err)
+	}
+	retryPolicy, err := retryPolicyFromAnnotations(ingress)
+	if err != nil {
+		errors = append(errors, err)
+	}
+	circuitBreaker, err := circuitBreakerFromAnnotations(ingress)
+	if err != nil {
+		errors = append(errors, err)
+	}
+	timeouts, err := timeoutsFromAnnotations(ingress)
+	if err != nil {
+		errors = append(errors, err)
+	}
+	rateLimit, err := rateLimitFromAnnotations(ingress)
+	if err != nil {
+		errors = append(errors, err)
+	}
+	// configureBackend applies the backend settings of the annotations that apply to the default backend and the paths
+	configureBackend := func(backendPath *BackendPath) {
+		backendPath.Protocol = protocol
+		backendPath.BackendTls = backendTls
+		backendPath.HealthCheck = healthCheck
+		backendPath.OutlierDetection = outlierDetection
+		backendPath.Retry = retryPolicy
+		backendPath.CircuitBreaker = circuitBreaker
+		backendPath.Timeouts = timeouts
+		backendPath.RateLimit = rateLimit
+	}
+	var defaultBackend *BackendPath
+	if ingress.Spec.DefaultBackend != nil && canary == nil {
+		defaultBackend, err = r.getBackendPath(ingress, nil, "", ingress.Spec.DefaultBackend)
+		if err != nil {
+			errors = append(errors, err)
+		} else {
+			configureBackend(defaultBackend)
+		}
+	}
+	if len(ingress.Spec.Rules) == 0 && defaultBackend != nil {
+		if err = claims.claimPath(ingressOwner(ingress), CatchAllHost, nil, "", nil); err != nil {
+			conflicts = append(conflicts, err)
+		} else {
+			result.getOrAddEmpty(CatchAllHost).DefaultBackend = defaultBackend
+		}
+	}
 	for _, rule := range ingress.Spec.Rules {
+		if canary == nil {
+			domainConfig := result.getOrAddEmpty(rule.Host)
+			if defaultBackend != nil {
+				if err = claims.claimPath(ingressOwner(ingress), rule.Host, nil, "", nil); err != nil {
+					conflicts = append(conflicts, err)
+				} else {
+					domainConfig.DefaultBackend = defaultBackend
+				}
+			}
+		}
 		if rule.HTTP == nil {
 			continue
 		}
-		domainConfig := result.getOrAddEmpty(rule.Host)
-		backendPaths := make([]*BackendPath, 0)
 		for _, path := range rule.HTTP.Paths {
-			backendPath := &BackendPath{
-				PathType:    path.PathType,
-				Path:        path.Path,
-				Namespace:   ingress.Namespace,
-				ServiceName: path.Backend.Service.Name,
-				ServicePort: path.Backend.Service.Port.Number,
-			}
-			err := r.updatePortFromService(backendPath, path.Backend.Service.Port.Name)
+			backendPath, err := r.getBackendPath(ingress, path.PathType, path.Path, &path.Backend)
 			if err != nil {
-				log.Warn().Err(err).Msgf("could not determine service port: %s for backend service %s in namespace %s", path.Backend.Service.Port.Name, path.Backend.Service.Name, ingress.Namespace)
-				errors = append(errors, fmt.Errorf("%w: %s for backend service %s", ErrServicePortNotFound, path.Backend.Service.Port.Name, path.Backend.Service.Name))
+				errors = append(errors, err)
 				continue
-			} else {
-				backendPaths = append(backendPaths, backendPath)
 			}
+			backendPath.RewriteTarget = rewriteTarget
+			configureBackend(backendPath)
+			if path.PathType != nil && *path.PathType == v1Net.PathTypeImplementationSpecific {
+				backendPath.PathMatching = pathMatching
+				// only validated here, the reverse proxy compiles the pattern when loading the state
+				if _, err = backendPath.CompilePathPattern(); err != nil {
+					log.Warn().Err(err).Msgf("invalid path pattern in ingress %s in namespace %s", ingress.Name, ingress.Namespace)
+					errors = append(errors, err)
+					continue
+				}
+			}
+			if canary != nil {
+				if err = claims.claimCanary(ingressOwner(ingress), rule.Host, path.PathType, path.Path); err != nil {
+					log.Warn().Err(err).Msgf("conflicting canary path in ingress %s in namespace %s", ingress.Name, ingress.Namespace)
+					conflicts = append(conflicts, err)
+					continue
+				}
+				if err = result.addCanary(rule.Host, backendPath, canary); err != nil {
+					log.Warn().Err(err).Msgf("could not add canary path for ingress %s in namespace %s", ingress.Name, ingress.Namespace)
+					errors = append(errors, err)
+				}
+				continue
+			}
+			if err = claims.claimPath(ingressOwner(ingress), rule.Host, path.PathType, path.Path, nil); err != nil {
+				log.Warn().Err(err).Msgf("conflicting path in ingress %s in namespace %s", ingress.Name, ingress.Namespace)
+				conflicts = append(conflicts, err)
+				continue
+			}
+			domainConfig := result[rule.Host]
+			domainConfig.BackendPaths = append(domainConfig.BackendPaths, backendPath)
 		}
-		domainConfig.BackendPaths = append(domainConfig.BackendPaths, backendPaths...)
 	}
-	return errors
+	return errors, conflicts
+}
+
+// getBackendPath resolves the service port and the endpoints for the given ingress backend
+func (r *IngressReconciler) getBackendPath(ingress *v1Net.Ingress, pathType *v1Net.PathType, path string, backend *v1Net.IngressBackend) (*BackendPath, error) {
+	if backend.Service == nil {
+		log.Warn().Msgf("unsupported non-service backend for path %s in ingress %s in namespace %s", path, ingress.Name, ingress.Namespace)
+		return nil, fmt.Errorf("%w: path %s", ErrUnsupportedBackend, path)
+	}
+	return r.getServiceBackendPath(ingress.Namespace, pathType, path, backend.Service)
+}
+
+// getServiceBackendPath resolves the service port and the endpoints for the given service in the namespace
+func (r *IngressReconciler) getServiceBackendPath(namespace string, pathType *v1Net.PathType, path string, service *v1Net.IngressServiceBackend) (*BackendPath, error) {
+	backendPath := &BackendPath{
+		PathType:    pathType,
+		Path:        path,
+		Namespace:   namespace,
+		ServiceName: service.Name,
+		ServicePort: service.Port.Number,
+	}
+	portName, err := r.updatePortFromService(backendPath, service.Port.Name)
+	if err != nil {
+		log.Warn().Err(err).Msgf("could not determine service port: %s for backend service %s in namespace %s", service.Port.Name, service.Name, namespace)
+		return nil, fmt.Errorf("%w: %s for backend service %s: %w", ErrServicePortNotFound, service.Port.Name, service.Name, err)
+	}
+	err = r.updateEndpoints(backendPath, portName)
+	if err != nil {
+		log.Warn().Err(err).Msgf("could not determine endpoints for backend service %s in namespace %s", service.Name, namespace)
+		return nil, fmt.Errorf("could not determine endpoints for backend service %s: %w", service.Name, err)
+	}
+	return backendPath, nil
 }
 
 // updatePortFromService uses the Kubernetes API to fetch the ServiceInformer status for the service referenced in the ingress config.
 // If this has finished without error the config.ServicePort property is guaranteed to be set according to the current service spec.
-func (r *IngressReconciler) updatePortFromService(config *BackendPath, servicePortName string) error {
+// The returned portName is the name of the matched service port, which is also used as port name in the EndpointSlices.
+func (r *IngressReconciler) updatePortFromService(config *BackendPath, servicePortName string) (portName string, err error) {
 	if config.ServicePort == 0 && servicePortName == "" {
-		return fmt.Errorf("%w: %s", ErrInvalidBackendService, config.Path)
+		return "", fmt.Errorf("%w: %s", ErrInvalidBackendService, config.Path)
 	}
 	svc, err := r.k8sClients.ServiceLister.Services(config.Namespace).Get(config.ServiceName)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	// matching number takes precedence
 	for _, svcPort := range svc.Spec.Ports {
 		if svcPort.Port == config.ServicePort {
-			return nil
+			return svcPort.Name, nil
 		}
 	}
 	for _, svcPort := range svc.Spec.Ports {
 		if svcPort.Name == servicePortName {
 			config.ServicePort = svcPort.Port
-			return nil
+			return svcPort.Name, nil
 		}
 	}
-	return fmt.Errorf("%w: port name %s in service %s in namespace %s", ErrServicePortNameNotFound, servicePortName, config.ServiceName, config.Namespace)
+	return "", fmt.Errorf("%w: port name %s in service %s in namespace %s", ErrServicePortNameNotFound, servicePortName, config.ServiceName, config.Namespace)
 }
 
-// collectTlsSecrets fetches for all secrets that are referenced in the ingresses the relevant kubernetes.io/tls secrets from the Kubernetes API and adds them to the ingressState
-func (r *IngressReconciler) collectTlsSecrets(ingress *v1Net.Ingress, result IngressState) []error {
-	errs := make([]error, 0)
+// updateEndpoints collects the addresses of the ready endpoints from the EndpointSlices of the service referenced in the ingress config.
+// The portName is the name of the service port, EndpointSlices use the same name for the corresponding target port.
+func (r *IngressReconciler) updateEndpoints(config *BackendPath, portName string) error {
+	endpointSlices, err := r.k8sClients.EndpointSliceLister.EndpointSlices(config.Namespace).List(
+		labels.SelectorFromSet(labels.Set{v1Discovery.LabelServiceName: config.ServiceName}))
+	if err != nil {
+		return err
+	}
+	endpoints := make([]string, 0)
+	for _, endpointSlice := range endpointSlices {
+		port, ok := findEndpointPort(endpointSlice.Ports, portName)
+		if !ok {
+			continue
+		}
+		for _, endpoint := range endpointSlice.Endpoints {
+			// unknown ready state has to be interpreted as ready according to the EndpointSlice API
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			for _, address := range endpoint.Addresses {
+				endpoints = append(endpoints, net.JoinHostPort(address, strconv.FormatInt(int64(port), 10)))
+			}
+		}
+	}
+	// sorted for a stable order as the lister order is random
+	slices.Sort(endpoints)
+	config.Endpoints = slices.Compact(endpoints)
+	if len(config.Endpoints) == 0 {
+		log.Debug().Msgf("no ready endpoints for service %s in namespace %s", config.ServiceName, config.Namespace)
+	}
+	return nil
+}
+
+// findEndpointPort returns the port number for the EndpointSlice port with the given name
+func findEndpointPort(ports []v1Discovery.EndpointPort, portName string) (port int32, ok bool) {
+	for _, endpointPort := range ports {
+		if endpointPort.Port == nil {
+			continue
+		}
+		if (endpointPort.Name == nil && portName == "") || (endpointPort.Name != nil && *endpointPort.Name == portName) {
+			return *endpointPort.Port, true
+		}
+	}
+	return 0, false
+}
+
+// collectDefaultCertificate sets the certificate of the default certificate secret as TlsCerts of the CatchAllHost, see SetDefaultCertificateSecret.
+// Errors are only logged as they do not belong to any ingress. Invalid certificates are skipped, see skipCertificate.
+func (r *IngressReconciler) collectDefaultCertificate(result IngressState) {
+	if r.defaultCertSecret == nil {
+		return
+	}
+	secret, err := r.k8sClients.SecretLister.Secrets(r.defaultCertSecret.Namespace).Get(r.defaultCertSecret.Name)
+	if err != nil {
+		log.Warn().Err(err).Msgf("error getting default TLS certificate secret %s in namespace %s",
+			r.defaultCertSecret.Name, r.defaultCertSecret.Namespace)
+		return
+	}
+	tlsCert := &TlsCert{
+		Secret: *r.defaultCertSecret,
+		Cert:   secret.Data["tls.crt"],
+		Key:    secret.Data["tls.key"],
+	}
+	certErrs := validateCertificate(tlsCert, nil, time.Now())
+	if len(certErrs) > 0 {
+		log.Warn().Err(errors.Join(certErrs...)).Msgf("invalid default TLS certificate secret %s in namespace %s",
+			r.defaultCertSecret.Name, r.defaultCertSecret.Namespace)
+	}
+	if r.skipCertificate(certErrs) {
+		return
+	}
+	result.getOrAddEmpty(CatchAllHost).TlsCerts = []*TlsCert{tlsCert}
+}
+
+// collectTlsSecrets fetches for all secrets that are referenced in the ingresses the relevant kubernetes.io/tls secrets from the Kubernetes API and adds them to the ingressState.
+// Hosts for which another ingress has already claimed a different secret are skipped and returned as conflicts, see routeClaims.
+func (r *IngressReconciler) collectTlsSecrets(ingress *v1Net.Ingress, result IngressState, claims *routeClaims) (errs []error, conflicts []error) {
+	errs = make([]error, 0)
+	conflicts = make([]error, 0)
+	clientAuth, err := r.getClientAuth(ingress)
+	if err != nil {
+		log.Warn().Err(err).Msgf("invalid client authentication in ingress %s in namespace %s", ingress.Name, ingress.Namespace)
+		errs = append(errs, err)
+	}
+	tlsProfile, err := tlsProfileFromAnnotations(ingress)
+	if err != nil {
+		log.Warn().Err(err).Msgf("invalid tls profile in ingress %s in namespace %s", ingress.Name, ingress.Namespace)
+		errs = append(errs, err)
+	}
 	for _, rule := range ingress.Spec.TLS {
+		// hosts are claimed independent of the secret state, so that a broken secret of an older ingress is not silently replaced
+		hosts := make([]string, 0, len(rule.Hosts))
+		for _, host := range rule.Hosts {
+			if err := claims.claimTls(ingressOwner(ingress), host, rule.SecretName); err != nil {
+				log.Warn().Err(err).Msgf("conflicting tls secret in ingress %s in namespace %s", ingress.Name, ingress.Namespace)
+				conflicts = append(conflicts, err)
+				continue
+			}
+			hosts = append(hosts, host)
+		}
+		for _, host := range hosts {
+			if clientAuth == nil && tlsProfile == "" {
+				break
+			}
+			domainConfig := result.getOrAddEmpty(host)
+			if domainConfig.ClientAuth == nil {
+				domainConfig.ClientAuth = clientAuth
+			}
+			if domainConfig.TlsProfile == "" {
+				domainConfig.TlsProfile = tlsProfile
+			}
+		}
+		acme := isAcme(ingress)
+		if acme {
+			for _, host := range hosts {
+				result.getOrAddEmpty(host).AcmeSecret = &types.NamespacedName{Namespace: ingress.Namespace, Name: rule.SecretName}
+			}
+		}
 		secret, err := r.k8sClients.SecretLister.Secrets(ingress.Namespace).Get(rule.SecretName)
+		if err != nil && acme {
+			log.Debug().Msgf("ACME TLS certificate secret %s in namespace %s not yet issued", rule.SecretName, ingress.Namespace)
+			continue
+		}
 		if err != nil {
 			log.Warn().Err(err).Msgf("error getting ingress TLS certificate secret %s in namespace %s",
 				rule.SecretName, ingress.Namespace)
@@ -214,13 +567,23 @@ func (r *IngressReconciler) collectTlsSecrets(ingress *v1Net.Ingress, result Ing
 			errs = append(errs, fmt.Errorf("%w: secret %s in namespace %s with type %s", ErrTlsSecretWrongType, secret.Name, secret.Namespace, secret.Type))
 			continue
 		}
-		for _, host := range rule.Hosts {
-			domainConfig := result.getOrAddEmpty(host)
-			domainConfig.TlsCert = &TlsCert{
-				Cert: secret.Data["tls.crt"],
-				Key:  secret.Data["tls.key"],
-			}
+		tlsCert := &TlsCert{
+			Secret: types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name},
+			Cert:   secret.Data["tls.crt"],
+			Key:    secret.Data["tls.key"],
+		}
+		certErrs := validateCertificate(tlsCert, hosts, time.Now())
+		if len(certErrs) > 0 {
+			log.Warn().Err(errors.Join(certErrs...)).Msgf("invalid TLS certificate secret %s in namespace %s", secret.Name, secret.Namespace)
+			errs = append(errs, certErrs...)
+		}
+		// the hosts fall back to the default certificate
+		if r.skipCertificate(certErrs) {
+			continue
+		}
+		for _, host := range hosts {
+			result.getOrAddEmpty(host).addTlsCert(tlsCert)
 		}
 	}
-	return errs
+	return errs, conflicts
 }
@@ -0,0 +1,116 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	v1Net "k8s.io/api/networking/v1"
+)
+
+// RateLimitKey is the source of the identifier of the clients whose request rate is limited
+type RateLimitKey string
+
+const (
+	// RateLimitKeyIp identifies the clients by their IP address
+	RateLimitKeyIp RateLimitKey = "ip"
+	// RateLimitKeyHeader identifies the clients by the value of a request header
+	RateLimitKeyHeader RateLimitKey = "header"
+	// RateLimitKeyJwtClaim identifies the clients by a claim of the JWT bearer token. The token is not verified.
+	RateLimitKeyJwtClaim RateLimitKey = "jwt-claim"
+)
+
+// RateLimitScope determines which requests of a client share a rate limit
+type RateLimitScope string
+
+const (
+	// RateLimitScopeRoute limits the requests to each path separately
+	RateLimitScopeRoute RateLimitScope = "route"
+	// RateLimitScopeHost limits the requests to all rate limited paths of the host together
+	RateLimitScopeHost RateLimitScope = "host"
+)
+
+//nolint:gomnd
+const (
+	defaultRateLimitPeriod = time.Second
+	// defaultRateLimitIpFactor is the default of the IP address limit for header and jwt-claim keys relative to the rate limit
+	defaultRateLimitIpFactor = 10
+)
+
+// RateLimit limits the request rate of each client via a token bucket, see AnnotationRateLimit
+type RateLimit struct {
+	// Requests is the number of tokens that are refilled per Period
+	Requests int
+	Period   time.Duration
+	// Burst is the capacity of the token bucket
+	Burst int
+	// Key is the source of the client identifier, KeyName the name of the header or JWT claim
+	Key     RateLimitKey
+	KeyName string
+	Scope   RateLimitScope
+	// IpRequests is the number of tokens that are refilled per Period for each IP address in addition to the header or jwt-claim key,
+	// so that clients can not bypass the limit with new values. Zero for the ip key, see AnnotationRateLimitIp.
+	IpRequests int
+}
+
+// IpLimit returns the limit of the IP addresses in addition to the header or jwt-claim key, nil for the ip key.
+// The burst is scaled in proportion to the requests.
+func (limit *RateLimit) IpLimit() *RateLimit {
+	if limit.IpRequests == 0 {
+		return nil
+	}
+	return &RateLimit{
+		Requests: limit.IpRequests,
+		Period:   limit.Period,
+		Burst:    max(1, limit.Burst*limit.IpRequests/limit.Requests),
+		Key:      RateLimitKeyIp,
+		Scope:    limit.Scope,
+	}
+}
+
+// rateLimitFromAnnotations returns the rate limit configured via annotations for the ingress, nil if not configured.
+// On errors the defaults are used for the invalid values, without valid rate-limit there is no rate limit.
+func rateLimitFromAnnotations(ingress *v1Net.Ingress) (*RateLimit, error) {
+	if _, ok := ingress.Annotations[AnnotationRateLimit]; !ok {
+		return nil, nil
+	}
+	requests, err := positiveIntFromAnnotation(ingress, AnnotationRateLimit, 0)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	rateLimit := &RateLimit{Requests: requests, Key: RateLimitKeyIp, Scope: RateLimitScopeRoute}
+	rateLimit.Period, err = durationFromAnnotation(ingress, AnnotationRateLimitPeriod, defaultRateLimitPeriod)
+	errs = append(errs, err)
+	rateLimit.Burst, err = positiveIntFromAnnotation(ingress, AnnotationRateLimitBurst, requests)
+	errs = append(errs, err)
+	if value, ok := ingress.Annotations[AnnotationRateLimitKey]; ok {
+		key, name, _ := strings.Cut(value, ":")
+		switch RateLimitKey(key) {
+		case RateLimitKeyIp:
+		case RateLimitKeyHeader, RateLimitKeyJwtClaim:
+			if name != "" {
+				rateLimit.Key = RateLimitKey(key)
+				rateLimit.KeyName = name
+				break
+			}
+			fallthrough
+		default:
+			errs = append(errs, fmt.Errorf("%w: %s for annotation %s has to be ip, header:<name> or jwt-claim:<name>", ErrInvalidAnnotation, value, AnnotationRateLimitKey))
+		}
+	}
+	if rateLimit.Key != RateLimitKeyIp {
+		rateLimit.IpRequests, err = positiveIntFromAnnotation(ingress, AnnotationRateLimitIp, defaultRateLimitIpFactor*requests)
+		errs = append(errs, err)
+	}
+	if value, ok := ingress.Annotations[AnnotationRateLimitScope]; ok {
+		switch scope := RateLimitScope(value); scope {
+		case RateLimitScopeRoute, RateLimitScopeHost:
+			rateLimit.Scope = scope
+		default:
+			errs = append(errs, fmt.Errorf("%w: %s for annotation %s", ErrInvalidAnnotation, value, AnnotationRateLimitScope))
+		}
+	}
+	return rateLimit, errors.Join(errs...)
+}
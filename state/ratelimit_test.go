@@ -0,0 +1,40 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRateLimitFromAnnotations(t *testing.T) {
+	for _, testCase := range []struct {
+		annotations       map[string]string
+		expectedRateLimit *RateLimit
+		expectedErr       error
+	}{
+		{nil, nil, nil},
+		{map[string]string{AnnotationRateLimitBurst: "10"}, nil, nil},
+		{map[string]string{AnnotationRateLimit: "10"}, &RateLimit{Requests: 10, Period: time.Second, Burst: 10, Key: RateLimitKeyIp, Scope: RateLimitScopeRoute}, nil},
+		{map[string]string{AnnotationRateLimit: "100", AnnotationRateLimitPeriod: "1m", AnnotationRateLimitBurst: "20", AnnotationRateLimitKey: "header:X-Api-Key", AnnotationRateLimitScope: "host"},
+			&RateLimit{Requests: 100, Period: time.Minute, Burst: 20, Key: RateLimitKeyHeader, KeyName: "X-Api-Key", Scope: RateLimitScopeHost, IpRequests: 1000}, nil},
+		{map[string]string{AnnotationRateLimit: "10", AnnotationRateLimitKey: "jwt-claim:sub"}, &RateLimit{Requests: 10, Period: time.Second, Burst: 10, Key: RateLimitKeyJwtClaim, KeyName: "sub", Scope: RateLimitScopeRoute, IpRequests: 100}, nil},
+		{map[string]string{AnnotationRateLimit: "10", AnnotationRateLimitKey: "jwt-claim:sub", AnnotationRateLimitIp: "50"},
+			&RateLimit{Requests: 10, Period: time.Second, Burst: 10, Key: RateLimitKeyJwtClaim, KeyName: "sub", Scope: RateLimitScopeRoute, IpRequests: 50}, nil},
+		{map[string]string{AnnotationRateLimit: "0"}, nil, ErrInvalidAnnotation},
+		{map[string]string{AnnotationRateLimit: "10", AnnotationRateLimitKey: "header"}, &RateLimit{Requests: 10, Period: time.Second, Burst: 10, Key: RateLimitKeyIp, Scope: RateLimitScopeRoute}, ErrInvalidAnnotation},
+		{map[string]string{AnnotationRateLimit: "10", AnnotationRateLimitScope: "global"}, &RateLimit{Requests: 10, Period: time.Second, Burst: 10, Key: RateLimitKeyIp, Scope: RateLimitScopeRoute}, ErrInvalidAnnotation},
+	} {
+		rateLimit, err := rateLimitFromAnnotations(&v1Net.Ingress{ObjectMeta: v1Meta.ObjectMeta{Annotations: testCase.annotations}})
+		require.ErrorIs(t, err, testCase.expectedErr)
+		require.Equal(t, testCase.expectedRateLimit, rateLimit)
+	}
+}
+
+func TestRateLimitIpLimit(t *testing.T) {
+	require.Nil(t, (&RateLimit{Requests: 10, Period: time.Second, Burst: 20, Key: RateLimitKeyIp}).IpLimit())
+	limit := &RateLimit{Requests: 10, Period: time.Minute, Burst: 20, Key: RateLimitKeyHeader, KeyName: "X-Api-Key", Scope: RateLimitScopeHost, IpRequests: 100}
+	require.Equal(t, &RateLimit{Requests: 100, Period: time.Minute, Burst: 200, Key: RateLimitKeyIp, Scope: RateLimitScopeHost}, limit.IpLimit())
+}
@@ -0,0 +1,56 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	v1Net "k8s.io/api/networking/v1"
+)
+
+// RetryOn determines which failures of idempotent requests are retried
+type RetryOn string
+
+const (
+	// RetryOnConnectFailure retries requests for which no connection to the endpoint could be established
+	RetryOnConnectFailure RetryOn = "connect-failure"
+	// RetryOnGatewayError additionally retries requests that the endpoint answered with 502, 503 or 504
+	RetryOnGatewayError RetryOn = "gateway-error"
+)
+
+// RetryPolicy overrides the default retries of the reverse proxy, see AnnotationRetries
+type RetryPolicy struct {
+	// Retries is the maximum number of retries after the first attempt, 0 disables retries. Nil for the default of the reverse proxy.
+	Retries *int
+	// RetryOn are the retried failures. Empty for the default of the reverse proxy.
+	RetryOn RetryOn
+}
+
+// retryPolicyFromAnnotations returns the retry policy configured via annotations for the ingress, nil if not configured.
+// On errors the invalid values are left to the defaults of the reverse proxy.
+func retryPolicyFromAnnotations(ingress *v1Net.Ingress) (*RetryPolicy, error) {
+	retriesValue, retriesOk := ingress.Annotations[AnnotationRetries]
+	retryOnValue, retryOnOk := ingress.Annotations[AnnotationRetryOn]
+	if !retriesOk && !retryOnOk {
+		return nil, nil
+	}
+	policy := &RetryPolicy{}
+	var errs []error
+	if retriesOk {
+		retries, err := strconv.Atoi(retriesValue)
+		if err != nil || retries < 0 {
+			errs = append(errs, fmt.Errorf("%w: %s for annotation %s has to be a non-negative number", ErrInvalidAnnotation, retriesValue, AnnotationRetries))
+		} else {
+			policy.Retries = &retries
+		}
+	}
+	if retryOnOk {
+		switch retryOn := RetryOn(retryOnValue); retryOn {
+		case RetryOnConnectFailure, RetryOnGatewayError:
+			policy.RetryOn = retryOn
+		default:
+			errs = append(errs, fmt.Errorf("%w: %s for annotation %s", ErrInvalidAnnotation, retryOnValue, AnnotationRetryOn))
+		}
+	}
+	return policy, errors.Join(errs...)
+}
@@ -0,0 +1,28 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRetryPolicyFromAnnotations(t *testing.T) {
+	noRetries := 0
+	for _, testCase := range []struct {
+		annotations    map[string]string
+		expectedPolicy *RetryPolicy
+		expectedErr    error
+	}{
+		{nil, nil, nil},
+		{map[string]string{AnnotationRetries: "0"}, &RetryPolicy{Retries: &noRetries}, nil},
+		{map[string]string{AnnotationRetryOn: "gateway-error"}, &RetryPolicy{RetryOn: RetryOnGatewayError}, nil},
+		{map[string]string{AnnotationRetries: "-1", AnnotationRetryOn: "gateway-error"}, &RetryPolicy{RetryOn: RetryOnGatewayError}, ErrInvalidAnnotation},
+		{map[string]string{AnnotationRetryOn: "always"}, &RetryPolicy{}, ErrInvalidAnnotation},
+	} {
+		policy, err := retryPolicyFromAnnotations(&v1Net.Ingress{ObjectMeta: v1Meta.ObjectMeta{Annotations: testCase.annotations}})
+		require.ErrorIs(t, err, testCase.expectedErr)
+		require.Equal(t, testCase.expectedPolicy, policy)
+	}
+}
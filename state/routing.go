@@ -0,0 +1,86 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidValueMatch = errors.New("invalid regular expression for value match")
+
+// RequestConditions are conditions beyond the path that a request has to fulfill, all of them have to match.
+// Only set for Gateway API HTTPRoutes.
+type RequestConditions struct {
+	// Method is the HTTP method of the request, empty matches all methods
+	Method      string
+	Headers     []*ValueMatch
+	QueryParams []*ValueMatch
+}
+
+// ValueMatch matches the value of a header or query parameter with the given name
+type ValueMatch struct {
+	Name string
+	// Value is the exact value or a regular expression (RE2 syntax) that has to match the complete value if Regex is set
+	Value string
+	Regex bool
+}
+
+// HeaderModifier sets, adds and removes HTTP headers
+type HeaderModifier struct {
+	Set    map[string]string
+	Add    map[string]string
+	Remove []string
+}
+
+// Redirect is a redirect response instead of proxying the request to a backend. Unset fields are taken from the request.
+type Redirect struct {
+	Scheme   string
+	Hostname string
+	Port     int32
+	// FullPath replaces the complete request path if set
+	FullPath *string
+	// PrefixPath replaces the matched path prefix if set
+	PrefixPath *string
+	// StatusCode of the redirect response, defaults to 302
+	StatusCode int
+}
+
+// WeightedBackend is one of multiple backends that share the traffic of a path according to their weight
+type WeightedBackend struct {
+	// Backend is nil if the backend could not be resolved, requests that are assigned to it fail
+	Backend *BackendPath
+	Weight  int32
+}
+
+// Compile compiles the regular expression of the value match. Returns nil for exact value matches.
+func (match *ValueMatch) Compile() (*regexp.Regexp, error) {
+	if !match.Regex {
+		return nil, nil //nolint:nilnil // exact matches need no pattern
+	}
+	pattern, err := regexp.Compile("^(?:" + match.Value + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidValueMatch, match.Value, err)
+	}
+	return pattern, nil
+}
+
+// key returns a string representation of the conditions that is equal for equal conditions
+func (conditions *RequestConditions) key() string {
+	if conditions == nil {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(conditions.Method)
+	for _, matches := range [][]*ValueMatch{conditions.Headers, conditions.QueryParams} {
+		keys := make([]string, 0, len(matches))
+		for _, match := range matches {
+			keys = append(keys, fmt.Sprintf("%s=%t:%s", match.Name, match.Regex, match.Value))
+		}
+		slices.Sort(keys)
+		sb.WriteString("|")
+		sb.WriteString(strings.Join(keys, ","))
+	}
+	return sb.String()
+}
@@ -0,0 +1,35 @@
+package state
+
+import (
+	"errors"
+	"time"
+
+	v1Net "k8s.io/api/networking/v1"
+)
+
+// Timeouts of the requests to a backend. Zero values are the defaults of the reverse proxy.
+type Timeouts struct {
+	// Connect is the timeout to establish a connection to an endpoint, see AnnotationConnectTimeout
+	Connect time.Duration
+	// ResponseHeader is the timeout for the response headers after the request has been sent, see AnnotationResponseHeaderTimeout
+	ResponseHeader time.Duration
+	// Request is the total timeout of a request including the request and response bodies, see AnnotationRequestTimeout
+	Request time.Duration
+	// StreamIdle is the timeout without any transfer of the request or response body, see AnnotationStreamIdleTimeout
+	StreamIdle time.Duration
+}
+
+// timeoutsFromAnnotations returns the timeouts configured via annotations for the ingress, nil if not configured.
+// On errors the invalid values are left to the defaults of the reverse proxy.
+func timeoutsFromAnnotations(ingress *v1Net.Ingress) (*Timeouts, error) {
+	connect, errConnect := durationFromAnnotation(ingress, AnnotationConnectTimeout, 0)
+	responseHeader, errResponseHeader := durationFromAnnotation(ingress, AnnotationResponseHeaderTimeout, 0)
+	request, errRequest := durationFromAnnotation(ingress, AnnotationRequestTimeout, 0)
+	streamIdle, errStreamIdle := durationFromAnnotation(ingress, AnnotationStreamIdleTimeout, 0)
+	err := errors.Join(errConnect, errResponseHeader, errRequest, errStreamIdle)
+	timeouts := &Timeouts{Connect: connect, ResponseHeader: responseHeader, Request: request, StreamIdle: streamIdle}
+	if *timeouts == (Timeouts{}) {
+		return nil, err
+	}
+	return timeouts, err
+}
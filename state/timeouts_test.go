@@ -0,0 +1,28 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1Net "k8s.io/api/networking/v1"
+	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTimeoutsFromAnnotations(t *testing.T) {
+	for _, testCase := range []struct {
+		annotations      map[string]string
+		expectedTimeouts *Timeouts
+		expectedErr      error
+	}{
+		{nil, nil, nil},
+		{map[string]string{AnnotationConnectTimeout: "2s", AnnotationResponseHeaderTimeout: "30s"}, &Timeouts{Connect: time.Duration(2) * time.Second, ResponseHeader: time.Duration(30) * time.Second}, nil},
+		{map[string]string{AnnotationRequestTimeout: "1h", AnnotationStreamIdleTimeout: "5m"}, &Timeouts{Request: time.Hour, StreamIdle: time.Duration(5) * time.Minute}, nil},
+		{map[string]string{AnnotationRequestTimeout: "0s"}, nil, ErrInvalidAnnotation},
+		{map[string]string{AnnotationConnectTimeout: "1s", AnnotationStreamIdleTimeout: "long"}, &Timeouts{Connect: time.Second}, ErrInvalidAnnotation},
+	} {
+		timeouts, err := timeoutsFromAnnotations(&v1Net.Ingress{ObjectMeta: v1Meta.ObjectMeta{Annotations: testCase.annotations}})
+		require.ErrorIs(t, err, testCase.expectedErr)
+		require.Equal(t, testCase.expectedTimeouts, timeouts)
+	}
+}
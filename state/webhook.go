@@ -0,0 +1,125 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1Core "k8s.io/api/core/v1"
+	v1Net "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var ErrUnexpectedObject = errors.New("expected an ingress")
+
+// ingressValidator is a validating admission webhook for ingresses of the ingress class of the reconciler.
+// It rejects ingresses for which the state processing would report errors or conflicts.
+type ingressValidator struct {
+	reconciler *IngressReconciler
+}
+
+// SetupWebhookWithManager registers the validating admission webhook for ingresses with the webhook server of the manager.
+// The webhook is served under /validate-networking-k8s-io-v1-ingress.
+func (r *IngressReconciler) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&v1Net.Ingress{}).
+		WithValidator(&ingressValidator{reconciler: r}).
+		Complete()
+}
+
+// ValidateCreate validates the ingress on creation
+func (v *ingressValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return v.validate(ctx, obj)
+}
+
+// ValidateUpdate validates the updated ingress
+func (v *ingressValidator) ValidateUpdate(ctx context.Context, _ runtime.Object, newObj runtime.Object) (admission.Warnings, error) {
+	return v.validate(ctx, newObj)
+}
+
+// ValidateDelete allows all deletions
+func (v *ingressValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate processes the ingress together with the currently known ingresses and returns the errors and conflicts of the ingress.
+// Services and secrets that do not exist (yet) are only returned as warnings, as they are often created after the ingress.
+// The same applies to invalid certificates, which are usually renewed independent of the ingress.
+func (v *ingressValidator) validate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	ingress, ok := obj.(*v1Net.Ingress)
+	if !ok {
+		return nil, fmt.Errorf("%w: got %T", ErrUnexpectedObject, obj)
+	}
+	if !v.reconciler.isIngressClass(ingress) {
+		return nil, nil
+	}
+	ingress = ingress.DeepCopy()
+	// not yet set for new ingresses, which are younger than all existing ones
+	if ingress.CreationTimestamp.IsZero() {
+		ingress.CreationTimestamp = metav1.NewTime(time.Now())
+	}
+	ingressName := types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name}
+
+	v.reconciler.ingressStateLock.RLock()
+	ingresses := make(map[types.NamespacedName]*v1Net.Ingress, len(v.reconciler.ingressState)+1)
+	for key, el := range v.reconciler.ingressState {
+		ingresses[key] = el
+	}
+	gatewayResources := v.reconciler.gatewayResources
+	v.reconciler.ingressStateLock.RUnlock()
+	ingresses[ingressName] = ingress
+
+	_, updates, _ := v.reconciler.processIngresses(ingresses, gatewayResources)
+	warnings, errs := v.validateTlsSecrets(ctx, ingress)
+	for _, update := range updates {
+		if update.Ingress.Namespace != ingress.Namespace || update.Ingress.Name != ingress.Name {
+			continue
+		}
+		for _, err := range update.Errors {
+			switch {
+			case errors.Is(err, ErrTlsSecretNotFound):
+				// already covered by validateTlsSecrets
+				continue
+			case apierrors.IsNotFound(err), isCertificateError(err):
+				warnings = append(warnings, err.Error())
+				continue
+			}
+			errs = append(errs, err)
+		}
+		errs = append(errs, update.Conflicts...)
+	}
+	if len(errs) > 0 {
+		log.Debug().Msgf("admission webhook rejected ingress %s in namespace %s: %v", ingress.Name, ingress.Namespace, errs)
+	}
+	return warnings, errors.Join(errs...)
+}
+
+// validateTlsSecrets checks the type of the referenced tls secrets via the Kubernetes API,
+// as the secret lister only contains kubernetes.io/tls secrets. Missing secrets are returned as warnings.
+func (v *ingressValidator) validateTlsSecrets(ctx context.Context, ingress *v1Net.Ingress) (warnings admission.Warnings, errs []error) {
+	errs = make([]error, 0)
+	for _, rule := range ingress.Spec.TLS {
+		secret, err := v.reconciler.k8sClients.client.CoreV1().Secrets(ingress.Namespace).Get(ctx, rule.SecretName, metav1.GetOptions{})
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return warnings, append(errs, fmt.Errorf("could not fetch secret %s: %w", rule.SecretName, err))
+			}
+			// secrets of ACME ingresses are created once the certificate is issued
+			if !isAcme(ingress) {
+				warnings = append(warnings, fmt.Errorf("%w: %s", ErrTlsSecretNotFound, rule.SecretName).Error())
+			}
+			continue
+		}
+		if secret.Type != v1Core.SecretTypeTLS {
+			errs = append(errs, fmt.Errorf("%w: secret %s in namespace %s with type %s", ErrTlsSecretWrongType, secret.Name, secret.Namespace, secret.Type))
+		}
+	}
+	return warnings, errs
+}
@@ -0,0 +1,106 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	v1Net "k8s.io/api/networking/v1"
+	v1Meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// getDummyValidator returns an ingressValidator with the dummy service, a secret of the wrong type and an existing ingress for the host other.localhost
+func getDummyValidator(t *testing.T) *ingressValidator {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	_, err := client.CoreV1().Services(namespace).Create(ctx, getDummyService(), v1Meta.CreateOptions{})
+	require.NoError(t, err)
+	secret, _, _ := getDummySecret(t)
+	secret.Type = v1.SecretTypeOpaque
+	_, err = client.CoreV1().Secrets(namespace).Create(ctx, secret, v1Meta.CreateOptions{})
+	require.NoError(t, err)
+	existingIngress := getDummyIngress()
+	existingIngress.Name = "existing"
+	existingIngress.Namespace = namespace
+	existingIngress.CreationTimestamp = v1Meta.NewTime(time.Now().Add(-time.Hour))
+	existingIngress.Spec.Rules[0].Host = "other." + host
+	existingIngress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.Service.Port.Number = servicePort
+
+	stateReconciler := &IngressReconciler{
+		ingressClassName:          ingressClassName,
+		ingressState:              map[types.NamespacedName]*v1Net.Ingress{{Namespace: namespace, Name: existingIngress.Name}: existingIngress},
+		ingressProcessedStateChan: make(chan IngressState),
+		k8sClients:                newKubernetesClients(client)}
+	err = stateReconciler.k8sClients.startInformers(ctx)
+	require.NoError(t, err)
+	stateReconciler.k8sClients.waitForSync(ctx)
+	return &ingressValidator{reconciler: stateReconciler}
+}
+
+func TestValidateIngress(t *testing.T) {
+	ctx := context.Background()
+	validator := getDummyValidator(t)
+	implementationSpecific := v1Net.PathTypeImplementationSpecific
+	otherClass := "other"
+	for _, testCase := range []struct {
+		name            string
+		modify          func(ingress *v1Net.Ingress)
+		expectedErr     error
+		expectedWarning bool
+	}{
+		{"valid", func(_ *v1Net.Ingress) {}, nil, false},
+		{"missing service port", func(ingress *v1Net.Ingress) {
+			ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.Service.Port.Number = servicePort + 1
+			ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.Service.Port.Name = ""
+		}, ErrServicePortNotFound, false},
+		{"missing service", func(ingress *v1Net.Ingress) {
+			ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.Service.Name = "missing"
+		}, nil, true},
+		{"wrong secret type", func(ingress *v1Net.Ingress) {
+			ingress.Spec.TLS = []v1Net.IngressTLS{{Hosts: []string{host}, SecretName: secretName}}
+		}, ErrTlsSecretWrongType, false},
+		{"missing secret", func(ingress *v1Net.Ingress) {
+			ingress.Spec.TLS = []v1Net.IngressTLS{{Hosts: []string{host}, SecretName: "missing"}}
+		}, nil, true},
+		{"invalid regex path", func(ingress *v1Net.Ingress) {
+			ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].PathType = &implementationSpecific
+			ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Path = "/api/(v1"
+		}, ErrInvalidPathPattern, false},
+		{"unknown annotation", func(ingress *v1Net.Ingress) {
+			ingress.Annotations = map[string]string{AnnotationPrefix + "rewrite-targt": "/"}
+		}, ErrUnknownAnnotation, false},
+		{"host conflict", func(ingress *v1Net.Ingress) {
+			ingress.Spec.Rules[0].Host = "other." + host
+		}, ErrPathConflict, false},
+		{"other ingress class", func(ingress *v1Net.Ingress) {
+			ingress.Spec.IngressClassName = &otherClass
+			ingress.Spec.Rules[0].Host = "other." + host
+		}, nil, false},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			ingress := getDummyIngress()
+			ingress.Namespace = namespace
+			ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.Service.Port.Number = servicePort
+			testCase.modify(ingress)
+			warnings, err := validator.ValidateCreate(ctx, ingress)
+			if testCase.expectedErr == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, testCase.expectedErr)
+			}
+			require.Equal(t, testCase.expectedWarning, len(warnings) > 0)
+		})
+	}
+}
+
+func TestValidateUpdateKeepsCreationTimestamp(t *testing.T) {
+	validator := getDummyValidator(t)
+	// the existing ingress is the oldest and keeps its paths on update
+	existingIngress := validator.reconciler.ingressState[types.NamespacedName{Namespace: namespace, Name: "existing"}].DeepCopy()
+	_, err := validator.ValidateUpdate(context.Background(), existingIngress, existingIngress)
+	require.NoError(t, err)
+}